@@ -30,6 +30,11 @@ func (*baseAudioDeviceConfiguration) audioDeviceConfiguration() {}
 //
 // After creating and configuring a VirtioSoundDeviceConfiguration struct, assign it to the
 // SetAudioDevicesVirtualMachineConfiguration method of your VM’s configuration.
+//
+// The streams cannot be muted nor their volume changed from the host while the virtual machine is
+// running, because the Virtualization framework has no runtime object for sound devices. Use the
+// mixer of the guest instead, or leave out the VirtioSoundDeviceHostOutputStreamConfiguration to
+// run the virtual machine without sound output.
 type VirtioSoundDeviceConfiguration struct {
 	*pointer
 
@@ -82,6 +87,11 @@ func (*baseVirtioSoundDeviceStreamConfiguration) virtioSoundDeviceStreamConfigur
 
 // VirtioSoundDeviceHostInputStreamConfiguration is a PCM stream of input audio data,
 // such as from a microphone via host.
+//
+// The audio is always captured from the default input device of the host: the Virtualization
+// framework has no other source, so a file cannot be fed to the guest as a virtual microphone.
+// Play the file in the guest instead (e.g. with aplay to an ALSA loopback device), or select a
+// loopback driver as the input device of the host.
 type VirtioSoundDeviceHostInputStreamConfiguration struct {
 	*pointer
 
@@ -113,6 +123,11 @@ func NewVirtioSoundDeviceHostInputStreamConfiguration() (*VirtioSoundDeviceHostI
 // defines a Virtio host sound device output stream configuration.
 //
 // A PCM stream of output audio data, such as to a speaker from host.
+//
+// The audio is always played on the default output device of the host: the Virtualization
+// framework has no other sink, so it cannot be written to a file. To record the audio of a
+// virtual machine, record it in the guest (e.g. with arecord from an ALSA loopback device), or
+// record the output device of the host with a loopback driver.
 type VirtioSoundDeviceHostOutputStreamConfiguration struct {
 	*pointer
 
@@ -110,6 +110,10 @@ func NewLinuxBootLoader(vmlinuz string, opts ...LinuxBootLoaderOption) (*LinuxBo
 var _ BootLoader = (*LinuxBootLoader)(nil)
 
 // EFIBootLoader Boot loader configuration for booting guest operating systems expecting an EFI ROM.
+//
+// The guest always boots the EFI firmware built into the Virtualization framework, which has no
+// API to select another firmware (e.g. a custom OVMF build). The firmware can only be customized
+// through its variables in the EFIVariableStore.
 // see: https://developer.apple.com/documentation/virtualization/vzefibootloader?language=objc
 type EFIBootLoader struct {
 	*pointer
@@ -22,6 +22,8 @@ type MacOSBootLoader struct {
 
 var _ BootLoader = (*MacOSBootLoader)(nil)
 
+func (*MacOSBootLoader) macOSBootLoader() {}
+
 // NewMacOSBootLoader creates a new MacOSBootLoader struct.
 //
 // This is only supported on macOS 12 and newer, error will
@@ -0,0 +1,63 @@
+package vz
+
+import "path/filepath"
+
+// BundleLayout defines where the files of a virtual machine are stored in its bundle directory,
+// so that tools which create a virtual machine and tools which start it agree on the layout.
+//
+//	Disk.img            the main disk image
+//	NVRAM               the EFI variable store
+//	MachineIdentifier   the data representation of the machine identifier
+//	AuxiliaryStorage    the auxiliary storage of a macOS guest
+//	HardwareModel       the data representation of the hardware model of a macOS guest
+//	RestoreImage.ipsw   the restore image a macOS guest is installed from
+//	vmlinuz, initrd     the kernel and initial ramdisk of a Linux guest booted directly
+type BundleLayout struct {
+	// Dir is the bundle directory.
+	Dir string
+}
+
+// NewBundleLayout returns the layout of the bundle directory dir.
+func NewBundleLayout(dir string) BundleLayout {
+	return BundleLayout{Dir: dir}
+}
+
+// DiskImagePath returns the path to the main disk image.
+func (l BundleLayout) DiskImagePath() string {
+	return filepath.Join(l.Dir, "Disk.img")
+}
+
+// EFIVariableStorePath returns the path to the EFI variable store, see NewEFIVariableStore.
+func (l BundleLayout) EFIVariableStorePath() string {
+	return filepath.Join(l.Dir, "NVRAM")
+}
+
+// MachineIdentifierPath returns the path to the machine identifier, see NewGenericMachineIdentifierWithDataPath.
+func (l BundleLayout) MachineIdentifierPath() string {
+	return filepath.Join(l.Dir, "MachineIdentifier")
+}
+
+// AuxiliaryStoragePath returns the path to the auxiliary storage of a macOS guest.
+func (l BundleLayout) AuxiliaryStoragePath() string {
+	return filepath.Join(l.Dir, "AuxiliaryStorage")
+}
+
+// HardwareModelPath returns the path to the hardware model of a macOS guest.
+func (l BundleLayout) HardwareModelPath() string {
+	return filepath.Join(l.Dir, "HardwareModel")
+}
+
+// RestoreImagePath returns the path to the restore image of a macOS guest.
+func (l BundleLayout) RestoreImagePath() string {
+	return filepath.Join(l.Dir, "RestoreImage.ipsw")
+}
+
+// KernelPath returns the path to the kernel of a Linux guest, see NewLinuxBootLoader.
+func (l BundleLayout) KernelPath() string {
+	return filepath.Join(l.Dir, "vmlinuz")
+}
+
+// InitrdPath returns the path to the optional initial ramdisk of a Linux guest, see WithInitrd.
+func (l BundleLayout) InitrdPath() string {
+	return filepath.Join(l.Dir, "initrd")
+}
@@ -0,0 +1,42 @@
+package vz_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Code-Hex/vz/v3"
+)
+
+func TestBundleLayout(t *testing.T) {
+	dir := filepath.Join("Users", "vz", "VM.bundle")
+	layout := vz.NewBundleLayout(dir + "/")
+	cases := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"DiskImagePath", layout.DiskImagePath(), "Disk.img"},
+		{"EFIVariableStorePath", layout.EFIVariableStorePath(), "NVRAM"},
+		{"MachineIdentifierPath", layout.MachineIdentifierPath(), "MachineIdentifier"},
+		{"AuxiliaryStoragePath", layout.AuxiliaryStoragePath(), "AuxiliaryStorage"},
+		{"HardwareModelPath", layout.HardwareModelPath(), "HardwareModel"},
+		{"RestoreImagePath", layout.RestoreImagePath(), "RestoreImage.ipsw"},
+		{"KernelPath", layout.KernelPath(), "vmlinuz"},
+		{"InitrdPath", layout.InitrdPath(), "initrd"},
+	}
+	seen := make(map[string]string)
+	for _, tc := range cases {
+		want := filepath.Join(dir, tc.want)
+		if tc.got != want {
+			t.Errorf("%s: want %q but got %q", tc.name, want, tc.got)
+		}
+		if other, ok := seen[tc.got]; ok {
+			t.Errorf("%s and %s have the same path %q", tc.name, other, tc.got)
+		}
+		seen[tc.got] = tc.name
+	}
+
+	if got := (vz.BundleLayout{}).DiskImagePath(); got != "Disk.img" {
+		t.Errorf("want the path relative to the working directory but got %q", got)
+	}
+}
@@ -25,6 +25,59 @@ const char *getNSErrorUserInfo(void *err)
 	return [uis UTF8String];
 }
 
+static id convertToJSONObject(id obj)
+{
+	if (obj == nil) {
+		return [NSNull null];
+	}
+	if ([obj isKindOfClass:[NSString class]] || [obj isKindOfClass:[NSNumber class]] || [obj isKindOfClass:[NSNull class]]) {
+		return obj;
+	}
+	if ([obj isKindOfClass:[NSURL class]]) {
+		return [(NSURL *)obj absoluteString];
+	}
+	if ([obj isKindOfClass:[NSError class]]) {
+		NSError *err = (NSError *)obj;
+		return @{
+			@"Domain" : [err domain],
+			@"Code" : @([err code]),
+			@"LocalizedDescription" : [err localizedDescription],
+			@"UserInfo" : convertToJSONObject([err userInfo]),
+		};
+	}
+	if ([obj isKindOfClass:[NSArray class]]) {
+		NSMutableArray *array = [NSMutableArray array];
+		for (id v in (NSArray *)obj) {
+			[array addObject:convertToJSONObject(v)];
+		}
+		return array;
+	}
+	if ([obj isKindOfClass:[NSDictionary class]]) {
+		NSMutableDictionary *dict = [NSMutableDictionary dictionary];
+		for (id key in (NSDictionary *)obj) {
+			dict[[key description]] = convertToJSONObject(((NSDictionary *)obj)[key]);
+		}
+		return dict;
+	}
+	return [obj description];
+}
+
+// getNSErrorUserInfoJSON returns userInfo as a JSON object. Values which are not
+// representable in JSON are converted to their description.
+const char *getNSErrorUserInfoJSON(void *err)
+{
+	id info = convertToJSONObject([(NSError *)err userInfo]);
+	if (![info isKindOfClass:[NSDictionary class]]) {
+		return "{}";
+	}
+	NSData *data = [NSJSONSerialization dataWithJSONObject:info options:0 error:nil];
+	if (data == nil) {
+		return "{}";
+	}
+	NSString *json = [[[NSString alloc] initWithData:data encoding:NSUTF8StringEncoding] autorelease];
+	return [json UTF8String];
+}
+
 NSInteger getNSErrorCode(void *err)
 {
 	return (NSInteger)[(NSError *)err code];
@@ -34,6 +87,7 @@ typedef struct NSErrorFlat {
 	const char *domain;
     const char *localizedDescription;
 	const char *userinfo;
+	const char *userinfoJSON;
     int code;
 } NSErrorFlat;
 
@@ -43,6 +97,7 @@ NSErrorFlat convertNSError2Flat(void *err)
 	ret.domain = getNSErrorDomain(err);
 	ret.localizedDescription = getNSErrorLocalizedDescription(err);
 	ret.userinfo = getNSErrorUserInfo(err);
+	ret.userinfoJSON = getNSErrorUserInfoJSON(err);
 	ret.code = (int)getNSErrorCode(err);
 
 	return ret;
@@ -61,6 +116,7 @@ bool hasError(void *err)
 */
 import "C"
 import (
+	"encoding/json"
 	"fmt"
 	"unsafe"
 
@@ -72,11 +128,28 @@ import (
 type pointer = objc.Pointer
 
 // NSError indicates NSError.
+//
+// Errors returned by the Virtualization framework (e.g. from (*VirtualMachine).Start)
+// are *NSError. Use ErrorCodeOf to switch on the code, or errors.As to read the domain
+// and the code:
+//
+//	var nserr *vz.NSError
+//	if errors.As(err, &nserr) && nserr.Domain == vz.ErrorDomain {
+//		switch vz.ErrorCode(nserr.Code) {
+//		...
+//		}
+//	}
 type NSError struct {
 	Domain               string
 	Code                 int
 	LocalizedDescription string
-	UserInfo             string
+	// UserInfo is the description of the userInfo dictionary.
+	UserInfo string
+	// UserInfoValues is the userInfo dictionary converted to Go values. Strings and numbers
+	// are kept, an NSError value (e.g. NSUnderlyingErrorKey) becomes a map which has
+	// "Domain", "Code", "LocalizedDescription" and "UserInfo" keys, and other values are
+	// converted to their description. This is nil if the dictionary is empty.
+	UserInfoValues map[string]any
 }
 
 // newNSErrorAsNil makes nil NSError in objective-c world.
@@ -111,8 +184,18 @@ func newNSError(p unsafe.Pointer) *NSError {
 		Domain:               (*char)(nsError.domain).String(),
 		Code:                 int((nsError.code)),
 		LocalizedDescription: (*char)(nsError.localizedDescription).String(),
-		UserInfo:             (*char)(nsError.userinfo).String(), // NOTE(codehex): maybe we can convert to map[string]interface{}
+		UserInfo:             (*char)(nsError.userinfo).String(),
+		UserInfoValues:       parseNSErrorUserInfo((*char)(nsError.userinfoJSON).String()),
+	}
+}
+
+// parseNSErrorUserInfo parses the userInfo dictionary which is encoded as JSON.
+func parseNSErrorUserInfo(s string) map[string]any {
+	var userInfo map[string]any
+	if err := json.Unmarshal([]byte(s), &userInfo); err != nil || len(userInfo) == 0 {
+		return nil
 	}
+	return userInfo
 }
 
 // CharWithGoString makes *Char which is *C.Char wrapper from Go string.
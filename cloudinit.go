@@ -0,0 +1,326 @@
+package vz
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// CloudInitSeedVolumeLabel is the volume label which cloud-init NoCloud
+// datasource looks for.
+const CloudInitSeedVolumeLabel = "cidata"
+
+// CreateCloudInitSeedISO creates a cloud-init NoCloud seed ISO image with specified filename.
+//
+// The image is an ISO 9660 file system with Joliet extensions labeled "cidata" which contains
+// "user-data", "meta-data" and "network-config" files in the root directory. "network-config" is
+// omitted if networkConfig is nil. The created image can be attached to the virtual machine using
+// NewDiskImageStorageDeviceAttachment as read-only.
+//
+// Note that if you have specified a pathname which already exists, this function
+// returns os.ErrExist error. So you can handle it with os.IsExist function.
+func CreateCloudInitSeedISO(pathname string, userData, metaData, networkConfig []byte) error {
+	files := []isoFile{
+		{name: "user-data", data: userData},
+		{name: "meta-data", data: metaData},
+	}
+	if networkConfig != nil {
+		files = append(files, isoFile{name: "network-config", data: networkConfig})
+	}
+	image, err := buildISO9660Image(CloudInitSeedVolumeLabel, files, time.Now())
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(pathname, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(image); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+const (
+	isoSectorSize = 2048
+
+	// The first 16 sectors are the system area.
+	isoPrimaryVolumeDescriptorSector = 16
+	isoJolietVolumeDescriptorSector  = 17
+	isoTerminatorSector              = 18
+	isoPathTableSector               = 19 // L, M, Joliet L, Joliet M
+	isoRootDirectorySector           = 23
+	isoJolietRootDirectorySector     = 24
+	isoFileDataSector                = 25
+)
+
+type isoFile struct {
+	name string
+	data []byte
+
+	sector uint32
+}
+
+// buildISO9660Image builds a minimal single directory ISO 9660 image.
+//
+// The primary volume descriptor uses level 1 (8.3) identifiers and the supplementary
+// volume descriptor uses Joliet identifiers so that the guest sees the original file names.
+func buildISO9660Image(volumeID string, files []isoFile, now time.Time) ([]byte, error) {
+	if len(volumeID) > 16 {
+		return nil, fmt.Errorf("volume identifier %q is too long", volumeID)
+	}
+	sector := uint32(isoFileDataSector)
+	for i := range files {
+		files[i].sector = sector
+		sector += isoSectors(len(files[i].data))
+	}
+	totalSectors := sector
+
+	primaryNames := make(map[string]string, len(files))
+	for _, f := range files {
+		name := isoLevel1Identifier(f.name)
+		for _, other := range primaryNames {
+			if other == name {
+				return nil, fmt.Errorf("duplicate ISO 9660 file identifier %q", name)
+			}
+		}
+		primaryNames[f.name] = name
+	}
+
+	image := make([]byte, int(totalSectors)*isoSectorSize)
+	sectorAt := func(n uint32) []byte {
+		return image[int(n)*isoSectorSize : int(n+1)*isoSectorSize]
+	}
+
+	// Directories
+	primaryRoot := isoDirectory(now, isoRootDirectorySector, files, func(f isoFile) []byte {
+		return []byte(primaryNames[f.name])
+	})
+	jolietRoot := isoDirectory(now, isoJolietRootDirectorySector, files, func(f isoFile) []byte {
+		return isoUCS2(f.name)
+	})
+	if len(primaryRoot) > isoSectorSize || len(jolietRoot) > isoSectorSize {
+		return nil, fmt.Errorf("too many files for a single directory sector")
+	}
+	copy(sectorAt(isoRootDirectorySector), primaryRoot)
+	copy(sectorAt(isoJolietRootDirectorySector), jolietRoot)
+
+	// Path tables
+	pathTableSize := len(isoPathTableRecord(binary.LittleEndian, 0))
+	copy(sectorAt(isoPathTableSector), isoPathTableRecord(binary.LittleEndian, isoRootDirectorySector))
+	copy(sectorAt(isoPathTableSector+1), isoPathTableRecord(binary.BigEndian, isoRootDirectorySector))
+	copy(sectorAt(isoPathTableSector+2), isoPathTableRecord(binary.LittleEndian, isoJolietRootDirectorySector))
+	copy(sectorAt(isoPathTableSector+3), isoPathTableRecord(binary.BigEndian, isoJolietRootDirectorySector))
+
+	// Volume descriptors
+	isoVolumeDescriptor(sectorAt(isoPrimaryVolumeDescriptorSector), isoVolumeDescriptorParams{
+		typ:           1,
+		volumeID:      isoPadded([]byte(volumeID), 32),
+		totalSectors:  totalSectors,
+		pathTableSize: uint32(pathTableSize),
+		pathTableL:    isoPathTableSector,
+		pathTableM:    isoPathTableSector + 1,
+		root:          isoDirectoryRecord(now, isoRootDirectorySector, isoSectorSize, true, []byte{0}),
+		now:           now,
+	})
+	isoVolumeDescriptor(sectorAt(isoJolietVolumeDescriptorSector), isoVolumeDescriptorParams{
+		typ:           2,
+		volumeID:      isoPaddedUCS2(volumeID, 32),
+		escape:        []byte("%/E"), // UCS-2 Level 3
+		totalSectors:  totalSectors,
+		pathTableSize: uint32(pathTableSize),
+		pathTableL:    isoPathTableSector + 2,
+		pathTableM:    isoPathTableSector + 3,
+		root:          isoDirectoryRecord(now, isoJolietRootDirectorySector, isoSectorSize, true, []byte{0}),
+		now:           now,
+	})
+	terminator := sectorAt(isoTerminatorSector)
+	terminator[0] = 255
+	copy(terminator[1:], "CD001")
+	terminator[6] = 1
+
+	// File data
+	for _, f := range files {
+		copy(image[int(f.sector)*isoSectorSize:], f.data)
+	}
+	return image, nil
+}
+
+func isoSectors(size int) uint32 {
+	return uint32((size + isoSectorSize - 1) / isoSectorSize)
+}
+
+// isoLevel1Identifier converts file name to the ISO 9660 level 1 (8.3) file identifier.
+func isoLevel1Identifier(name string) string {
+	base, ext := name, ""
+	if i := strings.LastIndexByte(name, '.'); i > 0 {
+		base, ext = name[:i], name[i+1:]
+	}
+	dchars := func(s string, max int) string {
+		var b strings.Builder
+		for _, r := range strings.ToUpper(s) {
+			if b.Len() == max {
+				break
+			}
+			if ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') || r == '_' {
+				b.WriteRune(r)
+			} else {
+				b.WriteByte('_')
+			}
+		}
+		return b.String()
+	}
+	return dchars(base, 8) + "." + dchars(ext, 3) + ";1"
+}
+
+func isoUCS2(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, len(u)*2)
+	for i, c := range u {
+		binary.BigEndian.PutUint16(b[i*2:], c)
+	}
+	return b
+}
+
+func isoPadded(b []byte, size int) []byte {
+	ret := bytes.Repeat([]byte{' '}, size)
+	copy(ret, b)
+	return ret
+}
+
+func isoPaddedUCS2(s string, size int) []byte {
+	ret := bytes.Repeat([]byte{0, ' '}, size/2)
+	copy(ret, isoUCS2(s))
+	return ret
+}
+
+func isoPutBothEndian32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b[0:], v)
+	binary.BigEndian.PutUint32(b[4:], v)
+}
+
+func isoPutBothEndian16(b []byte, v uint16) {
+	binary.LittleEndian.PutUint16(b[0:], v)
+	binary.BigEndian.PutUint16(b[2:], v)
+}
+
+func isoDirectoryRecord(now time.Time, sector, size uint32, dir bool, id []byte) []byte {
+	length := 33 + len(id)
+	if len(id)%2 == 0 {
+		length++
+	}
+	r := make([]byte, length)
+	r[0] = byte(length)
+	isoPutBothEndian32(r[2:], sector)
+	isoPutBothEndian32(r[10:], size)
+	t := now.UTC()
+	r[18] = byte(t.Year() - 1900)
+	r[19] = byte(t.Month())
+	r[20] = byte(t.Day())
+	r[21] = byte(t.Hour())
+	r[22] = byte(t.Minute())
+	r[23] = byte(t.Second())
+	if dir {
+		r[25] = 2
+	}
+	isoPutBothEndian16(r[28:], 1)
+	r[32] = byte(len(id))
+	copy(r[33:], id)
+	return r
+}
+
+func isoDirectory(now time.Time, sector uint32, files []isoFile, identifier func(isoFile) []byte) []byte {
+	type entry struct {
+		id   []byte
+		file isoFile
+	}
+	entries := make([]entry, len(files))
+	for i, f := range files {
+		entries[i] = entry{id: identifier(f), file: f}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].id, entries[j].id) < 0
+	})
+
+	var records [][]byte
+	for _, e := range entries {
+		records = append(records, isoDirectoryRecord(now, e.file.sector, uint32(len(e.file.data)), false, e.id))
+	}
+	// "." and ".." refer to the root directory itself.
+	size := uint32(isoSectorSize)
+	dot := isoDirectoryRecord(now, sector, size, true, []byte{0})
+	dotdot := isoDirectoryRecord(now, sector, size, true, []byte{1})
+
+	var buf bytes.Buffer
+	buf.Write(dot)
+	buf.Write(dotdot)
+	for _, r := range records {
+		buf.Write(r)
+	}
+	return buf.Bytes()
+}
+
+func isoPathTableRecord(order binary.ByteOrder, sector uint32) []byte {
+	r := make([]byte, 10)
+	r[0] = 1 // length of directory identifier
+	order.PutUint32(r[2:], sector)
+	order.PutUint16(r[6:], 1) // parent directory number
+	r[8] = 0                  // root directory identifier
+	return r
+}
+
+type isoVolumeDescriptorParams struct {
+	typ           byte
+	volumeID      []byte
+	escape        []byte
+	totalSectors  uint32
+	pathTableSize uint32
+	pathTableL    uint32
+	pathTableM    uint32
+	root          []byte
+	now           time.Time
+}
+
+func isoVolumeDescriptor(b []byte, p isoVolumeDescriptorParams) {
+	b[0] = p.typ
+	copy(b[1:], "CD001")
+	b[6] = 1
+
+	blank := isoPadded(nil, 128)
+	if p.escape != nil {
+		blank = isoPaddedUCS2("", 128)
+	}
+	copy(b[8:40], blank)
+	copy(b[40:72], p.volumeID)
+	isoPutBothEndian32(b[80:], p.totalSectors)
+	copy(b[88:], p.escape)
+	isoPutBothEndian16(b[120:], 1)
+	isoPutBothEndian16(b[124:], 1)
+	isoPutBothEndian16(b[128:], isoSectorSize)
+	isoPutBothEndian32(b[132:], p.pathTableSize)
+	binary.LittleEndian.PutUint32(b[140:], p.pathTableL)
+	binary.BigEndian.PutUint32(b[148:], p.pathTableM)
+	copy(b[156:190], p.root)
+	copy(b[190:318], blank) // volume set identifier
+	copy(b[318:446], blank) // publisher identifier
+	copy(b[446:574], blank) // data preparer identifier
+	copy(b[574:702], blank) // application identifier
+	copy(b[702:813], blank) // copyright, abstract and bibliographic file identifiers
+
+	t := p.now.UTC()
+	date := []byte(t.Format("20060102150405") + "00\x00")
+	noDate := []byte("0000000000000000\x00")
+	copy(b[813:], date)   // creation
+	copy(b[830:], date)   // modification
+	copy(b[847:], noDate) // expiration
+	copy(b[864:], noDate) // effective
+	b[881] = 1
+}
@@ -0,0 +1,130 @@
+package vz_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/Code-Hex/vz/v3"
+)
+
+func TestCreateCloudInitSeedISO(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "seed.iso")
+
+	userData := []byte("#cloud-config\npassword: passw0rd\nchpasswd: { expire: False }\n")
+	metaData := []byte("instance-id: iid-local01\nlocal-hostname: cloudimg\n")
+	networkConfig := []byte("version: 2\nethernets:\n  eth0:\n    dhcp4: true\n")
+	if err := vz.CreateCloudInitSeedISO(path, userData, metaData, networkConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	image, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(image)%2048 != 0 {
+		t.Fatalf("image size %d is not a multiple of the sector size", len(image))
+	}
+	sector := func(n uint32) []byte { return image[n*2048 : (n+1)*2048] }
+
+	pvd := sector(16)
+	if pvd[0] != 1 || string(pvd[1:6]) != "CD001" {
+		t.Fatalf("unexpected primary volume descriptor header: %q", pvd[:7])
+	}
+	if got := string(bytes.TrimRight(pvd[40:72], " ")); got != vz.CloudInitSeedVolumeLabel {
+		t.Fatalf("want volume label %q but got %q", vz.CloudInitSeedVolumeLabel, got)
+	}
+	if got := binary.LittleEndian.Uint32(pvd[80:]); int(got)*2048 != len(image) {
+		t.Fatalf("volume space size %d does not match the image size %d", got, len(image))
+	}
+
+	svd := sector(17)
+	if svd[0] != 2 || string(svd[1:6]) != "CD001" || string(svd[88:91]) != "%/E" {
+		t.Fatalf("unexpected joliet volume descriptor header: %q", svd[:7])
+	}
+	if got := decodeUCS2(bytes.TrimRight(svd[40:72], "\x00 ")); got != vz.CloudInitSeedVolumeLabel {
+		t.Fatalf("want joliet volume label %q but got %q", vz.CloudInitSeedVolumeLabel, got)
+	}
+
+	if terminator := sector(18); terminator[0] != 255 || string(terminator[1:6]) != "CD001" {
+		t.Fatalf("unexpected volume descriptor set terminator: %q", terminator[:7])
+	}
+
+	want := map[string][]byte{
+		"user-data":      userData,
+		"meta-data":      metaData,
+		"network-config": networkConfig,
+	}
+	got := readISORootDirectory(t, image, svd[156:190], decodeUCS2)
+	if len(got) != len(want) {
+		t.Fatalf("want %d files but got %d", len(want), len(got))
+	}
+	for name, data := range want {
+		if !bytes.Equal(got[name], data) {
+			t.Errorf("unexpected contents of %q: %q", name, got[name])
+		}
+	}
+
+	primary := readISORootDirectory(t, image, pvd[156:190], func(b []byte) string { return string(b) })
+	for _, name := range []string{"USER_DAT.;1", "META_DAT.;1", "NETWORK_.;1"} {
+		if _, ok := primary[name]; !ok {
+			t.Errorf("%q is not found in the primary volume", name)
+		}
+	}
+}
+
+func TestCreateCloudInitSeedISOWithoutNetworkConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "seed.iso")
+
+	if err := vz.CreateCloudInitSeedISO(path, []byte("#cloud-config\n"), []byte{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	image, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := readISORootDirectory(t, image, image[17*2048+156:17*2048+190], decodeUCS2)
+	if _, ok := got["network-config"]; ok {
+		t.Fatal("want network-config is omitted")
+	}
+	if _, ok := got["meta-data"]; !ok {
+		t.Fatal("want meta-data even if it is empty")
+	}
+
+	if err := vz.CreateCloudInitSeedISO(path, nil, nil, nil); !os.IsExist(err) {
+		t.Fatalf("want os.ErrExist but got %v", err)
+	}
+}
+
+func readISORootDirectory(t *testing.T, image, rootRecord []byte, decode func([]byte) string) map[string][]byte {
+	t.Helper()
+	extent := binary.LittleEndian.Uint32(rootRecord[2:])
+	size := binary.LittleEndian.Uint32(rootRecord[10:])
+	dir := image[extent*2048 : extent*2048+size]
+
+	files := make(map[string][]byte)
+	for off := 0; off < len(dir) && dir[off] != 0; off += int(dir[off]) {
+		record := dir[off : off+int(dir[off])]
+		id := record[33 : 33+int(record[32])]
+		if record[25]&2 != 0 {
+			continue // "." and ".."
+		}
+		fileExtent := binary.LittleEndian.Uint32(record[2:])
+		fileSize := binary.LittleEndian.Uint32(record[10:])
+		files[decode(id)] = image[fileExtent*2048 : fileExtent*2048+fileSize]
+	}
+	return files
+}
+
+func decodeUCS2(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.BigEndian.Uint16(b[i*2:])
+	}
+	return string(utf16.Decode(u))
+}
@@ -10,6 +10,11 @@ package vz
 */
 import "C"
 import (
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+
 	"github.com/Code-Hex/vz/v3/internal/objc"
 )
 
@@ -37,32 +42,61 @@ import (
 type VirtualMachineConfiguration struct {
 	cpuCount   uint
 	memorySize uint64
+	bootLoader BootLoader
 	*pointer
 
-	networkDeviceConfiguration []*VirtioNetworkDeviceConfiguration
-	storageDeviceConfiguration []StorageDeviceConfiguration
-	usbControllerConfiguration []USBControllerConfiguration
+	entropyDeviceConfiguration       []*VirtioEntropyDeviceConfiguration
+	memoryBalloonDeviceConfiguration []MemoryBalloonDeviceConfiguration
+	networkDeviceConfiguration       []*VirtioNetworkDeviceConfiguration
+	storageDeviceConfiguration       []StorageDeviceConfiguration
+	consoleDeviceConfiguration       []ConsoleDeviceConfiguration
+	usbControllerConfiguration       []USBControllerConfiguration
+	graphicsDeviceConfiguration      []GraphicsDeviceConfiguration
+	directorySharingConfiguration    []DirectorySharingDeviceConfiguration
+	platformConfiguration            PlatformConfiguration
 }
 
+// ErrMemorySizeNotAligned is returned by NewVirtualMachineConfiguration when the memory size
+// is not a multiple of VirtualMachineConfigurationMemorySizeAlignment.
+var ErrMemorySizeNotAligned = errors.New("memory size is not aligned")
+
+// ErrBootLoaderRequired is returned by NewVirtualMachineConfiguration when the boot loader is nil.
+var ErrBootLoaderRequired = errors.New("boot loader is required")
+
 // NewVirtualMachineConfiguration creates a new configuration.
 //
 //   - bootLoader parameter is used when the virtual machine starts.
+//     It is mandatory even if the virtual machine is only restored from a saved state
+//     (see RestoreVirtualMachine): the Virtualization framework rejects a configuration
+//     without a boot loader, and the saved state is only compatible with the configuration,
+//     including the boot loader and the platform, it was saved with.
 //   - cpu parameter is The number of CPUs must be a value between
 //     VZVirtualMachineConfiguration.minimumAllowedCPUCount and VZVirtualMachineConfiguration.maximumAllowedCPUCount.
 //   - memorySize parameter represents memory size in bytes.
 //     The memory size must be a multiple of a 1 megabyte (1024 * 1024 bytes) between
 //     VZVirtualMachineConfiguration.minimumAllowedMemorySize and VZVirtualMachineConfiguration.maximumAllowedMemorySize.
+//     An error which wraps ErrMemorySizeNotAligned is returned if it is not a multiple of
+//     VirtualMachineConfigurationMemorySizeAlignment, use AlignMemorySize to round it.
+//   - opts are applied to the new configuration, e.g. WithRecommendedDevices.
 //
 // This is only supported on macOS 11 and newer, error will
 // be returned on older versions.
-func NewVirtualMachineConfiguration(bootLoader BootLoader, cpu uint, memorySize uint64) (*VirtualMachineConfiguration, error) {
+func NewVirtualMachineConfiguration(bootLoader BootLoader, cpu uint, memorySize uint64, opts ...VirtualMachineConfigurationOption) (*VirtualMachineConfiguration, error) {
 	if err := macOSAvailable(11); err != nil {
 		return nil, err
 	}
+	if bootLoader == nil {
+		return nil, ErrBootLoaderRequired
+	}
+	if memorySize%memorySizeAlignment != 0 {
+		return nil, fmt.Errorf("%w: %d bytes is not a multiple of %d bytes",
+			ErrMemorySizeNotAligned, memorySize, uint64(memorySizeAlignment))
+	}
 
 	config := &VirtualMachineConfiguration{
 		cpuCount:   cpu,
 		memorySize: memorySize,
+		bootLoader: bootLoader,
 		pointer: objc.NewPointer(
 			C.newVZVirtualMachineConfiguration(
 				objc.Ptr(bootLoader),
@@ -74,9 +108,61 @@ func NewVirtualMachineConfiguration(bootLoader BootLoader, cpu uint, memorySize
 	objc.SetFinalizer(config, func(self *VirtualMachineConfiguration) {
 		objc.Release(self)
 	})
+	for _, opt := range opts {
+		if err := opt(config); err != nil {
+			return nil, err
+		}
+	}
 	return config, nil
 }
 
+// VirtualMachineConfigurationOption is an option for NewVirtualMachineConfiguration.
+type VirtualMachineConfigurationOption func(*VirtualMachineConfiguration) error
+
+// RecommendedConsolePortName is the name of the console port added by WithRecommendedDevices.
+const RecommendedConsolePortName = "console"
+
+// WithRecommendedDevices adds the devices most Linux guests want: a Virtio entropy device, a Virtio
+// traditional memory balloon device and, on macOS 13 and newer, a Virtio console device with a port
+// named RecommendedConsolePortName which is marked as the system console (hvc0). The port has no
+// attachment, attach it when the virtual machine runs with (*VirtualMachine).VirtioConsolePort.
+//
+// The devices replace the entropy, memory balloon and console devices of the configuration, so
+// append to EntropyDevices, MemoryBalloonDevices or ConsoleDevices to add more.
+func WithRecommendedDevices() VirtualMachineConfigurationOption {
+	return func(v *VirtualMachineConfiguration) error {
+		entropyDevice, err := NewVirtioEntropyDeviceConfiguration()
+		if err != nil {
+			return fmt.Errorf("failed to create entropy device: %w", err)
+		}
+		v.SetEntropyDevicesVirtualMachineConfiguration([]*VirtioEntropyDeviceConfiguration{entropyDevice})
+
+		memoryBalloonDevice, err := NewVirtioTraditionalMemoryBalloonDeviceConfiguration()
+		if err != nil {
+			return fmt.Errorf("failed to create memory balloon device: %w", err)
+		}
+		v.SetMemoryBalloonDevicesVirtualMachineConfiguration([]MemoryBalloonDeviceConfiguration{memoryBalloonDevice})
+
+		if err := macOSAvailable(13); err != nil {
+			return nil
+		}
+		consolePort, err := NewVirtioConsolePortConfiguration(
+			WithVirtioConsolePortConfigurationName(RecommendedConsolePortName),
+			WithVirtioConsolePortConfigurationIsConsole(true),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to create console port: %w", err)
+		}
+		consoleDevice, err := NewVirtioConsoleDeviceConfiguration()
+		if err != nil {
+			return fmt.Errorf("failed to create console device: %w", err)
+		}
+		consoleDevice.SetVirtioConsolePortConfiguration(0, consolePort)
+		v.SetConsoleDevicesVirtualMachineConfiguration([]ConsoleDeviceConfiguration{consoleDevice})
+		return nil
+	}
+}
+
 // Validate the configuration.
 //
 // Return true if the configuration is valid.
@@ -91,6 +177,25 @@ func (v *VirtualMachineConfiguration) Validate() (bool, error) {
 	return (bool)(ret), nil
 }
 
+// ErrInvalidPlatform is returned by ValidatePlatform when the platform configuration is invalid.
+var ErrInvalidPlatform = errors.New("invalid platform configuration")
+
+// ValidatePlatform checks the platform configuration and whether it matches the boot loader.
+//
+// Validate reports an invalid platform with the same generic error as an invalid device, so call
+// this first to tell them apart. The error wraps ErrInvalidPlatform and describes the problem,
+// e.g. a MacOSBootLoader without a MacPlatformConfiguration, or a MacPlatformConfiguration whose
+// hardware model is not supported by this host. A nil error does not guarantee that Validate succeeds.
+func (v *VirtualMachineConfiguration) ValidatePlatform() error {
+	if v.platformConfiguration == nil {
+		if isMacOSBootLoader(v.bootLoader) {
+			return fmt.Errorf("%w: MacOSBootLoader requires MacPlatformConfiguration", ErrInvalidPlatform)
+		}
+		return nil
+	}
+	return v.platformConfiguration.validatePlatform(v.bootLoader)
+}
+
 // SetEntropyDevicesVirtualMachineConfiguration sets list of entropy devices. Empty by default.
 func (v *VirtualMachineConfiguration) SetEntropyDevicesVirtualMachineConfiguration(cs []*VirtioEntropyDeviceConfiguration) {
 	ptrs := make([]objc.NSObject, len(cs))
@@ -99,6 +204,13 @@ func (v *VirtualMachineConfiguration) SetEntropyDevicesVirtualMachineConfigurati
 	}
 	array := objc.ConvertToNSMutableArray(ptrs)
 	C.setEntropyDevicesVZVirtualMachineConfiguration(objc.Ptr(v), objc.Ptr(array))
+	v.entropyDeviceConfiguration = cs
+}
+
+// EntropyDevices return the list of entropy device configuration set in this virtual machine configuration.
+// Return an empty array if no entropy device configuration is set.
+func (v *VirtualMachineConfiguration) EntropyDevices() []*VirtioEntropyDeviceConfiguration {
+	return v.entropyDeviceConfiguration
 }
 
 // SetMemoryBalloonDevicesVirtualMachineConfiguration sets list of memory balloon devices. Empty by default.
@@ -109,6 +221,13 @@ func (v *VirtualMachineConfiguration) SetMemoryBalloonDevicesVirtualMachineConfi
 	}
 	array := objc.ConvertToNSMutableArray(ptrs)
 	C.setMemoryBalloonDevicesVZVirtualMachineConfiguration(objc.Ptr(v), objc.Ptr(array))
+	v.memoryBalloonDeviceConfiguration = cs
+}
+
+// MemoryBalloonDevices return the list of memory balloon device configuration set in this virtual machine configuration.
+// Return an empty array if no memory balloon device configuration is set.
+func (v *VirtualMachineConfiguration) MemoryBalloonDevices() []MemoryBalloonDeviceConfiguration {
+	return v.memoryBalloonDeviceConfiguration
 }
 
 // SetNetworkDevicesVirtualMachineConfiguration sets list of network adapters. Empty by default.
@@ -192,6 +311,13 @@ func (v *VirtualMachineConfiguration) SetDirectorySharingDevicesVirtualMachineCo
 	}
 	array := objc.ConvertToNSMutableArray(ptrs)
 	C.setDirectorySharingDevicesVZVirtualMachineConfiguration(objc.Ptr(v), objc.Ptr(array))
+	v.directorySharingConfiguration = cs
+}
+
+// DirectorySharingDevices return the list of directory sharing device configuration configured in this virtual machine configuration.
+// Return an empty array if no directory sharing device configuration is set.
+func (v *VirtualMachineConfiguration) DirectorySharingDevices() []DirectorySharingDeviceConfiguration {
+	return v.directorySharingConfiguration
 }
 
 // SetPlatformVirtualMachineConfiguration sets the hardware platform to use. Defaults to GenericPlatformConfiguration.
@@ -202,9 +328,11 @@ func (v *VirtualMachineConfiguration) SetPlatformVirtualMachineConfiguration(c P
 		return
 	}
 	C.setPlatformVZVirtualMachineConfiguration(objc.Ptr(v), objc.Ptr(c))
+	v.platformConfiguration = c
 }
 
 // SetGraphicsDevicesVirtualMachineConfiguration sets list of graphics devices. Empty by default.
+// Passing an empty list configures a headless virtual machine, see CanUseVirtioGraphics.
 //
 // This is only supported on macOS 12 and newer. Older versions do nothing.
 func (v *VirtualMachineConfiguration) SetGraphicsDevicesVirtualMachineConfiguration(cs []GraphicsDeviceConfiguration) {
@@ -217,6 +345,13 @@ func (v *VirtualMachineConfiguration) SetGraphicsDevicesVirtualMachineConfigurat
 	}
 	array := objc.ConvertToNSMutableArray(ptrs)
 	C.setGraphicsDevicesVZVirtualMachineConfiguration(objc.Ptr(v), objc.Ptr(array))
+	v.graphicsDeviceConfiguration = cs
+}
+
+// GraphicsDevices return the list of graphics device configuration configured in this virtual machine configuration.
+// Return an empty array if no graphics device configuration is set.
+func (v *VirtualMachineConfiguration) GraphicsDevices() []GraphicsDeviceConfiguration {
+	return v.graphicsDeviceConfiguration
 }
 
 // SetPointingDevicesVirtualMachineConfiguration sets list of pointing devices. Empty by default.
@@ -277,6 +412,13 @@ func (v *VirtualMachineConfiguration) SetConsoleDevicesVirtualMachineConfigurati
 	}
 	array := objc.ConvertToNSMutableArray(ptrs)
 	C.setConsoleDevicesVZVirtualMachineConfiguration(objc.Ptr(v), objc.Ptr(array))
+	v.consoleDeviceConfiguration = cs
+}
+
+// ConsoleDevices return the list of console device configuration set in this virtual machine configuration.
+// Return an empty array if no console device configuration is set.
+func (v *VirtualMachineConfiguration) ConsoleDevices() []ConsoleDeviceConfiguration {
+	return v.consoleDeviceConfiguration
 }
 
 // SetUSBControllerConfiguration sets list of USB controllers. Empty by default.
@@ -313,6 +455,31 @@ func VirtualMachineConfigurationMaximumAllowedMemorySize() uint64 {
 	return uint64(C.maximumAllowedMemorySizeVZVirtualMachineConfiguration())
 }
 
+// memorySizeAlignment is the granularity of the memory size of a virtual machine, which the
+// Virtualization framework documents for VZVirtualMachineConfiguration.memorySize.
+const memorySizeAlignment = 1024 * 1024
+
+// VirtualMachineConfigurationMemorySizeAlignment returns the granularity in bytes of the memory size
+// of a virtual machine. The memory size must be a multiple of it, which is 1 MiB.
+func VirtualMachineConfigurationMemorySizeAlignment() uint64 {
+	return memorySizeAlignment
+}
+
+// AlignMemorySize rounds size down to a multiple of VirtualMachineConfigurationMemorySizeAlignment.
+// The result may be less than VirtualMachineConfigurationMinimumAllowedMemorySize.
+func AlignMemorySize(size uint64) uint64 {
+	return size - size%memorySizeAlignment
+}
+
+// CPUCount returns the number of CPUs of the configuration, as held by the Virtualization framework.
+//
+// The framework does not adjust the number of CPUs: a number outside of
+// VirtualMachineConfigurationMinimumAllowedCPUCount and VirtualMachineConfigurationMaximumAllowedCPUCount
+// fails Validate instead of being clamped, so this is the number passed to NewVirtualMachineConfiguration.
+func (v *VirtualMachineConfiguration) CPUCount() uint {
+	return uint(C.CPUCountVZVirtualMachineConfiguration(objc.Ptr(v)))
+}
+
 // VirtualMachineConfigurationMinimumAllowedCPUCount returns minimum
 // number of CPUs for a virtual machine.
 func VirtualMachineConfigurationMinimumAllowedCPUCount() uint {
@@ -324,3 +491,58 @@ func VirtualMachineConfigurationMinimumAllowedCPUCount() uint {
 func VirtualMachineConfigurationMaximumAllowedCPUCount() uint {
 	return uint(C.maximumAllowedCPUCountVZVirtualMachineConfiguration())
 }
+
+// RecommendedCPUCount returns the number of CPUs which is safe to assign to a virtual machine.
+//
+// It leaves one CPU to the host and the result is clamped between
+// VirtualMachineConfigurationMinimumAllowedCPUCount and VirtualMachineConfigurationMaximumAllowedCPUCount.
+func RecommendedCPUCount() uint {
+	return recommendedCPUCount(
+		runtime.NumCPU(),
+		VirtualMachineConfigurationMinimumAllowedCPUCount(),
+		VirtualMachineConfigurationMaximumAllowedCPUCount(),
+	)
+}
+
+// RecommendedMemorySize returns the memory size in bytes which is safe to assign to a virtual machine.
+//
+// The size is the fraction of the host physical memory. fraction is limited to between 0 and 1.
+// The result is rounded down to a multiple of 1 MiB and clamped between
+// VirtualMachineConfigurationMinimumAllowedMemorySize and VirtualMachineConfigurationMaximumAllowedMemorySize.
+func RecommendedMemorySize(fraction float64) uint64 {
+	return recommendedMemorySize(
+		uint64(C.hostPhysicalMemorySize()),
+		fraction,
+		VirtualMachineConfigurationMinimumAllowedMemorySize(),
+		VirtualMachineConfigurationMaximumAllowedMemorySize(),
+	)
+}
+
+func recommendedCPUCount(hostCPUs int, minAllowed, maxAllowed uint) uint {
+	count := uint(1)
+	if hostCPUs > 2 {
+		count = uint(hostCPUs - 1)
+	}
+	return clamp(count, minAllowed, maxAllowed)
+}
+
+func recommendedMemorySize(hostMemory uint64, fraction float64, minAllowed, maxAllowed uint64) uint64 {
+	if math.IsNaN(fraction) || fraction < 0 {
+		fraction = 0
+	}
+	if fraction > 1 {
+		fraction = 1
+	}
+	size := AlignMemorySize(uint64(float64(hostMemory) * fraction))
+	return clamp(size, minAllowed, maxAllowed)
+}
+
+func clamp[T uint | uint64](v, minAllowed, maxAllowed T) T {
+	if v > maxAllowed {
+		v = maxAllowed
+	}
+	if v < minAllowed {
+		v = minAllowed
+	}
+	return v
+}
@@ -0,0 +1,255 @@
+package vz_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/Code-Hex/vz/v3"
+)
+
+func TestRecommendedCPUCount(t *testing.T) {
+	cases := []struct {
+		name       string
+		hostCPUs   int
+		minAllowed uint
+		maxAllowed uint
+		want       uint
+	}{
+		{name: "leaves one CPU to the host", hostCPUs: 8, minAllowed: 1, maxAllowed: 64, want: 7},
+		{name: "single CPU host", hostCPUs: 1, minAllowed: 1, maxAllowed: 64, want: 1},
+		{name: "clamped to maximum", hostCPUs: 128, minAllowed: 1, maxAllowed: 64, want: 64},
+		{name: "clamped to minimum", hostCPUs: 2, minAllowed: 2, maxAllowed: 64, want: 2},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := vz.RecommendedCPUCountFor(tc.hostCPUs, tc.minAllowed, tc.maxAllowed)
+			if got != tc.want {
+				t.Fatalf("want %d but got %d", tc.want, got)
+			}
+		})
+	}
+
+	got := vz.RecommendedCPUCount()
+	if min := vz.VirtualMachineConfigurationMinimumAllowedCPUCount(); got < min {
+		t.Fatalf("%d is less than the minimum allowed CPU count %d", got, min)
+	}
+	if max := vz.VirtualMachineConfigurationMaximumAllowedCPUCount(); got > max {
+		t.Fatalf("%d is greater than the maximum allowed CPU count %d", got, max)
+	}
+}
+
+func TestVirtualMachineConfigurationCPUCount(t *testing.T) {
+	bootLoader, err := vz.NewLinuxBootLoader("./testdata/Image")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := vz.RecommendedCPUCount()
+	config, err := vz.NewVirtualMachineConfiguration(bootLoader, want, 256*1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := config.CPUCount(); got != want {
+		t.Fatalf("want %d CPUs but got %d", want, got)
+	}
+
+	// The framework rejects a number of CPUs out of range rather than clamping it.
+	tooMany := vz.VirtualMachineConfigurationMaximumAllowedCPUCount() + 1
+	config, err = vz.NewVirtualMachineConfiguration(bootLoader, tooMany, 256*1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := config.CPUCount(); got != tooMany {
+		t.Fatalf("want %d CPUs but got %d", tooMany, got)
+	}
+	if validated, err := config.Validate(); validated || err == nil {
+		t.Fatalf("want validation to fail for %d CPUs", tooMany)
+	}
+}
+
+func TestRecommendedMemorySize(t *testing.T) {
+	const (
+		mib = 1024 * 1024
+		gib = 1024 * mib
+	)
+	cases := []struct {
+		name       string
+		hostMemory uint64
+		fraction   float64
+		minAllowed uint64
+		maxAllowed uint64
+		want       uint64
+	}{
+		{name: "fraction of the host", hostMemory: 16 * gib, fraction: 0.25, minAllowed: 128 * mib, maxAllowed: 64 * gib, want: 4 * gib},
+		{name: "rounded down to MiB", hostMemory: 16*gib + 3, fraction: 0.5, minAllowed: 128 * mib, maxAllowed: 64 * gib, want: 8 * gib},
+		{name: "clamped to maximum", hostMemory: 128 * gib, fraction: 1, minAllowed: 128 * mib, maxAllowed: 64 * gib, want: 64 * gib},
+		{name: "clamped to minimum", hostMemory: 16 * gib, fraction: 0.001, minAllowed: 128 * mib, maxAllowed: 64 * gib, want: 128 * mib},
+		{name: "negative fraction", hostMemory: 16 * gib, fraction: -1, minAllowed: 128 * mib, maxAllowed: 64 * gib, want: 128 * mib},
+		{name: "NaN fraction", hostMemory: 16 * gib, fraction: math.NaN(), minAllowed: 128 * mib, maxAllowed: 64 * gib, want: 128 * mib},
+		{name: "fraction greater than 1", hostMemory: 16 * gib, fraction: 2, minAllowed: 128 * mib, maxAllowed: 64 * gib, want: 16 * gib},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := vz.RecommendedMemorySizeFor(tc.hostMemory, tc.fraction, tc.minAllowed, tc.maxAllowed)
+			if got != tc.want {
+				t.Fatalf("want %d but got %d", tc.want, got)
+			}
+		})
+	}
+
+	got := vz.RecommendedMemorySize(0.25)
+	if min := vz.VirtualMachineConfigurationMinimumAllowedMemorySize(); got < min {
+		t.Fatalf("%d is less than the minimum allowed memory size %d", got, min)
+	}
+	if max := vz.VirtualMachineConfigurationMaximumAllowedMemorySize(); got > max {
+		t.Fatalf("%d is greater than the maximum allowed memory size %d", got, max)
+	}
+}
+
+func TestValidatePlatformGeneric(t *testing.T) {
+	if vz.Available(12) {
+		t.Skip("GenericPlatformConfiguration is supported from macOS 12")
+	}
+	bootLoader, err := vz.NewLinuxBootLoader("./testdata/Image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := vz.NewVirtualMachineConfiguration(bootLoader, 1, 256*1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.ValidatePlatform(); err != nil {
+		t.Fatalf("want no error without platform but got %v", err)
+	}
+	platformConfig, err := vz.NewGenericPlatformConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.SetPlatformVirtualMachineConfiguration(platformConfig)
+	if err := config.ValidatePlatform(); err != nil {
+		t.Fatalf("want no error for the generic platform but got %v", err)
+	}
+}
+
+func TestNewVirtualMachineConfigurationWithoutBootLoader(t *testing.T) {
+	if vz.Available(11) {
+		t.Skip("NewVirtualMachineConfiguration is supported from macOS 11")
+	}
+	_, err := vz.NewVirtualMachineConfiguration(nil, 1, 512*1024*1024)
+	if !errors.Is(err, vz.ErrBootLoaderRequired) {
+		t.Fatalf("want ErrBootLoaderRequired but got %v", err)
+	}
+}
+
+func TestAlignMemorySize(t *testing.T) {
+	const mib = 1024 * 1024
+	if got := vz.VirtualMachineConfigurationMemorySizeAlignment(); got != mib {
+		t.Fatalf("want alignment of %d but got %d", mib, got)
+	}
+	cases := []struct {
+		size uint64
+		want uint64
+	}{
+		{size: 0, want: 0},
+		{size: mib - 1, want: 0},
+		{size: 512 * mib, want: 512 * mib},
+		{size: 512*mib + 1, want: 512 * mib},
+		{size: 513*mib - 1, want: 512 * mib},
+	}
+	for _, tc := range cases {
+		if got := vz.AlignMemorySize(tc.size); got != tc.want {
+			t.Errorf("AlignMemorySize(%d): want %d but got %d", tc.size, tc.want, got)
+		}
+	}
+}
+
+func TestNewVirtualMachineConfigurationUnalignedMemorySize(t *testing.T) {
+	if vz.Available(11) {
+		t.Skip("NewVirtualMachineConfiguration is supported from macOS 11")
+	}
+	bootLoader, err := vz.NewLinuxBootLoader("./testdata/Image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const size = 512*1024*1024 + 4096
+	if _, err := vz.NewVirtualMachineConfiguration(bootLoader, 1, size); !errors.Is(err, vz.ErrMemorySizeNotAligned) {
+		t.Fatalf("want ErrMemorySizeNotAligned but got %v", err)
+	}
+	config, err := vz.NewVirtualMachineConfiguration(bootLoader, 1, vz.AlignMemorySize(size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := config.Validate(); !ok || err != nil {
+		t.Fatalf("want the aligned memory size to validate but got %v, %v", ok, err)
+	}
+}
+
+func TestWithRecommendedDevices(t *testing.T) {
+	if vz.Available(11) {
+		t.Skip("NewVirtualMachineConfiguration is supported from macOS 11")
+	}
+	bootLoader, err := vz.NewLinuxBootLoader("./testdata/Image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := vz.NewVirtualMachineConfiguration(bootLoader, 1, 256*1024*1024, vz.WithRecommendedDevices())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(config.EntropyDevices()); got != 1 {
+		t.Errorf("want 1 entropy device but got %d", got)
+	}
+	if got := len(config.MemoryBalloonDevices()); got != 1 {
+		t.Errorf("want 1 memory balloon device but got %d", got)
+	}
+	wantConsoles := 1
+	if vz.Available(13) {
+		wantConsoles = 0
+	}
+	if got := len(config.ConsoleDevices()); got != wantConsoles {
+		t.Errorf("want %d console devices but got %d", wantConsoles, got)
+	}
+	if ok, err := config.Validate(); !ok || err != nil {
+		t.Fatalf("want the configuration to validate but got %v, %v", ok, err)
+	}
+}
+
+func TestNestedVirtualization(t *testing.T) {
+	if vz.Available(12) {
+		t.Skip("GenericPlatformConfiguration is supported from macOS 12")
+	}
+	if _, err := vz.NewGenericPlatformConfiguration(vz.WithNestedVirtualization(false)); err != nil {
+		t.Fatalf("want disabling nested virtualization to succeed but got %v", err)
+	}
+
+	platformConfig, err := vz.NewGenericPlatformConfiguration(vz.WithNestedVirtualization(true))
+	if !vz.IsNestedVirtualizationSupported() {
+		if err == nil {
+			t.Fatal("want error for enabling nested virtualization on a host which does not support it")
+		}
+		if !vz.Available(15) && !errors.Is(err, vz.ErrNestedVirtualizationUnsupported) {
+			t.Fatalf("want ErrNestedVirtualizationUnsupported but got %v", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !platformConfig.NestedVirtualizationEnabled() {
+		t.Fatal("want nested virtualization enabled")
+	}
+
+	bootLoader, err := vz.NewLinuxBootLoader("./testdata/Image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := vz.NewVirtualMachineConfiguration(bootLoader, 1, 256*1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.SetPlatformVirtualMachineConfiguration(platformConfig)
+	if ok, err := config.Validate(); !ok || err != nil {
+		t.Fatalf("want the configuration with nested virtualization to validate but got %v, %v", ok, err)
+	}
+}
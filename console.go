@@ -7,6 +7,14 @@ package vz
 */
 import "C"
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime/cgo"
+	"sync"
+	"syscall"
 	"unsafe"
 
 	"github.com/Code-Hex/vz/v3/internal/objc"
@@ -117,6 +125,10 @@ func WithVirtioConsolePortConfigurationName(name string) NewVirtioConsolePortCon
 
 // WithVirtioConsolePortConfigurationIsConsole sets the console port may be marked
 // for use as the system console. The default is false.
+//
+// The flag designates the primary console port of the device: the guest may use the port
+// which has it for the messages of its kernel and a login prompt, and treat the others as
+// plain data channels (e.g. the SPICE agent). Set it on at most one port of a device.
 func WithVirtioConsolePortConfigurationIsConsole(isConsole bool) NewVirtioConsolePortConfigurationOption {
 	return func(vcpc *VirtioConsolePortConfiguration) {
 		C.setIsConsoleVZVirtioConsolePortConfiguration(
@@ -171,3 +183,186 @@ func (v *VirtioConsolePortConfiguration) IsConsole() bool { return v.isConsole }
 func (v *VirtioConsolePortConfiguration) Attachment() SerialPortAttachment {
 	return v.attachment
 }
+
+// consolePortState tracks the console ports which are opened by the guest.
+type consolePortState struct {
+	mu   sync.Mutex
+	open map[string]bool
+	// changed is closed and replaced whenever a console port is opened or closed.
+	changed chan struct{}
+}
+
+func newConsolePortState() *consolePortState {
+	return &consolePortState{
+		open:    make(map[string]bool),
+		changed: make(chan struct{}),
+	}
+}
+
+func (s *consolePortState) set(name string, open bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.open[name] = open
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// wait waits until the console port of the name is opened by the guest.
+func (s *consolePortState) wait(ctx context.Context, name string) error {
+	for {
+		s.mu.Lock()
+		open, changed := s.open[name], s.changed
+		s.mu.Unlock()
+		if open {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+//export consolePortDidChangeHandler
+func consolePortDidChangeHandler(cgoHandleUintptr C.uintptr_t, portName *C.char, open C.bool) {
+	cgoHandle := cgo.Handle(cgoHandleUintptr)
+	state := cgoHandle.Value().(*consolePortState)
+	state.set(C.GoString(portName), bool(open))
+}
+
+// WaitForSpiceAgent blocks until the Spice agent running in the guest opens the Spice agent
+// console port, or ctx is done. The port must be configured with a VirtioConsolePortConfiguration
+// named SpiceAgentPortAttachmentName which has a SpiceAgentPortAttachment.
+//
+// Once this returns nil, the guest tools are up and features such as clipboard sharing work.
+//
+// This is only supported on macOS 13 and newer, error will be returned on older versions.
+func (v *VirtualMachine) WaitForSpiceAgent(ctx context.Context) error {
+	if err := macOSAvailable(13); err != nil {
+		return err
+	}
+	name, err := SpiceAgentPortAttachmentName()
+	if err != nil {
+		return err
+	}
+	return v.consolePorts.wait(ctx, name)
+}
+
+// ErrConsolePortNotFound is returned when the virtual machine has no console port of the given name.
+var ErrConsolePortNotFound = errors.New("console port not found")
+
+// VirtioConsolePort is a port of a Virtio console device of a running virtual machine.
+// see: https://developer.apple.com/documentation/virtualization/vzvirtioconsoleport?language=objc
+type VirtioConsolePort struct {
+	*pointer
+
+	dispatchQueue unsafe.Pointer
+	name          string
+}
+
+// VirtioConsolePort returns the port of the Virtio console devices which is configured with
+// the name (see WithVirtioConsolePortConfigurationName). ErrConsolePortNotFound is returned
+// if there is no such port.
+//
+// This is only supported on macOS 13 and newer, error will be returned on older versions.
+func (v *VirtualMachine) VirtioConsolePort(name string) (*VirtioConsolePort, error) {
+	if err := macOSAvailable(13); err != nil {
+		return nil, err
+	}
+	cs := charWithGoString(name)
+	defer cs.Free()
+	ptr := C.VZVirtualMachine_virtioConsolePortNamed(objc.Ptr(v), v.dispatchQueue, cs.CString())
+	if ptr == nil {
+		return nil, fmt.Errorf("%w: %q", ErrConsolePortNotFound, name)
+	}
+	port := &VirtioConsolePort{
+		pointer:       objc.NewPointer(ptr),
+		dispatchQueue: v.dispatchQueue,
+		name:          name,
+	}
+	objc.SetFinalizer(port, func(self *VirtioConsolePort) {
+		objc.Release(self)
+	})
+	return port, nil
+}
+
+// Name returns the name of the console port.
+func (p *VirtioConsolePort) Name() string { return p.name }
+
+// SetAttachment replaces the attachment of the console port while the virtual machine is running.
+// A nil attachment detaches the port.
+func (p *VirtioConsolePort) SetAttachment(attachment SerialPortAttachment) {
+	var ptr unsafe.Pointer
+	if attachment != nil {
+		ptr = objc.Ptr(attachment)
+	}
+	C.VZVirtioConsolePort_setAttachment(objc.Ptr(p), p.dispatchQueue, ptr)
+}
+
+// Pipe attaches the console port to one end of an internal socket pair and returns the other end,
+// so the guest can be driven from Go: data written to the returned pipe is sent to the guest,
+// and data sent by the guest is read from it. Any existing attachment of the port is replaced.
+//
+// Closing the pipe detaches the console port and releases both ends of the socket pair.
+func (p *VirtioConsolePort) Pipe() (io.ReadWriteCloser, error) {
+	pipe, guest, err := newConsolePortPipe(func() { p.SetAttachment(nil) })
+	if err != nil {
+		return nil, err
+	}
+	attachment, err := NewFileHandleSerialPortAttachment(guest, guest)
+	if err != nil {
+		pipe.Close()
+		return nil, err
+	}
+	p.SetAttachment(attachment)
+	return pipe, nil
+}
+
+// consolePortPipe is the host end of the socket pair created by (*VirtioConsolePort).Pipe.
+type consolePortPipe struct {
+	*os.File
+
+	// guest is the end of the socket pair which is handed to the virtual machine.
+	// The file handle attachment does not own it, so it is closed together with the pipe.
+	guest     *os.File
+	detach    func()
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// newConsolePortPipe creates a connected pair of stream sockets. detach is called on Close
+// before the sockets are closed, so the virtual machine stops using the guest end first.
+func newConsolePortPipe(detach func()) (*consolePortPipe, *os.File, error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create a socket pair: %w", err)
+	}
+	syscall.CloseOnExec(fds[0])
+	syscall.CloseOnExec(fds[1])
+	// The host end is non-blocking so that it uses the runtime poller, which lets
+	// Close interrupt pending reads.
+	if err := syscall.SetNonblock(fds[0], true); err != nil {
+		syscall.Close(fds[0])
+		syscall.Close(fds[1])
+		return nil, nil, fmt.Errorf("failed to set the socket non-blocking: %w", err)
+	}
+	guest := os.NewFile(uintptr(fds[1]), "console-port-guest")
+	pipe := &consolePortPipe{
+		File:   os.NewFile(uintptr(fds[0]), "console-port"),
+		guest:  guest,
+		detach: detach,
+	}
+	return pipe, guest, nil
+}
+
+// Close detaches the console port and closes both ends of the socket pair.
+func (c *consolePortPipe) Close() error {
+	c.closeOnce.Do(func() {
+		if c.detach != nil {
+			c.detach()
+		}
+		c.closeErr = errors.Join(c.File.Close(), c.guest.Close())
+	})
+	return c.closeErr
+}
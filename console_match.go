@@ -0,0 +1,76 @@
+package vz
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+)
+
+// ConsoleMatcher detects when the console output of the guest contains a pattern.
+//
+// The Virtualization framework does not report the boot progress of the guest, so readiness
+// (e.g. macOS Recovery or a Linux guest being interactive) can only be detected from what the
+// guest writes to a console. Write the console output to a ConsoleMatcher, e.g. with io.TeeReader
+// or io.MultiWriter over the host end of a serial port attachment or (*VirtioConsolePort).Pipe,
+// and wait for a string which the guest prints once it is ready, such as a login or shell prompt.
+//
+// The pattern is matched as is, even if it is split across writes, so it may also be a prompt
+// which is not followed by a newline.
+type ConsoleMatcher struct {
+	pattern []byte
+
+	mu      sync.Mutex
+	tail    []byte
+	matched chan struct{}
+	done    bool
+}
+
+// NewConsoleMatcher creates a new ConsoleMatcher which matches pattern.
+func NewConsoleMatcher(pattern string) (*ConsoleMatcher, error) {
+	if pattern == "" {
+		return nil, errors.New("console pattern must not be empty")
+	}
+	return &ConsoleMatcher{
+		pattern: []byte(pattern),
+		matched: make(chan struct{}),
+	}, nil
+}
+
+// Write looks for the pattern in p and the end of the previous writes. It never fails.
+func (m *ConsoleMatcher) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.done {
+		return len(p), nil
+	}
+	buf := append(m.tail, p...)
+	if bytes.Contains(buf, m.pattern) {
+		m.done = true
+		m.tail = nil
+		close(m.matched)
+		return len(p), nil
+	}
+	// Keep just enough of the output to find the pattern split across writes.
+	keep := len(m.pattern) - 1
+	if len(buf) > keep {
+		buf = buf[len(buf)-keep:]
+	}
+	m.tail = append(m.tail[:0], buf...)
+	return len(p), nil
+}
+
+// Matched returns a channel which is closed once the pattern has been written.
+func (m *ConsoleMatcher) Matched() <-chan struct{} {
+	return m.matched
+}
+
+// Wait blocks until the pattern has been written or ctx is done.
+func (m *ConsoleMatcher) Wait(ctx context.Context) error {
+	select {
+	case <-m.matched:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,82 @@
+package vz_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Code-Hex/vz/v3"
+)
+
+func TestConsoleMatcher(t *testing.T) {
+	cases := []struct {
+		name    string
+		writes  []string
+		matched bool
+	}{
+		{name: "single write", writes: []string{"Welcome\nlogin: "}, matched: true},
+		{name: "split across writes", writes: []string{"Welcome\nlo", "g", "in: "}, matched: true},
+		{name: "after long output", writes: []string{strings.Repeat("x", 4096), "login: "}, matched: true},
+		{name: "not written", writes: []string{"Welcome\n", "loading..."}, matched: false},
+		{name: "only a prefix", writes: []string{"login"}, matched: false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := vz.NewConsoleMatcher("login: ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, w := range tc.writes {
+				n, err := m.Write([]byte(w))
+				if err != nil || n != len(w) {
+					t.Fatalf("want %d bytes written but got %d, %v", len(w), n, err)
+				}
+			}
+			select {
+			case <-m.Matched():
+				if !tc.matched {
+					t.Fatal("want no match")
+				}
+			default:
+				if tc.matched {
+					t.Fatal("want match")
+				}
+			}
+		})
+	}
+}
+
+func TestConsoleMatcherWait(t *testing.T) {
+	m, err := vz.NewConsoleMatcher("-bash-3.2# ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := m.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want context.DeadlineExceeded but got %v", err)
+	}
+
+	// The console output is usually teed to the matcher.
+	console := io.TeeReader(strings.NewReader("Recovery\n-bash-3.2# "), m)
+	if _, err := io.Copy(io.Discard, console); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// Writes after the match are accepted.
+	if _, err := m.Write([]byte("more")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewConsoleMatcherEmpty(t *testing.T) {
+	if _, err := vz.NewConsoleMatcher(""); err == nil {
+		t.Fatal("want error for empty pattern")
+	}
+}
@@ -0,0 +1,475 @@
+package vz_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Code-Hex/vz/v3"
+)
+
+func TestConsolePortStateWait(t *testing.T) {
+	const portName = "com.redhat.spice.0"
+
+	t.Run("opened after wait", func(t *testing.T) {
+		state := vz.NewConsolePortState()
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- state.Wait(context.Background(), portName)
+		}()
+
+		state.Set("other", true)
+		select {
+		case err := <-errCh:
+			t.Fatalf("want waiting until %q is opened but returned %v", portName, err)
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		state.Set(portName, true)
+		select {
+		case err := <-errCh:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for the console port")
+		}
+	})
+
+	t.Run("already opened", func(t *testing.T) {
+		state := vz.NewConsolePortState()
+		state.Set(portName, true)
+		if err := state.Wait(context.Background(), portName); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		state := vz.NewConsolePortState()
+		state.Set(portName, true)
+		state.Set(portName, false)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		if err := state.Wait(ctx, portName); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("want context.DeadlineExceeded but got %v", err)
+		}
+	})
+}
+
+func TestWaitForSpiceAgentWithoutAgent(t *testing.T) {
+	if vz.Available(13) {
+		t.Skip("WaitForSpiceAgent is supported from macOS 13")
+	}
+	container := newVirtualizationMachine(t)
+	t.Cleanup(func() {
+		if err := container.Shutdown(); err != nil {
+			t.Log(err)
+		}
+	})
+
+	// The test guest has no Spice agent port.
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if err := container.WaitForSpiceAgent(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want context.DeadlineExceeded but got %v", err)
+	}
+}
+
+func TestNewStdioSerialPortAttachment(t *testing.T) {
+	attachment, restore, err := vz.NewStdioSerialPortAttachment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attachment == nil {
+		t.Fatal("want attachment")
+	}
+	// restore can be called more than once.
+	for i := 0; i < 2; i++ {
+		if err := restore(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestNewPTYSerialPortAttachment(t *testing.T) {
+	attachment, path, err := vz.NewPTYSerialPortAttachment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attachment == nil {
+		t.Fatal("want attachment")
+	}
+	if !strings.HasPrefix(path, "/dev/") {
+		t.Fatalf("want a terminal device path but got %q", path)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Fatalf("failed to open the terminal device: %v", err)
+	}
+	f.Close()
+}
+
+func TestConsolePortPipe(t *testing.T) {
+	detached := 0
+	pipe, guest, err := vz.NewConsolePortPipe(func() { detached++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// host -> guest
+	if _, err := io.WriteString(pipe, "ls\n"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(guest, buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf); got != "ls\n" {
+		t.Fatalf("guest read %q, want %q", got, "ls\n")
+	}
+
+	// guest -> host
+	const output = "bin  etc  usr\n"
+	if _, err := io.WriteString(guest, output); err != nil {
+		t.Fatal(err)
+	}
+	buf = make([]byte, len(output))
+	if _, err := io.ReadFull(pipe, buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf); got != output {
+		t.Fatalf("host read %q, want %q", got, output)
+	}
+
+	// A pending read is interrupted by Close.
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := pipe.Read(make([]byte, 1))
+		readErr <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	if err := pipe.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pipe.Close(); err != nil {
+		t.Fatalf("second close: %v", err)
+	}
+	if detached != 1 {
+		t.Fatalf("detach was called %d times, want 1", detached)
+	}
+	select {
+	case err := <-readErr:
+		if !errors.Is(err, os.ErrClosed) {
+			t.Fatalf("want os.ErrClosed but got %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("read was not interrupted by close")
+	}
+	if _, err := guest.Write([]byte("x")); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("guest end should be closed, got %v", err)
+	}
+}
+
+func TestVirtioConsolePortConfigurationIsConsole(t *testing.T) {
+	if vz.Available(13) {
+		t.Skip("VirtioConsolePortConfiguration is supported from macOS 13")
+	}
+
+	port, err := vz.NewVirtioConsolePortConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port.IsConsole() {
+		t.Fatal("want a port which is not the console by default")
+	}
+
+	for _, isConsole := range []bool{true, false} {
+		port, err := vz.NewVirtioConsolePortConfiguration(
+			vz.WithVirtioConsolePortConfigurationName("console"),
+			vz.WithVirtioConsolePortConfigurationIsConsole(isConsole),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := port.IsConsole(); got != isConsole {
+			t.Errorf("want IsConsole %v but got %v", isConsole, got)
+		}
+		if got := port.Name(); got != "console" {
+			t.Errorf("want name %q but got %q", "console", got)
+		}
+	}
+
+	// The last option wins.
+	port, err = vz.NewVirtioConsolePortConfiguration(
+		vz.WithVirtioConsolePortConfigurationIsConsole(true),
+		vz.WithVirtioConsolePortConfigurationIsConsole(false),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port.IsConsole() {
+		t.Error("want the last IsConsole option to win")
+	}
+}
+
+func TestVirtioConsolePortNotFound(t *testing.T) {
+	if vz.Available(13) {
+		t.Skip("VirtioConsolePort is supported from macOS 13")
+	}
+	bootLoader, err := vz.NewLinuxBootLoader("./testdata/Image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := setupConfiguration(bootLoader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, err := vz.NewVirtualMachine(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vm.VirtioConsolePort("no-such-port"); !errors.Is(err, vz.ErrConsolePortNotFound) {
+		t.Fatalf("want ErrConsolePortNotFound but got %v", err)
+	}
+}
+
+func TestRingBufferTail(t *testing.T) {
+	r := vz.NewRingBuffer(8)
+	if got := r.Tail(4); len(got) != 0 {
+		t.Fatalf("want empty tail but got %q", got)
+	}
+
+	cases := []struct {
+		write string
+		n     int
+		want  string
+	}{
+		{write: "abc", n: 2, want: "bc"},
+		{write: "", n: 10, want: "abc"},
+		{write: "defgh", n: 8, want: "abcdefgh"},
+		// wraps around
+		{write: "ij", n: 8, want: "cdefghij"},
+		{write: "", n: 3, want: "hij"},
+		{write: "", n: 0, want: ""},
+		{write: "", n: -1, want: ""},
+		// longer than the buffer
+		{write: "0123456789", n: 100, want: "23456789"},
+		{write: "x", n: 4, want: "789x"},
+	}
+	for _, tc := range cases {
+		r.Write([]byte(tc.write))
+		if got := string(r.Tail(tc.n)); got != tc.want {
+			t.Fatalf("after writing %q, Tail(%d) = %q, want %q", tc.write, tc.n, got, tc.want)
+		}
+	}
+
+	// Tail returns a copy.
+	got := r.Tail(1)
+	got[0] = '!'
+	if got := string(r.Tail(1)); got != "x" {
+		t.Fatalf("want %q but got %q", "x", got)
+	}
+}
+
+func TestBufferedSerialPortAttachment(t *testing.T) {
+	if _, err := vz.NewBufferedSerialPortAttachment(0); err == nil {
+		t.Fatal("want error for zero buffer size")
+	}
+
+	attachment, err := vz.NewBufferedSerialPortAttachment(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	guestInput, guestOutput := attachment.GuestIO()
+
+	// guest -> host is kept in the buffer without anyone reading it.
+	if _, err := io.WriteString(guestOutput, "[    0.000000] Booting Linux\n"); err != nil {
+		t.Fatal(err)
+	}
+	waitTail(t, attachment, "Booting Linux\n")
+
+	// host -> guest
+	if _, err := attachment.Write([]byte("root\n")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(guestInput, buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf); got != "root\n" {
+		t.Fatalf("guest read %q, want %q", got, "root\n")
+	}
+
+	if err := attachment.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := attachment.Close(); err != nil {
+		t.Fatalf("second close: %v", err)
+	}
+	if got := string(attachment.Tail(100)); got != "] Booting Linux\n" {
+		t.Fatalf("want the buffered output kept after close but got %q", got)
+	}
+}
+
+func TestCreateConsoleWindowGuard(t *testing.T) {
+	if vz.Available(12) {
+		t.Skip("CreateConsoleWindow is supported from macOS 12")
+	}
+	console, err := vz.NewBufferedSerialPortAttachment(1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer console.Close()
+
+	if err := vz.CreateConsoleWindow(nil, 640, 480); err == nil {
+		t.Error("want error for nil console")
+	}
+	if err := vz.CreateConsoleWindow(console, 0, 480); err == nil {
+		t.Error("want error for zero width")
+	}
+	if err := vz.CreateConsoleWindow(console, 640, -1); err == nil {
+		t.Error("want error for negative height")
+	}
+
+	// A console which already has a window is rejected before a window is created.
+	detach, err := console.AttachConsoleWindow(func([]byte) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vz.CreateConsoleWindow(console, 640, 480); !errors.Is(err, vz.ErrConsoleWindowExists) {
+		t.Fatalf("want ErrConsoleWindowExists but got %v", err)
+	}
+	detach()
+}
+
+func TestConsoleWindowOutput(t *testing.T) {
+	console, err := vz.NewBufferedSerialPortAttachment(1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer console.Close()
+	_, guestOutput := console.GuestIO()
+
+	if _, err := io.WriteString(guestOutput, "\x1b[0;32mOK\x1b[0m\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	waitTail(t, console, "OK\x1b[0m\r\n")
+
+	texts := make(chan string, 10)
+	detach, err := console.AttachConsoleWindow(func(text []byte) { texts <- string(text) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The window starts with the buffered output.
+	if got := receiveText(t, texts); got != "OK\n" {
+		t.Fatalf("want buffered output %q but got %q", "OK\n", got)
+	}
+	if _, err := console.AttachConsoleWindow(func([]byte) {}); !errors.Is(err, vz.ErrConsoleWindowExists) {
+		t.Fatalf("want ErrConsoleWindowExists but got %v", err)
+	}
+
+	// Then it follows the output.
+	if _, err := io.WriteString(guestOutput, "login: "); err != nil {
+		t.Fatal(err)
+	}
+	if got := receiveText(t, texts); got != "login: " {
+		t.Fatalf("want %q but got %q", "login: ", got)
+	}
+
+	// Once the window is closed, another one can be attached.
+	detach()
+	if _, err := io.WriteString(guestOutput, "root\n"); err != nil {
+		t.Fatal(err)
+	}
+	waitTail(t, console, "login: root\n")
+	select {
+	case got := <-texts:
+		t.Fatalf("want no output after detach but got %q", got)
+	default:
+	}
+	detach, err = console.AttachConsoleWindow(func(text []byte) { texts <- string(text) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer detach()
+	if got := receiveText(t, texts); got != "OK\nlogin: root\n" {
+		t.Fatalf("want buffered output %q but got %q", "OK\nlogin: root\n", got)
+	}
+}
+
+func waitTail(t *testing.T, console *vz.BufferedSerialPortAttachment, want string) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for string(console.Tail(len(want))) != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("want tail %q but got %q", want, console.Tail(len(want)))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func receiveText(t *testing.T, texts <-chan string) string {
+	t.Helper()
+	select {
+	case text := <-texts:
+		return text
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for console window text")
+		return ""
+	}
+}
+
+func TestFilterConsoleText(t *testing.T) {
+	cases := []struct {
+		name   string
+		chunks []string
+		want   []string
+	}{
+		{
+			name:   "plain",
+			chunks: []string{"hello\tworld\n"},
+			want:   []string{"hello\tworld\n"},
+		},
+		{
+			name:   "carriage return and control characters",
+			chunks: []string{"a\r\nb\x07\x08 \x08c\x7f\n"},
+			want:   []string{"a\nb c\n"},
+		},
+		{
+			name:   "color",
+			chunks: []string{"\x1b[1;31mFAILED\x1b[0m\n"},
+			want:   []string{"FAILED\n"},
+		},
+		{
+			name:   "escape sequence split between writes",
+			chunks: []string{"[  \x1b[0;3", "2mOK  \x1b", "[0m] done\n"},
+			want:   []string{"[  ", "OK  ", "] done\n"},
+		},
+		{
+			name:   "title",
+			chunks: []string{"\x1b]0;root@debian\x07$ ", "\x1b]2;t\x1b\\# "},
+			want:   []string{"$ ", "# "},
+		},
+		{
+			name:   "UTF-8 split between writes",
+			chunks: []string{"caf\xc3", "\xa9 \xe2\x9c", "\x93\n"},
+			want:   []string{"caf", "é ", "✓\n"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := vz.FilterConsoleText(tc.chunks...)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("want %q but got %q", tc.want, got)
+			}
+		})
+	}
+}
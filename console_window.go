@@ -0,0 +1,222 @@
+package vz
+
+/*
+#cgo darwin CFLAGS: -mmacosx-version-min=11 -x objective-c -fno-objc-arc
+#cgo darwin LDFLAGS: -lobjc -framework Foundation -framework Cocoa
+# include "virtualization_default_app.h"
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"runtime/cgo"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// ErrConsoleWindowExists is returned by CreateConsoleWindow when the console already has a window.
+var ErrConsoleWindowExists = errors.New("console already has a window")
+
+// CreateConsoleWindow creates and displays a window which shows the output of console in a scrollable
+// text view without blocking, e.g. for a virtual machine which has no graphics devices. The window
+// starts with the output which console buffered so far, and follows the output while it is scrolled
+// to the end. Keys typed into the window are sent to the guest.
+//
+// Closing the window does not stop the virtual machine. A console has at most one window at a time,
+// ErrConsoleWindowExists is returned until the window is closed.
+//
+// WithWindowTitle and WithStartHidden are applied, the other options are ignored.
+// Call RunApplication() to start the event loop, or use this in an app that
+// already has an event loop running.
+//
+// You must call runtime.LockOSThread before calling this method.
+//
+// This is only supported on macOS 12 and newer, error will be returned on older versions.
+func CreateConsoleWindow(console *BufferedSerialPortAttachment, width, height float64, opts ...StartGraphicApplicationOption) error {
+	if err := macOSAvailable(12); err != nil {
+		return err
+	}
+	if err := checkConsoleWindowSize(width, height); err != nil {
+		return err
+	}
+	defaultOpts, err := newStartGraphicApplicationOptions(opts...)
+	if err != nil {
+		return err
+	}
+	if console == nil {
+		return errors.New("console is required")
+	}
+	w := &consoleWindow{}
+	if err := console.attachWindow(w); err != nil {
+		return err
+	}
+	handle := cgo.NewHandle(&consoleWindowHandle{console: console, window: w})
+
+	windowTitle := charWithGoString(defaultOpts.title)
+	defer windowTitle.Free()
+
+	controller := C.createConsoleWindow(
+		C.double(width),
+		C.double(height),
+		windowTitle.CString(),
+		C.uintptr_t(handle),
+		C.bool(defaultOpts.startHidden),
+	)
+	if controller == nil {
+		console.detachWindow(w)
+		handle.Delete()
+		return errors.New("failed to create the console window")
+	}
+	console.showWindow(w, func(text []byte) {
+		C.appendConsoleWindowText(controller, (*C.char)(unsafe.Pointer(&text[0])), C.int(len(text)))
+	})
+	return nil
+}
+
+func checkConsoleWindowSize(width, height float64) error {
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("invalid console window size %vx%v", width, height)
+	}
+	return nil
+}
+
+// consoleWindowHandle is passed to the Objective-C side to route the events of a console window.
+type consoleWindowHandle struct {
+	console *BufferedSerialPortAttachment
+	window  *consoleWindow
+}
+
+//export consoleWindowInputHandler
+func consoleWindowInputHandler(cgoHandleUintptr C.uintptr_t, data *C.char, length C.int) {
+	h, _ := cgo.Handle(cgoHandleUintptr).Value().(*consoleWindowHandle)
+	// The error is not reported, the console is closed once the virtual machine stopped.
+	h.console.Write(C.GoBytes(unsafe.Pointer(data), length))
+}
+
+//export consoleWindowClosedHandler
+func consoleWindowClosedHandler(cgoHandleUintptr C.uintptr_t) {
+	handle := cgo.Handle(cgoHandleUintptr)
+	h, _ := handle.Value().(*consoleWindowHandle)
+	h.console.detachWindow(h.window)
+	handle.Delete()
+}
+
+// consoleWindow receives the output of a BufferedSerialPortAttachment for the window which shows it.
+type consoleWindow struct {
+	// appendText shows the text in the window. It is nil until the window is created,
+	// the output up to then is taken from the buffer of the attachment.
+	appendText func(text []byte)
+	filter     consoleTextFilter
+}
+
+func (w *consoleWindow) write(p []byte) {
+	if w.appendText == nil {
+		return
+	}
+	if text := w.filter.filter(p); len(text) > 0 {
+		w.appendText(text)
+	}
+}
+
+// attachWindow reserves the console for w. ErrConsoleWindowExists is returned if the console already has a window.
+func (b *BufferedSerialPortAttachment) attachWindow(w *consoleWindow) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.window != nil {
+		return ErrConsoleWindowExists
+	}
+	b.window = w
+	return nil
+}
+
+// showWindow sends the buffered output to the window which was created for w, then the output which follows.
+// Holding the lock, no output is lost or sent twice between the two.
+func (b *BufferedSerialPortAttachment) showWindow(w *consoleWindow, appendText func(text []byte)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.window != w {
+		return
+	}
+	w.appendText = appendText
+	w.write(b.ring.Tail(len(b.ring.buf)))
+}
+
+// detachWindow stops sending the output to w, so that another window can be created for the console.
+func (b *BufferedSerialPortAttachment) detachWindow(w *consoleWindow) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.window == w {
+		b.window = nil
+	}
+}
+
+// consoleTextFilter prepares the output of a console for a text view. It removes the escape
+// sequences of a terminal and the control characters other than new lines and tabs, which the
+// text view can not render, and holds back a UTF-8 sequence which is split between writes.
+type consoleTextFilter struct {
+	state   consoleTextFilterState
+	partial []byte
+}
+
+type consoleTextFilterState int
+
+const (
+	consoleTextFilterText consoleTextFilterState = iota
+	consoleTextFilterEscape
+	consoleTextFilterCSI // ESC [ ... up to a final byte
+	consoleTextFilterOSC // ESC ] ... up to BEL or ESC \
+)
+
+func (f *consoleTextFilter) filter(p []byte) []byte {
+	text := make([]byte, 0, len(f.partial)+len(p))
+	text = append(text, f.partial...)
+	f.partial = f.partial[:0]
+	for _, c := range p {
+		switch f.state {
+		case consoleTextFilterEscape:
+			switch c {
+			case '[':
+				f.state = consoleTextFilterCSI
+			case ']':
+				f.state = consoleTextFilterOSC
+			default:
+				f.state = consoleTextFilterText
+			}
+			continue
+		case consoleTextFilterCSI:
+			if 0x40 <= c && c <= 0x7e {
+				f.state = consoleTextFilterText
+			}
+			continue
+		case consoleTextFilterOSC:
+			switch c {
+			case 0x07:
+				f.state = consoleTextFilterText
+			case 0x1b:
+				f.state = consoleTextFilterEscape
+			}
+			continue
+		}
+		switch {
+		case c == 0x1b:
+			f.state = consoleTextFilterEscape
+		case c == '\n' || c == '\t' || (c >= 0x20 && c != 0x7f):
+			text = append(text, c)
+		}
+	}
+	// Hold back the start of a UTF-8 sequence whose remaining bytes are not written yet.
+	for i := 1; i <= min(utf8.UTFMax-1, len(text)); i++ {
+		c := text[len(text)-i]
+		if c < utf8.RuneSelf {
+			break
+		}
+		if utf8.RuneStart(c) {
+			if !utf8.FullRune(text[len(text)-i:]) {
+				f.partial = append(f.partial, text[len(text)-i:]...)
+				text = text[:len(text)-i]
+			}
+			break
+		}
+	}
+	return text
+}
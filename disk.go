@@ -1,11 +1,39 @@
 package vz
 
+/*
+#include <errno.h>
+#include <fcntl.h>
+#include <unistd.h>
+
+static int punchHole(int fd, off_t offset, off_t length)
+{
+#ifdef F_PUNCHHOLE
+	struct fpunchhole args = { 0 };
+	args.fp_offset = offset;
+	args.fp_length = length;
+	return fcntl(fd, F_PUNCHHOLE, &args);
+#else
+	errno = ENOTSUP;
+	return -1;
+#endif
+}
+
+static int setNoCache(int fd)
+{
+	return fcntl(fd, F_NOCACHE, 1);
+}
+*/
+import "C"
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"strconv"
+	"syscall"
 )
 
 // CreateDiskImage is creating disk image with specified filename and filesize.
@@ -26,6 +54,162 @@ func CreateDiskImage(pathname string, size int64) error {
 	return nil
 }
 
+// DiskImageInfo returns the logical size of the disk image at pathname and the size which is
+// actually allocated on the host file system. A raw disk image created by CreateDiskImage is sparse,
+// so allocatedSize grows only as the guest writes to the disk. sparse reports whether allocatedSize
+// is less than logicalSize.
+//
+// Note that for an "Apple Sparse Image Format" disk image created by CreateSparseDiskImage,
+// logicalSize is the size of the image file, not the size of the disk which the guest sees.
+func DiskImageInfo(pathname string) (logicalSize, allocatedSize uint64, sparse bool, err error) {
+	fi, err := os.Stat(pathname)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	if !fi.Mode().IsRegular() {
+		return 0, 0, false, fmt.Errorf("%q is not a regular file", pathname)
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false, fmt.Errorf("failed to get the allocated size of %q", pathname)
+	}
+	logicalSize = uint64(fi.Size())
+	// st_blocks is always counted in 512-byte units.
+	allocatedSize = uint64(st.Blocks) * 512
+	return logicalSize, allocatedSize, allocatedSize < logicalSize, nil
+}
+
+// CompactDiskImage reclaims the host storage of the raw disk image at pathname by deallocating
+// the file system blocks which contain only zeros. The content of the disk image does not change.
+//
+// The host file does not shrink by itself when the guest deletes files. Run "fstrim -a" in a Linux guest
+// (or mount with the "discard" option) so that the freed space is zeroed in the disk image, then stop
+// the virtual machine and call this function.
+//
+// The virtual machine which uses the disk image must be stopped. The file system of the disk image
+// must support punching holes (e.g. APFS).
+func CompactDiskImage(pathname string) error {
+	f, err := os.OpenFile(pathname, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || st.Blksize <= 0 {
+		return fmt.Errorf("failed to get the block size of %q", pathname)
+	}
+	size, blockSize := fi.Size(), int64(st.Blksize)
+
+	for offset := int64(0); offset < size; {
+		dataStart, dataEnd, err := nextDataRegion(f, offset, size)
+		if err != nil {
+			return err
+		}
+		if dataStart >= size {
+			break
+		}
+		holes, err := zeroBlockRuns(f, dataStart, dataEnd, blockSize)
+		if err != nil {
+			return err
+		}
+		for _, h := range holes {
+			if _, err := C.punchHole(C.int(f.Fd()), C.off_t(h.offset), C.off_t(h.length)); err != nil {
+				return fmt.Errorf("failed to punch a hole at %d in %q: %w", h.offset, pathname, err)
+			}
+		}
+		offset = dataEnd
+	}
+	return f.Sync()
+}
+
+// directIOAlignment is the alignment in bytes of the size of a disk image attached with direct I/O.
+// Direct I/O is only done for requests aligned to the page size, others go through the page cache.
+const directIOAlignment = 4096
+
+// ErrDirectIOUnaligned is returned by NewDirectIODiskImageStorageDeviceAttachment when the size of
+// the disk image is not a multiple of 4096 bytes.
+var ErrDirectIOUnaligned = errors.New("disk image size is not aligned for direct I/O")
+
+func checkDirectIOAlignment(size int64) error {
+	if size%directIOAlignment != 0 {
+		return fmt.Errorf("%w: %d bytes is not a multiple of %d", ErrDirectIOUnaligned, size, directIOAlignment)
+	}
+	return nil
+}
+
+// setNoCache makes the I/O on the file bypass the unified buffer cache. This is the macOS
+// equivalent of O_DIRECT, which macOS does not have.
+func setNoCache(f *os.File) error {
+	if _, err := C.setNoCache(C.int(f.Fd())); err != nil {
+		return fmt.Errorf("failed to set F_NOCACHE on %q: %w", f.Name(), err)
+	}
+	return nil
+}
+
+// nextDataRegion returns the region which has data from offset. Holes which are already in the file
+// are skipped. If the file system does not report holes, the rest of the file is the region.
+func nextDataRegion(f *os.File, offset, size int64) (start, end int64, err error) {
+	start, err = f.Seek(offset, C.SEEK_DATA)
+	if errors.Is(err, syscall.ENXIO) {
+		// No data after offset.
+		return size, size, nil
+	}
+	if err != nil {
+		return offset, size, nil
+	}
+	end, err = f.Seek(start, C.SEEK_HOLE)
+	if err != nil || end > size {
+		end = size
+	}
+	return start, end, nil
+}
+
+type holeRange struct {
+	offset int64
+	length int64
+}
+
+// zeroBlockRuns returns the runs of blocks in [start, end) which contain only zeros.
+// Runs are aligned to blockSize, and the partial block at the end is never included.
+func zeroBlockRuns(r io.ReaderAt, start, end, blockSize int64) ([]holeRange, error) {
+	start = start / blockSize * blockSize
+	end = end / blockSize * blockSize
+
+	// Read multiple blocks at once.
+	chunkSize := blockSize * max(1, (1<<20)/blockSize)
+	buf := make([]byte, chunkSize)
+	zero := make([]byte, blockSize)
+
+	var (
+		holes   []holeRange
+		current *holeRange
+	)
+	for offset := start; offset < end; {
+		n := min(chunkSize, end-offset)
+		if _, err := r.ReadAt(buf[:n], offset); err != nil {
+			return nil, err
+		}
+		for i := int64(0); i < n; i += blockSize {
+			if !bytes.Equal(buf[i:i+blockSize], zero) {
+				current = nil
+				continue
+			}
+			if current == nil {
+				holes = append(holes, holeRange{offset: offset + i})
+				current = &holes[len(holes)-1]
+			}
+			current.length += blockSize
+		}
+		offset += n
+	}
+	return holes, nil
+}
+
 // CreateSparseDiskImage is creating an "Apple Sparse Image Format" disk image
 // with specified filename and filesize. The function "shells out" to diskutil, as currently
 // this is the only known way of creating ASIF images.
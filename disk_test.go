@@ -1,10 +1,12 @@
 package vz_test
 
 import (
+	"bytes"
 	"context"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
 	"testing"
@@ -111,3 +113,150 @@ func TestCreateSparseDiskImage_CorrectSize(t *testing.T) {
 		t.Fatalf("actual disk size (%d) doesn't equal to desired size (%d)", actualSize, desiredSize)
 	}
 }
+
+func TestDiskImageInfo(t *testing.T) {
+	const (
+		mib  = 1024 * 1024
+		size = 64 * mib
+	)
+	dir := t.TempDir()
+
+	t.Run("sparse", func(t *testing.T) {
+		path := filepath.Join(dir, "sparse.img")
+		if err := vz.CreateDiskImage(path, size); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.WriteAt(make([]byte, mib), 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.WriteAt([]byte{1}, 32*mib); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		logicalSize, allocatedSize, sparse, err := vz.DiskImageInfo(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if logicalSize != size {
+			t.Errorf("want logical size %d but got %d", size, logicalSize)
+		}
+		if allocatedSize < mib || allocatedSize >= size/2 {
+			t.Errorf("want allocated size between %d and %d but got %d", mib, size/2, allocatedSize)
+		}
+		if !sparse {
+			t.Error("want sparse")
+		}
+	})
+
+	t.Run("fully allocated", func(t *testing.T) {
+		path := filepath.Join(dir, "full.img")
+		if err := os.WriteFile(path, bytes.Repeat([]byte{1}, mib), 0600); err != nil {
+			t.Fatal(err)
+		}
+		logicalSize, allocatedSize, sparse, err := vz.DiskImageInfo(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if logicalSize != mib || allocatedSize < mib {
+			t.Errorf("want logical size %d and allocated size >= %d but got %d and %d", mib, mib, logicalSize, allocatedSize)
+		}
+		if sparse {
+			t.Error("want not sparse")
+		}
+	})
+
+	t.Run("not exist", func(t *testing.T) {
+		if _, _, _, err := vz.DiskImageInfo(filepath.Join(dir, "not-exist.img")); !os.IsNotExist(err) {
+			t.Fatalf("want os.ErrNotExist but got %v", err)
+		}
+	})
+}
+
+func TestZeroBlockRuns(t *testing.T) {
+	const bs = 4
+	data := bytes.Join([][]byte{
+		{1, 1, 1, 1},
+		{0, 0, 0, 0},
+		{0, 0, 0, 0},
+		{0, 0, 1, 0},
+		{0, 0, 0, 0},
+		{0, 0}, // partial block is never punched
+	}, nil)
+	got, err := vz.ZeroBlockRuns(bytes.NewReader(data), 0, int64(len(data)), bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []vz.HoleRange{
+		{Offset: 4, Length: 8},
+		{Offset: 16, Length: 4},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("want %v but got %v", want, got)
+	}
+
+	// Unaligned start is rounded down to the block boundary.
+	got, err = vz.ZeroBlockRuns(bytes.NewReader(data), 6, 16, bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []vz.HoleRange{{Offset: 4, Length: 8}}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("want %v but got %v", want, got)
+	}
+}
+
+func TestCompactDiskImage(t *testing.T) {
+	const mib = 1024 * 1024
+	path := filepath.Join(t.TempDir(), "disk.img")
+
+	// Data, a large run of zeros which the guest freed, then data again.
+	content := bytes.Join([][]byte{
+		bytes.Repeat([]byte{1}, mib),
+		make([]byte, 30*mib),
+		bytes.Repeat([]byte{2}, mib),
+	}, nil)
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, before, _, err := vz.DiskImageInfo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := vz.CompactDiskImage(path); err != nil {
+		t.Fatal(err)
+	}
+
+	logicalSize, after, sparse, err := vz.DiskImageInfo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logicalSize != uint64(len(content)) {
+		t.Errorf("want logical size %d but got %d", len(content), logicalSize)
+	}
+	if before-after < 16*mib {
+		t.Errorf("want at least %d bytes reclaimed but allocated size changed %d -> %d", 16*mib, before, after)
+	}
+	if !sparse {
+		t.Error("want sparse")
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, got) {
+		t.Fatal("content of the disk image is changed")
+	}
+
+	// Compacting again is a no-op.
+	if err := vz.CompactDiskImage(path); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,363 @@
+package vz
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// ErrEFIVariableStoreFormat is returned when the EFI variable store file is not
+// in the format which can be parsed by this package.
+var ErrEFIVariableStoreFormat = errors.New("unsupported EFI variable store format")
+
+// EFIBootEntry represents a "Boot####" load option in the EFI variable store.
+type EFIBootEntry struct {
+	// Index is the "####" part of the "Boot####" variable.
+	Index uint16
+	// Description is the human readable description of the load option.
+	Description string
+	// Active reports whether the LOAD_OPTION_ACTIVE attribute is set.
+	Active bool
+}
+
+// BootEntries returns the boot entries stored in the EFI variable store.
+//
+// The entries listed in "BootOrder" come first in that order, followed by
+// the remaining entries sorted by index.
+//
+// The variable store must not be used by a running virtual machine.
+func (e *EFIVariableStore) BootEntries() ([]EFIBootEntry, error) {
+	b, err := os.ReadFile(e.path)
+	if err != nil {
+		return nil, err
+	}
+	return efiBootEntries(b)
+}
+
+// SetBootNext sets the "BootNext" variable, so that the boot entry of the index
+// is used on the next boot only. index must be one of the EFIBootEntry.Index
+// returned by BootEntries.
+//
+// The variable store must not be used by a running virtual machine.
+func (e *EFIVariableStore) SetBootNext(index int) error {
+	if index < 0 || index > 0xFFFF {
+		return fmt.Errorf("invalid boot entry index: %d", index)
+	}
+	info, err := os.Stat(e.path)
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(e.path)
+	if err != nil {
+		return err
+	}
+	if err := efiSetBootNext(b, uint16(index)); err != nil {
+		return err
+	}
+	return os.WriteFile(e.path, b, info.Mode().Perm())
+}
+
+// efiGlobalVariableGUID is EFI_GLOBAL_VARIABLE (8be4df61-93ca-11d2-aa0d-00e098032b8c) in binary form.
+var efiGlobalVariableGUID = [16]byte{
+	0x61, 0xdf, 0xe4, 0x8b, 0xca, 0x93, 0xd2, 0x11,
+	0xaa, 0x0d, 0x00, 0xe0, 0x98, 0x03, 0x2b, 0x8c,
+}
+
+var (
+	// gEfiAuthenticatedVariableGuid (aa1305b9-01f3-4afb-920e-c9b979a852fd)
+	efiAuthenticatedVariableStoreGUID = [16]byte{
+		0xb9, 0x05, 0x13, 0xaa, 0xf3, 0x01, 0xfb, 0x4a,
+		0x92, 0x0e, 0xc9, 0xb9, 0x79, 0xa8, 0x52, 0xfd,
+	}
+	// gEfiVariableGuid (ddcf3616-3275-4164-98b6-fe85707ffe7d)
+	efiVariableStoreGUID = [16]byte{
+		0x16, 0x36, 0xcf, 0xdd, 0x75, 0x32, 0x64, 0x41,
+		0x98, 0xb6, 0xfe, 0x85, 0x70, 0x7f, 0xfe, 0x7d,
+	}
+)
+
+const (
+	efiVariableStartID = 0x55AA
+
+	efiVariableAdded                 = 0x3F
+	efiVariableDeleted               = 0xFD
+	efiVariableInDeletedTransition   = 0xFE
+	efiVariableStoreHeaderSize       = 28
+	efiVariableHeaderSize            = 32
+	efiAuthenticatedVariableHeadSize = 60
+
+	efiVariableNonVolatile       = 0x1
+	efiVariableBootServiceAccess = 0x2
+	efiVariableRuntimeAccess     = 0x4
+
+	efiLoadOptionActive = 0x1
+)
+
+// efiVariable is a variable in the EDK II variable store.
+type efiVariable struct {
+	offset     int // offset of the header
+	headerSize int
+	state      byte
+	attributes uint32
+	name       string
+	vendor     [16]byte
+	data       []byte
+}
+
+func (v *efiVariable) valid() bool {
+	return v.state == efiVariableAdded || v.state == efiVariableAdded&efiVariableInDeletedTransition
+}
+
+// efiVariableStore is the EDK II variable store which is placed after the firmware volume header.
+type efiVariableStore struct {
+	authenticated bool
+	variables     []*efiVariable
+	// free is the offset of the free space in the store.
+	free int
+	// end is the end offset of the store.
+	end int
+}
+
+func efiAlign4(n int) int { return (n + 3) &^ 3 }
+
+func parseEFIVariableStore(b []byte) (*efiVariableStore, error) {
+	// EFI_FIRMWARE_VOLUME_HEADER
+	if len(b) < 56 || string(b[40:44]) != "_FVH" {
+		return nil, fmt.Errorf("%w: firmware volume header is not found", ErrEFIVariableStoreFormat)
+	}
+	start := int(binary.LittleEndian.Uint16(b[48:]))
+	if len(b) < start+efiVariableStoreHeaderSize {
+		return nil, fmt.Errorf("%w: variable store header is truncated", ErrEFIVariableStoreFormat)
+	}
+
+	// VARIABLE_STORE_HEADER
+	store := &efiVariableStore{}
+	var guid [16]byte
+	copy(guid[:], b[start:])
+	switch guid {
+	case efiAuthenticatedVariableStoreGUID:
+		store.authenticated = true
+	case efiVariableStoreGUID:
+	default:
+		return nil, fmt.Errorf("%w: unknown variable store signature", ErrEFIVariableStoreFormat)
+	}
+	size := int(binary.LittleEndian.Uint32(b[start+16:]))
+	store.end = start + size
+	if store.end > len(b) {
+		return nil, fmt.Errorf("%w: variable store size %d exceeds the file", ErrEFIVariableStoreFormat, size)
+	}
+
+	headerSize := efiVariableHeaderSize
+	if store.authenticated {
+		headerSize = efiAuthenticatedVariableHeadSize
+	}
+	off := efiAlign4(start + efiVariableStoreHeaderSize)
+	for off+headerSize <= store.end && binary.LittleEndian.Uint16(b[off:]) == efiVariableStartID {
+		v := &efiVariable{
+			offset:     off,
+			headerSize: headerSize,
+			state:      b[off+2],
+			attributes: binary.LittleEndian.Uint32(b[off+4:]),
+		}
+		sizes := off + 8 // NameSize, DataSize and VendorGuid
+		if store.authenticated {
+			sizes = off + 8 + 8 + 16 + 4 // skip MonotonicCount, TimeStamp and PubKeyIndex
+		}
+		nameSize := int(binary.LittleEndian.Uint32(b[sizes:]))
+		dataSize := int(binary.LittleEndian.Uint32(b[sizes+4:]))
+		copy(v.vendor[:], b[sizes+8:])
+		nameOff := off + headerSize
+		dataOff := nameOff + nameSize
+		next := efiAlign4(dataOff + dataSize)
+		if nameSize < 0 || dataSize < 0 || next > store.end {
+			return nil, fmt.Errorf("%w: variable at offset %d is truncated", ErrEFIVariableStoreFormat, off)
+		}
+		v.name = decodeUTF16LE(b[nameOff:dataOff])
+		v.data = b[dataOff : dataOff+dataSize]
+		store.variables = append(store.variables, v)
+		off = next
+	}
+	store.free = off
+	return store, nil
+}
+
+// lookup returns the valid global variable which has the name.
+func (s *efiVariableStore) lookup(name string) *efiVariable {
+	for _, v := range s.variables {
+		if v.valid() && v.vendor == efiGlobalVariableGUID && v.name == name {
+			return v
+		}
+	}
+	return nil
+}
+
+func efiBootEntries(b []byte) ([]EFIBootEntry, error) {
+	store, err := parseEFIVariableStore(b)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[uint16]EFIBootEntry)
+	for _, v := range store.variables {
+		if !v.valid() || v.vendor != efiGlobalVariableGUID {
+			continue
+		}
+		index, ok := efiBootOptionIndex(v.name)
+		if !ok {
+			continue
+		}
+		entry, err := parseEFILoadOption(index, v.data)
+		if err != nil {
+			return nil, err
+		}
+		entries[index] = entry
+	}
+
+	ret := make([]EFIBootEntry, 0, len(entries))
+	if order := store.lookup("BootOrder"); order != nil {
+		for i := 0; i+1 < len(order.data); i += 2 {
+			index := binary.LittleEndian.Uint16(order.data[i:])
+			if entry, ok := entries[index]; ok {
+				ret = append(ret, entry)
+				delete(entries, index)
+			}
+		}
+	}
+	rest := make([]EFIBootEntry, 0, len(entries))
+	for _, entry := range entries {
+		rest = append(rest, entry)
+	}
+	sort.Slice(rest, func(i, j int) bool { return rest[i].Index < rest[j].Index })
+	return append(ret, rest...), nil
+}
+
+func efiSetBootNext(b []byte, index uint16) error {
+	entries, err := efiBootEntries(b)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, entry := range entries {
+		if entry.Index == index {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("boot entry Boot%04X is not found", index)
+	}
+
+	store, err := parseEFIVariableStore(b)
+	if err != nil {
+		return err
+	}
+	data := make([]byte, 2)
+	binary.LittleEndian.PutUint16(data, index)
+	if err := store.append(b, "BootNext", data); err != nil {
+		return err
+	}
+	// Invalidate the previous value after the new one has been written.
+	if old := store.lookup("BootNext"); old != nil {
+		b[old.offset+2] &= efiVariableDeleted
+	}
+	return nil
+}
+
+// append writes a new global variable to the free space of the store.
+func (s *efiVariableStore) append(b []byte, name string, data []byte) error {
+	headerSize := efiVariableHeaderSize
+	if s.authenticated {
+		headerSize = efiAuthenticatedVariableHeadSize
+	}
+	nameBytes := encodeUTF16LE(name)
+	size := efiAlign4(headerSize + len(nameBytes) + len(data))
+	if s.free+size > s.end {
+		return fmt.Errorf("no space left in the EFI variable store for %q", name)
+	}
+
+	v := b[s.free : s.free+size]
+	for i := range v {
+		v[i] = 0
+	}
+	binary.LittleEndian.PutUint16(v[0:], efiVariableStartID)
+	v[2] = efiVariableAdded
+	binary.LittleEndian.PutUint32(v[4:], efiVariableNonVolatile|efiVariableBootServiceAccess|efiVariableRuntimeAccess)
+	sizes := 8
+	if s.authenticated {
+		sizes = 8 + 8 + 16 + 4
+	}
+	binary.LittleEndian.PutUint32(v[sizes:], uint32(len(nameBytes)))
+	binary.LittleEndian.PutUint32(v[sizes+4:], uint32(len(data)))
+	copy(v[sizes+8:], efiGlobalVariableGUID[:])
+	copy(v[headerSize:], nameBytes)
+	copy(v[headerSize+len(nameBytes):], data)
+	// Keep the free space erased.
+	for i := headerSize + len(nameBytes) + len(data); i < size; i++ {
+		v[i] = 0xFF
+	}
+	s.free += size
+	return nil
+}
+
+func efiBootOptionIndex(name string) (uint16, bool) {
+	if len(name) != 8 || !strings.HasPrefix(name, "Boot") {
+		return 0, false
+	}
+	hex := name[4:]
+	if strings.ToUpper(hex) != hex {
+		return 0, false
+	}
+	index, err := strconv.ParseUint(hex, 16, 16)
+	if err != nil {
+		return 0, false
+	}
+	return uint16(index), true
+}
+
+// parseEFILoadOption parses EFI_LOAD_OPTION.
+func parseEFILoadOption(index uint16, data []byte) (EFIBootEntry, error) {
+	if len(data) < 6 {
+		return EFIBootEntry{}, fmt.Errorf("%w: Boot%04X is truncated", ErrEFIVariableStoreFormat, index)
+	}
+	attributes := binary.LittleEndian.Uint32(data)
+	description := data[6:]
+	for i := 0; i+1 < len(description); i += 2 {
+		if description[i] == 0 && description[i+1] == 0 {
+			description = description[:i]
+			break
+		}
+	}
+	return EFIBootEntry{
+		Index:       index,
+		Description: decodeUTF16LE(description),
+		Active:      attributes&efiLoadOptionActive != 0,
+	}, nil
+}
+
+func decodeUTF16LE(b []byte) string {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		c := binary.LittleEndian.Uint16(b[i:])
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u))
+}
+
+func encodeUTF16LE(s string) []byte {
+	var buf bytes.Buffer
+	for _, c := range utf16.Encode([]rune(s)) {
+		buf.WriteByte(byte(c))
+		buf.WriteByte(byte(c >> 8))
+	}
+	buf.Write([]byte{0, 0})
+	return buf.Bytes()
+}
@@ -0,0 +1,249 @@
+package vz_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/Code-Hex/vz/v3"
+)
+
+var testEFIGlobalVariableGUID = []byte{
+	0x61, 0xdf, 0xe4, 0x8b, 0xca, 0x93, 0xd2, 0x11,
+	0xaa, 0x0d, 0x00, 0xe0, 0x98, 0x03, 0x2b, 0x8c,
+}
+
+type testEFIVariable struct {
+	name  string
+	state byte
+	data  []byte
+}
+
+// newTestNVRAM builds an EDK II style variable store which contains vars.
+func newTestNVRAM(t *testing.T, authenticated bool, vars ...testEFIVariable) []byte {
+	t.Helper()
+	const (
+		fvHeaderLength = 72
+		storeSize      = 4096
+	)
+	b := make([]byte, fvHeaderLength+storeSize)
+	for i := range b {
+		b[i] = 0xFF
+	}
+	copy(b[40:], "_FVH")
+	binary.LittleEndian.PutUint16(b[48:], fvHeaderLength)
+
+	store := b[fvHeaderLength:]
+	headerSize, sizes := 32, 8
+	if authenticated {
+		copy(store, []byte{
+			0xb9, 0x05, 0x13, 0xaa, 0xf3, 0x01, 0xfb, 0x4a,
+			0x92, 0x0e, 0xc9, 0xb9, 0x79, 0xa8, 0x52, 0xfd,
+		})
+		headerSize, sizes = 60, 36
+	} else {
+		copy(store, []byte{
+			0x16, 0x36, 0xcf, 0xdd, 0x75, 0x32, 0x64, 0x41,
+			0x98, 0xb6, 0xfe, 0x85, 0x70, 0x7f, 0xfe, 0x7d,
+		})
+	}
+	binary.LittleEndian.PutUint32(store[16:], storeSize)
+	copy(store[20:28], []byte{0x5a, 0xfe, 0, 0, 0, 0, 0, 0})
+
+	off := 28
+	for _, v := range vars {
+		name := testUTF16LE(v.name)
+		h := store[off:]
+		for i := 0; i < headerSize; i++ {
+			h[i] = 0
+		}
+		binary.LittleEndian.PutUint16(h, 0x55AA)
+		h[2] = v.state
+		binary.LittleEndian.PutUint32(h[4:], 0x7)
+		binary.LittleEndian.PutUint32(h[sizes:], uint32(len(name)))
+		binary.LittleEndian.PutUint32(h[sizes+4:], uint32(len(v.data)))
+		copy(h[sizes+8:], testEFIGlobalVariableGUID)
+		copy(h[headerSize:], name)
+		copy(h[headerSize+len(name):], v.data)
+		off += (headerSize + len(name) + len(v.data) + 3) &^ 3
+	}
+	return b
+}
+
+func testUTF16LE(s string) []byte {
+	u := utf16.Encode([]rune(s + "\x00"))
+	b := make([]byte, len(u)*2)
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(b[i*2:], c)
+	}
+	return b
+}
+
+func testEFILoadOption(active bool, description string) []byte {
+	b := make([]byte, 6)
+	if active {
+		binary.LittleEndian.PutUint32(b, 0x1)
+	}
+	devicePath := []byte{0x7f, 0xff, 0x04, 0x00} // End of Hardware Device Path
+	binary.LittleEndian.PutUint16(b[4:], uint16(len(devicePath)))
+	b = append(b, testUTF16LE(description)...)
+	return append(b, devicePath...)
+}
+
+func testEFIBootOrder(indexes ...uint16) []byte {
+	b := make([]byte, len(indexes)*2)
+	for i, index := range indexes {
+		binary.LittleEndian.PutUint16(b[i*2:], index)
+	}
+	return b
+}
+
+func TestEFIBootEntries(t *testing.T) {
+	for _, authenticated := range []bool{true, false} {
+		nvram := newTestNVRAM(t, authenticated,
+			testEFIVariable{name: "Boot0000", state: 0x3F, data: testEFILoadOption(true, "UiApp")},
+			testEFIVariable{name: "Boot0001", state: 0x3F, data: testEFILoadOption(true, "UEFI Misc Device")},
+			testEFIVariable{name: "Boot0002", state: 0x3D, data: testEFILoadOption(true, "Deleted")},
+			testEFIVariable{name: "Boot000A", state: 0x3E, data: testEFILoadOption(false, "EFI Internal Shell")},
+			testEFIVariable{name: "BootOrder", state: 0x3F, data: testEFIBootOrder(0x0001, 0x0000)},
+		)
+		got, err := vz.EFIBootEntries(nvram)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []vz.EFIBootEntry{
+			{Index: 0x0001, Description: "UEFI Misc Device", Active: true},
+			{Index: 0x0000, Description: "UiApp", Active: true},
+			{Index: 0x000A, Description: "EFI Internal Shell", Active: false},
+		}
+		if len(got) != len(want) {
+			t.Fatalf("authenticated=%v: want %v but got %v", authenticated, want, got)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("authenticated=%v: want %v but got %v", authenticated, want[i], got[i])
+			}
+		}
+	}
+}
+
+func TestEFIBootEntriesInvalidFormat(t *testing.T) {
+	if _, err := vz.EFIBootEntries(make([]byte, 4096)); !errors.Is(err, vz.ErrEFIVariableStoreFormat) {
+		t.Fatalf("want ErrEFIVariableStoreFormat but got %v", err)
+	}
+}
+
+func TestEFISetBootNext(t *testing.T) {
+	for _, authenticated := range []bool{true, false} {
+		nvram := newTestNVRAM(t, authenticated,
+			testEFIVariable{name: "Boot0000", state: 0x3F, data: testEFILoadOption(true, "UiApp")},
+			testEFIVariable{name: "Boot0001", state: 0x3F, data: testEFILoadOption(true, "UEFI Misc Device")},
+			testEFIVariable{name: "BootNext", state: 0x3F, data: testEFIBootOrder(0x0000)},
+		)
+		if err := vz.EFISetBootNext(nvram, 0x0002); err == nil {
+			t.Fatalf("authenticated=%v: want error for the unknown boot entry", authenticated)
+		}
+		if err := vz.EFISetBootNext(nvram, 0x0001); err != nil {
+			t.Fatal(err)
+		}
+		if got := testBootNextValues(t, nvram, authenticated); len(got) != 1 || got[0] != 0x0001 {
+			t.Fatalf("authenticated=%v: want a single BootNext 0x0001 but got %v", authenticated, got)
+		}
+
+		// Parsing must still succeed after the store has been modified.
+		if _, err := vz.EFIBootEntries(nvram); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestEFIVariableStoreSetBootNext(t *testing.T) {
+	if vz.Available(13) {
+		t.Skip("NewEFIVariableStore is supported from macOS 13")
+	}
+	dir := t.TempDir()
+
+	// The store created by the Virtualization framework must be readable.
+	created, err := vz.NewEFIVariableStore(filepath.Join(dir, "created"), vz.WithCreatingEFIVariableStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := created.BootEntries(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "nvram")
+	nvram := newTestNVRAM(t, true,
+		testEFIVariable{name: "Boot0000", state: 0x3F, data: testEFILoadOption(true, "UiApp")},
+		testEFIVariable{name: "Boot0001", state: 0x3F, data: testEFILoadOption(true, "UEFI Misc Device")},
+	)
+	if err := os.WriteFile(path, nvram, 0640); err != nil {
+		t.Fatal(err)
+	}
+	store, err := vz.NewEFIVariableStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetBootNext(0x0001); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := testBootNextValues(t, b, true); len(got) != 1 || got[0] != 0x0001 {
+		t.Fatalf("want a single BootNext 0x0001 but got %v", got)
+	}
+	entries, err := store.BootEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("want 2 boot entries but got %v", entries)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := info.Mode().Perm(); got != 0640 {
+		t.Fatalf("want the mode of the store to be kept as 0640 but got %o", got)
+	}
+}
+
+func TestEFISetBootNextNoSpace(t *testing.T) {
+	nvram := newTestNVRAM(t, true,
+		testEFIVariable{name: "Boot0000", state: 0x3F, data: testEFILoadOption(true, "UiApp")},
+		// Leaves only 44 bytes in the store.
+		testEFIVariable{name: "Filler", state: 0x3D, data: make([]byte, 3850)},
+	)
+	if err := vz.EFISetBootNext(nvram, 0x0000); err == nil {
+		t.Fatal("want error if there is no space in the variable store")
+	}
+}
+
+// testBootNextValues returns values of the valid BootNext variables.
+func testBootNextValues(t *testing.T, nvram []byte, authenticated bool) []uint16 {
+	t.Helper()
+	headerSize, sizes := 32, 8
+	if authenticated {
+		headerSize, sizes = 60, 36
+	}
+	var ret []uint16
+	off := 72 + 28
+	for binary.LittleEndian.Uint16(nvram[off:]) == 0x55AA {
+		h := nvram[off:]
+		nameSize := int(binary.LittleEndian.Uint32(h[sizes:]))
+		dataSize := int(binary.LittleEndian.Uint32(h[sizes+4:]))
+		name := h[headerSize : headerSize+nameSize]
+		data := h[headerSize+nameSize : headerSize+nameSize+dataSize]
+		if (h[2] == 0x3F || h[2] == 0x3E) && string(name) == string(testUTF16LE("BootNext")) {
+			ret = append(ret, binary.LittleEndian.Uint16(data))
+		}
+		off += (headerSize + nameSize + dataSize + 3) &^ 3
+	}
+	return ret
+}
@@ -0,0 +1,247 @@
+package vz
+
+/*
+#cgo darwin CFLAGS: -mmacosx-version-min=11 -x objective-c -fno-objc-arc
+#cgo darwin LDFLAGS: -lobjc -framework Foundation -framework Virtualization
+# include "virtualization_11.h"
+*/
+import "C"
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"runtime/cgo"
+	"sync"
+	"unsafe"
+
+	infinity "github.com/Code-Hex/go-infinity-channel"
+)
+
+// EventKind represents the kind of Event.
+//
+//go:generate stringer -type=EventKind
+type EventKind int
+
+const (
+	// EventStateChanged is sent when the execution state of the virtual machine is changed.
+	EventStateChanged EventKind = iota
+
+	// EventNetworkDisconnected is sent when a network attachment of the virtual machine is disconnected.
+	EventNetworkDisconnected
+
+	// EventWindowClosed is sent when the window created by (*VirtualMachine).CreateWindow is closed.
+	EventWindowClosed
+
+	// EventGuestStopped is sent when the guest operating system stopped the virtual machine,
+	// e.g. the guest shut down cleanly.
+	EventGuestStopped
+
+	// EventStoppedWithError is sent when the virtual machine stopped because of an error.
+	EventStoppedWithError
+)
+
+// Event is a lifecycle event of the virtual machine.
+//
+// Kind tells which of the other fields is set.
+type Event struct {
+	Kind EventKind
+
+	// State is the new execution state for EventStateChanged.
+	State VirtualMachineState
+
+	// Err is a *DisconnectedError for EventNetworkDisconnected, and the error which
+	// stopped the virtual machine for EventStoppedWithError.
+	Err error
+}
+
+// eventEmitter delivers the lifecycle events of a virtual machine to a single channel.
+type eventEmitter struct {
+	mu     sync.Mutex
+	events *infinity.Channel[Event]
+	closed bool
+
+	// stoppedErrors receives the error of every EventStoppedWithError.
+	stoppedErrors *infinity.Channel[error]
+
+	// logger logs every event if it is not nil.
+	logger *slog.Logger
+
+	// id of the virtual machine.
+	id string
+
+	// networkDevices are the network devices the virtual machine was created with, in the
+	// order of VZVirtualMachine.networkDevices, which the index of a disconnection refers to.
+	networkDevices []*VirtioNetworkDeviceConfiguration
+}
+
+func newEventEmitter(id string, networkDevices []*VirtioNetworkDeviceConfiguration) *eventEmitter {
+	return &eventEmitter{
+		events:         infinity.NewChannel[Event](),
+		stoppedErrors:  infinity.NewChannel[error](),
+		id:             id,
+		networkDevices: networkDevices,
+	}
+}
+
+func (e *eventEmitter) emit(event Event) {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return
+	}
+	e.events.In() <- event
+	logger := e.logger
+	e.mu.Unlock()
+
+	if logger != nil {
+		logEvent(logger, e.id, event)
+	}
+}
+
+func (e *eventEmitter) setLogger(logger *slog.Logger) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.logger = logger
+}
+
+var eventLogMessages = map[EventKind]string{
+	EventStateChanged:        "virtual machine state changed",
+	EventNetworkDisconnected: "network attachment disconnected",
+	EventWindowClosed:        "virtual machine window closed",
+	EventGuestStopped:        "guest stopped the virtual machine",
+	EventStoppedWithError:    "virtual machine stopped with error",
+}
+
+// logEvent writes a structured record of the event of the virtual machine which has the id.
+func logEvent(logger *slog.Logger, id string, event Event) {
+	level := slog.LevelInfo
+	attrs := []slog.Attr{
+		slog.String("vm", id),
+		slog.String("event", event.Kind.String()),
+	}
+	switch event.Kind {
+	case EventStateChanged:
+		attrs = append(attrs, slog.String("state", event.State.String()))
+	case EventNetworkDisconnected:
+		level = slog.LevelWarn
+	case EventStoppedWithError:
+		level = slog.LevelError
+	}
+	if event.Err != nil {
+		attrs = append(attrs, slog.Any("error", event.Err))
+	}
+	logger.LogAttrs(context.Background(), level, eventLogMessages[event.Kind], attrs...)
+}
+
+func (e *eventEmitter) networkDisconnected(index int, err error) {
+	e.emit(Event{
+		Kind: EventNetworkDisconnected,
+		Err:  newDisconnectedError(e.networkDevices, index, err),
+	})
+}
+
+// windowClosed is called when the window of the window controller is closed.
+func (e *eventEmitter) windowClosed(windowController uintptr) {
+	windows.remove(windowController)
+	e.emit(Event{Kind: EventWindowClosed})
+}
+
+func (e *eventEmitter) guestStopped() {
+	e.emit(Event{Kind: EventGuestStopped})
+}
+
+func (e *eventEmitter) stoppedWithError(err error) {
+	e.mu.Lock()
+	if !e.closed {
+		e.stoppedErrors.In() <- err
+	}
+	e.mu.Unlock()
+	e.emit(Event{Kind: EventStoppedWithError, Err: err})
+}
+
+func (e *eventEmitter) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.closed {
+		e.closed = true
+		e.events.Close()
+		e.stoppedErrors.Close()
+	}
+}
+
+// Events returns a receive channel which emits every lifecycle event of the virtual machine
+// (state changes, network disconnections, window close, guest stop and stop with error) in the order they happened.
+//
+// Like StateChangedNotify, the channel should be read by a single receiver. Events are buffered
+// until they are received.
+func (v *VirtualMachine) Events() <-chan Event {
+	return v.events.events.Out()
+}
+
+// SetLogger makes the virtual machine log its lifecycle events (state changes, network
+// disconnections, window close and stops) to logger as structured records which have the
+// "vm" attribute set to the id of the virtual machine. Errors are logged at the error level
+// and network disconnections at the warning level. A nil logger stops logging.
+//
+// Logging does not consume the events, Events still receives all of them.
+func (v *VirtualMachine) SetLogger(logger *slog.Logger) {
+	v.events.setLogger(logger)
+}
+
+// StoppedWithErrorNotify returns a receive channel which emits the error reported by the
+// Virtualization framework each time the virtual machine stops because of an internal error.
+// The error is an *NSError which describes why the virtual machine stopped, while the state of
+// the virtual machine only changes to VirtualMachineStateError.
+//
+// Like StateChangedNotify, the channel should be read by a single receiver. Errors are buffered
+// until they are received. The same errors are also sent as EventStoppedWithError by Events.
+func (v *VirtualMachine) StoppedWithErrorNotify() <-chan error {
+	return v.events.stoppedErrors.Out()
+}
+
+var errUnknownStopReason = errors.New("virtual machine stopped with an unknown error")
+
+//export emitGuestStoppedEvent
+func emitGuestStoppedEvent(cgoHandleUintptr C.uintptr_t) {
+	emitGuestStopped(cgo.Handle(cgoHandleUintptr))
+}
+
+//export emitStoppedWithErrorEvent
+func emitStoppedWithErrorEvent(errPtr unsafe.Pointer, cgoHandleUintptr C.uintptr_t) {
+	// The framework always passes the error, but do not send a nil error on StoppedWithErrorNotify.
+	err := errUnknownStopReason
+	if nserr := newNSError(errPtr); nserr != nil {
+		err = nserr
+	}
+	emitStoppedWithError(cgo.Handle(cgoHandleUintptr), err)
+}
+
+// emitGuestStopped sends EventGuestStopped to the eventEmitter of the handle.
+// It is called from the guestDidStopVirtualMachine: delegate method.
+func emitGuestStopped(handle cgo.Handle) {
+	// I expected it will not cause panic.
+	// if caused panic, that's unexpected behavior.
+	e, _ := handle.Value().(*eventEmitter)
+	e.guestStopped()
+}
+
+// emitStoppedWithError sends EventStoppedWithError to the eventEmitter of the handle.
+// It is called from the virtualMachine:didStopWithError: delegate method.
+func emitStoppedWithError(handle cgo.Handle, err error) {
+	e, _ := handle.Value().(*eventEmitter)
+	e.stoppedWithError(err)
+}
+
+//export emitNetworkDisconnectedEvent
+func emitNetworkDisconnectedEvent(index C.int, errPtr unsafe.Pointer, cgoHandleUintptr C.uintptr_t) {
+	cgoHandle := cgo.Handle(cgoHandleUintptr)
+	e, _ := cgoHandle.Value().(*eventEmitter)
+	e.networkDisconnected(int(index), newNSError(errPtr))
+}
+
+//export emitWindowClosedEvent
+func emitWindowClosedEvent(cgoHandleUintptr C.uintptr_t, windowController C.uintptr_t) {
+	cgoHandle := cgo.Handle(cgoHandleUintptr)
+	e, _ := cgoHandle.Value().(*eventEmitter)
+	e.windowClosed(uintptr(windowController))
+}
@@ -0,0 +1,330 @@
+package vz_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"runtime/cgo"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Code-Hex/vz/v3"
+)
+
+func TestEventEmitter(t *testing.T) {
+	emitter := vz.NewEventEmitter()
+	stopErr := errors.New("internal error")
+	disconnectErr := errors.New("disconnected")
+
+	emitter.EmitStateChanged(vz.VirtualMachineStateStarting)
+	emitter.EmitStateChanged(vz.VirtualMachineStateRunning)
+	emitter.EmitNetworkDisconnected(0, disconnectErr)
+	emitter.EmitGuestStopped()
+	emitter.EmitStoppedWithError(stopErr)
+	emitter.EmitWindowClosed()
+	emitter.Close()
+
+	// Events emitted after close are dropped.
+	emitter.EmitWindowClosed()
+
+	want := []vz.Event{
+		{Kind: vz.EventStateChanged, State: vz.VirtualMachineStateStarting},
+		{Kind: vz.EventStateChanged, State: vz.VirtualMachineStateRunning},
+		{Kind: vz.EventNetworkDisconnected},
+		{Kind: vz.EventGuestStopped},
+		{Kind: vz.EventStoppedWithError, Err: stopErr},
+		{Kind: vz.EventWindowClosed},
+	}
+	var got []vz.Event
+	timeout := time.After(time.Second)
+	for done := false; !done; {
+		select {
+		case event, ok := <-emitter.Events():
+			if !ok {
+				done = true
+				break
+			}
+			got = append(got, event)
+		case <-timeout:
+			t.Fatal("timed out waiting for events")
+		}
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("want %d events but got %d: %v", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i].Kind != want[i].Kind || got[i].State != want[i].State {
+			t.Errorf("event %d: want %v (%v) but got %v (%v)", i, want[i].Kind, want[i].State, got[i].Kind, got[i].State)
+		}
+		if want[i].Kind == vz.EventNetworkDisconnected {
+			var disconnected *vz.DisconnectedError
+			if !errors.As(got[i].Err, &disconnected) || !errors.Is(got[i].Err, disconnectErr) {
+				t.Errorf("event %d: want *vz.DisconnectedError wrapping %v but got %v", i, disconnectErr, got[i].Err)
+			}
+			continue
+		}
+		if got[i].Err != want[i].Err {
+			t.Errorf("event %d: want error %v but got %v", i, want[i].Err, got[i].Err)
+		}
+	}
+}
+
+func TestEmitStoppedEvents(t *testing.T) {
+	emitter := vz.NewEventEmitter()
+	handle := cgo.NewHandle(emitter)
+	defer handle.Delete()
+	stopErr := errors.New("internal error")
+
+	// The delegate methods pass the handle of the emitter of the virtual machine.
+	vz.EmitGuestStoppedEvent(handle)
+	vz.EmitStoppedWithErrorEvent(handle, stopErr)
+	emitter.Close()
+
+	want := []vz.Event{
+		{Kind: vz.EventGuestStopped},
+		{Kind: vz.EventStoppedWithError, Err: stopErr},
+	}
+	var got []vz.Event
+	for event := range emitter.Events() {
+		got = append(got, event)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("want %d events but got %d: %v", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i].Kind != want[i].Kind || got[i].Err != want[i].Err {
+			t.Errorf("event %d: want %v (%v) but got %v (%v)", i, want[i].Kind, want[i].Err, got[i].Kind, got[i].Err)
+		}
+	}
+}
+
+func TestStoppedWithErrorNotify(t *testing.T) {
+	emitter := vz.NewEventEmitter()
+	handle := cgo.NewHandle(emitter)
+	defer handle.Delete()
+	stopErr := &vz.NSError{
+		Domain:               "VZErrorDomain",
+		Code:                 1,
+		LocalizedDescription: "The virtual machine stopped unexpectedly.",
+	}
+
+	vz.EmitGuestStoppedEvent(handle)
+	vz.EmitStoppedWithErrorEvent(handle, stopErr)
+	emitter.Close()
+
+	var got []error
+	for err := range emitter.StoppedWithErrorNotify() {
+		got = append(got, err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("want only the error stop but got %v", got)
+	}
+	var nserr *vz.NSError
+	if !errors.As(got[0], &nserr) || nserr.LocalizedDescription != stopErr.LocalizedDescription {
+		t.Fatalf("want %v but got %v", stopErr, got[0])
+	}
+}
+
+func TestEventEmitterLogger(t *testing.T) {
+	emitter := vz.NewEventEmitterWithID("vm-1")
+	var buf bytes.Buffer
+	emitter.SetLogger(slog.New(slog.NewJSONHandler(&buf, nil)))
+
+	emitter.EmitStateChanged(vz.VirtualMachineStateRunning)
+	emitter.EmitStoppedWithError(errors.New("internal error"))
+	emitter.SetLogger(nil)
+	emitter.EmitStateChanged(vz.VirtualMachineStateStopped)
+	emitter.Close()
+
+	// Logging does not consume the events.
+	n := 0
+	for range emitter.Events() {
+		n++
+	}
+	if n != 3 {
+		t.Fatalf("want 3 events but got %d", n)
+	}
+
+	type record struct {
+		Level string `json:"level"`
+		VM    string `json:"vm"`
+		Event string `json:"event"`
+		State string `json:"state"`
+		Error string `json:"error"`
+	}
+	want := []record{
+		{Level: "INFO", VM: "vm-1", Event: "EventStateChanged", State: "VirtualMachineStateRunning"},
+		{Level: "ERROR", VM: "vm-1", Event: "EventStoppedWithError", Error: "internal error"},
+	}
+	var got []record
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var r record
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, r)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("want %d log records but got %d: %v", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("record %d: want %+v but got %+v", i, want[i], got[i])
+		}
+	}
+}
+
+// stateReader reads the state of the virtual machine each time a record is logged.
+type stateReader struct {
+	state func() vz.VirtualMachineState
+	got   []vz.VirtualMachineState
+}
+
+func (r *stateReader) Write(p []byte) (int, error) {
+	r.got = append(r.got, r.state())
+	return len(p), nil
+}
+
+func TestStateChangeLoggerReadsState(t *testing.T) {
+	emitter := vz.NewEventEmitterWithID("vm-1")
+	observer := vz.NewStateObserver(emitter)
+	reader := &stateReader{state: observer.State}
+	emitter.SetLogger(slog.New(slog.NewJSONHandler(reader, nil)))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		observer.ChangeState(vz.VirtualMachineStateStarting)
+		observer.ChangeState(vz.VirtualMachineStateRunning)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the logger deadlocked reading the state")
+	}
+
+	want := []vz.VirtualMachineState{vz.VirtualMachineStateStarting, vz.VirtualMachineStateRunning}
+	if len(reader.got) != len(want) || reader.got[0] != want[0] || reader.got[1] != want[1] {
+		t.Fatalf("want %v but got %v", want, reader.got)
+	}
+}
+
+func TestEventKindString(t *testing.T) {
+	cases := map[vz.EventKind]string{
+		vz.EventStateChanged:        "EventStateChanged",
+		vz.EventNetworkDisconnected: "EventNetworkDisconnected",
+		vz.EventWindowClosed:        "EventWindowClosed",
+		vz.EventGuestStopped:        "EventGuestStopped",
+		vz.EventStoppedWithError:    "EventStoppedWithError",
+		vz.EventKind(42):            "EventKind(42)",
+	}
+	for kind, want := range cases {
+		if got := kind.String(); got != want {
+			t.Errorf("want %q but got %q", want, got)
+		}
+	}
+}
+
+func newMultiNICNetworkDevices(t *testing.T) []*vz.VirtioNetworkDeviceConfiguration {
+	t.Helper()
+	natAttachment, err := vz.NewNATNetworkDeviceAttachment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostFile := os.NewFile(uintptr(fds[0]), "host")
+	t.Cleanup(func() {
+		hostFile.Close()
+		syscall.Close(fds[1])
+	})
+	fileHandleAttachment, err := vz.NewFileHandleNetworkDeviceAttachment(hostFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var devices []*vz.VirtioNetworkDeviceConfiguration
+	for _, attachment := range []vz.NetworkDeviceAttachment{natAttachment, fileHandleAttachment} {
+		device, err := vz.NewVirtioNetworkDeviceConfiguration(attachment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mac, err := vz.NewRandomLocallyAdministeredMACAddress()
+		if err != nil {
+			t.Fatal(err)
+		}
+		device.SetMACAddress(mac)
+		devices = append(devices, device)
+	}
+	return devices
+}
+
+func TestNetworkDisconnectedMultipleDevices(t *testing.T) {
+	if vz.Available(11) {
+		t.Skip("network devices are supported from macOS 11")
+	}
+	devices := newMultiNICNetworkDevices(t)
+	emitter := vz.NewEventEmitterWithNetworkDevices(devices)
+	disconnectErr := errors.New("disconnected")
+	for _, index := range []int{1, 0, -1, 2} {
+		emitter.EmitNetworkDisconnected(index, disconnectErr)
+	}
+	emitter.Close()
+
+	cases := []struct {
+		wantIndex  int
+		wantConfig *vz.VirtioNetworkDeviceConfiguration
+	}{
+		{wantIndex: 1, wantConfig: devices[1]},
+		{wantIndex: 0, wantConfig: devices[0]},
+		{wantIndex: -1},
+		{wantIndex: -1},
+	}
+	for i, tc := range cases {
+		event := <-emitter.Events()
+		var disconnected *vz.DisconnectedError
+		if !errors.As(event.Err, &disconnected) || !errors.Is(event.Err, disconnectErr) {
+			t.Fatalf("event %d: want *vz.DisconnectedError wrapping %v but got %v", i, disconnectErr, event.Err)
+		}
+		if disconnected.Index != tc.wantIndex || disconnected.Config != tc.wantConfig {
+			t.Errorf("event %d: want device %d (%v) but got %d (%v)", i, tc.wantIndex, tc.wantConfig, disconnected.Index, disconnected.Config)
+		}
+	}
+}
+
+func TestVirtualMachineMultipleNetworkDevices(t *testing.T) {
+	if vz.Available(11) {
+		t.Skip("network devices are supported from macOS 11")
+	}
+	bootLoader, err := vz.NewLinuxBootLoader("./testdata/Image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := setupConfiguration(bootLoader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices := newMultiNICNetworkDevices(t)
+	config.SetNetworkDevicesVirtualMachineConfiguration(devices)
+	if ok, err := config.Validate(); !ok || err != nil {
+		t.Fatalf("want the configuration with two network devices to validate but got %v, %v", ok, err)
+	}
+	vm, err := vz.NewVirtualMachine(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Disconnections are reported by the index of the devices the virtual machine was created with.
+	config.SetNetworkDevicesVirtualMachineConfiguration(devices[1:])
+	got := vm.NetworkDevices()
+	if len(got) != 2 || got[0] != devices[0] || got[1] != devices[1] {
+		t.Fatalf("want the network devices in the configured order but got %v", got)
+	}
+}
@@ -1,9 +1,32 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
+
+	"github.com/Code-Hex/vz/v3"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	MetadataFileName = "info.json"
+)
+
+// Operating systems of the guest.
+const (
+	OSKindLinux = "linux"
+	OSKindMacOS = "macos"
+)
+
+// Boot types of the guest.
+const (
+	BootTypeEFI   = "efi"   // boot from the disk with the EFI boot loader
+	BootTypeLinux = "linux" // boot the kernel (and initial ramdisk) in the bundle directly
+	BootTypeMacOS = "macos" // boot with the macOS boot loader
 )
 
 // Bundle represents a VM bundle directory containing disk, EFI, and machine ID.
@@ -27,19 +50,164 @@ func (b *Bundle) Exists() bool {
 	return err == nil
 }
 
+// Layout returns the standard layout of the files in the bundle.
+func (b *Bundle) Layout() vz.BundleLayout {
+	return vz.NewBundleLayout(b.Path)
+}
+
 // DiskImagePath returns the path to the disk image.
 func (b *Bundle) DiskImagePath() string {
-	return filepath.Join(b.Path, "Disk.img")
+	return b.Layout().DiskImagePath()
 }
 
 // EFIVariableStorePath returns the path to the EFI variable store.
 func (b *Bundle) EFIVariableStorePath() string {
-	return filepath.Join(b.Path, "NVRAM")
+	return b.Layout().EFIVariableStorePath()
 }
 
 // MachineIdentifierPath returns the path to the machine identifier.
 func (b *Bundle) MachineIdentifierPath() string {
-	return filepath.Join(b.Path, "MachineIdentifier")
+	return b.Layout().MachineIdentifierPath()
+}
+
+// KernelPath returns the path to the Linux kernel used by BootTypeLinux.
+func (b *Bundle) KernelPath() string {
+	return b.Layout().KernelPath()
+}
+
+// InitrdPath returns the path to the optional initial ramdisk used by BootTypeLinux.
+func (b *Bundle) InitrdPath() string {
+	return b.Layout().InitrdPath()
+}
+
+// EnsureDisk creates an empty sparse disk image of size bytes at DiskImagePath unless the bundle
+// already has a disk image, which is kept whatever its size is.
+func (b *Bundle) EnsureDisk(size int64) error {
+	if _, err := os.Lstat(b.DiskImagePath()); err == nil {
+		return nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := vz.CreateDiskImage(b.DiskImagePath(), size); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("failed to create disk image: %w", err)
+	}
+	return nil
+}
+
+// MetadataPath returns the path to the metadata file.
+func (b *Bundle) MetadataPath() string {
+	return filepath.Join(b.Path, MetadataFileName)
+}
+
+// BundleMetadata describes the VM stored in a bundle, so the bundle is
+// self-describing even if it is moved without the registry.
+type BundleMetadata struct {
+	Name       string    `json:"name"`
+	ISOPath    string    `json:"iso_path,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	OSKind     string    `json:"os_kind"`
+	BootType   string    `json:"boot_type"`
+	GoldenPath string    `json:"golden_path,omitempty"` // read-only disk image cloned to Disk.img
+}
+
+// WriteMetadata writes meta to the metadata file of the bundle.
+func (b *Bundle) WriteMetadata(meta BundleMetadata) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal bundle metadata: %w", err)
+	}
+	if err := os.WriteFile(b.MetadataPath(), data, 0644); err != nil {
+		return fmt.Errorf("failed to write bundle metadata: %w", err)
+	}
+	return nil
+}
+
+// ReadMetadata reads the metadata file of the bundle.
+// The error wraps os.ErrNotExist if the bundle has no metadata.
+func (b *Bundle) ReadMetadata() (BundleMetadata, error) {
+	var meta BundleMetadata
+	data, err := os.ReadFile(b.MetadataPath())
+	if err != nil {
+		return meta, fmt.Errorf("failed to read bundle metadata: %w", err)
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, fmt.Errorf("failed to parse bundle metadata: %w", err)
+	}
+	return meta, nil
+}
+
+// PrepareOverlay makes Disk.img a private copy-on-write clone of the golden disk image at
+// goldenPath, so that many VMs can boot the same golden image without writing to it.
+// It does nothing if Disk.img already exists, so the clone is only made on the first boot.
+//
+// The Virtualization framework has no block level overlay, so the image is cloned with
+// clonefile(2), which shares the blocks with the golden image until either is written.
+// If the bundle is not on the same APFS volume as the golden image, the image is copied instead.
+func (b *Bundle) PrepareOverlay(goldenPath string) error {
+	if _, err := os.Lstat(b.DiskImagePath()); err == nil {
+		return nil
+	}
+	if err := checkRawDiskImage(goldenPath); err != nil {
+		return err
+	}
+
+	// Clone to a temporary name first, so an interrupted copy is never booted.
+	tmpPath := b.DiskImagePath() + ".tmp"
+	os.Remove(tmpPath)
+	err := cloneFile(tmpPath, goldenPath)
+	if err == nil {
+		err = os.Chmod(tmpPath, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, b.DiskImagePath())
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to clone golden image %q: %w", goldenPath, err)
+	}
+	return nil
+}
+
+// cloneFile clones src to dst with clonefile(2), which shares the blocks until either file is
+// written. If they are not on the same APFS volume, src is copied instead. dst must not exist.
+func cloneFile(dst, src string) error {
+	err := unix.Clonefile(src, dst, 0)
+	if errors.Is(err, unix.EXDEV) || errors.Is(err, unix.ENOTSUP) {
+		err = copySparseFile(dst, src)
+	}
+	return err
+}
+
+// CopyEFIVariableStoreFrom replaces the EFI variable store of the bundle with a copy of the one
+// of other, e.g. when cloning a VM, so that the clone boots the same boot entry as other.
+// Neither VM may be running.
+//
+// The store is copied to a temporary name first, so an interrupted copy leaves the
+// EFI variable store of the bundle as it was.
+func (b *Bundle) CopyEFIVariableStoreFrom(other *Bundle) error {
+	src, dst := other.EFIVariableStorePath(), b.EFIVariableStorePath()
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("EFI variable store of %q: %w", other.Path, err)
+	}
+	if dstInfo, err := os.Stat(dst); err == nil && os.SameFile(srcInfo, dstInfo) {
+		return fmt.Errorf("EFI variable store %q can not be copied onto itself", src)
+	}
+	if err := b.Create(); err != nil {
+		return err
+	}
+
+	tmpPath := dst + ".tmp"
+	os.Remove(tmpPath)
+	err = cloneFile(tmpPath, src)
+	if err == nil {
+		err = os.Rename(tmpPath, dst)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to copy EFI variable store from %q: %w", other.Path, err)
+	}
+	return nil
 }
 
 // IsInstalled returns true if the bundle has been initialized (has NVRAM).
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBundleMetadata(t *testing.T) {
+	bundle := NewBundle(filepath.Join(t.TempDir(), "test.bundle"))
+	if err := bundle.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bundle.ReadMetadata(); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("want os.ErrNotExist but got %v", err)
+	}
+
+	entry := VMEntry{
+		Name:       "test",
+		BundleName: "test.bundle",
+		ISOPath:    "/path/to/installer.iso",
+		CreatedAt:  time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		OSKind:     OSKindLinux,
+		BootType:   BootTypeEFI,
+	}
+	want := entry.Metadata()
+	if err := bundle.WriteMetadata(want); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reload from another Bundle to make sure nothing is kept in memory.
+	got, err := NewBundle(bundle.Path).ReadMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != want.Name || got.ISOPath != want.ISOPath || got.OSKind != OSKindLinux || got.BootType != BootTypeEFI || !got.CreatedAt.Equal(want.CreatedAt) {
+		t.Fatalf("want %+v but got %+v", want, got)
+	}
+}
+
+func TestBundleMetadataInvalid(t *testing.T) {
+	bundle := NewBundle(t.TempDir())
+	if err := os.WriteFile(bundle.MetadataPath(), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bundle.ReadMetadata(); err == nil {
+		t.Fatal("want error for invalid metadata")
+	}
+}
+
+func TestBundlePrepareOverlay(t *testing.T) {
+	golden := filepath.Join(t.TempDir(), "golden.img")
+	content := append(bytes.Repeat([]byte{1}, 4096), make([]byte, 1<<20)...)
+	if err := os.WriteFile(golden, content, 0444); err != nil {
+		t.Fatal(err)
+	}
+
+	var bundles []*Bundle
+	for _, name := range []string{"vm1.bundle", "vm2.bundle"} {
+		bundle := NewBundle(filepath.Join(t.TempDir(), name))
+		if err := bundle.Create(); err != nil {
+			t.Fatal(err)
+		}
+		if err := bundle.PrepareOverlay(golden); err != nil {
+			t.Fatal(err)
+		}
+		bundles = append(bundles, bundle)
+	}
+
+	// Each VM writes to its own copy.
+	for i, bundle := range bundles {
+		f, err := os.OpenFile(bundle.DiskImagePath(), os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.WriteAt([]byte{byte(10 + i)}, 0); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	for i, bundle := range bundles {
+		got, err := os.ReadFile(bundle.DiskImagePath())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(content) || got[0] != byte(10+i) || !bytes.Equal(got[1:], content[1:]) {
+			t.Errorf("bundle %d: want the golden image with its own write", i)
+		}
+	}
+	got, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("want the golden image unchanged")
+	}
+
+	// The clone is only made once, later boots keep the written disk.
+	if err := bundles[0].PrepareOverlay(golden); err != nil {
+		t.Fatal(err)
+	}
+	got, err = os.ReadFile(bundles[0].DiskImagePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != 10 {
+		t.Error("want the existing disk kept")
+	}
+
+	if err := NewBundle(t.TempDir()).PrepareOverlay(filepath.Join(t.TempDir(), "missing.img")); err == nil {
+		t.Error("want error for a missing golden image")
+	}
+}
+
+func TestBundleCopyEFIVariableStoreFrom(t *testing.T) {
+	source := NewBundle(filepath.Join(t.TempDir(), "source.bundle"))
+	if err := source.Create(); err != nil {
+		t.Fatal(err)
+	}
+	clone := NewBundle(filepath.Join(t.TempDir(), "clone.bundle"))
+
+	if err := clone.CopyEFIVariableStoreFrom(source); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("want os.ErrNotExist for a bundle without EFI variable store but got %v", err)
+	}
+
+	nvram := append([]byte("BootOrder"), bytes.Repeat([]byte{0xff}, 4096)...)
+	if err := os.WriteFile(source.EFIVariableStorePath(), nvram, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := clone.CopyEFIVariableStoreFrom(source); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(clone.EFIVariableStorePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, nvram) {
+		t.Error("want the EFI variable store copied")
+	}
+	if !clone.IsInstalled() {
+		t.Error("want the clone installed")
+	}
+
+	// Copying again replaces the store, and the copies are independent.
+	if err := os.WriteFile(clone.EFIVariableStorePath(), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := clone.CopyEFIVariableStoreFrom(source); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(clone.EFIVariableStorePath()); err != nil || !bytes.Equal(got, nvram) {
+		t.Errorf("want the EFI variable store replaced but got %v", err)
+	}
+	if got, err := os.ReadFile(source.EFIVariableStorePath()); err != nil || !bytes.Equal(got, nvram) {
+		t.Errorf("want the source EFI variable store unchanged but got %v", err)
+	}
+	if _, err := os.Stat(clone.EFIVariableStorePath() + ".tmp"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("want no leftover temporary file but got %v", err)
+	}
+
+	if err := source.CopyEFIVariableStoreFrom(NewBundle(source.Path)); err == nil {
+		t.Error("want error for copying onto itself")
+	}
+}
+
+func TestBundleEnsureDisk(t *testing.T) {
+	bundle := NewBundle(filepath.Join(t.TempDir(), "test.bundle"))
+	if err := bundle.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bundle.EnsureDisk(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(bundle.DiskImagePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 1<<20 {
+		t.Fatalf("want a disk of %d bytes but got %d", 1<<20, fi.Size())
+	}
+
+	// An existing disk is kept, even if it has another size.
+	if err := os.WriteFile(bundle.DiskImagePath(), []byte("installed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := bundle.EnsureDisk(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(bundle.DiskImagePath()); err != nil || string(got) != "installed" {
+		t.Fatalf("want the existing disk to be kept but got %q (%v)", got, err)
+	}
+}
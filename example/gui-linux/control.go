@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// controlSocketName is the name of the unix socket, in the base directory, on which the
+// GUI process answers queries from other invocations (e.g. list).
+const controlSocketName = "control.sock"
+
+// controlTimeout bounds a whole query on the control socket, so that a hung GUI process
+// does not hang the command querying it.
+const controlTimeout = 2 * time.Second
+
+// Live statuses of a VM as reported by the process owning it.
+const (
+	vmStatusRunning = "running"
+	vmStatusPaused  = "paused"
+	vmStatusStopped = "stopped"
+)
+
+// controlStatusCommand requests the live status of the VMs owned by the GUI process.
+const controlStatusCommand = "status"
+
+// ErrControlUnavailable is returned when no process is serving the control socket.
+var ErrControlUnavailable = errors.New("control socket unavailable")
+
+// ErrControlInUse is returned when another process is already serving the control socket.
+var ErrControlInUse = errors.New("control socket in use by another process")
+
+// controlStatusResponse is the reply to controlStatusCommand. VMs maps the name of each
+// VM owned by the process to its live status; VMs which are not listed are stopped.
+type controlStatusResponse struct {
+	VMs   map[string]string `json:"vms"`
+	Error string            `json:"error,omitempty"`
+}
+
+// ControlSocketPath returns the path of the control socket of the GUI process.
+func ControlSocketPath() string {
+	return filepath.Join(BaseDirectory(), controlSocketName)
+}
+
+// listenControl listens on the control socket at path. A socket left behind by a process
+// which exited without cleaning up is replaced, while one which is still served results
+// in ErrControlInUse.
+func listenControl(path string) (net.Listener, error) {
+	if _, err := os.Lstat(path); err == nil {
+		if conn, err := net.DialTimeout("unix", path, controlTimeout); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%w: %s", ErrControlInUse, path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale control socket: %w", err)
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+	return ln, nil
+}
+
+// serveControl answers the queries made on ln until ln is closed. statuses returns the
+// live status of each VM owned by the process.
+func serveControl(ln net.Listener, statuses func() map[string]string) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("control socket: %v", err)
+			}
+			return
+		}
+		go handleControlConn(conn, statuses)
+	}
+}
+
+func handleControlConn(conn net.Conn, statuses func() map[string]string) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+	var resp controlStatusResponse
+	switch command := strings.TrimSpace(line); command {
+	case controlStatusCommand:
+		resp.VMs = statuses()
+	default:
+		resp.Error = fmt.Sprintf("unknown command %q", command)
+	}
+	if err := json.NewEncoder(conn).Encode(&resp); err != nil {
+		log.Printf("control socket: failed to reply: %v", err)
+	}
+}
+
+// queryControlStatus asks the process serving the control socket at path for the live
+// status of the VMs it owns. ErrControlUnavailable is returned if no process serves it.
+func queryControlStatus(path string) (map[string]string, error) {
+	conn, err := net.DialTimeout("unix", path, controlTimeout)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, fmt.Errorf("%w: %s", ErrControlUnavailable, path)
+		}
+		return nil, fmt.Errorf("failed to connect to control socket: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	if _, err := fmt.Fprintln(conn, controlStatusCommand); err != nil {
+		return nil, fmt.Errorf("failed to query control socket: %w", err)
+	}
+	var resp controlStatusResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to read control socket reply: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("control socket: %s", resp.Error)
+	}
+	return resp.VMs, nil
+}
+
+// liveStatus returns the status of the VM name according to statuses, as returned by
+// queryControlStatus.
+func liveStatus(statuses map[string]string, name string) string {
+	if status, ok := statuses[name]; ok {
+		return status
+	}
+	return vmStatusStopped
+}
+
+// listStatus returns the status shown by list for a VM with the given live status. The
+// static status of a stopped VM (e.g. needs boot media) is kept, as it is still relevant.
+func listStatus(live, static string) string {
+	if live == vmStatusStopped && static != "ready" {
+		return live + ", " + static
+	}
+	return live
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// shortTempDir returns a temporary directory with a path short enough for a unix socket,
+// as the directories of t.TempDir can exceed the limit on macOS.
+func shortTempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "vz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestControlStatus(t *testing.T) {
+	t.Setenv(baseDirectoryEnvVar, shortTempDir(t))
+
+	path := ControlSocketPath()
+	if _, err := queryControlStatus(path); !errors.Is(err, ErrControlUnavailable) {
+		t.Fatalf("want ErrControlUnavailable without a GUI process, got %v", err)
+	}
+
+	var mu sync.Mutex
+	owned := map[string]string{
+		"web": vmStatusRunning,
+		"db":  vmStatusPaused,
+	}
+	ln, err := listenControl(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go serveControl(ln, func() map[string]string {
+		mu.Lock()
+		defer mu.Unlock()
+		statuses := make(map[string]string, len(owned))
+		for name, status := range owned {
+			statuses[name] = status
+		}
+		return statuses
+	})
+
+	if _, err := listenControl(path); !errors.Is(err, ErrControlInUse) {
+		t.Fatalf("want ErrControlInUse while served, got %v", err)
+	}
+
+	statuses, err := queryControlStatus(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"web":  vmStatusRunning,
+		"db":   vmStatusPaused,
+		"mail": vmStatusStopped,
+	} {
+		if got := liveStatus(statuses, name); got != want {
+			t.Errorf("status of %q: want %q, got %q", name, want, got)
+		}
+	}
+
+	// The status reflects changes made by the owning process.
+	mu.Lock()
+	delete(owned, "web")
+	owned["db"] = vmStatusRunning
+	mu.Unlock()
+	statuses, err = queryControlStatus(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"db": vmStatusRunning}; !reflect.DeepEqual(statuses, want) {
+		t.Errorf("want %v, got %v", want, statuses)
+	}
+}
+
+func TestListenControlReplacesStaleSocket(t *testing.T) {
+	t.Setenv(baseDirectoryEnvVar, shortTempDir(t))
+	path := ControlSocketPath()
+
+	// A socket file which no process serves, as left behind by a crashed process.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := queryControlStatus(path); !errors.Is(err, ErrControlUnavailable) {
+		t.Fatalf("want ErrControlUnavailable for a stale socket, got %v", err)
+	}
+
+	ln, err := listenControl(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go serveControl(ln, func() map[string]string { return map[string]string{} })
+	if _, err := queryControlStatus(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListStatus(t *testing.T) {
+	cases := []struct {
+		live, static, want string
+	}{
+		{vmStatusRunning, "ready", "running"},
+		{vmStatusPaused, "ready", "paused"},
+		{vmStatusStopped, "ready", "stopped"},
+		{vmStatusStopped, "needs boot media", "stopped, needs boot media"},
+		{vmStatusRunning, "needs boot media", "running"},
+	}
+	for _, tc := range cases {
+		if got := listStatus(tc.live, tc.static); got != tc.want {
+			t.Errorf("listStatus(%q, %q): want %q, got %q", tc.live, tc.static, tc.want, got)
+		}
+	}
+}
@@ -7,12 +7,11 @@ replace github.com/Code-Hex/vz/v3 => ../../
 require (
 	github.com/Code-Hex/vz/v3 v3.0.0-00010101000000-000000000000
 	github.com/Songmu/prompter v0.5.1
+	golang.org/x/sys v0.39.0
 )
 
 require (
 	github.com/Code-Hex/go-infinity-channel v1.0.0 // indirect
 	github.com/mattn/go-isatty v0.0.14 // indirect
-	golang.org/x/mod v0.22.0 // indirect
-	golang.org/x/sys v0.39.0 // indirect
 	golang.org/x/term v0.0.0-20220526004731-065cf7ba2467 // indirect
 )
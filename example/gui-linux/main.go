@@ -10,52 +10,131 @@ void setupAppFileMenu(void);
 */
 import "C"
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/Code-Hex/vz/v3"
 )
 
 const isoEnvVar = "ISO"
 
+// installerWritableEnvVar attaches the installer media as read-write when set to a non-empty value.
+const installerWritableEnvVar = "INSTALLER_WRITABLE"
+
 // Channels for GUI menu events
 var (
 	createVMCh = make(chan [2]string, 10) // receives (isoPath, vmName)
 	startVMCh  = make(chan [2]string, 10) // receives (vmName, isoPath)
 )
 
-// runningVMs tracks which VMs are currently running to prevent double-starts
+// maxConcurrentStarts bounds the VMs which are starting at the same time, so that starting
+// several VMs at once (e.g. from the menu) does not exhaust the host.
+const maxConcurrentStarts = 2
+
+var startSemaphore, _ = vz.NewStartSemaphore(maxConcurrentStarts)
+
+// runningVMs tracks which VMs are currently running to prevent double-starts.
+// The VM is nil while it is being created and started.
 var runningVMs = struct {
 	sync.RWMutex
-	names map[string]bool
-}{names: make(map[string]bool)}
+	vms map[string]*vz.VirtualMachine
+}{vms: make(map[string]*vz.VirtualMachine)}
 
 func markRunning(name string) bool {
 	runningVMs.Lock()
 	defer runningVMs.Unlock()
-	if runningVMs.names[name] {
+	if _, ok := runningVMs.vms[name]; ok {
 		return false // already running
 	}
-	runningVMs.names[name] = true
+	runningVMs.vms[name] = nil
 	return true
 }
 
+func setRunningVM(name string, vm *vz.VirtualMachine) {
+	runningVMs.Lock()
+	defer runningVMs.Unlock()
+	runningVMs.vms[name] = vm
+}
+
 func markStopped(name string) {
 	runningVMs.Lock()
 	defer runningVMs.Unlock()
-	delete(runningVMs.names, name)
+	delete(runningVMs.vms, name)
 }
 
 func isRunning(name string) bool {
 	runningVMs.RLock()
 	defer runningVMs.RUnlock()
-	return runningVMs.names[name]
+	_, ok := runningVMs.vms[name]
+	return ok
+}
+
+// runningVMStatuses returns the live status of each VM owned by this process, for the
+// control socket.
+func runningVMStatuses() map[string]string {
+	runningVMs.RLock()
+	defer runningVMs.RUnlock()
+	statuses := make(map[string]string, len(runningVMs.vms))
+	for name, vm := range runningVMs.vms {
+		if vm == nil {
+			// Still being created and started.
+			statuses[name] = vmStatusRunning
+			continue
+		}
+		statuses[name] = vmStatus(vm.State())
+	}
+	return statuses
+}
+
+// vmStatus maps the state of a VM to the live status shown by list.
+func vmStatus(state vz.VirtualMachineState) string {
+	switch state {
+	case vz.VirtualMachineStatePaused, vz.VirtualMachineStatePausing, vz.VirtualMachineStateSaving:
+		return vmStatusPaused
+	case vz.VirtualMachineStateStopped, vz.VirtualMachineStateError:
+		return vmStatusStopped
+	default:
+		return vmStatusRunning
+	}
+}
+
+// shutdownTimeout is how long a guest is given to shut down when the application quits.
+const shutdownTimeout = 30 * time.Second
+
+// stopRunningVMs stops the running VMs cleanly so that their disks are not corrupted.
+func stopRunningVMs() {
+	runningVMs.RLock()
+	vms := make(map[string]*vz.VirtualMachine, len(runningVMs.vms))
+	for name, vm := range runningVMs.vms {
+		if vm != nil {
+			vms[name] = vm
+		}
+	}
+	runningVMs.RUnlock()
+
+	var wg sync.WaitGroup
+	for name, vm := range vms {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			log.Printf("[%s] stopping VM...", name)
+			if err := vm.GracefulStop(shutdownTimeout); err != nil {
+				log.Printf("[%s] failed to stop VM: %v", name, err)
+			}
+		}()
+	}
+	wg.Wait()
 }
 
 // CGO exports for Obj-C menu callbacks
@@ -115,11 +194,19 @@ Commands:
   (none)                        Open GUI with no VMs started
   start [name] [-iso path]      Start a VM (default: "default")
   create [name] -iso path       Create and start a new VM (default: "default")
-  list                          List all VMs
+  list                          List all VMs (with their live status while the GUI runs)
+  snapshots <name>              List the saved states of a VM
+  validate <name> [-iso path]   Validate the configuration of a VM without starting it
   delete <name> [--force]       Delete a VM (--force stops if running)
+  import <name> -disk path [--reference|--golden]
+                                Create a VM from an existing raw disk image
+                                (--reference links the disk instead of copying it,
+                                --golden boots a private clone of the read-only disk)
 
 Environment:
   ISO                           Default ISO path for start/create
+  INSTALLER_WRITABLE            Attach the installer media as read-write if set
+  VZ_VM_HOME                    Directory of the registry and bundles (default: ~/GUI Linux VM)
 
 Legacy:
   -install                      Start 'default' VM with INSTALLER_ISO_PATH env
@@ -133,8 +220,10 @@ Examples:
   %[1]s create myvm -iso boot.iso    # Create new VM with ISO
   ISO=boot.iso %[1]s create myvm     # Create using env var
   %[1]s list                         # List all VMs
+  %[1]s validate myvm                # Validate the configuration of a VM
   %[1]s delete myvm                  # Delete a VM
   %[1]s delete myvm --force          # Stop and delete a running VM
+  %[1]s import myvm -disk ubuntu.img # Import a VM from a disk image
 `, os.Args[0])
 }
 
@@ -148,6 +237,16 @@ func getISOPath(args []string) string {
 	return os.Getenv(isoEnvVar)
 }
 
+// getDiskPath returns the disk image path from args
+func getDiskPath(args []string) string {
+	for i, arg := range args {
+		if (arg == "-disk" || arg == "--disk") && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
 // getNameArg returns the name argument (first non-flag arg after command)
 func getNameArg(args []string) string {
 	for _, arg := range args {
@@ -163,6 +262,10 @@ func run() error {
 	if err != nil {
 		return fmt.Errorf("failed to load registry: %w", err)
 	}
+	// Finish deleting the bundles of VMs whose removal was interrupted.
+	if err := registry.Reconcile(); err != nil {
+		log.Printf("warning: %v", err)
+	}
 
 	// No args = open GUI with no VMs
 	if len(os.Args) < 2 {
@@ -204,6 +307,20 @@ func run() error {
 	case "list":
 		return runListCommand(registry)
 
+	case "snapshots":
+		name := getNameArg(args)
+		if name == "" {
+			return fmt.Errorf("usage: %s snapshots <name>", os.Args[0])
+		}
+		return runSnapshotsCommand(registry, name)
+
+	case "validate":
+		name := getNameArg(args)
+		if name == "" {
+			return fmt.Errorf("usage: %s validate <name> [-iso path]", os.Args[0])
+		}
+		return runValidateCommand(registry, name, getISOPath(args))
+
 	case "delete":
 		name := getNameArg(args)
 		if name == "" {
@@ -217,6 +334,26 @@ func run() error {
 		}
 		return runDeleteCommand(registry, name, force)
 
+	case "import":
+		name := getNameArg(args)
+		diskPath := getDiskPath(args)
+		if name == "" || diskPath == "" {
+			return fmt.Errorf("usage: %s import <name> -disk <path> [--reference|--golden]", os.Args[0])
+		}
+		reference, golden := false, false
+		for _, arg := range args {
+			switch arg {
+			case "--reference":
+				reference = true
+			case "--golden":
+				golden = true
+			}
+		}
+		if reference && golden {
+			return fmt.Errorf("--reference and --golden can not be used together")
+		}
+		return runImportCommand(registry, name, diskPath, reference, golden)
+
 	case "-h", "--help", "help":
 		usage()
 		return nil
@@ -279,6 +416,9 @@ func runCreateCommand(registry *Registry, name, isoPath string) error {
 	if _, err := os.Stat(isoPath); os.IsNotExist(err) {
 		return fmt.Errorf("ISO not found: %s", isoPath)
 	}
+	if warning := isoBootWarning(isoPath); warning != "" {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
 
 	entry, err := registry.Add(name, isoPath)
 	if err != nil {
@@ -289,6 +429,9 @@ func runCreateCommand(registry *Registry, name, isoPath string) error {
 	if err := bundle.Create(); err != nil {
 		return fmt.Errorf("failed to create bundle: %w", err)
 	}
+	if err := bundle.WriteMetadata(entry.Metadata()); err != nil {
+		return err
+	}
 
 	fmt.Printf("Created VM %q\n", name)
 	return runEventLoop(registry, &vmStartRequest{
@@ -298,6 +441,39 @@ func runCreateCommand(registry *Registry, name, isoPath string) error {
 	})
 }
 
+func runImportCommand(registry *Registry, name, diskPath string, reference, golden bool) error {
+	// Expand ~ in disk path
+	if strings.HasPrefix(diskPath, "~/") {
+		home, _ := os.UserHomeDir()
+		diskPath = filepath.Join(home, diskPath[2:])
+	}
+
+	var entry *VMEntry
+	var err error
+	if golden {
+		entry, err = registry.ImportGolden(name, diskPath)
+	} else {
+		entry, err = registry.Import(name, diskPath, reference)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to import VM: %w", err)
+	}
+
+	bundle := registry.BundleFor(entry)
+	if _, err := createAndSaveMachineIdentifier(bundle.MachineIdentifierPath()); err != nil {
+		return err
+	}
+	if _, err := createEFIVariableStore(bundle.EFIVariableStorePath()); err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported VM %q from %s\n", name, diskPath)
+	return runEventLoop(registry, &vmStartRequest{
+		entry:  entry,
+		bundle: bundle,
+	})
+}
+
 func runListCommand(registry *Registry) error {
 	vms := registry.List()
 	if len(vms) == 0 {
@@ -305,6 +481,13 @@ func runListCommand(registry *Registry) error {
 		return nil
 	}
 
+	// The live status is only known while the GUI process serves the control socket.
+	statuses, err := queryControlStatus(ControlSocketPath())
+	if err != nil && !errors.Is(err, ErrControlUnavailable) {
+		fmt.Fprintf(os.Stderr, "Warning: cannot query the live status of VMs: %v\n", err)
+	}
+	live := err == nil
+
 	fmt.Println("Virtual Machines:")
 	for _, vm := range vms {
 		bundle := registry.BundleFor(&vm)
@@ -312,15 +495,49 @@ func runListCommand(registry *Registry) error {
 		if !bundle.HasBootableDisk() && vm.ISOPath != "" {
 			status = "needs boot media"
 		}
+		if live {
+			status = listStatus(liveStatus(statuses, vm.Name), status)
+		}
+		disk := ""
+		if logicalSize, allocatedSize, _, err := vz.DiskImageInfo(bundle.DiskImagePath()); err == nil {
+			disk = fmt.Sprintf(" %s (%s used)", formatSize(logicalSize), formatSize(allocatedSize))
+		}
 		iso := ""
 		if vm.ISOPath != "" {
 			iso = fmt.Sprintf(" (iso: %s)", vm.ISOPath)
 		}
-		fmt.Printf("  %s [%s]%s\n", vm.Name, status, iso)
+		fmt.Printf("  %s [%s] %s/%s%s%s\n", vm.Name, status, vm.OSKind, vm.BootType, disk, iso)
 	}
 	return nil
 }
 
+func runSnapshotsCommand(registry *Registry, name string) error {
+	entry := registry.Find(name)
+	if entry == nil {
+		return fmt.Errorf("VM %q not found", name)
+	}
+	states, err := registry.BundleFor(entry).ListStates()
+	if err != nil {
+		return err
+	}
+	if len(states) == 0 {
+		fmt.Printf("No saved states for %s.\n", name)
+		return nil
+	}
+
+	fmt.Printf("Saved states of %s:\n", name)
+	for _, state := range states {
+		fmt.Printf("  %s (%s)\n", state.Name, state.CreatedAt.Format(time.DateTime))
+	}
+	return nil
+}
+
+// formatSize formats bytes in GiB, e.g. "64 GiB" or "1.5 GiB".
+func formatSize(size uint64) string {
+	gib := strconv.FormatFloat(float64(size)/(1<<30), 'f', 1, 64)
+	return strings.TrimSuffix(gib, ".0") + " GiB"
+}
+
 func runDeleteCommand(registry *Registry, name string, force bool) error {
 	if !registry.Exists(name) {
 		return fmt.Errorf("VM %q not found", name)
@@ -366,11 +583,19 @@ func runEventLoop(registry *Registry, initialVM *vmStartRequest) error {
 	go handleCreateVMRequests()
 	go handleStartVMRequests()
 
+	// Answer status queries from other invocations (e.g. list)
+	if ln, err := listenControl(ControlSocketPath()); err != nil {
+		log.Printf("Control socket disabled: %v", err)
+	} else {
+		defer ln.Close()
+		go serveControl(ln, runningVMStatuses)
+	}
+
 	// Start initial VM if requested
 	if initialVM != nil {
 		needsInstall := initialVM.isoPath != ""
 		log.Printf("Starting VM %q (needsInstall=%v)", initialVM.entry.Name, needsInstall)
-		if err := createAndShowVM(initialVM.isoPath, needsInstall, initialVM.entry.Name, initialVM.bundle); err != nil {
+		if err := createAndShowVM(initialVM.entry, initialVM.isoPath, needsInstall, initialVM.bundle); err != nil {
 			return err
 		}
 	}
@@ -383,8 +608,19 @@ func runEventLoop(registry *Registry, initialVM *vmStartRequest) error {
 		C.setupAppFileMenu()
 	}()
 
+	// Stop VMs and quit the event loop on Ctrl+C
+	go func() {
+		signalCh := make(chan os.Signal, 1)
+		signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM)
+		<-signalCh
+		log.Printf("Received signal, stopping application...")
+		if err := vz.StopApplication(shutdownTimeout); err != nil {
+			log.Printf("Failed to stop application: %v", err)
+		}
+	}()
+
 	log.Printf("Running application event loop...")
-	return vz.RunApplication()
+	return vz.RunApplication(vz.WithShutdownHook(stopRunningVMs))
 }
 
 func handleStartVMRequests() {
@@ -419,7 +655,7 @@ func handleStartVMRequests() {
 		}
 		needsInstall := effectiveISO != ""
 
-		if err := createAndShowVM(effectiveISO, needsInstall, entry.Name, bundle); err != nil {
+		if err := createAndShowVM(entry, effectiveISO, needsInstall, bundle); err != nil {
 			log.Printf("Failed to start VM %q: %v", vmName, err)
 		}
 	}
@@ -459,20 +695,34 @@ func handleCreateVMRequests() {
 			log.Printf("Failed to create bundle: %v", err)
 			continue
 		}
+		if err := bundle.WriteMetadata(entry.Metadata()); err != nil {
+			log.Printf("Failed to write bundle metadata: %v", err)
+		}
 
-		if err := createAndShowVM(isoPath, true, entry.Name, bundle); err != nil {
+		if err := createAndShowVM(entry, isoPath, true, bundle); err != nil {
 			log.Printf("Failed to create VM from %s: %v", isoPath, err)
 		}
 	}
 }
 
-func createAndShowVM(isoPath string, needsInstall bool, title string, bundle *Bundle) error {
+func createAndShowVM(entry *VMEntry, isoPath string, needsInstall bool, bundle *Bundle) error {
+	title := entry.Name
 	// Mark VM as running (prevent double-start)
 	if !markRunning(title) {
+		// Bring the window of the running VM to the front instead
+		if err := vz.FocusWindowByTitle(title); err == nil {
+			return nil
+		}
 		return fmt.Errorf("VM %q is already running", title)
 	}
 
-	config, err := createVirtualMachineConfig(isoPath, needsInstall, bundle)
+	if needsInstall {
+		if warning := isoBootWarning(isoPath); warning != "" {
+			log.Printf("[%s] warning: %s", title, warning)
+		}
+	}
+
+	config, err := createVirtualMachineConfig(entry, isoPath, needsInstall, bundle)
 	if err != nil {
 		markStopped(title)
 		return fmt.Errorf("failed to create VM config: %w", err)
@@ -484,22 +734,52 @@ func createAndShowVM(isoPath string, needsInstall bool, title string, bundle *Bu
 		return fmt.Errorf("failed to create VM: %w", err)
 	}
 
-	if err := vm.Start(); err != nil {
+	if err := vm.PreflightStart(); err != nil {
+		log.Printf("[%s] warning: %v", title, err)
+	}
+
+	if err := vm.Start(vz.WithStartSemaphore(startSemaphore)); err != nil {
 		markStopped(title)
 		return fmt.Errorf("failed to start VM: %w", err)
 	}
+	setRunningVM(title, vm)
+	for _, share := range vm.DirectoryShares() {
+		log.Printf("[%s] directory share %q: %+v", title, share.Tag, share.Directories)
+	}
 
-	// Monitor VM state in background
+	// Monitor VM lifecycle events in background
 	go func() {
-		for state := range vm.StateChangedNotify() {
-			log.Printf("[%s] VM state: %v", title, state)
-			if state == vz.VirtualMachineStateStopped {
-				markStopped(title)
-				return
+		for event := range vm.Events() {
+			switch event.Kind {
+			case vz.EventStateChanged:
+				log.Printf("[%s] VM state: %v", title, event.State)
+				if event.State == vz.VirtualMachineStateStopped {
+					markStopped(title)
+					return
+				}
+			case vz.EventNetworkDisconnected:
+				log.Printf("[%s] network disconnected: %v", title, event.Err)
+			case vz.EventGuestStopped:
+				log.Printf("[%s] guest shut down", title)
+			case vz.EventStoppedWithError:
+				log.Printf("[%s] VM stopped with error: %v", title, event.Err)
+			case vz.EventWindowClosed:
+				log.Printf("[%s] window closed", title)
 			}
 		}
 	}()
 
+	// Log when the guest tools are up so that clipboard sharing works
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		if err := vm.WaitForSpiceAgent(ctx); err != nil {
+			log.Printf("[%s] SPICE agent is not connected: %v", title, err)
+			return
+		}
+		log.Printf("[%s] SPICE agent connected", title)
+	}()
+
 	// Create window (non-blocking, window shows immediately)
 	if err := vm.CreateWindow(960, 600, vz.WithWindowTitle(title), vz.WithController(true)); err != nil {
 		markStopped(title)
@@ -510,19 +790,120 @@ func createAndShowVM(isoPath string, needsInstall bool, title string, bundle *Bu
 	return nil
 }
 
-// Create an empty disk image for the virtual machine.
-func createMainDiskImage(diskPath string) error {
-	// create disk image with 64 GiB
-	if err := vz.CreateDiskImage(diskPath, 64*1024*1024*1024); err != nil {
-		if !os.IsExist(err) {
-			return fmt.Errorf("failed to create disk image: %w", err)
+// isoBootWarning returns a warning if the installer media at isoPath can not be booted, which is
+// why nothing boots after a data-only ISO image is attached, or "" if it is bootable.
+func isoBootWarning(isoPath string) string {
+	bootable, err := vz.IsBootableISO(isoPath)
+	if err != nil {
+		return fmt.Sprintf("can not check whether %s is bootable: %v", isoPath, err)
+	}
+	if !bootable {
+		return fmt.Sprintf("%s has no EFI boot image, the VM will not boot from it", isoPath)
+	}
+	return ""
+}
+
+// createPlatformConfiguration creates the platform configuration for the OS kind of the VM.
+func createPlatformConfiguration(osKind string, bundle *Bundle) (vz.PlatformConfiguration, error) {
+	switch osKind {
+	case OSKindLinux:
+		machineIdentifier, err := vz.NewGenericMachineIdentifierWithDataPath(bundle.MachineIdentifierPath())
+		if err != nil {
+			return nil, err
+		}
+		platformConfig, err := vz.NewGenericPlatformConfiguration(
+			vz.WithGenericMachineIdentifier(machineIdentifier),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create a new platform config: %w", err)
+		}
+		return platformConfig, nil
+	case OSKindMacOS:
+		return nil, fmt.Errorf("macOS guests are not supported by this example yet")
+	default:
+		return nil, fmt.Errorf("unknown OS kind %q", osKind)
+	}
+}
+
+// linuxCommandLine is the kernel command line used by BootTypeLinux.
+const linuxCommandLine = "console=hvc0 root=/dev/vda"
+
+// createBootLoader creates the boot loader for the boot type of the VM.
+func createBootLoader(bootType string, bundle *Bundle) (vz.BootLoader, error) {
+	switch bootType {
+	case BootTypeEFI:
+		efiVariableStore, err := vz.NewEFIVariableStore(bundle.EFIVariableStorePath())
+		if err != nil {
+			return nil, err
+		}
+		bootLoader, err := vz.NewEFIBootLoader(
+			vz.WithEFIVariableStore(efiVariableStore),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create a new EFI boot loader: %w", err)
+		}
+		return bootLoader, nil
+	case BootTypeLinux:
+		opts := []vz.LinuxBootLoaderOption{vz.WithCommandLine(linuxCommandLine)}
+		if _, err := os.Stat(bundle.InitrdPath()); err == nil {
+			opts = append(opts, vz.WithInitrd(bundle.InitrdPath()))
+		}
+		bootLoader, err := vz.NewLinuxBootLoader(bundle.KernelPath(), opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create a new Linux boot loader: %w", err)
+		}
+		return bootLoader, nil
+	case BootTypeMacOS:
+		return nil, fmt.Errorf("macOS guests are not supported by this example yet")
+	default:
+		return nil, fmt.Errorf("unknown boot type %q", bootType)
+	}
+}
+
+// mainDiskSize is the size of the main disk image of a new VM (64 GiB).
+const mainDiskSize = 64 * 1024 * 1024 * 1024
+
+// prepareBundle creates the files in the bundle which the config of the VM references: the files
+// of a new VM (see createInstallFiles) if needsInstall, and the overlay of the golden image.
+// It is the only step which changes the bundle, buildVirtualMachineConfig only opens existing files.
+func prepareBundle(entry *VMEntry, bundle *Bundle, needsInstall bool) error {
+	// Clone the golden image first, EnsureDisk would otherwise create an empty Disk.img
+	// and PrepareOverlay would keep it.
+	if entry.GoldenPath != "" {
+		if err := bundle.PrepareOverlay(entry.GoldenPath); err != nil {
+			return err
+		}
+	}
+	if needsInstall {
+		return createInstallFiles(entry, bundle)
+	}
+	return nil
+}
+
+// createInstallFiles creates the files of a VM which is installed from the installer media:
+// a new machine identifier and EFI variable store, and an empty main disk image unless the bundle
+// already has one.
+func createInstallFiles(entry *VMEntry, bundle *Bundle) error {
+	if entry.OSKind == OSKindLinux {
+		if _, err := createAndSaveMachineIdentifier(bundle.MachineIdentifierPath()); err != nil {
+			return err
+		}
+	}
+	if entry.BootType == BootTypeEFI {
+		if _, err := createEFIVariableStore(bundle.EFIVariableStorePath()); err != nil {
+			return err
 		}
 	}
+	if err := bundle.EnsureDisk(mainDiskSize); err != nil {
+		return fmt.Errorf("failed to create a main disk image: %w", err)
+	}
 	return nil
 }
 
 func createBlockDeviceConfiguration(diskPath string) (*vz.VirtioBlockDeviceConfiguration, error) {
-	attachment, err := vz.NewDiskImageStorageDeviceAttachmentWithCacheAndSync(diskPath, false, vz.DiskImageCachingModeAutomatic, vz.DiskImageSynchronizationModeFsync)
+	cachingMode, syncMode := vz.DiskImageCachingModeAutomatic, vz.DiskImageSynchronizationModeFsync
+	log.Printf("Attaching disk image %s (caching=%s, sync=%s)", diskPath, cachingMode, syncMode)
+	attachment, err := vz.NewDiskImageStorageDeviceAttachmentWithCacheAndSync(diskPath, false, cachingMode, syncMode)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create a new disk image storage device attachment: %w", err)
 	}
@@ -533,23 +914,6 @@ func createBlockDeviceConfiguration(diskPath string) (*vz.VirtioBlockDeviceConfi
 	return mainDisk, nil
 }
 
-func computeCPUCount() uint {
-	totalAvailableCPUs := runtime.NumCPU()
-	virtualCPUCount := uint(totalAvailableCPUs - 1)
-	if virtualCPUCount <= 1 {
-		virtualCPUCount = 1
-	}
-	maxAllowed := vz.VirtualMachineConfigurationMaximumAllowedCPUCount()
-	if virtualCPUCount > maxAllowed {
-		virtualCPUCount = maxAllowed
-	}
-	minAllowed := vz.VirtualMachineConfigurationMinimumAllowedCPUCount()
-	if virtualCPUCount < minAllowed {
-		virtualCPUCount = minAllowed
-	}
-	return virtualCPUCount
-}
-
 func computeMemorySize() uint64 {
 	memorySize := uint64(4 * 1024 * 1024 * 1024)
 	maxAllowed := vz.VirtualMachineConfigurationMaximumAllowedMemorySize()
@@ -586,10 +950,17 @@ func createEFIVariableStore(efiVariableStorePath string) (*vz.EFIVariableStore,
 	return variableStore, nil
 }
 
-func createUSBMassStorageDeviceConfiguration(installerISOPath string) (*vz.USBMassStorageDeviceConfiguration, error) {
+func createUSBMassStorageDeviceConfiguration(installerISOPath string, readOnly bool) (*vz.USBMassStorageDeviceConfiguration, error) {
+	if readOnly {
+		config, err := vz.NewISOStorageDeviceConfiguration(installerISOPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create a new USB storage device for the ISO image: %w", err)
+		}
+		return config, nil
+	}
 	installerDiskAttachment, err := vz.NewDiskImageStorageDeviceAttachment(
 		installerISOPath,
-		true,
+		readOnly,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create a new disk attachment for USBMassConfiguration: %w", err)
@@ -601,7 +972,9 @@ func createUSBMassStorageDeviceConfiguration(installerISOPath string) (*vz.USBMa
 	return config, nil
 }
 
-func createNetworkDeviceConfiguration() (*vz.VirtioNetworkDeviceConfiguration, error) {
+// createNetworkDeviceConfiguration creates a NAT network device. The MAC address is derived from
+// macAddressSeed, so the VM keeps the same address (and DHCP lease) when it is recreated.
+func createNetworkDeviceConfiguration(macAddressSeed string) (*vz.VirtioNetworkDeviceConfiguration, error) {
 	natAttachment, err := vz.NewNATNetworkDeviceAttachment()
 	if err != nil {
 		return nil, fmt.Errorf("nat attachment initialization failed: %w", err)
@@ -610,6 +983,11 @@ func createNetworkDeviceConfiguration() (*vz.VirtioNetworkDeviceConfiguration, e
 	if err != nil {
 		return nil, fmt.Errorf("failed to create a network device: %w", err)
 	}
+	mac, err := vz.DeterministicMACAddress(macAddressSeed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a MAC address: %w", err)
+	}
+	netConfig.SetMACAddress(mac)
 	return netConfig, nil
 }
 
@@ -675,6 +1053,8 @@ func createSpiceAgentConsoleDeviceConfiguration() (*vz.VirtioConsoleDeviceConfig
 	spiceAgentPort, err := vz.NewVirtioConsolePortConfiguration(
 		vz.WithVirtioConsolePortConfigurationAttachment(spiceAgentAttachment),
 		vz.WithVirtioConsolePortConfigurationName(spiceAgentName),
+		// The port of the system console is added by vz.WithRecommendedDevices.
+		vz.WithVirtioConsolePortConfigurationIsConsole(false),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create a new console port for spice agent: %w", err)
@@ -685,56 +1065,54 @@ func createSpiceAgentConsoleDeviceConfiguration() (*vz.VirtioConsoleDeviceConfig
 	return consoleDevice, nil
 }
 
-// createVirtualMachineConfig creates a VM config using the specified bundle
-func createVirtualMachineConfig(installerISOPath string, needsInstall bool, bundle *Bundle) (*vz.VirtualMachineConfiguration, error) {
-	var machineIdentifier *vz.GenericMachineIdentifier
-	var err error
-	if needsInstall {
-		machineIdentifier, err = createAndSaveMachineIdentifier(bundle.MachineIdentifierPath())
-	} else {
-		machineIdentifier, err = vz.NewGenericMachineIdentifierWithDataPath(bundle.MachineIdentifierPath())
+// createVirtualMachineConfig prepares the bundle and creates a validated VM config using it
+func createVirtualMachineConfig(entry *VMEntry, installerISOPath string, needsInstall bool, bundle *Bundle) (*vz.VirtualMachineConfiguration, error) {
+	if err := prepareBundle(entry, bundle, needsInstall); err != nil {
+		return nil, err
 	}
+	config, err := buildVirtualMachineConfig(entry, installerISOPath, needsInstall, bundle)
 	if err != nil {
 		return nil, err
 	}
-
-	platformConfig, err := vz.NewGenericPlatformConfiguration(
-		vz.WithGenericMachineIdentifier(machineIdentifier),
-	)
+	validated, err := config.Validate()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create a new platform config: %w", err)
+		return nil, fmt.Errorf("failed to validate configuration: %w", err)
 	}
-
-	var efiVariableStore *vz.EFIVariableStore
-	if needsInstall {
-		efiVariableStore, err = createEFIVariableStore(bundle.EFIVariableStorePath())
-	} else {
-		efiVariableStore, err = vz.NewEFIVariableStore(bundle.EFIVariableStorePath())
+	if !validated {
+		return nil, fmt.Errorf("invalid configuration")
 	}
+	return config, nil
+}
+
+// buildVirtualMachineConfig builds the VM config of the bundle without validating it. It never
+// changes the file system: the files of the bundle which the config references must exist, see
+// prepareBundle. needsInstall attaches the installer media.
+func buildVirtualMachineConfig(entry *VMEntry, installerISOPath string, needsInstall bool, bundle *Bundle) (*vz.VirtualMachineConfiguration, error) {
+	platformConfig, err := createPlatformConfiguration(entry.OSKind, bundle)
 	if err != nil {
 		return nil, err
 	}
-
-	bootLoader, err := vz.NewEFIBootLoader(
-		vz.WithEFIVariableStore(efiVariableStore),
-	)
+	bootLoader, err := createBootLoader(entry.BootType, bundle)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create a new EFI boot loader: %w", err)
+		return nil, err
 	}
 
 	disks := make([]vz.StorageDeviceConfiguration, 0)
 	if needsInstall {
-		usbConfig, err := createUSBMassStorageDeviceConfiguration(installerISOPath)
+		readOnly := os.Getenv(installerWritableEnvVar) == ""
+		usbConfig, err := createUSBMassStorageDeviceConfiguration(installerISOPath, readOnly)
 		if err != nil {
 			return nil, err
 		}
+		log.Printf("installer media %s is attached as read-only: %v", installerISOPath, usbConfig.ReadOnly())
 		disks = append(disks, usbConfig)
 	}
 
 	config, err := vz.NewVirtualMachineConfiguration(
 		bootLoader,
-		computeCPUCount(),
+		vz.RecommendedCPUCount(),
 		computeMemorySize(),
+		vz.WithRecommendedDevices(),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create vm config: %w", err)
@@ -742,21 +1120,20 @@ func createVirtualMachineConfig(installerISOPath string, needsInstall bool, bund
 
 	config.SetPlatformVirtualMachineConfiguration(platformConfig)
 
-	// Set graphic device
-	graphicsDeviceConfig, err := createGraphicsDeviceConfiguration()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create graphics device configuration: %w", err)
+	// Set graphic device (skipped when the host cannot provide one)
+	if vz.CanUseVirtioGraphics() {
+		graphicsDeviceConfig, err := createGraphicsDeviceConfiguration()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create graphics device configuration: %w", err)
+		}
+		config.SetGraphicsDevicesVirtualMachineConfiguration([]vz.GraphicsDeviceConfiguration{
+			graphicsDeviceConfig,
+		})
+	} else {
+		log.Printf("Virtio graphics is not supported on this host, starting without a display")
 	}
-	config.SetGraphicsDevicesVirtualMachineConfiguration([]vz.GraphicsDeviceConfiguration{
-		graphicsDeviceConfig,
-	})
 
 	// Set storage device
-	if needsInstall {
-		if err := createMainDiskImage(bundle.DiskImagePath()); err != nil {
-			return nil, fmt.Errorf("failed to create a main disk image: %w", err)
-		}
-	}
 	mainDisk, err := createBlockDeviceConfiguration(bundle.DiskImagePath())
 	if err != nil {
 		return nil, err
@@ -768,12 +1145,12 @@ func createVirtualMachineConfig(installerISOPath string, needsInstall bool, bund
 	if err != nil {
 		return nil, fmt.Errorf("failed to create console device configuration: %w", err)
 	}
-	config.SetConsoleDevicesVirtualMachineConfiguration([]vz.ConsoleDeviceConfiguration{
-		consoleDeviceConfig,
-	})
+	config.SetConsoleDevicesVirtualMachineConfiguration(
+		append(config.ConsoleDevices(), consoleDeviceConfig),
+	)
 
 	// Set network device
-	networkDeviceConfig, err := createNetworkDeviceConfiguration()
+	networkDeviceConfig, err := createNetworkDeviceConfiguration(filepath.Base(bundle.Path))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create network device configuration: %w", err)
 	}
@@ -825,13 +1202,5 @@ func createVirtualMachineConfig(installerISOPath string, needsInstall bool, bund
 
 	config.SetDirectorySharingDevicesVirtualMachineConfiguration(directorySharingConfigs)
 
-	validated, err := config.Validate()
-	if err != nil {
-		return nil, fmt.Errorf("failed to validate configuration: %w", err)
-	}
-	if !validated {
-		return nil, fmt.Errorf("invalid configuration")
-	}
-
 	return config, nil
 }
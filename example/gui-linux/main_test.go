@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// bundleFiles returns the names of the files in the bundle with their size and modification time.
+func bundleFiles(t *testing.T, bundle *Bundle) map[string]string {
+	t.Helper()
+	entries, err := os.ReadDir(bundle.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string, len(entries))
+	for _, e := range entries {
+		fi, err := e.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[e.Name()] = fmt.Sprintf("%s %d", fi.ModTime(), fi.Size())
+	}
+	return files
+}
+
+func TestPrepareBundleAndBuildConfig(t *testing.T) {
+	dir := t.TempDir()
+	isoPath := filepath.Join(dir, "installer.iso")
+	if err := os.WriteFile(isoPath, make([]byte, 1<<20), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bundle := NewBundle(filepath.Join(dir, "test.bundle"))
+	if err := bundle.Create(); err != nil {
+		t.Fatal(err)
+	}
+	entry := &VMEntry{
+		Name:       "test",
+		BundleName: "test.bundle",
+		CreatedAt:  time.Now(),
+		OSKind:     OSKindLinux,
+		BootType:   BootTypeEFI,
+	}
+
+	// Building the config of a new VM before its files are created fails without creating them.
+	if _, err := buildVirtualMachineConfig(entry, isoPath, true, bundle); err == nil {
+		t.Fatal("want an error for the missing files of the bundle")
+	}
+	if files := bundleFiles(t, bundle); len(files) != 0 {
+		t.Fatalf("building the config created %v", files)
+	}
+
+	if err := prepareBundle(entry, bundle, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{bundle.MachineIdentifierPath(), bundle.EFIVariableStorePath(), bundle.DiskImagePath()} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("prepareBundle did not create %s: %v", filepath.Base(path), err)
+		}
+	}
+	fi, err := os.Stat(bundle.DiskImagePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != mainDiskSize {
+		t.Errorf("want a disk of %d bytes but got %d", int64(mainDiskSize), fi.Size())
+	}
+
+	before := bundleFiles(t, bundle)
+	for _, needsInstall := range []bool{true, false} {
+		if _, err := buildVirtualMachineConfig(entry, isoPath, needsInstall, bundle); err != nil {
+			t.Fatalf("needsInstall=%v: %v", needsInstall, err)
+		}
+	}
+	after := bundleFiles(t, bundle)
+	if len(before) != len(after) {
+		t.Fatalf("building the config changed the bundle from %v to %v", before, after)
+	}
+	for name, want := range before {
+		if got := after[name]; got != want {
+			t.Errorf("building the config changed %s from %s to %s", name, want, got)
+		}
+	}
+}
+
+// writeISO writes a minimal ISO 9660 image, with an El Torito boot catalog which has a bootable
+// EFI entry if efi is true.
+func writeISO(t *testing.T, path string, efi bool) {
+	t.Helper()
+	const sectorSize, catalogSector = 2048, 20
+	image := make([]byte, 24*sectorSize)
+	sector := 16
+	descriptor := func(typ byte) []byte {
+		d := image[sector*sectorSize : (sector+1)*sectorSize]
+		d[0] = typ
+		copy(d[1:], "CD001")
+		d[6] = 1
+		sector++
+		return d
+	}
+	descriptor(1) // primary volume descriptor
+	if efi {
+		d := descriptor(0)
+		copy(d[7:], "EL TORITO SPECIFICATION")
+		binary.LittleEndian.PutUint32(d[0x47:], catalogSector)
+
+		validation := image[catalogSector*sectorSize:][:32]
+		validation[0], validation[1] = 0x01, 0xef
+		validation[30], validation[31] = 0x55, 0xaa
+		var sum uint16
+		for i := 0; i < 32; i += 2 {
+			sum += binary.LittleEndian.Uint16(validation[i:])
+		}
+		binary.LittleEndian.PutUint16(validation[28:], -sum)
+		image[catalogSector*sectorSize+32] = 0x88 // the default entry is bootable
+	}
+	descriptor(255)
+	if err := os.WriteFile(path, image, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestISOBootWarning(t *testing.T) {
+	dir := t.TempDir()
+
+	bootable := filepath.Join(dir, "bootable.iso")
+	writeISO(t, bootable, true)
+	if warning := isoBootWarning(bootable); warning != "" {
+		t.Errorf("want no warning for a bootable ISO but got %q", warning)
+	}
+
+	dataOnly := filepath.Join(dir, "data.iso")
+	writeISO(t, dataOnly, false)
+	if warning := isoBootWarning(dataOnly); warning == "" {
+		t.Error("want a warning for a data-only ISO")
+	}
+
+	if warning := isoBootWarning(filepath.Join(dir, "missing.iso")); warning == "" {
+		t.Error("want a warning for a missing ISO")
+	}
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"time"
 )
 
@@ -14,22 +18,72 @@ const (
 	DefaultVMName     = "default"
 )
 
+// registryVersion is the version of the registry.json format written by this example.
+//
+//   - 0: no version field. Entries have no os_kind and boot_type.
+//   - 1: adds version, and os_kind and boot_type to the entries.
+const registryVersion = 1
+
+// ErrRegistryVersion is returned when registry.json was written by a newer version of the example.
+var ErrRegistryVersion = errors.New("unsupported registry version")
+
 // VMEntry represents a registered virtual machine.
 type VMEntry struct {
 	Name       string    `json:"name"`
 	BundleName string    `json:"bundle_name"`        // relative to base dir, e.g., "default.bundle"
 	ISOPath    string    `json:"iso_path,omitempty"` // path to ISO used for creation/live boot
 	CreatedAt  time.Time `json:"created_at"`
+	OSKind     string    `json:"os_kind"`               // OSKindLinux or OSKindMacOS
+	BootType   string    `json:"boot_type"`             // BootTypeEFI, BootTypeLinux or BootTypeMacOS
+	GoldenPath string    `json:"golden_path,omitempty"` // read-only disk image cloned to Disk.img on first boot
+}
+
+// Metadata returns the bundle metadata of the VM entry.
+func (e *VMEntry) Metadata() BundleMetadata {
+	return BundleMetadata{
+		Name:       e.Name,
+		ISOPath:    e.ISOPath,
+		CreatedAt:  e.CreatedAt,
+		OSKind:     e.OSKind,
+		BootType:   e.BootType,
+		GoldenPath: e.GoldenPath,
+	}
+}
+
+// fillDefaults sets the fields which version 0 registries lack.
+// Version 0 only created Linux VMs booted with EFI.
+func (e *VMEntry) fillDefaults() {
+	if e.OSKind == "" {
+		e.OSKind = OSKindLinux
+	}
+	if e.BootType == "" {
+		e.BootType = BootTypeEFI
+	}
 }
 
 // Registry tracks all VMs in the base directory.
 type Registry struct {
-	VMs  []VMEntry `json:"vms"`
-	path string    // path to registry.json
+	Version int       `json:"version"`
+	VMs     []VMEntry `json:"vms"`
+	// PendingDeletions lists the bundles (relative to base dir) of removed VMs
+	// which may not have been deleted yet. Reconcile deletes them.
+	PendingDeletions []string `json:"pending_deletions,omitempty"`
+	path             string   // path to registry.json
 }
 
-// BaseDirectory returns the base directory for all VMs.
+// removeBundle deletes a bundle directory. Tests replace it to simulate a failure.
+var removeBundle = os.RemoveAll
+
+// baseDirectoryEnvVar overrides the base directory when set to a non-empty value,
+// e.g. to keep the VMs of a CI job or of another profile apart.
+const baseDirectoryEnvVar = "VZ_VM_HOME"
+
+// BaseDirectory returns the base directory for all VMs: $VZ_VM_HOME if it is set,
+// otherwise BaseDirectoryName in the home directory.
 func BaseDirectory() string {
+	if dir := os.Getenv(baseDirectoryEnvVar); dir != "" {
+		return dir
+	}
 	home, err := os.UserHomeDir()
 	if err != nil {
 		home = os.Getenv("HOME")
@@ -54,8 +108,9 @@ func LoadRegistry() (*Registry, error) {
 	}
 
 	r := &Registry{
-		VMs:  []VMEntry{},
-		path: RegistryPath(),
+		Version: registryVersion,
+		VMs:     []VMEntry{},
+		path:    RegistryPath(),
 	}
 
 	data, err := os.ReadFile(r.path)
@@ -66,20 +121,68 @@ func LoadRegistry() (*Registry, error) {
 		return nil, fmt.Errorf("failed to read registry: %w", err)
 	}
 
+	// Check the version first, a newer format may not parse as the current one.
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("failed to parse registry: %w", err)
+	}
+	if header.Version < 0 || header.Version > registryVersion {
+		return nil, fmt.Errorf("%w: %s has version %d, this example supports up to version %d",
+			ErrRegistryVersion, r.path, header.Version, registryVersion)
+	}
+
+	r.Version = header.Version
 	if err := json.Unmarshal(data, r); err != nil {
 		return nil, fmt.Errorf("failed to parse registry: %w", err)
 	}
+	if r.Version < registryVersion {
+		r.migrate()
+		if err := r.Save(); err != nil {
+			return nil, fmt.Errorf("failed to save migrated registry: %w", err)
+		}
+	}
 
 	return r, nil
 }
 
-// Save writes the registry to disk.
+// migrate upgrades the registry loaded from an older format to registryVersion.
+func (r *Registry) migrate() {
+	if r.Version < 1 {
+		for i := range r.VMs {
+			r.VMs[i].fillDefaults()
+		}
+	}
+	r.Version = registryVersion
+}
+
+// Save writes the registry to disk. The file is replaced atomically, so an interrupted
+// save leaves the previous registry in place.
 func (r *Registry) Save() error {
 	data, err := json.MarshalIndent(r, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal registry: %w", err)
 	}
-	if err := os.WriteFile(r.path, data, 0644); err != nil {
+	f, err := os.CreateTemp(filepath.Dir(r.path), RegistryFileName+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write registry: %w", err)
+	}
+	defer os.Remove(f.Name()) // no-op once renamed
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), r.path)
+	}
+	if err != nil {
 		return fmt.Errorf("failed to write registry: %w", err)
 	}
 	return nil
@@ -105,12 +208,17 @@ func (r *Registry) Add(name string, isoPath string) (*VMEntry, error) {
 	if r.Exists(name) {
 		return nil, fmt.Errorf("VM %q already exists", name)
 	}
+	if err := r.finishDeletion(name + ".bundle"); err != nil {
+		return nil, err
+	}
 
 	entry := VMEntry{
 		Name:       name,
 		BundleName: name + ".bundle",
 		ISOPath:    isoPath,
 		CreatedAt:  time.Now(),
+		OSKind:     OSKindLinux,
+		BootType:   BootTypeEFI,
 	}
 	r.VMs = append(r.VMs, entry)
 
@@ -123,7 +231,193 @@ func (r *Registry) Add(name string, isoPath string) (*VMEntry, error) {
 	return &entry, nil
 }
 
+// Import creates a new VM entry whose bundle uses an existing raw disk image as Disk.img.
+// If reference is true, Disk.img is a symbolic link to diskPath, otherwise diskPath is copied
+// into the bundle (zero blocks are skipped, so the copy is sparse).
+//
+// The machine identifier and the EFI variable store are not created here; the caller creates them
+// before the VM is started.
+func (r *Registry) Import(name, diskPath string, reference bool) (*VMEntry, error) {
+	if r.Exists(name) {
+		return nil, fmt.Errorf("VM %q already exists", name)
+	}
+	diskPath, err := filepath.Abs(diskPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRawDiskImage(diskPath); err != nil {
+		return nil, err
+	}
+	if err := r.finishDeletion(name + ".bundle"); err != nil {
+		return nil, err
+	}
+
+	entry := VMEntry{
+		Name:       name,
+		BundleName: name + ".bundle",
+		CreatedAt:  time.Now(),
+		OSKind:     OSKindLinux,
+		BootType:   BootTypeEFI,
+	}
+	bundle := r.BundleFor(&entry)
+	if bundle.Exists() {
+		return nil, fmt.Errorf("bundle %q already exists", bundle.Path)
+	}
+	if err := bundle.Create(); err != nil {
+		return nil, fmt.Errorf("failed to create bundle: %w", err)
+	}
+	if reference {
+		err = os.Symlink(diskPath, bundle.DiskImagePath())
+	} else {
+		err = copySparseFile(bundle.DiskImagePath(), diskPath)
+	}
+	if err == nil {
+		err = bundle.WriteMetadata(entry.Metadata())
+	}
+	if err != nil {
+		os.RemoveAll(bundle.Path)
+		return nil, fmt.Errorf("failed to import disk image: %w", err)
+	}
+
+	r.VMs = append(r.VMs, entry)
+	if err := r.Save(); err != nil {
+		// rollback
+		r.VMs = r.VMs[:len(r.VMs)-1]
+		os.RemoveAll(bundle.Path)
+		return nil, err
+	}
+	return &r.VMs[len(r.VMs)-1], nil
+}
+
+// ImportGolden creates a new VM entry which boots a private copy-on-write clone of the
+// read-only golden disk image at goldenPath. The clone is made by (*Bundle).PrepareOverlay
+// when the VM is first started, so the golden image is never written.
+func (r *Registry) ImportGolden(name, goldenPath string) (*VMEntry, error) {
+	if r.Exists(name) {
+		return nil, fmt.Errorf("VM %q already exists", name)
+	}
+	goldenPath, err := filepath.Abs(goldenPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRawDiskImage(goldenPath); err != nil {
+		return nil, err
+	}
+	if err := r.finishDeletion(name + ".bundle"); err != nil {
+		return nil, err
+	}
+
+	entry := VMEntry{
+		Name:       name,
+		BundleName: name + ".bundle",
+		CreatedAt:  time.Now(),
+		OSKind:     OSKindLinux,
+		BootType:   BootTypeEFI,
+		GoldenPath: goldenPath,
+	}
+	bundle := r.BundleFor(&entry)
+	if bundle.Exists() {
+		return nil, fmt.Errorf("bundle %q already exists", bundle.Path)
+	}
+	if err := bundle.Create(); err != nil {
+		return nil, fmt.Errorf("failed to create bundle: %w", err)
+	}
+	if err := bundle.WriteMetadata(entry.Metadata()); err != nil {
+		os.RemoveAll(bundle.Path)
+		return nil, err
+	}
+
+	r.VMs = append(r.VMs, entry)
+	if err := r.Save(); err != nil {
+		// rollback
+		r.VMs = r.VMs[:len(r.VMs)-1]
+		os.RemoveAll(bundle.Path)
+		return nil, err
+	}
+	return &r.VMs[len(r.VMs)-1], nil
+}
+
+var qcow2Magic = []byte{'Q', 'F', 'I', 0xfb}
+
+// checkRawDiskImage returns an error if path is not a regular file or is a qcow2 image,
+// which the Virtualization framework can not boot.
+func checkRawDiskImage(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("disk image %q is not a regular file", path)
+	}
+	magic := make([]byte, len(qcow2Magic))
+	if _, err := io.ReadFull(f, magic); err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if bytes.Equal(magic, qcow2Magic) {
+		return fmt.Errorf("disk image %q is qcow2, convert it to raw first with vz.ConvertDiskImage", path)
+	}
+	return nil
+}
+
+// copySparseFile copies src to dst without writing the blocks which contain only zeros.
+func copySparseFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if err := copySparse(out, in); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// copySparse copies in to the start of out, which must be empty, without writing the
+// blocks which contain only zeros.
+func copySparse(out *os.File, in io.Reader) error {
+	buf := make([]byte, 1<<20)
+	zero := make([]byte, len(buf))
+	var size int64
+	for {
+		n, readErr := io.ReadFull(in, buf)
+		if n > 0 {
+			var err error
+			if bytes.Equal(buf[:n], zero[:n]) {
+				_, err = out.Seek(int64(n), io.SeekCurrent)
+			} else {
+				_, err = out.Write(buf[:n])
+			}
+			if err != nil {
+				return err
+			}
+			size += int64(n)
+		}
+		if errors.Is(readErr, io.EOF) || errors.Is(readErr, io.ErrUnexpectedEOF) {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+	// Extend the file if it ends with zeros.
+	return out.Truncate(size)
+}
+
 // Remove deletes a VM entry and optionally its bundle.
+//
+// The entry is removed from the registry before the bundle is deleted, and the bundle is
+// recorded as a pending deletion in the same save. If deleting the bundle fails or the process
+// dies before it is done, the registry stays consistent and Reconcile deletes the bundle later.
 func (r *Registry) Remove(name string, deleteBundle bool) error {
 	idx := -1
 	for i := range r.VMs {
@@ -137,16 +431,57 @@ func (r *Registry) Remove(name string, deleteBundle bool) error {
 	}
 
 	entry := r.VMs[idx]
+	vms := r.VMs
+	pending := r.PendingDeletions
+
+	r.VMs = slices.Delete(slices.Clone(vms), idx, idx+1)
+	if deleteBundle && !slices.Contains(r.PendingDeletions, entry.BundleName) {
+		r.PendingDeletions = append(slices.Clone(pending), entry.BundleName)
+	}
+	if err := r.Save(); err != nil {
+		// rollback
+		r.VMs = vms
+		r.PendingDeletions = pending
+		return err
+	}
 
 	if deleteBundle {
-		bundlePath := filepath.Join(BaseDirectory(), entry.BundleName)
-		if err := os.RemoveAll(bundlePath); err != nil {
-			return fmt.Errorf("failed to delete bundle: %w", err)
+		if err := r.finishDeletion(entry.BundleName); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	r.VMs = append(r.VMs[:idx], r.VMs[idx+1:]...)
-	return r.Save()
+// Reconcile deletes the bundles whose deletion was interrupted, and removes them from
+// the pending deletions. Bundles which can not be deleted are kept pending.
+func (r *Registry) Reconcile() error {
+	var errs []error
+	for _, bundleName := range slices.Clone(r.PendingDeletions) {
+		if err := r.finishDeletion(bundleName); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// finishDeletion deletes the bundle if it is pending deletion, then removes it from
+// the pending deletions.
+func (r *Registry) finishDeletion(bundleName string) error {
+	idx := slices.Index(r.PendingDeletions, bundleName)
+	if idx == -1 {
+		return nil
+	}
+	if err := removeBundle(filepath.Join(BaseDirectory(), bundleName)); err != nil {
+		return fmt.Errorf("failed to delete bundle: %w", err)
+	}
+	pending := r.PendingDeletions
+	r.PendingDeletions = slices.Delete(slices.Clone(pending), idx, idx+1)
+	if err := r.Save(); err != nil {
+		r.PendingDeletions = pending
+		return err
+	}
+	return nil
 }
 
 // List returns all VM entries.
@@ -174,5 +509,10 @@ func (r *Registry) UpdateISO(name, isoPath string) error {
 		return fmt.Errorf("VM %q not found", name)
 	}
 	entry.ISOPath = isoPath
+	if bundle := r.BundleFor(entry); bundle.Exists() {
+		if err := bundle.WriteMetadata(entry.Metadata()); err != nil {
+			return err
+		}
+	}
 	return r.Save()
 }
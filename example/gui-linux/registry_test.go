@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func newTestRegistry(t *testing.T) *Registry {
+	t.Helper()
+	t.Setenv(baseDirectoryEnvVar, t.TempDir())
+	r, err := LoadRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestBaseDirectoryOverride(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	t.Setenv(baseDirectoryEnvVar, "")
+	if got, want := BaseDirectory(), filepath.Join(home, BaseDirectoryName); got != want {
+		t.Fatalf("want %q but got %q", want, got)
+	}
+
+	base := filepath.Join(t.TempDir(), "vms")
+	t.Setenv(baseDirectoryEnvVar, base)
+	if got := BaseDirectory(); got != base {
+		t.Fatalf("want %q but got %q", base, got)
+	}
+	r, err := LoadRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := r.Add("test", "/path/to/installer.iso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.BundleFor(entry).Create(); err != nil {
+		t.Fatal(err)
+	}
+	if got := filepath.Dir(RegistryPath()); got != base {
+		t.Errorf("want the registry in %q but got %q", base, got)
+	}
+	if got := filepath.Dir(r.BundleFor(entry).Path); got != base {
+		t.Errorf("want the bundle in %q but got %q", base, got)
+	}
+	if _, err := os.Stat(RegistryPath()); err != nil {
+		t.Errorf("want the registry to be saved: %v", err)
+	}
+	// Nothing is created in the home directory.
+	if entries, err := os.ReadDir(home); err != nil || len(entries) != 0 {
+		t.Errorf("want an empty home directory but got %v (%v)", entries, err)
+	}
+}
+
+func writeTestDiskImage(t *testing.T) (string, []byte) {
+	t.Helper()
+	content := bytes.Join([][]byte{
+		bytes.Repeat([]byte{1}, 4096),
+		make([]byte, 3<<20),
+		bytes.Repeat([]byte{2}, 100),
+	}, nil)
+	path := filepath.Join(t.TempDir(), "ubuntu.img")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, content
+}
+
+func TestRegistryImport(t *testing.T) {
+	for _, reference := range []bool{false, true} {
+		r := newTestRegistry(t)
+		diskPath, content := writeTestDiskImage(t)
+
+		entry, err := r.Import("myvm", diskPath, reference)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bundle := r.BundleFor(entry)
+
+		fi, err := os.Lstat(bundle.DiskImagePath())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isLink := fi.Mode()&os.ModeSymlink != 0; isLink != reference {
+			t.Errorf("reference=%v: want symlink %v but got %v", reference, reference, isLink)
+		}
+		got, err := os.ReadFile(bundle.DiskImagePath())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(content, got) {
+			t.Errorf("reference=%v: content of Disk.img differs from the imported disk", reference)
+		}
+
+		meta, err := bundle.ReadMetadata()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta.Name != "myvm" {
+			t.Errorf("want metadata name %q but got %q", "myvm", meta.Name)
+		}
+
+		reloaded, err := LoadRegistry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reloaded.Exists("myvm") {
+			t.Error("want imported VM in the saved registry")
+		}
+
+		if _, err := r.Import("myvm", diskPath, reference); err == nil {
+			t.Error("want error for a duplicated name")
+		}
+
+		// Deleting the bundle must not delete the referenced disk.
+		if err := r.Remove("myvm", true); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(diskPath); err != nil {
+			t.Errorf("want the source disk kept but got %v", err)
+		}
+	}
+}
+
+func TestRegistryImportInvalid(t *testing.T) {
+	r := newTestRegistry(t)
+	dir := t.TempDir()
+
+	qcow2Path := filepath.Join(dir, "image.qcow2")
+	if err := os.WriteFile(qcow2Path, append([]byte("QFI\xfb"), make([]byte, 100)...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{
+		qcow2Path,
+		filepath.Join(dir, "not-exist.img"),
+		dir,
+	} {
+		if _, err := r.Import("invalid", path, false); err == nil {
+			t.Errorf("want error for %q", path)
+		}
+	}
+	if r.Exists("invalid") {
+		t.Error("want no entry after failed imports")
+	}
+	if _, err := os.Stat(filepath.Join(BaseDirectory(), "invalid.bundle")); !os.IsNotExist(err) {
+		t.Errorf("want no bundle after failed imports but got %v", err)
+	}
+}
+
+func TestLoadRegistryFillsDefaults(t *testing.T) {
+	t.Setenv(baseDirectoryEnvVar, t.TempDir())
+	if err := EnsureBaseDirectory(); err != nil {
+		t.Fatal(err)
+	}
+	old := `{"vms": [{"name": "old", "bundle_name": "old.bundle", "created_at": "2024-01-02T03:04:05Z"}]}`
+	if err := os.WriteFile(RegistryPath(), []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := LoadRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := r.Find("old")
+	if entry == nil {
+		t.Fatal("want the entry of the old registry")
+	}
+	if entry.OSKind != OSKindLinux || entry.BootType != BootTypeEFI {
+		t.Errorf("want %s/%s but got %s/%s", OSKindLinux, BootTypeEFI, entry.OSKind, entry.BootType)
+	}
+
+	added, err := r.Add("new", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added.OSKind != OSKindLinux || added.BootType != BootTypeEFI {
+		t.Errorf("want %s/%s for a new entry but got %s/%s", OSKindLinux, BootTypeEFI, added.OSKind, added.BootType)
+	}
+}
+
+func TestLoadRegistryV0(t *testing.T) {
+	t.Setenv(baseDirectoryEnvVar, t.TempDir())
+	if err := EnsureBaseDirectory(); err != nil {
+		t.Fatal(err)
+	}
+	fixture, err := os.ReadFile(filepath.Join("testdata", "registry_v0.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(RegistryPath(), fixture, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := LoadRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []VMEntry{
+		{
+			Name:       "default",
+			BundleName: "default.bundle",
+			CreatedAt:  time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			OSKind:     OSKindLinux,
+			BootType:   BootTypeEFI,
+		},
+		{
+			Name:       "ubuntu",
+			BundleName: "ubuntu.bundle",
+			ISOPath:    "/Users/me/Downloads/ubuntu-24.04-live-server-arm64.iso",
+			CreatedAt:  time.Date(2024, 5, 6, 7, 8, 9, 123456000, time.FixedZone("", 9*60*60)),
+			OSKind:     OSKindLinux,
+			BootType:   BootTypeEFI,
+		},
+	}
+	checkEntries := func(t *testing.T, r *Registry) {
+		t.Helper()
+		if r.Version != registryVersion {
+			t.Errorf("want version %d but got %d", registryVersion, r.Version)
+		}
+		if len(r.VMs) != len(want) {
+			t.Fatalf("want %d entries but got %d", len(want), len(r.VMs))
+		}
+		for i, got := range r.VMs {
+			w := want[i]
+			if got.Name != w.Name || got.BundleName != w.BundleName || got.ISOPath != w.ISOPath ||
+				!got.CreatedAt.Equal(w.CreatedAt) || got.OSKind != w.OSKind || got.BootType != w.BootType {
+				t.Errorf("entry %d: want %+v but got %+v", i, w, got)
+			}
+		}
+	}
+	checkEntries(t, r)
+
+	// The registry is rewritten in the current format.
+	data, err := os.ReadFile(RegistryPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved struct {
+		Version int `json:"version"`
+		VMs     []map[string]any
+	}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Version != registryVersion {
+		t.Errorf("want saved version %d but got %d", registryVersion, saved.Version)
+	}
+	for i, vm := range saved.VMs {
+		if vm["os_kind"] != OSKindLinux || vm["boot_type"] != BootTypeEFI {
+			t.Errorf("entry %d: want os_kind and boot_type saved but got %v", i, vm)
+		}
+	}
+
+	reloaded, err := LoadRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEntries(t, reloaded)
+}
+
+func TestLoadRegistryFutureVersion(t *testing.T) {
+	t.Setenv(baseDirectoryEnvVar, t.TempDir())
+	if err := EnsureBaseDirectory(); err != nil {
+		t.Fatal(err)
+	}
+	// A future format may change the shape of the entries.
+	future := `{"version": 99, "vms": {"default": {"disk": "Disk.img"}}}`
+	if err := os.WriteFile(RegistryPath(), []byte(future), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadRegistry(); !errors.Is(err, ErrRegistryVersion) {
+		t.Fatalf("want ErrRegistryVersion but got %v", err)
+	}
+	// The registry must be left untouched.
+	data, err := os.ReadFile(RegistryPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != future {
+		t.Errorf("want registry unchanged but got %s", data)
+	}
+}
+
+func TestRegistryRemoveInterrupted(t *testing.T) {
+	r := newTestRegistry(t)
+	entry, err := r.Add("myvm", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := r.BundleFor(entry)
+	if err := bundle.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate the process dying while the bundle is deleted.
+	errCrash := errors.New("crash")
+	removeBundle = func(string) error { return errCrash }
+	t.Cleanup(func() { removeBundle = os.RemoveAll })
+	if err := r.Remove("myvm", true); !errors.Is(err, errCrash) {
+		t.Fatalf("want %v but got %v", errCrash, err)
+	}
+	removeBundle = os.RemoveAll
+
+	reloaded, err := LoadRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Exists("myvm") {
+		t.Error("want the entry removed from the saved registry")
+	}
+	if want := []string{entry.BundleName}; !slices.Equal(reloaded.PendingDeletions, want) {
+		t.Errorf("want pending deletions %v but got %v", want, reloaded.PendingDeletions)
+	}
+	if !bundle.Exists() {
+		t.Fatal("want the bundle left by the interrupted delete")
+	}
+
+	if err := reloaded.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	if bundle.Exists() {
+		t.Error("want the bundle deleted by Reconcile")
+	}
+	reloaded, err = LoadRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.PendingDeletions) != 0 {
+		t.Errorf("want no pending deletions but got %v", reloaded.PendingDeletions)
+	}
+}
+
+func TestRegistryAddFinishesPendingDeletion(t *testing.T) {
+	r := newTestRegistry(t)
+	entry, err := r.Add("myvm", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := r.BundleFor(entry)
+	if err := bundle.Create(); err != nil {
+		t.Fatal(err)
+	}
+	removeBundle = func(string) error { return errors.New("crash") }
+	t.Cleanup(func() { removeBundle = os.RemoveAll })
+	if err := r.Remove("myvm", true); err == nil {
+		t.Fatal("want error")
+	}
+	removeBundle = os.RemoveAll
+
+	// The new VM must not reuse the bundle of the removed one.
+	if _, err := r.Add("myvm", ""); err != nil {
+		t.Fatal(err)
+	}
+	if bundle.Exists() {
+		t.Error("want the old bundle deleted before adding the VM")
+	}
+	if len(r.PendingDeletions) != 0 {
+		t.Errorf("want no pending deletions but got %v", r.PendingDeletions)
+	}
+}
+
+func TestRegistryImportGolden(t *testing.T) {
+	r := newTestRegistry(t)
+	diskPath, content := writeTestDiskImage(t)
+
+	entry, err := r.ImportGolden("myvm", diskPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.GoldenPath != diskPath {
+		t.Errorf("want golden path %q but got %q", diskPath, entry.GoldenPath)
+	}
+	bundle := r.BundleFor(entry)
+	// The disk is cloned on the first boot.
+	if _, err := os.Lstat(bundle.DiskImagePath()); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("want no Disk.img before the first boot but got %v", err)
+	}
+	meta, err := bundle.ReadMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.GoldenPath != diskPath {
+		t.Errorf("want golden path %q in metadata but got %q", diskPath, meta.GoldenPath)
+	}
+
+	if err := bundle.PrepareOverlay(entry.GoldenPath); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(bundle.DiskImagePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("want Disk.img cloned from the golden image")
+	}
+}
@@ -4,6 +4,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 
@@ -16,11 +17,11 @@ func createRosettaDirectoryShareConfiguration() (*vz.VirtioFileSystemDeviceConfi
 	if err != nil {
 		return nil, fmt.Errorf("failed to create a new virtio file system configuration: %w", err)
 	}
-	availability := vz.LinuxRosettaDirectoryShareAvailability()
-	switch availability {
-	case vz.LinuxRosettaAvailabilityNotSupported:
+	rosettaShare, err := vz.NewLinuxRosettaDirectoryShare()
+	switch {
+	case errors.Is(err, vz.ErrRosettaNotSupported):
 		return nil, fmt.Errorf("not supported rosetta: %w", errIgnoreInstall)
-	case vz.LinuxRosettaAvailabilityNotInstalled:
+	case errors.Is(err, vz.ErrRosettaNotInstalled):
 		want := prompter.YN("Do you want to install rosetta?", false)
 		if !want {
 			return nil, errIgnoreInstall
@@ -30,11 +31,8 @@ func createRosettaDirectoryShareConfiguration() (*vz.VirtioFileSystemDeviceConfi
 			return nil, fmt.Errorf("failed to install rosetta: %w", err)
 		}
 		log.Println("complete.")
-	case vz.LinuxRosettaAvailabilityInstalled:
-		// nothing to do
+		rosettaShare, err = vz.NewLinuxRosettaDirectoryShare()
 	}
-
-	rosettaShare, err := vz.NewLinuxRosettaDirectoryShare()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create a new rosetta directory share: %w", err)
 	}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrStateIncompatible is returned by RestoreState when the saved state was taken from a VM
+// which does not match the bundle anymore.
+var ErrStateIncompatible = errors.New("saved state is incompatible with the VM")
+
+// stateFileExt is the extension of the machine state files in the snapshots directory.
+const stateFileExt = ".vzstate"
+
+// MachineStateSaver saves and restores the state of a VM. *vz.VirtualMachine implements it
+// on macOS 14 and newer on Apple silicon.
+type MachineStateSaver interface {
+	SaveMachineStateToPath(saveFilePath string) error
+	RestoreMachineStateFromURL(saveFilePath string) error
+}
+
+// StateInfo describes a saved state of the VM of a bundle.
+type StateInfo struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	OSKind    string    `json:"os_kind,omitempty"`
+	BootType  string    `json:"boot_type,omitempty"`
+	Arch      string    `json:"arch"`
+	DiskSize  int64     `json:"disk_size"`
+}
+
+// SnapshotsPath returns the path to the directory of the saved states.
+func (b *Bundle) SnapshotsPath() string {
+	return filepath.Join(b.Path, "snapshots")
+}
+
+// StatePath returns the path to the machine state file of the saved state name.
+func (b *Bundle) StatePath(name string) string {
+	return filepath.Join(b.SnapshotsPath(), name+stateFileExt)
+}
+
+func (b *Bundle) stateDiskPath(name string) string {
+	return filepath.Join(b.SnapshotsPath(), name+".img")
+}
+
+func (b *Bundle) stateNVRAMPath(name string) string {
+	return filepath.Join(b.SnapshotsPath(), name+".nvram")
+}
+
+func (b *Bundle) stateInfoPath(name string) string {
+	return filepath.Join(b.SnapshotsPath(), name+".json")
+}
+
+func validateStateName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) || strings.HasSuffix(name, ".tmp") {
+		return fmt.Errorf("invalid saved state name %q", name)
+	}
+	return nil
+}
+
+// SaveState saves the state of vm, which must be paused, as name, replacing a saved state with
+// the same name. The machine state only holds the memory and the devices, so the disk image and
+// the EFI variable store are cloned next to it to restore the VM to the same point.
+func (b *Bundle) SaveState(vm MachineStateSaver, name string) error {
+	if err := validateStateName(name); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(b.SnapshotsPath(), 0755); err != nil {
+		return fmt.Errorf("failed to create snapshots directory: %w", err)
+	}
+	info, err := b.currentStateInfo(name)
+	if err != nil {
+		return err
+	}
+
+	// Write everything to temporary names first, and rename the state file last,
+	// so an interrupted save never shows up in ListStates.
+	files := []struct{ src, dst string }{
+		{b.DiskImagePath(), b.stateDiskPath(name)},
+		{b.EFIVariableStorePath(), b.stateNVRAMPath(name)},
+	}
+	var tmpPaths []string
+	defer func() {
+		for _, p := range tmpPaths {
+			os.Remove(p)
+		}
+	}()
+	for _, f := range files {
+		if _, err := os.Stat(f.src); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		tmpPath := f.dst + ".tmp"
+		os.Remove(tmpPath)
+		tmpPaths = append(tmpPaths, tmpPath)
+		if err := cloneFile(tmpPath, f.src); err != nil {
+			return fmt.Errorf("failed to clone %q: %w", f.src, err)
+		}
+	}
+	stateTmpPath := b.StatePath(name) + ".tmp"
+	os.Remove(stateTmpPath)
+	tmpPaths = append(tmpPaths, stateTmpPath)
+	if err := vm.SaveMachineStateToPath(stateTmpPath); err != nil {
+		return fmt.Errorf("failed to save machine state: %w", err)
+	}
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal saved state info: %w", err)
+	}
+	if err := os.WriteFile(b.stateInfoPath(name), data, 0644); err != nil {
+		return fmt.Errorf("failed to write saved state info: %w", err)
+	}
+	for _, f := range files {
+		tmpPath := f.dst + ".tmp"
+		if _, err := os.Stat(tmpPath); err != nil {
+			// The bundle has no such file, drop the one of a replaced saved state.
+			os.Remove(f.dst)
+			continue
+		}
+		if err := os.Rename(tmpPath, f.dst); err != nil {
+			return fmt.Errorf("failed to save %q: %w", f.dst, err)
+		}
+	}
+	if err := os.Rename(stateTmpPath, b.StatePath(name)); err != nil {
+		return fmt.Errorf("failed to save machine state: %w", err)
+	}
+	return nil
+}
+
+// RestoreState restores vm, which must be created from the bundle and not started yet, to the
+// saved state name. The VM is paused afterwards, resume it to run.
+//
+// The disk image and the EFI variable store are rolled back first. They are copied in place,
+// because the storage device of vm already opened them. The saved state must have been taken
+// from the same kind of VM on the same host; the Virtualization framework rejects a state which
+// does not match the configuration of vm.
+func (b *Bundle) RestoreState(vm MachineStateSaver, name string) error {
+	if err := validateStateName(name); err != nil {
+		return err
+	}
+	saved, err := b.readStateInfo(name)
+	if err != nil {
+		return err
+	}
+	current, err := b.currentStateInfo(name)
+	if err != nil {
+		return err
+	}
+	if err := checkStateCompatible(saved, current); err != nil {
+		return err
+	}
+
+	files := []struct{ src, dst string }{
+		{b.stateDiskPath(name), b.DiskImagePath()},
+		{b.stateNVRAMPath(name), b.EFIVariableStorePath()},
+	}
+	for _, f := range files {
+		if err := copyFileInPlace(f.dst, f.src); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to roll back %q: %w", f.dst, err)
+		}
+	}
+	if err := vm.RestoreMachineStateFromURL(b.StatePath(name)); err != nil {
+		return fmt.Errorf("failed to restore machine state: %w", err)
+	}
+	return nil
+}
+
+// ListStates returns the saved states of the bundle sorted by creation time.
+func (b *Bundle) ListStates() ([]StateInfo, error) {
+	matches, err := filepath.Glob(filepath.Join(b.SnapshotsPath(), "*"+stateFileExt))
+	if err != nil {
+		return nil, err
+	}
+	states := make([]StateInfo, 0, len(matches))
+	for _, m := range matches {
+		info, err := b.readStateInfo(strings.TrimSuffix(filepath.Base(m), stateFileExt))
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, info)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].CreatedAt.Before(states[j].CreatedAt)
+	})
+	return states, nil
+}
+
+// DeleteState removes the saved state name.
+func (b *Bundle) DeleteState(name string) error {
+	if err := validateStateName(name); err != nil {
+		return err
+	}
+	// Remove the state file first, so a partly deleted state is not listed.
+	if err := os.Remove(b.StatePath(name)); err != nil {
+		return fmt.Errorf("failed to delete saved state: %w", err)
+	}
+	for _, p := range []string{b.stateDiskPath(name), b.stateNVRAMPath(name), b.stateInfoPath(name)} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to delete saved state: %w", err)
+		}
+	}
+	return nil
+}
+
+// readStateInfo reads the info of the saved state name.
+// The error wraps os.ErrNotExist if there is no such saved state.
+func (b *Bundle) readStateInfo(name string) (StateInfo, error) {
+	var info StateInfo
+	if _, err := os.Stat(b.StatePath(name)); err != nil {
+		return info, fmt.Errorf("saved state %q: %w", name, err)
+	}
+	data, err := os.ReadFile(b.stateInfoPath(name))
+	if err != nil {
+		return info, fmt.Errorf("failed to read saved state info: %w", err)
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return info, fmt.Errorf("failed to parse saved state info: %w", err)
+	}
+	return info, nil
+}
+
+// currentStateInfo describes the VM of the bundle as it is now.
+func (b *Bundle) currentStateInfo(name string) (StateInfo, error) {
+	info := StateInfo{
+		Name:      name,
+		CreatedAt: time.Now(),
+		Arch:      runtime.GOARCH,
+	}
+	meta, err := b.ReadMetadata()
+	switch {
+	case err == nil:
+		info.OSKind = meta.OSKind
+		info.BootType = meta.BootType
+	case !errors.Is(err, os.ErrNotExist):
+		return info, err
+	}
+	fi, err := os.Stat(b.DiskImagePath())
+	if err != nil {
+		return info, fmt.Errorf("failed to stat disk image: %w", err)
+	}
+	info.DiskSize = fi.Size()
+	return info, nil
+}
+
+// checkStateCompatible returns an error wrapping ErrStateIncompatible if the VM described by
+// current can not be restored to saved.
+func checkStateCompatible(saved, current StateInfo) error {
+	switch {
+	case saved.Arch != current.Arch:
+		return fmt.Errorf("%w: saved on %s, restoring on %s", ErrStateIncompatible, saved.Arch, current.Arch)
+	case saved.OSKind != current.OSKind || saved.BootType != current.BootType:
+		return fmt.Errorf("%w: saved from a %s VM booted with %s, the VM is %s booted with %s",
+			ErrStateIncompatible, saved.OSKind, saved.BootType, current.OSKind, current.BootType)
+	case saved.DiskSize != current.DiskSize:
+		return fmt.Errorf("%w: disk image was resized from %d to %d bytes", ErrStateIncompatible, saved.DiskSize, current.DiskSize)
+	}
+	return nil
+}
+
+// copyFileInPlace replaces the contents of dst with src without replacing the file,
+// so that open file descriptors of dst see the new contents.
+func copyFileInPlace(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if err := copySparse(out, in); err != nil {
+		return err
+	}
+	return out.Close()
+}
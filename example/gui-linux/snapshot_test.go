@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeMachine stands in for a *vz.VirtualMachine, whose save and restore need macOS 14.
+type fakeMachine struct {
+	saveErr  error
+	restored string
+}
+
+func (m *fakeMachine) SaveMachineStateToPath(saveFilePath string) error {
+	if m.saveErr != nil {
+		return m.saveErr
+	}
+	return os.WriteFile(saveFilePath, []byte("state"), 0644)
+}
+
+func (m *fakeMachine) RestoreMachineStateFromURL(saveFilePath string) error {
+	m.restored = saveFilePath
+	return nil
+}
+
+func newTestSnapshotBundle(t *testing.T, disk []byte) *Bundle {
+	t.Helper()
+	bundle := NewBundle(filepath.Join(t.TempDir(), "test.bundle"))
+	if err := bundle.Create(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bundle.WriteMetadata(BundleMetadata{Name: "test", OSKind: OSKindLinux, BootType: BootTypeEFI}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bundle.DiskImagePath(), disk, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bundle.EFIVariableStorePath(), []byte("nvram"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return bundle
+}
+
+func TestBundleSaveRestoreState(t *testing.T) {
+	disk := append(bytes.Repeat([]byte{1}, 4096), make([]byte, 1<<20)...)
+	bundle := newTestSnapshotBundle(t, disk)
+
+	if err := bundle.SaveState(&fakeMachine{}, "before-upgrade"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(bundle.StatePath("before-upgrade")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Upgrade the guest.
+	f, err := os.OpenFile(bundle.DiskImagePath(), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteAt([]byte{2, 2, 2}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bundle.EFIVariableStorePath(), []byte("upgraded"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	vm := &fakeMachine{}
+	if err := bundle.RestoreState(vm, "before-upgrade"); err != nil {
+		t.Fatal(err)
+	}
+	if vm.restored != bundle.StatePath("before-upgrade") {
+		t.Errorf("want machine state restored from %q but got %q", bundle.StatePath("before-upgrade"), vm.restored)
+	}
+	got, err := os.ReadFile(bundle.DiskImagePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, disk) {
+		t.Error("want the disk image rolled back")
+	}
+	// The disk image is rolled back in place, so the file opened by the VM sees it.
+	buf := make([]byte, 3)
+	if _, err := f.ReadAt(buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, []byte{1, 1, 1}) {
+		t.Errorf("want the open disk image rolled back but got %v", buf)
+	}
+	if got, err := os.ReadFile(bundle.EFIVariableStorePath()); err != nil || string(got) != "nvram" {
+		t.Errorf("want the EFI variable store rolled back but got %q, %v", got, err)
+	}
+}
+
+func TestBundleSaveStateFailed(t *testing.T) {
+	bundle := newTestSnapshotBundle(t, []byte("disk"))
+	errSave := errors.New("save failed")
+	if err := bundle.SaveState(&fakeMachine{saveErr: errSave}, "broken"); !errors.Is(err, errSave) {
+		t.Fatalf("want %v but got %v", errSave, err)
+	}
+
+	states, err := bundle.ListStates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 0 {
+		t.Errorf("want no saved states but got %v", states)
+	}
+	entries, err := os.ReadDir(bundle.SnapshotsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		t.Errorf("want no leftover file but got %s", e.Name())
+	}
+}
+
+func TestBundleListStates(t *testing.T) {
+	bundle := newTestSnapshotBundle(t, []byte("disk"))
+	if states, err := bundle.ListStates(); err != nil || len(states) != 0 {
+		t.Fatalf("want no saved states but got %v, %v", states, err)
+	}
+
+	for _, name := range []string{"first", "second"} {
+		if err := bundle.SaveState(&fakeMachine{}, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	states, err := bundle.ListStates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 2 || states[0].Name != "first" || states[1].Name != "second" {
+		t.Fatalf("want first and second but got %v", states)
+	}
+	if states[0].OSKind != OSKindLinux || states[0].BootType != BootTypeEFI || states[0].DiskSize != 4 {
+		t.Errorf("unexpected saved state info %+v", states[0])
+	}
+
+	if err := bundle.DeleteState("first"); err != nil {
+		t.Fatal(err)
+	}
+	states, err = bundle.ListStates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 1 || states[0].Name != "second" {
+		t.Fatalf("want second but got %v", states)
+	}
+	if err := bundle.RestoreState(&fakeMachine{}, "first"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("want os.ErrNotExist for a deleted saved state but got %v", err)
+	}
+}
+
+func TestBundleRestoreStateIncompatible(t *testing.T) {
+	bundle := newTestSnapshotBundle(t, []byte("disk"))
+	if err := bundle.SaveState(&fakeMachine{}, "small"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(bundle.DiskImagePath(), 1<<20); err != nil {
+		t.Fatal(err)
+	}
+
+	vm := &fakeMachine{}
+	if err := bundle.RestoreState(vm, "small"); !errors.Is(err, ErrStateIncompatible) {
+		t.Fatalf("want ErrStateIncompatible but got %v", err)
+	}
+	if vm.restored != "" {
+		t.Error("want the machine state not restored")
+	}
+	if fi, err := os.Stat(bundle.DiskImagePath()); err != nil || fi.Size() != 1<<20 {
+		t.Errorf("want the disk image untouched but got %v, %v", fi, err)
+	}
+}
+
+func TestBundleStateName(t *testing.T) {
+	bundle := newTestSnapshotBundle(t, []byte("disk"))
+	for _, name := range []string{"", ".", "..", "a/b", `a\b`, "x.tmp"} {
+		if err := bundle.SaveState(&fakeMachine{}, name); err == nil {
+			t.Errorf("want error for saved state name %q", name)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ValidationResult is the result of validating the config of a VM with DryRunValidate.
+type ValidationResult struct {
+	// Valid reports whether the config was built and is valid.
+	Valid bool
+
+	// Err is the error of building or validating the config. It is nil if Valid is true.
+	// vz.ErrorCodeOf returns the error code of the Virtualization framework, if it has one.
+	Err error
+
+	// PlatformErr is the error of (*vz.VirtualMachineConfiguration).ValidatePlatform, which tells
+	// whether the platform or the boot loader is the reason why the config is invalid.
+	PlatformErr error
+}
+
+// DryRunValidate builds and validates the config which createVirtualMachineConfig would create
+// for the VM, without creating a VM or changing the bundle. The config is built from a scratch
+// bundle which is removed before DryRunValidate returns: the files which prepareBundle would
+// create are created in it, and the other files of the bundle are linked into it. The golden
+// image is linked in place of the overlay which is not cloned yet.
+func DryRunValidate(entry *VMEntry, installerISOPath string, needsInstall bool, bundle *Bundle) ValidationResult {
+	dir, err := os.MkdirTemp("", "vz-dry-run-")
+	if err != nil {
+		return ValidationResult{Err: fmt.Errorf("failed to create a scratch directory: %w", err)}
+	}
+	defer os.RemoveAll(dir)
+
+	// The scratch bundle has the name of the bundle, which the MAC address is derived from.
+	scratch := NewBundle(filepath.Join(dir, filepath.Base(bundle.Path)))
+	if err := prepareScratchBundle(entry, needsInstall, bundle, scratch); err != nil {
+		return ValidationResult{Err: err}
+	}
+	config, err := buildVirtualMachineConfig(entry, installerISOPath, needsInstall, scratch)
+	if err != nil {
+		return ValidationResult{Err: err}
+	}
+	result := ValidationResult{PlatformErr: config.ValidatePlatform()}
+	result.Valid, result.Err = config.Validate()
+	if !result.Valid && result.Err == nil {
+		result.Err = errors.New("invalid configuration")
+	}
+	return result
+}
+
+// prepareScratchBundle prepares scratch like prepareBundle prepares bundle, without changing bundle.
+// The files which prepareBundle would write are created in scratch rather than linked, so that
+// they are never written through a link.
+func prepareScratchBundle(entry *VMEntry, needsInstall bool, bundle, scratch *Bundle) error {
+	if err := scratch.Create(); err != nil {
+		return err
+	}
+	links := map[string]string{
+		bundle.KernelPath(): scratch.KernelPath(),
+		bundle.InitrdPath(): scratch.InitrdPath(),
+	}
+	if !needsInstall {
+		links[bundle.MachineIdentifierPath()] = scratch.MachineIdentifierPath()
+		links[bundle.EFIVariableStorePath()] = scratch.EFIVariableStorePath()
+		links[bundle.DiskImagePath()] = scratch.DiskImagePath()
+	}
+	for src, dst := range links {
+		if _, err := os.Stat(src); err != nil {
+			continue
+		}
+		if err := os.Symlink(src, dst); err != nil {
+			return err
+		}
+	}
+	if needsInstall {
+		if err := createInstallFiles(entry, scratch); err != nil {
+			return err
+		}
+	}
+	if entry.GoldenPath != "" {
+		if _, err := os.Lstat(scratch.DiskImagePath()); errors.Is(err, os.ErrNotExist) {
+			return os.Symlink(entry.GoldenPath, scratch.DiskImagePath())
+		}
+	}
+	return nil
+}
+
+func runValidateCommand(registry *Registry, name, isoPath string) error {
+	entry := registry.Find(name)
+	if entry == nil {
+		return fmt.Errorf("VM %q not found", name)
+	}
+	result := DryRunValidate(entry, isoPath, isoPath != "", registry.BundleFor(entry))
+	if result.PlatformErr != nil {
+		fmt.Printf("platform: %v\n", result.PlatformErr)
+	}
+	if !result.Valid {
+		return fmt.Errorf("VM %q has an invalid configuration: %w", name, result.Err)
+	}
+	fmt.Printf("VM %q has a valid configuration\n", name)
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDryRunValidate(t *testing.T) {
+	// The scratch directory of the dry run is created in TMPDIR.
+	tmpDir := t.TempDir()
+	t.Setenv("TMPDIR", tmpDir)
+
+	dir := t.TempDir()
+	isoPath := filepath.Join(dir, "installer.iso")
+	if err := os.WriteFile(isoPath, make([]byte, 1<<20), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bundle := NewBundle(filepath.Join(dir, "test.bundle"))
+	if err := bundle.Create(); err != nil {
+		t.Fatal(err)
+	}
+	entry := &VMEntry{
+		Name:       "test",
+		BundleName: "test.bundle",
+		ISOPath:    isoPath,
+		CreatedAt:  time.Now(),
+		OSKind:     OSKindLinux,
+		BootType:   BootTypeEFI,
+	}
+
+	assertUnchanged := func(t *testing.T) {
+		t.Helper()
+		for _, d := range []string{bundle.Path, tmpDir} {
+			entries, err := os.ReadDir(d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				t.Errorf("dry run created %s", filepath.Join(d, e.Name()))
+			}
+		}
+	}
+
+	t.Run("new VM", func(t *testing.T) {
+		result := DryRunValidate(entry, isoPath, true, bundle)
+		if result.Valid != (result.Err == nil) {
+			t.Errorf("Valid is %v but Err is %v", result.Valid, result.Err)
+		}
+		t.Logf("valid=%v err=%v platform=%v", result.Valid, result.Err, result.PlatformErr)
+		assertUnchanged(t)
+	})
+
+	t.Run("existing VM without its files", func(t *testing.T) {
+		result := DryRunValidate(entry, "", false, bundle)
+		if result.Valid || result.Err == nil {
+			t.Fatalf("want an error for the missing machine identifier but got %+v", result)
+		}
+		assertUnchanged(t)
+	})
+
+	t.Run("golden image", func(t *testing.T) {
+		golden := filepath.Join(dir, "golden.img")
+		if err := os.WriteFile(golden, make([]byte, 1<<20), 0444); err != nil {
+			t.Fatal(err)
+		}
+		goldenEntry := *entry
+		goldenEntry.GoldenPath = golden
+		DryRunValidate(&goldenEntry, isoPath, true, bundle)
+		assertUnchanged(t)
+	})
+}
@@ -0,0 +1,9 @@
+module github.com/Code-Hex/vz/example/linux-headless
+
+go 1.24.0
+
+replace github.com/Code-Hex/vz/v3 => ../../
+
+require github.com/Code-Hex/vz/v3 v3.0.0-00010101000000-000000000000
+
+require github.com/Code-Hex/go-infinity-channel v1.0.0 // indirect
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Code-Hex/vz/v3"
+)
+
+var (
+	kernelPath  = flag.String("kernel", os.Getenv("VMLINUZ_PATH"), "path to the uncompressed Linux kernel (default $VMLINUZ_PATH)")
+	initrdPath  = flag.String("initrd", os.Getenv("INITRD_PATH"), "path to the initial ramdisk (default $INITRD_PATH)")
+	diskPath    = flag.String("disk", os.Getenv("DISKIMG_PATH"), "path to the raw disk image attached as /dev/vda (default $DISKIMG_PATH)")
+	commandLine = flag.String("cmdline", "console=hvc0 root=/dev/vda", "kernel command line")
+	cpuCount    = flag.Uint("cpus", 1, "number of CPUs")
+	memorySize  = flag.Uint64("memory", 2048, "memory size in MiB")
+	usePTY      = flag.Bool("pty", false, "connect the console to a new pseudo terminal instead of stdin/stdout")
+	background  = flag.Bool("background", false, "run the virtual machine queue at utility QoS so it yields to interactive work")
+)
+
+func main() {
+	flag.Parse()
+	log.SetFlags(log.LstdFlags)
+	log.SetPrefix("[linux-headless] ")
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run() error {
+	if *kernelPath == "" {
+		flag.Usage()
+		return errors.New("-kernel is required")
+	}
+
+	bootLoaderOpts := []vz.LinuxBootLoaderOption{vz.WithCommandLine(*commandLine)}
+	if *initrdPath != "" {
+		bootLoaderOpts = append(bootLoaderOpts, vz.WithInitrd(*initrdPath))
+	}
+	bootLoader, err := vz.NewLinuxBootLoader(*kernelPath, bootLoaderOpts...)
+	if err != nil {
+		return fmt.Errorf("bootloader creation failed: %w", err)
+	}
+
+	config, err := vz.NewVirtualMachineConfiguration(bootLoader, *cpuCount, *memorySize*1024*1024)
+	if err != nil {
+		return fmt.Errorf("failed to create virtual machine configuration: %w", err)
+	}
+
+	// console
+	var serialPortAttachment *vz.FileHandleSerialPortAttachment
+	if *usePTY {
+		attachment, path, err := vz.NewPTYSerialPortAttachment()
+		if err != nil {
+			return fmt.Errorf("pty serial port attachment creation failed: %w", err)
+		}
+		log.Printf("console is connected to %s (e.g. screen %s)", path, path)
+		serialPortAttachment = attachment
+	} else {
+		attachment, restore, err := vz.NewStdioSerialPortAttachment()
+		if err != nil {
+			return fmt.Errorf("serial port attachment creation failed: %w", err)
+		}
+		defer restore()
+		serialPortAttachment = attachment
+	}
+	consoleConfig, err := vz.NewVirtioConsoleDeviceSerialPortConfiguration(serialPortAttachment)
+	if err != nil {
+		return fmt.Errorf("failed to create serial configuration: %w", err)
+	}
+	config.SetSerialPortsVirtualMachineConfiguration([]*vz.VirtioConsoleDeviceSerialPortConfiguration{
+		consoleConfig,
+	})
+
+	// network
+	natAttachment, err := vz.NewNATNetworkDeviceAttachment()
+	if err != nil {
+		return fmt.Errorf("NAT network device creation failed: %w", err)
+	}
+	networkConfig, err := vz.NewVirtioNetworkDeviceConfiguration(natAttachment)
+	if err != nil {
+		return fmt.Errorf("creation of the networking configuration failed: %w", err)
+	}
+	mac, err := vz.NewRandomLocallyAdministeredMACAddress()
+	if err != nil {
+		return fmt.Errorf("random MAC address creation failed: %w", err)
+	}
+	networkConfig.SetMACAddress(mac)
+	config.SetNetworkDevicesVirtualMachineConfiguration([]*vz.VirtioNetworkDeviceConfiguration{
+		networkConfig,
+	})
+
+	// entropy
+	entropyConfig, err := vz.NewVirtioEntropyDeviceConfiguration()
+	if err != nil {
+		return fmt.Errorf("entropy device creation failed: %w", err)
+	}
+	config.SetEntropyDevicesVirtualMachineConfiguration([]*vz.VirtioEntropyDeviceConfiguration{
+		entropyConfig,
+	})
+
+	// disk (optional, e.g. when the kernel boots into the initial ramdisk)
+	if *diskPath != "" {
+		diskImageAttachment, err := vz.NewDiskImageStorageDeviceAttachment(*diskPath, false)
+		if err != nil {
+			return fmt.Errorf("disk image attachment creation failed: %w", err)
+		}
+		storageDeviceConfig, err := vz.NewVirtioBlockDeviceConfiguration(diskImageAttachment)
+		if err != nil {
+			return fmt.Errorf("block device creation failed: %w", err)
+		}
+		config.SetStorageDevicesVirtualMachineConfiguration([]vz.StorageDeviceConfiguration{
+			storageDeviceConfig,
+		})
+	}
+
+	// traditional memory balloon device which allows for managing guest memory. (optional)
+	memoryBalloonDevice, err := vz.NewVirtioTraditionalMemoryBalloonDeviceConfiguration()
+	if err != nil {
+		return fmt.Errorf("balloon device creation failed: %w", err)
+	}
+	config.SetMemoryBalloonDevicesVirtualMachineConfiguration([]vz.MemoryBalloonDeviceConfiguration{
+		memoryBalloonDevice,
+	})
+
+	validated, err := config.Validate()
+	if !validated || err != nil {
+		return fmt.Errorf("validation failed: %w", err)
+	}
+
+	var vmOpts []vz.NewVirtualMachineOption
+	if *background {
+		vmOpts = append(vmOpts, vz.WithQoSClass(vz.QoSClassUtility))
+	}
+	vm, err := vz.NewVirtualMachine(config, vmOpts...)
+	if err != nil {
+		return fmt.Errorf("virtual machine creation failed: %w", err)
+	}
+
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, syscall.SIGINT, syscall.SIGTERM)
+
+	if err := vm.Start(); err != nil {
+		return fmt.Errorf("start virtual machine is failed: %w", err)
+	}
+
+	var forceStop <-chan time.Time
+	for {
+		select {
+		case <-signalCh:
+			if forceStop != nil {
+				continue
+			}
+			log.Println("requesting the guest to stop")
+			if _, err := vm.RequestStop(); err != nil {
+				log.Println("request stop error:", err)
+			}
+			// Stop the virtual machine if the guest does not stop itself in time.
+			forceStop = time.After(10 * time.Second)
+		case <-forceStop:
+			log.Println("guest did not stop, stopping the virtual machine")
+			if err := vm.Stop(); err != nil {
+				return fmt.Errorf("failed to stop virtual machine: %w", err)
+			}
+		case event := <-vm.Events():
+			switch event.Kind {
+			case vz.EventStateChanged:
+				log.Println("state:", event.State)
+				if event.State == vz.VirtualMachineStateStopped {
+					return nil
+				}
+			case vz.EventGuestStopped:
+				log.Println("guest shut down")
+				return nil
+			case vz.EventStoppedWithError:
+				return fmt.Errorf("virtual machine stopped with error: %w", event.Err)
+			case vz.EventNetworkDisconnected:
+				log.Println("network disconnected:", event.Err)
+			}
+		}
+	}
+}
@@ -10,7 +10,4 @@ require (
 	golang.org/x/sys v0.39.0
 )
 
-require (
-	github.com/Code-Hex/go-infinity-channel v1.0.0 // indirect
-	golang.org/x/mod v0.22.0 // indirect
-)
+require github.com/Code-Hex/go-infinity-channel v1.0.0 // indirect
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+
+	"github.com/Code-Hex/vz/v3"
 )
 
 // CreateVMBundle creates macOS VM bundle path if not exists.
@@ -20,29 +22,33 @@ func GetVMBundlePath() string {
 	return filepath.Join(home, "/VM.bundle/")
 }
 
+func vmBundleLayout() vz.BundleLayout {
+	return vz.NewBundleLayout(GetVMBundlePath())
+}
+
 // GetAuxiliaryStoragePath gets a path for auxiliary storage.
 func GetAuxiliaryStoragePath() string {
-	return filepath.Join(GetVMBundlePath(), "AuxiliaryStorage")
+	return vmBundleLayout().AuxiliaryStoragePath()
 }
 
 // GetDiskImagePath gets a path for disk image.
 func GetDiskImagePath() string {
-	return filepath.Join(GetVMBundlePath(), "Disk.img")
+	return vmBundleLayout().DiskImagePath()
 }
 
 // GetHardwareModelPath gets a path for hardware model.
 func GetHardwareModelPath() string {
-	return filepath.Join(GetVMBundlePath(), "HardwareModel")
+	return vmBundleLayout().HardwareModelPath()
 }
 
 // GetMachineIdentifierPath gets a path for machine identifier.
 func GetMachineIdentifierPath() string {
-	return filepath.Join(GetVMBundlePath(), "MachineIdentifier")
+	return vmBundleLayout().MachineIdentifierPath()
 }
 
 // GetRestoreImagePath gets a path for restore image file.
 func GetRestoreImagePath() string {
-	return filepath.Join(GetVMBundlePath(), "RestoreImage.ipsw")
+	return vmBundleLayout().RestoreImagePath()
 }
 
 // CreateFileAndWriteTo creates a new file and write data to it.
@@ -35,6 +35,9 @@ func installMacOS(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("failed to setup config: %w", err)
 	}
+	if err := restoreImage.ValidateAgainst(config); err != nil {
+		return err
+	}
 	vm, err := vz.NewVirtualMachine(config)
 	if err != nil {
 		return err
@@ -126,24 +126,6 @@ func runVM(ctx context.Context) error {
 	return <-errCh
 }
 
-func computeCPUCount() uint {
-	totalAvailableCPUs := runtime.NumCPU()
-	virtualCPUCount := uint(totalAvailableCPUs - 1)
-	if virtualCPUCount <= 1 {
-		virtualCPUCount = 1
-	}
-	// TODO(codehex): use generics function when deprecated Go 1.17
-	maxAllowed := vz.VirtualMachineConfigurationMaximumAllowedCPUCount()
-	if virtualCPUCount > maxAllowed {
-		virtualCPUCount = maxAllowed
-	}
-	minAllowed := vz.VirtualMachineConfigurationMinimumAllowedCPUCount()
-	if virtualCPUCount < minAllowed {
-		virtualCPUCount = minAllowed
-	}
-	return virtualCPUCount
-}
-
 func computeMemorySize() uint64 {
 	// We arbitrarily choose 4GB.
 	memorySize := uint64(4 * 1024 * 1024 * 1024)
@@ -275,7 +257,7 @@ func setupVMConfiguration(ctx context.Context, platformConfig vz.PlatformConfigu
 
 	config, err := vz.NewVirtualMachineConfiguration(
 		bootloader,
-		computeCPUCount(),
+		vz.RecommendedCPUCount(),
 		computeMemorySize(),
 	)
 	if err != nil {
@@ -5,7 +5,6 @@ go 1.24.0
 require (
 	github.com/Code-Hex/go-infinity-channel v1.0.0
 	golang.org/x/crypto v0.46.0
-	golang.org/x/mod v0.22.0
 )
 
 require golang.org/x/sys v0.39.0 // indirect
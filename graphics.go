@@ -27,6 +27,10 @@ func (*baseGraphicsDeviceConfiguration) graphicsDeviceConfiguration() {}
 // This device configuration creates a graphics device using paravirtualization.
 // The emulated device follows the Virtio GPU Device specification.
 //
+// The scanouts are fixed when the virtual machine is created: the Virtualization framework cannot add
+// or remove a display of a running virtual machine. To change the resolution of the display, resize
+// the window or the VZVirtualMachineView instead.
+//
 // see: https://developer.apple.com/documentation/virtualization/vzvirtiographicsdeviceconfiguration?language=objc
 type VirtioGraphicsDeviceConfiguration struct {
 	*pointer
@@ -36,6 +40,13 @@ type VirtioGraphicsDeviceConfiguration struct {
 
 var _ GraphicsDeviceConfiguration = (*VirtioGraphicsDeviceConfiguration)(nil)
 
+// CanUseVirtioGraphics reports whether a Virtio graphics device can be created
+// on this host. When it returns false, leave the graphics devices of the
+// configuration empty to build a headless virtual machine instead.
+func CanUseVirtioGraphics() bool {
+	return macOSAvailable(13) == nil
+}
+
 // NewVirtioGraphicsDeviceConfiguration creates a new Virtio graphics device.
 //
 // This is only supported on macOS 13 and newer, error will
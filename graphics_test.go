@@ -0,0 +1,34 @@
+package vz_test
+
+import (
+	"testing"
+
+	"github.com/Code-Hex/vz/v3"
+)
+
+func TestCanUseVirtioGraphics(t *testing.T) {
+	_, err := vz.NewVirtioGraphicsDeviceConfiguration()
+	if got, want := vz.CanUseVirtioGraphics(), err == nil; got != want {
+		t.Fatalf("CanUseVirtioGraphics() = %v but NewVirtioGraphicsDeviceConfiguration returned %v", got, err)
+	}
+}
+
+func TestHeadlessConfigurationValidates(t *testing.T) {
+	bootLoader, err := vz.NewLinuxBootLoader("./testdata/Image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := setupConfiguration(bootLoader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.SetGraphicsDevicesVirtualMachineConfiguration(nil)
+
+	validated, err := config.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !validated {
+		t.Fatal("configuration without graphics devices should be valid")
+	}
+}
@@ -0,0 +1,192 @@
+package vz
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dhcpLeasesPath is the lease database of the DHCP server of macOS, which gives the addresses
+// to the virtual machines which use a NATNetworkDeviceAttachment.
+var dhcpLeasesPath = "/var/db/dhcpd_leases"
+
+// ErrGuestAddressNotFound is returned by LookupGuestIPAddress when the DHCP server has no lease
+// for the MAC address, e.g. because the guest has not requested an address yet.
+var ErrGuestAddressNotFound = errors.New("no DHCP lease for the MAC address")
+
+// ErrGuestUnreachable is returned by (*VirtualMachine).WaitForGuestReachable when the guest does
+// not accept a connection before the context ends.
+var ErrGuestUnreachable = errors.New("guest is not reachable")
+
+// dhcpLease is an entry of the lease database.
+type dhcpLease struct {
+	ipAddress  net.IP
+	hwAddress  net.HardwareAddr
+	expiration int64 // in seconds since the epoch
+}
+
+// parseDHCPLeases parses the lease database, which has an entry like this for each lease:
+//
+//	{
+//		name=ubuntu
+//		ip_address=192.168.64.2
+//		hw_address=1,a:b:c:d:e:f
+//		identifier=1,a:b:c:d:e:f
+//		lease=0x65a1b2c3
+//	}
+//
+// The bytes of hw_address have no leading zeros, and it is prefixed with the hardware type.
+// Entries which can not be parsed are skipped.
+func parseDHCPLeases(r io.Reader) ([]dhcpLease, error) {
+	var (
+		leases  []dhcpLease
+		current dhcpLease
+	)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch line {
+		case "{":
+			current = dhcpLease{}
+			continue
+		case "}":
+			if current.ipAddress != nil && current.hwAddress != nil {
+				leases = append(leases, current)
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "ip_address":
+			current.ipAddress = net.ParseIP(value)
+		case "hw_address":
+			current.hwAddress = parseLeaseHardwareAddr(value)
+		case "lease":
+			current.expiration, _ = strconv.ParseInt(strings.TrimPrefix(value, "0x"), 16, 64)
+		}
+	}
+	return leases, scanner.Err()
+}
+
+// parseLeaseHardwareAddr parses hw_address of the lease database, e.g. "1,a:b:c:d:e:f".
+// It returns nil if value is not an Ethernet address.
+func parseLeaseHardwareAddr(value string) net.HardwareAddr {
+	hwType, addr, ok := strings.Cut(value, ",")
+	if !ok || hwType != "1" {
+		return nil
+	}
+	parts := strings.Split(addr, ":")
+	if len(parts) != 6 {
+		return nil
+	}
+	hw := make(net.HardwareAddr, 0, len(parts))
+	for _, p := range parts {
+		b, err := strconv.ParseUint(p, 16, 8)
+		if err != nil {
+			return nil
+		}
+		hw = append(hw, byte(b))
+	}
+	return hw
+}
+
+// lookupDHCPLease returns the address of the lease of mac which expires last.
+func lookupDHCPLease(leases []dhcpLease, mac net.HardwareAddr) (net.IP, error) {
+	var found *dhcpLease
+	for i, lease := range leases {
+		if lease.hwAddress.String() != mac.String() {
+			continue
+		}
+		if found == nil || lease.expiration > found.expiration {
+			found = &leases[i]
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%w: %s", ErrGuestAddressNotFound, mac)
+	}
+	return found.ipAddress, nil
+}
+
+// LookupGuestIPAddress returns the IP address which the DHCP server of macOS leased to mac, the MAC
+// address of a network device with a NATNetworkDeviceAttachment. If mac has more than one lease,
+// the one which expires last is returned. ErrGuestAddressNotFound is returned if there is no lease.
+func LookupGuestIPAddress(mac net.HardwareAddr) (net.IP, error) {
+	f, err := os.Open(dhcpLeasesPath)
+	if errors.Is(err, os.ErrNotExist) {
+		// The database is created when the first lease is given.
+		return nil, fmt.Errorf("%w: %s", ErrGuestAddressNotFound, mac)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	leases, err := parseDHCPLeases(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dhcpLeasesPath, err)
+	}
+	return lookupDHCPLease(leases, mac)
+}
+
+// guestReachablePollInterval is the interval at which WaitForGuestReachable retries.
+var guestReachablePollInterval = time.Second
+
+// WaitForGuestReachable waits until the guest accepts a TCP connection on port (e.g. 22 for SSH),
+// so provisioning can proceed once the service is up. The address of the guest is looked up with
+// LookupGuestIPAddress for the first network device with a NATNetworkDeviceAttachment, until the
+// guest got a lease.
+//
+// If ctx ends first, an error which matches both ErrGuestUnreachable and the error of ctx is returned.
+func (v *VirtualMachine) WaitForGuestReachable(ctx context.Context, port int) error {
+	var mac net.HardwareAddr
+	for _, device := range v.networkDevices {
+		if _, ok := device.Attachment().(*NATNetworkDeviceAttachment); ok {
+			mac = device.MACAddress().HardwareAddr()
+			break
+		}
+	}
+	if mac == nil {
+		return errors.New("the virtual machine has no network device with a NAT attachment")
+	}
+	resolve := func() (net.IP, error) { return LookupGuestIPAddress(mac) }
+	dialer := &net.Dialer{Timeout: guestReachablePollInterval}
+	return waitForGuestReachable(ctx, resolve, dialer.DialContext, port, guestReachablePollInterval)
+}
+
+// waitForGuestReachable resolves the address of the guest and dials port every interval until
+// a connection succeeds or ctx ends.
+func waitForGuestReachable(
+	ctx context.Context,
+	resolve func() (net.IP, error),
+	dial func(ctx context.Context, network, address string) (net.Conn, error),
+	port int,
+	interval time.Duration,
+) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		ip, err := resolve()
+		if err == nil {
+			var conn net.Conn
+			conn, err = dial(ctx, "tcp", net.JoinHostPort(ip.String(), strconv.Itoa(port)))
+			if err == nil {
+				conn.Close()
+				return nil
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("%w on port %d: %w (last error: %v)", ErrGuestUnreachable, port, ctx.Err(), err)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package vz_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Code-Hex/vz/v3"
+)
+
+const testDHCPLeases = `{
+	name=ubuntu
+	ip_address=192.168.64.2
+	hw_address=1,2:a:b:c:d:e
+	identifier=1,2:a:b:c:d:e
+	lease=0x65a1b2c3
+}
+{
+	name=debian
+	ip_address=192.168.64.3
+	hw_address=1,6e:1:0:ff:20:3
+	identifier=1,6e:1:0:ff:20:3
+	lease=0x65a1b000
+}
+{
+	name=ubuntu
+	ip_address=192.168.64.9
+	hw_address=1,2:a:b:c:d:e
+	identifier=1,2:a:b:c:d:e
+	lease=0x65a1c000
+}
+{
+	name=broken
+	ip_address=192.168.64.4
+	hw_address=ff,1:2
+}
+`
+
+func TestLookupDHCPLease(t *testing.T) {
+	cases := []struct {
+		mac  string
+		want string
+	}{
+		// The lease which expires last wins.
+		{mac: "02:0a:0b:0c:0d:0e", want: "192.168.64.9"},
+		// The bytes of the database have no leading zeros.
+		{mac: "6e:01:00:ff:20:03", want: "192.168.64.3"},
+	}
+	for _, tc := range cases {
+		mac, err := net.ParseMAC(tc.mac)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := vz.LookupDHCPLease(testDHCPLeases, mac)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != tc.want {
+			t.Errorf("%s: want %s but got %s", tc.mac, tc.want, got)
+		}
+	}
+
+	mac, _ := net.ParseMAC("02:00:00:00:00:01")
+	if _, err := vz.LookupDHCPLease(testDHCPLeases, mac); !errors.Is(err, vz.ErrGuestAddressNotFound) {
+		t.Fatalf("want ErrGuestAddressNotFound but got %v", err)
+	}
+}
+
+func TestLookupGuestIPAddress(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dhcpd_leases")
+	defer vz.SwapDHCPLeasesPath(path)()
+
+	mac, _ := net.ParseMAC("6e:01:00:ff:20:03")
+	// No lease has been given yet.
+	if _, err := vz.LookupGuestIPAddress(mac); !errors.Is(err, vz.ErrGuestAddressNotFound) {
+		t.Fatalf("want ErrGuestAddressNotFound but got %v", err)
+	}
+	if err := os.WriteFile(path, []byte(testDHCPLeases), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := vz.LookupGuestIPAddress(mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != "192.168.64.3" {
+		t.Fatalf("want 192.168.64.3 but got %s", got)
+	}
+}
+
+func TestWaitForGuestReachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	port := ln.Addr().(*net.TCPAddr).Port
+	var dialer net.Dialer
+
+	t.Run("reachable after the lease", func(t *testing.T) {
+		resolves := 0
+		resolve := func() (net.IP, error) {
+			resolves++
+			if resolves < 3 {
+				return nil, vz.ErrGuestAddressNotFound
+			}
+			return net.IPv4(127, 0, 0, 1), nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := vz.WaitForGuestReachableWith(ctx, resolve, dialer.DialContext, port, time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		if resolves != 3 {
+			t.Fatalf("want 3 lookups but got %d", resolves)
+		}
+	})
+
+	t.Run("reachable after the service started", func(t *testing.T) {
+		resolve := func() (net.IP, error) { return net.IPv4(127, 0, 0, 1), nil }
+		dials := 0
+		dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+			dials++
+			if address != net.JoinHostPort("127.0.0.1", strconv.Itoa(port)) {
+				t.Errorf("unexpected address %s", address)
+			}
+			if dials < 3 {
+				return nil, errors.New("connection refused")
+			}
+			return dialer.DialContext(ctx, network, address)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := vz.WaitForGuestReachableWith(ctx, resolve, dial, port, time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		if dials != 3 {
+			t.Fatalf("want 3 dials but got %d", dials)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		errRefused := errors.New("connection refused")
+		resolve := func() (net.IP, error) { return net.IPv4(127, 0, 0, 1), nil }
+		dial := func(context.Context, string, string) (net.Conn, error) { return nil, errRefused }
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err := vz.WaitForGuestReachableWith(ctx, resolve, dial, 22, time.Millisecond)
+		if !errors.Is(err, vz.ErrGuestUnreachable) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("want ErrGuestUnreachable and context.DeadlineExceeded but got %v", err)
+		}
+	})
+}
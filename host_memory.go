@@ -0,0 +1,123 @@
+package vz
+
+/*
+#include <mach/mach.h>
+
+static int hostVMStatistics(vm_statistics64_data_t *stats, vm_size_t *pageSize)
+{
+	mach_port_t host = mach_host_self();
+	mach_msg_type_number_t count = HOST_VM_INFO64_COUNT;
+	kern_return_t ret = host_statistics64(host, HOST_VM_INFO64, (host_info64_t)stats, &count);
+	if (ret == KERN_SUCCESS) {
+		ret = host_page_size(host, pageSize);
+	}
+	mach_port_deallocate(mach_task_self(), host);
+	return ret;
+}
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrInsufficientHostMemory is returned by PreflightStart when the host is unlikely to have
+// enough memory for the virtual machine.
+var ErrInsufficientHostMemory = errors.New("insufficient host memory")
+
+// hostMemoryStatistics is the subset of the virtual memory statistics of the host
+// used to estimate the available memory.
+type hostMemoryStatistics struct {
+	pageSize         uint64
+	freePages        uint64
+	inactivePages    uint64
+	speculativePages uint64
+}
+
+// available returns the memory which can be allocated without paging out active memory:
+// free pages, and inactive and speculative pages which the system can reclaim.
+func (s hostMemoryStatistics) available() uint64 {
+	return (s.freePages + s.inactivePages + s.speculativePages) * s.pageSize
+}
+
+// EstimateHostMemoryAvailable returns an estimate in bytes of the memory which the host can
+// allocate right now, or 0 if it cannot be determined.
+//
+// Memory of running virtual machines which the guest has not touched yet is still counted
+// as available, see PreflightStart.
+func EstimateHostMemoryAvailable() uint64 {
+	var stats C.vm_statistics64_data_t
+	var pageSize C.vm_size_t
+	if C.hostVMStatistics(&stats, &pageSize) != C.KERN_SUCCESS {
+		return 0
+	}
+	return hostMemoryStatistics{
+		pageSize:         uint64(pageSize),
+		freePages:        uint64(stats.free_count),
+		inactivePages:    uint64(stats.inactive_count),
+		speculativePages: uint64(stats.speculative_count),
+	}.available()
+}
+
+// committedMemory is the sum of the memory sizes of the virtual machines of this process
+// which are not stopped. Guests allocate their memory lazily, so this is an upper bound
+// of the memory they will use.
+var committedMemory struct {
+	mu    sync.Mutex
+	bytes uint64
+}
+
+// holdsMemory reports whether a virtual machine in the state may hold guest memory.
+func holdsMemory(state VirtualMachineState) bool {
+	return state != VirtualMachineStateStopped && state != VirtualMachineStateError
+}
+
+// commitMemory updates committedMemory for the state change of a virtual machine.
+// The caller must hold m.mu.
+func (m *machineState) commitMemory(state VirtualMachineState) {
+	hold := holdsMemory(state)
+	if hold == m.memoryCommitted {
+		return
+	}
+	m.memoryCommitted = hold
+	committedMemory.mu.Lock()
+	defer committedMemory.mu.Unlock()
+	if hold {
+		committedMemory.bytes += m.memorySize
+	} else {
+		committedMemory.bytes -= m.memorySize
+	}
+}
+
+// checkHostMemory returns ErrInsufficientHostMemory if the requested memory and the memory
+// committed to other virtual machines exceed the available memory. available 0 means unknown.
+func checkHostMemory(requested, committed, available uint64) error {
+	if available == 0 || requested+committed <= available {
+		return nil
+	}
+	return fmt.Errorf("%w: %d MiB requested and %d MiB committed to running virtual machines, but %d MiB is available",
+		ErrInsufficientHostMemory, requested>>20, committed>>20, available>>20)
+}
+
+// PreflightStart checks whether the host is likely to have enough memory to start
+// the virtual machine, before a Start which would fail or make the host swap heavily.
+//
+// It returns an error which wraps ErrInsufficientHostMemory when the memory size of the
+// configuration plus the memory sizes of the other running virtual machines of this process
+// exceed EstimateHostMemoryAvailable. The check is deliberately pessimistic, so treat the
+// error as a warning: the virtual machine can still be started.
+func (v *VirtualMachine) PreflightStart() error {
+	v.machineState.mu.RLock()
+	own := uint64(0)
+	if v.machineState.memoryCommitted {
+		own = v.machineState.memorySize
+	}
+	v.machineState.mu.RUnlock()
+
+	committedMemory.mu.Lock()
+	committed := committedMemory.bytes - own
+	committedMemory.mu.Unlock()
+
+	return checkHostMemory(v.config.memorySize, committed, EstimateHostMemoryAvailable())
+}
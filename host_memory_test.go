@@ -0,0 +1,87 @@
+package vz_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Code-Hex/vz/v3"
+)
+
+func TestHostMemoryAvailable(t *testing.T) {
+	// 16 KiB pages: 1000 free, 500 inactive and 24 speculative pages.
+	if got, want := vz.HostMemoryAvailable(16384, 1000, 500, 24), uint64(1524*16384); got != want {
+		t.Fatalf("want %d but got %d", want, got)
+	}
+	if got := vz.EstimateHostMemoryAvailable(); got == 0 {
+		t.Fatal("want available memory of the host")
+	}
+}
+
+func TestCheckHostMemory(t *testing.T) {
+	const gib = 1 << 30
+	cases := []struct {
+		name      string
+		requested uint64
+		committed uint64
+		available uint64
+		wantErr   bool
+	}{
+		{name: "fits", requested: 4 * gib, committed: 0, available: 8 * gib},
+		{name: "fits exactly", requested: 4 * gib, committed: 4 * gib, available: 8 * gib},
+		{name: "exceeds with running VMs", requested: 4 * gib, committed: 6 * gib, available: 8 * gib, wantErr: true},
+		{name: "exceeds alone", requested: 16 * gib, committed: 0, available: 8 * gib, wantErr: true},
+		{name: "unknown availability", requested: 16 * gib, committed: 0, available: 0},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := vz.CheckHostMemory(tc.requested, tc.committed, tc.available)
+			if got := errors.Is(err, vz.ErrInsufficientHostMemory); got != tc.wantErr {
+				t.Fatalf("want ErrInsufficientHostMemory %v but got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestCommittedMemory(t *testing.T) {
+	const size = 512 << 20
+	base := vz.CommittedMemory()
+	c := vz.NewMemoryCommitter(size)
+
+	steps := []struct {
+		state vz.VirtualMachineState
+		want  uint64
+	}{
+		{vz.VirtualMachineStateStarting, base + size},
+		{vz.VirtualMachineStateRunning, base + size},
+		{vz.VirtualMachineStatePaused, base + size},
+		{vz.VirtualMachineStateStopped, base},
+		{vz.VirtualMachineStateRunning, base + size},
+		{vz.VirtualMachineStateError, base},
+		{vz.VirtualMachineStateStopped, base},
+	}
+	for _, step := range steps {
+		c.SetState(step.state)
+		if got := vz.CommittedMemory(); got != step.want {
+			t.Fatalf("after %v: want %d bytes committed but got %d", step.state, step.want, got)
+		}
+	}
+}
+
+func TestPreflightStart(t *testing.T) {
+	bootLoader, err := vz.NewLinuxBootLoader("./testdata/Image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := setupConfiguration(bootLoader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, err := vz.NewVirtualMachine(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The test configuration only requests 256 MiB.
+	if err := vm.PreflightStart(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,129 @@
+package vz
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrNotISOImage is returned by IsBootableISO when the file is not an ISO 9660 image.
+var ErrNotISOImage = errors.New("not an ISO 9660 image")
+
+const (
+	// The volume descriptors start at sector 16.
+	isoVolumeDescriptorSector = 16
+
+	isoVolumeDescriptorBootRecord = 0
+	isoVolumeDescriptorTerminator = 255
+
+	// elToritoPlatformEFI is the platform ID of an EFI boot image in the boot catalog.
+	elToritoPlatformEFI = 0xef
+
+	elToritoBootable      = 0x88
+	elToritoSectionHeader = 0x90
+	elToritoFinalHeader   = 0x91
+)
+
+var (
+	isoStandardIdentifier = []byte("CD001")
+	elToritoIdentifier    = []byte("EL TORITO SPECIFICATION")
+)
+
+// IsBootableISO reports whether the ISO image at path has an EFI boot image, so that EFIBootLoader
+// can boot it, e.g. when it is attached with NewISOStorageDeviceConfiguration. The image is
+// bootable if its El Torito boot catalog has a bootable entry for the EFI platform. A data-only
+// ISO image, or one which only boots with a BIOS, is not bootable.
+//
+// ErrNotISOImage is returned if the file is not an ISO 9660 image.
+func IsBootableISO(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	return isBootableISO(f)
+}
+
+func isBootableISO(r io.ReaderAt) (bool, error) {
+	catalogSector, err := findElToritoBootCatalog(r)
+	if err != nil || catalogSector == 0 {
+		return false, err
+	}
+	catalog := make([]byte, isoSectorSize)
+	if _, err := r.ReadAt(catalog, int64(catalogSector)*isoSectorSize); err != nil {
+		if errors.Is(err, io.EOF) {
+			return false, fmt.Errorf("%w: the boot catalog is out of the image", ErrNotISOImage)
+		}
+		return false, err
+	}
+	return hasEFIBootEntry(catalog), nil
+}
+
+// findElToritoBootCatalog returns the sector of the El Torito boot catalog, or 0 if the image
+// has no El Torito boot record.
+func findElToritoBootCatalog(r io.ReaderAt) (uint32, error) {
+	descriptor := make([]byte, isoSectorSize)
+	for sector := int64(isoVolumeDescriptorSector); ; sector++ {
+		if _, err := r.ReadAt(descriptor, sector*isoSectorSize); err != nil {
+			if errors.Is(err, io.EOF) {
+				return 0, ErrNotISOImage
+			}
+			return 0, err
+		}
+		if !bytes.Equal(descriptor[1:6], isoStandardIdentifier) {
+			return 0, ErrNotISOImage
+		}
+		switch descriptor[0] {
+		case isoVolumeDescriptorTerminator:
+			return 0, nil
+		case isoVolumeDescriptorBootRecord:
+			// The boot system identifier is padded with zeros.
+			if bytes.Equal(bytes.TrimRight(descriptor[7:39], "\x00"), elToritoIdentifier) {
+				return binary.LittleEndian.Uint32(descriptor[0x47:]), nil
+			}
+		}
+	}
+}
+
+// hasEFIBootEntry reports whether the boot catalog has a bootable entry for the EFI platform.
+// The catalog is a list of 32-byte entries: the validation entry with the platform of the
+// default entry which follows it, then sections which each have a header with their platform
+// and the number of their entries.
+func hasEFIBootEntry(catalog []byte) bool {
+	const entrySize = 32
+	validation := catalog[:entrySize]
+	if validation[0] != 0x01 || validation[30] != 0x55 || validation[31] != 0xaa {
+		return false
+	}
+	var sum uint16
+	for i := 0; i < entrySize; i += 2 {
+		sum += binary.LittleEndian.Uint16(validation[i:])
+	}
+	if sum != 0 {
+		return false
+	}
+	if validation[1] == elToritoPlatformEFI && catalog[entrySize] == elToritoBootable {
+		return true
+	}
+	for offset := 2 * entrySize; offset+entrySize <= len(catalog); {
+		header := catalog[offset : offset+entrySize]
+		if header[0] != elToritoSectionHeader && header[0] != elToritoFinalHeader {
+			return false
+		}
+		platform, entries := header[1], int(binary.LittleEndian.Uint16(header[2:]))
+		offset += entrySize
+		for i := 0; i < entries && offset+entrySize <= len(catalog); i++ {
+			if platform == elToritoPlatformEFI && catalog[offset] == elToritoBootable {
+				return true
+			}
+			offset += entrySize
+		}
+		if header[0] == elToritoFinalHeader {
+			return false
+		}
+	}
+	return false
+}
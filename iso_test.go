@@ -0,0 +1,165 @@
+package vz_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Code-Hex/vz/v3"
+)
+
+// isoFixture describes the El Torito boot catalog of an ISO image created by writeISOFixture.
+type isoFixture struct {
+	elTorito        bool
+	defaultPlatform byte // platform of the validation entry
+	defaultBootable bool
+	sections        []isoFixtureSection
+}
+
+type isoFixtureSection struct {
+	platform byte
+	bootable bool
+}
+
+// writeISOFixture writes a minimal ISO 9660 image: the primary volume descriptor, the El Torito
+// boot record if f.elTorito, the terminator and the boot catalog in sector 20.
+func writeISOFixture(t *testing.T, f isoFixture) string {
+	t.Helper()
+	const sectorSize, catalogSector = 2048, 20
+	image := make([]byte, 24*sectorSize)
+	descriptor := func(sector int, typ byte) []byte {
+		d := image[sector*sectorSize : (sector+1)*sectorSize]
+		d[0] = typ
+		copy(d[1:], "CD001")
+		d[6] = 1
+		return d
+	}
+	sector := 16
+	descriptor(sector, 1) // primary volume descriptor
+	sector++
+	if f.elTorito {
+		d := descriptor(sector, 0)
+		copy(d[7:], "EL TORITO SPECIFICATION")
+		binary.LittleEndian.PutUint32(d[0x47:], catalogSector)
+		sector++
+	}
+	descriptor(sector, 255)
+
+	catalog := image[catalogSector*sectorSize:]
+	validation := catalog[:32]
+	validation[0], validation[1] = 0x01, f.defaultPlatform
+	validation[30], validation[31] = 0x55, 0xaa
+	var sum uint16
+	for i := 0; i < 32; i += 2 {
+		sum += binary.LittleEndian.Uint16(validation[i:])
+	}
+	binary.LittleEndian.PutUint16(validation[28:], -sum)
+	if f.defaultBootable {
+		catalog[32] = 0x88
+	}
+	offset := 64
+	for i, s := range f.sections {
+		header := catalog[offset : offset+32]
+		header[0] = 0x90
+		if i == len(f.sections)-1 {
+			header[0] = 0x91
+		}
+		header[1] = s.platform
+		binary.LittleEndian.PutUint16(header[2:], 1)
+		if s.bootable {
+			catalog[offset+32] = 0x88
+		}
+		offset += 64
+	}
+
+	path := filepath.Join(t.TempDir(), "fixture.iso")
+	if err := os.WriteFile(path, image, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestIsBootableISO(t *testing.T) {
+	const (
+		platformBIOS = 0x00
+		platformEFI  = 0xef
+	)
+	cases := []struct {
+		name    string
+		fixture isoFixture
+		want    bool
+	}{
+		{
+			name:    "data only",
+			fixture: isoFixture{},
+			want:    false,
+		},
+		{
+			name:    "EFI default entry",
+			fixture: isoFixture{elTorito: true, defaultPlatform: platformEFI, defaultBootable: true},
+			want:    true,
+		},
+		{
+			name: "BIOS default entry and EFI section",
+			fixture: isoFixture{
+				elTorito:        true,
+				defaultPlatform: platformBIOS,
+				defaultBootable: true,
+				sections:        []isoFixtureSection{{platform: platformEFI, bootable: true}},
+			},
+			want: true,
+		},
+		{
+			name:    "BIOS only",
+			fixture: isoFixture{elTorito: true, defaultPlatform: platformBIOS, defaultBootable: true},
+			want:    false,
+		},
+		{
+			name: "EFI entry which is not bootable",
+			fixture: isoFixture{
+				elTorito:        true,
+				defaultPlatform: platformBIOS,
+				defaultBootable: true,
+				sections:        []isoFixtureSection{{platform: platformEFI, bootable: false}},
+			},
+			want: false,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := vz.IsBootableISO(writeISOFixture(t, tc.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Fatalf("want %v but got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestIsBootableISOErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := vz.IsBootableISO(filepath.Join(dir, "missing.iso")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("want os.ErrNotExist but got %v", err)
+	}
+
+	notISO := filepath.Join(dir, "disk.img")
+	if err := os.WriteFile(notISO, make([]byte, 64*1024), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vz.IsBootableISO(notISO); !errors.Is(err, vz.ErrNotISOImage) {
+		t.Errorf("want ErrNotISOImage but got %v", err)
+	}
+
+	short := filepath.Join(dir, "short.iso")
+	if err := os.WriteFile(short, []byte("CD001"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vz.IsBootableISO(short); !errors.Is(err, vz.ErrNotISOImage) {
+		t.Errorf("want ErrNotISOImage but got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package vz
+
+/*
+#cgo darwin CFLAGS: -mmacosx-version-min=11 -x objective-c -fno-objc-arc
+#cgo darwin LDFLAGS: -lobjc -framework Foundation -framework Virtualization -framework Cocoa
+# include "virtualization_default_app.h"
+*/
+import "C"
+import (
+	"fmt"
+
+	"github.com/Code-Hex/vz/v3/internal/objc"
+)
+
+// SendKey presses and releases a key with the modifiers of stroke in the virtual machine, for callers
+// which manage their own key mapping. See KeyStroke for the HID usage IDs of the keys.
+//
+// The Virtualization framework has no API to inject input, so the key is sent as keyboard events to
+// the window created by (*VirtualMachine).CreateWindow, like the keys typed in it. An error which wraps
+// ErrWindowNotFound is returned if the virtual machine has no window, and ErrInvalidVirtualMachineState
+// if it is not running.
+//
+// This is only supported on macOS 12 and newer, error will be returned on older versions.
+func (v *VirtualMachine) SendKey(stroke KeyStroke) error {
+	if err := macOSAvailable(12); err != nil {
+		return err
+	}
+	state := v.State()
+	if err := checkVirtualMachineState("send keys", state == VirtualMachineStateRunning, state); err != nil {
+		return err
+	}
+	events, err := keyEvents(stroke)
+	if err != nil {
+		return err
+	}
+	for _, e := range events {
+		if !C.sendVirtualMachineKeyEvent(objc.Ptr(v), C.int(e.typ), C.ushort(e.keyCode), C.ulonglong(e.flags)) {
+			return fmt.Errorf("%w: virtual machine has no window", ErrWindowNotFound)
+		}
+	}
+	return nil
+}
+
+// SendString types s in the virtual machine with keymap, which must match the keyboard layout of
+// the guest. Nothing is typed if a character of s is not in keymap. See SendKey for the requirements.
+//
+// This is only supported on macOS 12 and newer, error will be returned on older versions.
+func (v *VirtualMachine) SendString(s string, keymap *Keymap) error {
+	strokes, err := keymap.Strokes(s)
+	if err != nil {
+		return err
+	}
+	for _, stroke := range strokes {
+		if err := v.SendKey(stroke); err != nil {
+			return err
+		}
+	}
+	return nil
+}
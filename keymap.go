@@ -0,0 +1,245 @@
+package vz
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnmappedKey is returned when a character or a HID usage can not be typed as a key.
+var ErrUnmappedKey = errors.New("key is not mapped")
+
+// KeyModifiers are the modifier keys which are held while a key is pressed.
+type KeyModifiers uint8
+
+const (
+	// KeyModifierShift holds the left Shift key.
+	KeyModifierShift KeyModifiers = 1 << iota
+	// KeyModifierControl holds the left Control key.
+	KeyModifierControl
+	// KeyModifierAlt holds the left Alt (Option) key.
+	KeyModifierAlt
+	// KeyModifierAltGr holds the right Alt (Option) key, which selects the third
+	// level of keys (e.g. "@" or "{") on most non-US layouts of the guest.
+	KeyModifierAltGr
+)
+
+// KeyStroke is a press and release of a physical key of the keyboard.
+//
+// Usage is the HID usage ID of the key on the Keyboard/Keypad page (0x07) of the
+// HID Usage Tables. It identifies the position of the key, not the character it types,
+// which depends on the keyboard layout of the guest. The usage IDs are named after the
+// US layout:
+//
+//	0x04-0x1d  A to Z
+//	0x1e-0x27  1 to 9, then 0
+//	0x28       Enter
+//	0x29       Escape
+//	0x2a       Backspace
+//	0x2b       Tab
+//	0x2c       Space
+//	0x2d       - and _
+//	0x2e       = and +
+//	0x2f       [ and {
+//	0x30       ] and }
+//	0x31       \ and | (also the key left of Enter on ISO keyboards)
+//	0x33       ; and :
+//	0x34       ' and "
+//	0x35       ` and ~
+//	0x36       , and <
+//	0x37       . and >
+//	0x38       / and ?
+//	0x64       the key right of left Shift on ISO keyboards
+type KeyStroke struct {
+	Usage     uint16
+	Modifiers KeyModifiers
+}
+
+// Keymap maps characters to the key strokes which type them with a keyboard layout of the guest.
+//
+// The guest translates the keys to characters with its own layout, so use the keymap which matches
+// the layout configured in the guest, e.g. KeymapFrenchAZERTY types "a" with the key which is "q"
+// on a US keyboard. Dead keys and characters which need an input method are not mapped.
+type Keymap struct {
+	name    string
+	strokes map[rune]KeyStroke
+}
+
+// NewKeymap creates a keymap from strokes, for a layout which is not provided by this package.
+func NewKeymap(name string, strokes map[rune]KeyStroke) *Keymap {
+	m := make(map[rune]KeyStroke, len(strokes))
+	for r, s := range strokes {
+		m[r] = s
+	}
+	return &Keymap{name: name, strokes: m}
+}
+
+// String returns the name of the keymap.
+func (k *Keymap) String() string {
+	return k.name
+}
+
+// Strokes returns the key strokes which type s. An error which wraps ErrUnmappedKey is
+// returned if a character of s is not in the keymap.
+func (k *Keymap) Strokes(s string) ([]KeyStroke, error) {
+	strokes := make([]KeyStroke, 0, len(s))
+	for _, r := range s {
+		stroke, ok := k.strokes[r]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q in keymap %s", ErrUnmappedKey, r, k.name)
+		}
+		strokes = append(strokes, stroke)
+	}
+	return strokes, nil
+}
+
+// keyLevels is a key of a layout and the characters it types without a modifier,
+// with Shift and with AltGr. Zero means that the key types nothing at the level.
+type keyLevels struct {
+	usage                uint16
+	normal, shift, altGr rune
+}
+
+// newLayoutKeymap creates a keymap from letters, which lists the character typed by
+// each of the usages 0x04 to 0x1d, and the other keys of the layout. Only the letters
+// a to z in letters are mapped (with Shift for upper case), other keys are in keys.
+func newLayoutKeymap(name string, letters string, keys []keyLevels) *Keymap {
+	strokes := map[rune]KeyStroke{
+		' ':  {Usage: 0x2c},
+		'\n': {Usage: 0x28},
+		'\t': {Usage: 0x2b},
+	}
+	for i, r := range letters {
+		if r < 'a' || r > 'z' {
+			continue
+		}
+		usage := uint16(0x04 + i)
+		strokes[r] = KeyStroke{Usage: usage}
+		strokes[r-'a'+'A'] = KeyStroke{Usage: usage, Modifiers: KeyModifierShift}
+	}
+	for _, key := range keys {
+		if key.normal != 0 {
+			strokes[key.normal] = KeyStroke{Usage: key.usage}
+		}
+		if key.shift != 0 {
+			strokes[key.shift] = KeyStroke{Usage: key.usage, Modifiers: KeyModifierShift}
+		}
+		if key.altGr != 0 {
+			strokes[key.altGr] = KeyStroke{Usage: key.usage, Modifiers: KeyModifierAltGr}
+		}
+	}
+	return &Keymap{name: name, strokes: strokes}
+}
+
+var (
+	// KeymapUS is the US QWERTY layout.
+	KeymapUS = newLayoutKeymap("us", "abcdefghijklmnopqrstuvwxyz", []keyLevels{
+		{0x1e, '1', '!', 0}, {0x1f, '2', '@', 0}, {0x20, '3', '#', 0}, {0x21, '4', '$', 0},
+		{0x22, '5', '%', 0}, {0x23, '6', '^', 0}, {0x24, '7', '&', 0}, {0x25, '8', '*', 0},
+		{0x26, '9', '(', 0}, {0x27, '0', ')', 0},
+		{0x2d, '-', '_', 0}, {0x2e, '=', '+', 0}, {0x2f, '[', '{', 0}, {0x30, ']', '}', 0},
+		{0x31, '\\', '|', 0}, {0x33, ';', ':', 0}, {0x34, '\'', '"', 0}, {0x35, '`', '~', 0},
+		{0x36, ',', '<', 0}, {0x37, '.', '>', 0}, {0x38, '/', '?', 0},
+	})
+
+	// KeymapFrenchAZERTY is the French AZERTY layout of Linux and Windows.
+	KeymapFrenchAZERTY = newLayoutKeymap("fr", "qbcdefghijkl,noparstuvzxyw", []keyLevels{
+		{0x1e, '&', '1', 0}, {0x1f, 'é', '2', 0}, {0x20, '"', '3', '#'}, {0x21, '\'', '4', '{'},
+		{0x22, '(', '5', '['}, {0x23, '-', '6', '|'}, {0x24, 'è', '7', 0}, {0x25, '_', '8', '\\'},
+		{0x26, 'ç', '9', '^'}, {0x27, 'à', '0', '@'},
+		{0x2d, ')', '°', ']'}, {0x2e, '=', '+', '}'}, {0x30, '$', '£', '¤'},
+		{0x31, '*', 'µ', 0}, {0x33, 'm', 'M', 0}, {0x34, 'ù', '%', 0}, {0x35, '²', 0, 0},
+		{0x10, ',', '?', 0}, {0x36, ';', '.', 0}, {0x37, ':', '/', 0}, {0x38, '!', '§', 0},
+		{0x64, '<', '>', 0},
+	})
+
+	// KeymapGermanQWERTZ is the German QWERTZ layout.
+	KeymapGermanQWERTZ = newLayoutKeymap("de", "abcdefghijklmnopqrstuvwxzy", []keyLevels{
+		{0x1e, '1', '!', 0}, {0x1f, '2', '"', '²'}, {0x20, '3', '§', '³'}, {0x21, '4', '$', 0},
+		{0x22, '5', '%', 0}, {0x23, '6', '&', 0}, {0x24, '7', '/', '{'}, {0x25, '8', '(', '['},
+		{0x26, '9', ')', ']'}, {0x27, '0', '=', '}'},
+		{0x14, 0, 0, '@'}, {0x08, 0, 0, '€'},
+		{0x2d, 'ß', '?', '\\'}, {0x2f, 'ü', 'Ü', 0}, {0x30, '+', '*', '~'},
+		{0x31, '#', '\'', 0}, {0x33, 'ö', 'Ö', 0}, {0x34, 'ä', 'Ä', 0},
+		{0x36, ',', ';', 0}, {0x37, '.', ':', 0}, {0x38, '-', '_', 0},
+		{0x64, '<', '>', '|'},
+	})
+)
+
+// macOSKeyCodes maps HID usage IDs to the virtual key codes of macOS (kVK_* in
+// HIToolbox/Events.h), which identify the same physical keys in NSEvent.
+var macOSKeyCodes = map[uint16]uint16{
+	0x04: 0x00, 0x05: 0x0b, 0x06: 0x08, 0x07: 0x02, 0x08: 0x0e, 0x09: 0x03, 0x0a: 0x05,
+	0x0b: 0x04, 0x0c: 0x22, 0x0d: 0x26, 0x0e: 0x28, 0x0f: 0x25, 0x10: 0x2e, 0x11: 0x2d,
+	0x12: 0x1f, 0x13: 0x23, 0x14: 0x0c, 0x15: 0x0f, 0x16: 0x01, 0x17: 0x11, 0x18: 0x20,
+	0x19: 0x09, 0x1a: 0x0d, 0x1b: 0x07, 0x1c: 0x10, 0x1d: 0x06,
+	0x1e: 0x12, 0x1f: 0x13, 0x20: 0x14, 0x21: 0x15, 0x22: 0x17, 0x23: 0x16, 0x24: 0x1a,
+	0x25: 0x1c, 0x26: 0x19, 0x27: 0x1d,
+	0x28: 0x24, 0x29: 0x35, 0x2a: 0x33, 0x2b: 0x30, 0x2c: 0x31, 0x2d: 0x1b, 0x2e: 0x18,
+	0x2f: 0x21, 0x30: 0x1e, 0x31: 0x2a, 0x33: 0x29, 0x34: 0x27, 0x35: 0x32, 0x36: 0x2b,
+	0x37: 0x2f, 0x38: 0x2c, 0x64: 0x0a,
+}
+
+// keyEventType is the type of a keyboard NSEvent.
+type keyEventType int
+
+const (
+	keyEventDown keyEventType = iota
+	keyEventUp
+	keyEventFlagsChanged
+)
+
+// keyEvent is a keyboard NSEvent which is sent to the view of the virtual machine.
+type keyEvent struct {
+	typ     keyEventType
+	keyCode uint16
+	flags   uint64 // NSEventModifierFlags
+}
+
+// modifierKeys are the keys of KeyModifiers in the order they are pressed, with their
+// macOS virtual key codes and the NSEventModifierFlags (including the device dependent
+// bit of the left or right key) which are set while they are held.
+var modifierKeys = []struct {
+	modifier KeyModifiers
+	keyCode  uint16
+	flags    uint64
+}{
+	{KeyModifierControl, 0x3b, 1<<18 | 0x01},
+	{KeyModifierShift, 0x38, 1<<17 | 0x02},
+	{KeyModifierAlt, 0x3a, 1<<19 | 0x20},
+	{KeyModifierAltGr, 0x3d, 1<<19 | 0x40},
+}
+
+// keyEvents returns the events which press the modifiers, press and release the key,
+// then release the modifiers, like a physical keyboard does.
+func keyEvents(stroke KeyStroke) ([]keyEvent, error) {
+	keyCode, ok := macOSKeyCodes[stroke.Usage]
+	if !ok {
+		return nil, fmt.Errorf("%w: HID usage 0x%02x", ErrUnmappedKey, stroke.Usage)
+	}
+	var (
+		events  []keyEvent
+		flags   uint64
+		pressed []int
+	)
+	for i, m := range modifierKeys {
+		if stroke.Modifiers&m.modifier == 0 {
+			continue
+		}
+		flags |= m.flags
+		events = append(events, keyEvent{typ: keyEventFlagsChanged, keyCode: m.keyCode, flags: flags})
+		pressed = append(pressed, i)
+	}
+	events = append(events,
+		keyEvent{typ: keyEventDown, keyCode: keyCode, flags: flags},
+		keyEvent{typ: keyEventUp, keyCode: keyCode, flags: flags},
+	)
+	for j := len(pressed) - 1; j >= 0; j-- {
+		// Alt and AltGr share the flag of Option, so recompute the flags of the keys still held.
+		flags = 0
+		for _, i := range pressed[:j] {
+			flags |= modifierKeys[i].flags
+		}
+		events = append(events, keyEvent{typ: keyEventFlagsChanged, keyCode: modifierKeys[pressed[j]].keyCode, flags: flags})
+	}
+	return events, nil
+}
@@ -0,0 +1,100 @@
+package vz_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Code-Hex/vz/v3"
+)
+
+func TestKeymapStrokes(t *testing.T) {
+	const (
+		shift = vz.KeyModifierShift
+		altGr = vz.KeyModifierAltGr
+	)
+	cases := []struct {
+		keymap *vz.Keymap
+		s      string
+		want   []vz.KeyStroke
+	}{
+		{
+			keymap: vz.KeymapUS,
+			s:      "aQ1!\n",
+			want:   []vz.KeyStroke{{Usage: 0x04}, {Usage: 0x14, Modifiers: shift}, {Usage: 0x1e}, {Usage: 0x1e, Modifiers: shift}, {Usage: 0x28}},
+		},
+		{
+			// The keys are at the positions of "qwerty" on a US keyboard.
+			keymap: vz.KeymapFrenchAZERTY,
+			s:      "azerty",
+			want:   []vz.KeyStroke{{Usage: 0x14}, {Usage: 0x1a}, {Usage: 0x08}, {Usage: 0x15}, {Usage: 0x17}, {Usage: 0x1c}},
+		},
+		{
+			keymap: vz.KeymapFrenchAZERTY,
+			s:      "Mw1&@,",
+			want: []vz.KeyStroke{
+				{Usage: 0x33, Modifiers: shift}, {Usage: 0x1d}, {Usage: 0x1e, Modifiers: shift},
+				{Usage: 0x1e}, {Usage: 0x27, Modifiers: altGr}, {Usage: 0x10},
+			},
+		},
+		{
+			keymap: vz.KeymapGermanQWERTZ,
+			s:      "zyZ@ß",
+			want:   []vz.KeyStroke{{Usage: 0x1c}, {Usage: 0x1d}, {Usage: 0x1c, Modifiers: shift}, {Usage: 0x14, Modifiers: altGr}, {Usage: 0x2d}},
+		},
+	}
+	for _, tc := range cases {
+		got, err := tc.keymap.Strokes(tc.s)
+		if err != nil {
+			t.Fatalf("%s %q: %v", tc.keymap, tc.s, err)
+		}
+		if !reflect.DeepEqual(tc.want, got) {
+			t.Errorf("%s %q: want %v but got %v", tc.keymap, tc.s, tc.want, got)
+		}
+	}
+
+	if _, err := vz.KeymapUS.Strokes("é"); !errors.Is(err, vz.ErrUnmappedKey) {
+		t.Errorf("want ErrUnmappedKey but got %v", err)
+	}
+
+	custom := vz.NewKeymap("custom", map[rune]vz.KeyStroke{'x': {Usage: 0x04}})
+	if got, err := custom.Strokes("x"); err != nil || !reflect.DeepEqual(got, []vz.KeyStroke{{Usage: 0x04}}) {
+		t.Errorf("want custom stroke but got %v, %v", got, err)
+	}
+}
+
+func TestKeyEvents(t *testing.T) {
+	got, err := vz.KeyEvents(vz.KeyStroke{Usage: 0x04})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []vz.KeyEvent{{Type: "down", KeyCode: 0x00}, {Type: "up", KeyCode: 0x00}}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want %v but got %v", want, got)
+	}
+
+	// Shift is pressed before the key and released after it, AltGr is the right Option key.
+	got, err = vz.KeyEvents(vz.KeyStroke{Usage: 0x27, Modifiers: vz.KeyModifierShift | vz.KeyModifierAltGr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const (
+		shiftFlags = 1<<17 | 0x02
+		altGrFlags = 1<<19 | 0x40
+	)
+	want = []vz.KeyEvent{
+		{Type: "flags", KeyCode: 0x38, Flags: shiftFlags},
+		{Type: "flags", KeyCode: 0x3d, Flags: shiftFlags | altGrFlags},
+		{Type: "down", KeyCode: 0x1d, Flags: shiftFlags | altGrFlags},
+		{Type: "up", KeyCode: 0x1d, Flags: shiftFlags | altGrFlags},
+		{Type: "flags", KeyCode: 0x3d, Flags: shiftFlags},
+		{Type: "flags", KeyCode: 0x38},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want %v but got %v", want, got)
+	}
+
+	if _, err := vz.KeyEvents(vz.KeyStroke{Usage: 0xff}); !errors.Is(err, vz.ErrUnmappedKey) {
+		t.Errorf("want ErrUnmappedKey but got %v", err)
+	}
+}
@@ -0,0 +1,264 @@
+package vz
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrInvalidLinuxVMOptions is returned by NewLinuxVirtualMachine when the options are inconsistent.
+var ErrInvalidLinuxVMOptions = errors.New("invalid Linux virtual machine options")
+
+// DefaultLinuxCommandLine is the kernel command line used when LinuxVMOptions.CommandLine is empty.
+const DefaultLinuxCommandLine = "console=hvc0"
+
+// LinuxVMOptions describes a Linux virtual machine for NewLinuxVirtualMachine.
+//
+// The guest boots Kernel directly if it is set, otherwise it boots from Disks
+// with the EFI boot loader using EFIVariableStore.
+type LinuxVMOptions struct {
+	// Kernel is the path to the uncompressed Linux kernel for direct kernel boot.
+	Kernel string
+	// Initrd is the path to the initial ramdisk. Optional, only used with Kernel.
+	Initrd string
+	// CommandLine is the kernel command line. Only used with Kernel.
+	// DefaultLinuxCommandLine is used if empty.
+	CommandLine string
+
+	// EFIVariableStore is the path to the EFI variable store for EFI boot.
+	// The store is created if the file does not exist.
+	EFIVariableStore string
+	// MachineIdentifier is the path to the file which keeps the machine identifier.
+	// The identifier is created and saved if the file does not exist. Optional, a new
+	// identifier is used on every boot if empty.
+	MachineIdentifier string
+
+	// Disks are the raw disk images attached as Virtio block devices in order,
+	// so the first one is /dev/vda in the guest.
+	Disks []LinuxVMDisk
+
+	// CPUCount is the number of CPUs. RecommendedCPUCount is used if 0.
+	CPUCount uint
+	// MemorySize is the memory size in bytes. RecommendedMemorySize(0.5) is used if 0.
+	MemorySize uint64
+
+	// NAT attaches a Virtio network device with a NAT attachment and a random MAC address.
+	NAT bool
+
+	// Shares are the directories shared with the guest with Virtio file system devices.
+	Shares []LinuxVMShare
+
+	// Console is attached to the Virtio console (hvc0). Optional.
+	Console SerialPortAttachment
+}
+
+// LinuxVMDisk is a disk of LinuxVMOptions.
+type LinuxVMDisk struct {
+	// Path is the path to the raw disk image.
+	Path     string
+	ReadOnly bool
+}
+
+// LinuxVMShare is a shared directory of LinuxVMOptions.
+// The guest mounts it with e.g. "mount -t virtiofs <Tag> /mnt".
+type LinuxVMShare struct {
+	Tag      string
+	Path     string
+	ReadOnly bool
+}
+
+func (o *LinuxVMOptions) validate() error {
+	switch {
+	case o.Kernel != "" && o.EFIVariableStore != "":
+		return fmt.Errorf("%w: Kernel and EFIVariableStore are mutually exclusive", ErrInvalidLinuxVMOptions)
+	case o.Kernel == "" && o.EFIVariableStore == "":
+		return fmt.Errorf("%w: either Kernel or EFIVariableStore is required", ErrInvalidLinuxVMOptions)
+	case o.Kernel == "" && (o.Initrd != "" || o.CommandLine != ""):
+		return fmt.Errorf("%w: Initrd and CommandLine require Kernel", ErrInvalidLinuxVMOptions)
+	case o.Kernel == "" && len(o.Disks) == 0:
+		return fmt.Errorf("%w: EFI boot requires at least one disk", ErrInvalidLinuxVMOptions)
+	}
+	for i, disk := range o.Disks {
+		if disk.Path == "" {
+			return fmt.Errorf("%w: disk %d has no path", ErrInvalidLinuxVMOptions, i)
+		}
+	}
+	tags := make(map[string]bool, len(o.Shares))
+	for _, share := range o.Shares {
+		if share.Path == "" {
+			return fmt.Errorf("%w: share %q has no path", ErrInvalidLinuxVMOptions, share.Tag)
+		}
+		if tags[share.Tag] {
+			return fmt.Errorf("%w: duplicate share tag %q", ErrInvalidLinuxVMOptions, share.Tag)
+		}
+		tags[share.Tag] = true
+	}
+	return nil
+}
+
+// NewLinuxVirtualMachine creates a Linux virtual machine from opts. The configuration has
+// the boot loader, the disks, the network device, the shared directories and the console
+// described by opts, plus the devices added by WithRecommendedDevices, and is validated
+// before the virtual machine is created. The recommended console port is left out when
+// opts.Console is set. The virtual machine is not started.
+//
+// Use NewVirtualMachineConfiguration and NewVirtualMachine for anything opts can not express.
+//
+// This is only supported on macOS 12 and newer, error will
+// be returned on older versions.
+func NewLinuxVirtualMachine(opts LinuxVMOptions) (*VirtualMachine, error) {
+	if err := macOSAvailable(12); err != nil {
+		return nil, err
+	}
+	config, err := newLinuxVirtualMachineConfiguration(&opts)
+	if err != nil {
+		return nil, err
+	}
+	return NewVirtualMachine(config)
+}
+
+func newLinuxVirtualMachineConfiguration(opts *LinuxVMOptions) (*VirtualMachineConfiguration, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	bootLoader, err := newLinuxVMBootLoader(opts)
+	if err != nil {
+		return nil, err
+	}
+	cpuCount := opts.CPUCount
+	if cpuCount == 0 {
+		cpuCount = RecommendedCPUCount()
+	}
+	memorySize := opts.MemorySize
+	if memorySize == 0 {
+		memorySize = RecommendedMemorySize(0.5)
+	}
+	config, err := NewVirtualMachineConfiguration(bootLoader, cpuCount, memorySize, WithRecommendedDevices())
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.MachineIdentifier != "" {
+		machineIdentifier, err := loadOrCreateGenericMachineIdentifier(opts.MachineIdentifier)
+		if err != nil {
+			return nil, err
+		}
+		platformConfig, err := NewGenericPlatformConfiguration(WithGenericMachineIdentifier(machineIdentifier))
+		if err != nil {
+			return nil, err
+		}
+		config.SetPlatformVirtualMachineConfiguration(platformConfig)
+	}
+
+	storageDevices := make([]StorageDeviceConfiguration, 0, len(opts.Disks))
+	for _, disk := range opts.Disks {
+		attachment, err := NewDiskImageStorageDeviceAttachment(disk.Path, disk.ReadOnly)
+		if err != nil {
+			return nil, fmt.Errorf("failed to attach disk %q: %w", disk.Path, err)
+		}
+		blockDevice, err := NewVirtioBlockDeviceConfiguration(attachment)
+		if err != nil {
+			return nil, err
+		}
+		storageDevices = append(storageDevices, blockDevice)
+	}
+	config.SetStorageDevicesVirtualMachineConfiguration(storageDevices)
+
+	if opts.NAT {
+		attachment, err := NewNATNetworkDeviceAttachment()
+		if err != nil {
+			return nil, err
+		}
+		networkDevice, err := NewVirtioNetworkDeviceConfiguration(attachment)
+		if err != nil {
+			return nil, err
+		}
+		macAddress, err := NewRandomLocallyAdministeredMACAddress()
+		if err != nil {
+			return nil, err
+		}
+		networkDevice.SetMACAddress(macAddress)
+		config.SetNetworkDevicesVirtualMachineConfiguration([]*VirtioNetworkDeviceConfiguration{networkDevice})
+	}
+
+	sharingDevices := make([]DirectorySharingDeviceConfiguration, 0, len(opts.Shares))
+	for _, share := range opts.Shares {
+		sharedDirectory, err := NewSharedDirectory(share.Path, share.ReadOnly)
+		if err != nil {
+			return nil, fmt.Errorf("failed to share %q: %w", share.Path, err)
+		}
+		directoryShare, err := NewSingleDirectoryShare(sharedDirectory)
+		if err != nil {
+			return nil, err
+		}
+		fileSystemDevice, err := NewVirtioFileSystemDeviceConfiguration(share.Tag)
+		if err != nil {
+			return nil, fmt.Errorf("invalid share tag %q: %w", share.Tag, err)
+		}
+		fileSystemDevice.SetDirectoryShare(directoryShare)
+		sharingDevices = append(sharingDevices, fileSystemDevice)
+	}
+	config.SetDirectorySharingDevicesVirtualMachineConfiguration(sharingDevices)
+
+	if opts.Console != nil {
+		consoleDevice, err := NewVirtioConsoleDeviceSerialPortConfiguration(opts.Console)
+		if err != nil {
+			return nil, err
+		}
+		config.SetSerialPortsVirtualMachineConfiguration([]*VirtioConsoleDeviceSerialPortConfiguration{consoleDevice})
+		// opts.Console is the system console, not the port added by WithRecommendedDevices.
+		config.SetConsoleDevicesVirtualMachineConfiguration(nil)
+	}
+
+	validated, err := config.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if !validated {
+		return nil, errors.New("invalid virtual machine configuration")
+	}
+	return config, nil
+}
+
+func newLinuxVMBootLoader(opts *LinuxVMOptions) (BootLoader, error) {
+	if opts.Kernel != "" {
+		commandLine := opts.CommandLine
+		if commandLine == "" {
+			commandLine = DefaultLinuxCommandLine
+		}
+		bootLoaderOpts := []LinuxBootLoaderOption{WithCommandLine(commandLine)}
+		if opts.Initrd != "" {
+			bootLoaderOpts = append(bootLoaderOpts, WithInitrd(opts.Initrd))
+		}
+		return NewLinuxBootLoader(opts.Kernel, bootLoaderOpts...)
+	}
+
+	var storeOpts []NewEFIVariableStoreOption
+	if _, err := os.Stat(opts.EFIVariableStore); errors.Is(err, os.ErrNotExist) {
+		storeOpts = append(storeOpts, WithCreatingEFIVariableStore())
+	}
+	variableStore, err := NewEFIVariableStore(opts.EFIVariableStore, storeOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return NewEFIBootLoader(WithEFIVariableStore(variableStore))
+}
+
+// loadOrCreateGenericMachineIdentifier loads the machine identifier saved in path,
+// or creates a new one and saves it in path.
+func loadOrCreateGenericMachineIdentifier(path string) (*GenericMachineIdentifier, error) {
+	if _, err := os.Stat(path); err == nil {
+		return NewGenericMachineIdentifierWithDataPath(path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	machineIdentifier, err := NewGenericMachineIdentifier()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, machineIdentifier.DataRepresentation(), 0600); err != nil {
+		return nil, fmt.Errorf("failed to save machine identifier: %w", err)
+	}
+	return machineIdentifier, nil
+}
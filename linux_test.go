@@ -0,0 +1,123 @@
+package vz_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Code-Hex/vz/v3"
+)
+
+func TestLinuxVMOptionsValidate(t *testing.T) {
+	disk := []vz.LinuxVMDisk{{Path: "/path/to/disk.img"}}
+	cases := []struct {
+		name    string
+		opts    vz.LinuxVMOptions
+		wantErr bool
+	}{
+		{name: "kernel", opts: vz.LinuxVMOptions{Kernel: "vmlinuz"}},
+		{name: "kernel with initrd and disk", opts: vz.LinuxVMOptions{Kernel: "vmlinuz", Initrd: "initrd", CommandLine: "console=hvc0 root=/dev/vda", Disks: disk}},
+		{name: "efi with disk", opts: vz.LinuxVMOptions{EFIVariableStore: "nvram", Disks: disk}},
+		{name: "no boot loader", opts: vz.LinuxVMOptions{Disks: disk}, wantErr: true},
+		{name: "kernel and efi", opts: vz.LinuxVMOptions{Kernel: "vmlinuz", EFIVariableStore: "nvram", Disks: disk}, wantErr: true},
+		{name: "initrd without kernel", opts: vz.LinuxVMOptions{EFIVariableStore: "nvram", Initrd: "initrd", Disks: disk}, wantErr: true},
+		{name: "command line without kernel", opts: vz.LinuxVMOptions{EFIVariableStore: "nvram", CommandLine: "quiet", Disks: disk}, wantErr: true},
+		{name: "efi without disk", opts: vz.LinuxVMOptions{EFIVariableStore: "nvram"}, wantErr: true},
+		{name: "disk without path", opts: vz.LinuxVMOptions{Kernel: "vmlinuz", Disks: []vz.LinuxVMDisk{{}}}, wantErr: true},
+		{name: "share without path", opts: vz.LinuxVMOptions{Kernel: "vmlinuz", Shares: []vz.LinuxVMShare{{Tag: "a"}}}, wantErr: true},
+		{
+			name: "duplicate share tags",
+			opts: vz.LinuxVMOptions{Kernel: "vmlinuz", Shares: []vz.LinuxVMShare{
+				{Tag: "a", Path: "/tmp"},
+				{Tag: "a", Path: "/var"},
+			}},
+			wantErr: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.opts.Validate()
+			if tc.wantErr {
+				if !errors.Is(err, vz.ErrInvalidLinuxVMOptions) {
+					t.Fatalf("want ErrInvalidLinuxVMOptions but got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestNewLinuxVirtualMachine(t *testing.T) {
+	if vz.Available(12) {
+		t.Skip("NewLinuxVirtualMachine is supported from macOS 12")
+	}
+
+	dir := t.TempDir()
+	diskPath := filepath.Join(dir, "disk.img")
+	if err := vz.CreateDiskImage(diskPath, 64*1024*1024); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		opts vz.LinuxVMOptions
+	}{
+		{
+			name: "minimal kernel",
+			opts: vz.LinuxVMOptions{Kernel: "./testdata/Image"},
+		},
+		{
+			name: "kernel with disk, NAT and share",
+			opts: vz.LinuxVMOptions{
+				Kernel:      "./testdata/Image",
+				CommandLine: "console=hvc0 root=/dev/vda",
+				Disks:       []vz.LinuxVMDisk{{Path: diskPath}},
+				CPUCount:    1,
+				MemorySize:  256 * 1024 * 1024,
+				NAT:         true,
+				Shares:      []vz.LinuxVMShare{{Tag: "share", Path: t.TempDir(), ReadOnly: true}},
+			},
+		},
+		{
+			name: "efi with disk and machine identifier",
+			opts: vz.LinuxVMOptions{
+				EFIVariableStore:  filepath.Join(dir, "NVRAM"),
+				MachineIdentifier: filepath.Join(dir, "MachineIdentifier"),
+				Disks:             []vz.LinuxVMDisk{{Path: diskPath, ReadOnly: true}},
+				MemorySize:        256 * 1024 * 1024,
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			vm, err := vz.NewLinuxVirtualMachine(tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := vm.State(); got != vz.VirtualMachineStateStopped {
+				t.Fatalf("want %v but got %v", vz.VirtualMachineStateStopped, got)
+			}
+			if !vm.CanStart() {
+				t.Fatal("want the virtual machine to be startable")
+			}
+		})
+	}
+
+	// The EFI variable store and the machine identifier are created once and reused.
+	for _, name := range []string{"NVRAM", "MachineIdentifier"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("want %s created but got %v", name, err)
+		}
+	}
+	if _, err := vz.NewLinuxVirtualMachine(cases[2].opts); err != nil {
+		t.Fatalf("reusing the EFI variable store and machine identifier: %v", err)
+	}
+
+	if _, err := vz.NewLinuxVirtualMachine(vz.LinuxVMOptions{Kernel: "./testdata/Image", Shares: []vz.LinuxVMShare{{Tag: "", Path: dir}}}); err == nil {
+		t.Fatal("want error for an empty share tag")
+	}
+}
@@ -0,0 +1,362 @@
+//go:build darwin && arm64
+// +build darwin,arm64
+
+package vz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// MacOSVMOptions describes a macOS virtual machine for NewMacOSVirtualMachine.
+type MacOSVMOptions struct {
+	// CPUCount is the number of CPUs. If 0, RecommendedCPUCount is used, raised to
+	// the minimum of the restore image when the bundle is provisioned.
+	CPUCount uint
+	// MemorySize is the memory size in bytes. If 0, RecommendedMemorySize(0.5) is used,
+	// raised to the minimum of the restore image when the bundle is provisioned.
+	MemorySize uint64
+	// DiskSize is the size in bytes of the disk image created when the bundle is provisioned.
+	// 64 GiB is used if 0.
+	DiskSize int64
+
+	// NAT attaches a Virtio network device with a NAT attachment.
+	NAT bool
+
+	// DisplayWidth, DisplayHeight and DisplayPixelsPerInch describe the display.
+	// 1920x1200 at 80 pixels per inch is used if any of them is 0.
+	DisplayWidth         int64
+	DisplayHeight        int64
+	DisplayPixelsPerInch int64
+
+	// Install installs macOS from the restore image when the bundle is provisioned by this call.
+	Install bool
+	// InstallProgress is called periodically with the fraction completed while installing. Optional.
+	InstallProgress func(fractionCompleted float64)
+}
+
+const defaultMacOSDiskSize = 64 * 1024 * 1024 * 1024
+
+// macOSVMResources returns the number of CPUs and the memory size of the virtual machine.
+// Zero values in opts are replaced with the recommended values, raised to the minimums
+// which are non-zero only when the bundle is provisioned from a restore image.
+func macOSVMResources(opts *MacOSVMOptions, recommendedCPUCount uint, recommendedMemorySize uint64, minCPUCount, minMemorySize uint64) (uint, uint64) {
+	cpuCount := opts.CPUCount
+	if cpuCount == 0 {
+		cpuCount = recommendedCPUCount
+		if uint64(cpuCount) < minCPUCount {
+			cpuCount = uint(minCPUCount)
+		}
+	}
+	memorySize := opts.MemorySize
+	if memorySize == 0 {
+		memorySize = recommendedMemorySize
+		if memorySize < minMemorySize {
+			memorySize = minMemorySize
+		}
+	}
+	return cpuCount, memorySize
+}
+
+// NewMacOSVirtualMachine creates a macOS virtual machine whose files are kept in bundleDir,
+// laid out as described by BundleLayout.
+//
+// If bundleDir has not been provisioned yet, the hardware model, the machine identifier,
+// the auxiliary storage and the disk image are created from the restore image (an .ipsw file),
+// and macOS is installed onto the disk if opts.Install is true. Otherwise the files in
+// bundleDir are used and restoreImage may be empty.
+//
+// If an error is returned while bundleDir is provisioned, including a failed installation,
+// the files created in bundleDir are removed so that it is provisioned again on the next call.
+//
+// The virtual machine is not started, call (*VirtualMachine).Start to boot it.
+//
+// This is only supported on macOS 12 and newer, error will
+// be returned on older versions.
+func NewMacOSVirtualMachine(ctx context.Context, bundleDir, restoreImage string, opts MacOSVMOptions) (_ *VirtualMachine, err error) {
+	if err := macOSAvailable(12); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(bundleDir, 0755); err != nil {
+		return nil, err
+	}
+
+	layout := NewBundleLayout(bundleDir)
+	_, err = os.Stat(layout.HardwareModelPath())
+	provision := errors.Is(err, os.ErrNotExist)
+	if err != nil && !provision {
+		return nil, err
+	}
+
+	var (
+		image    *MacOSRestoreImage
+		platform *MacPlatformConfiguration
+		minCPUs  uint64
+		minBytes uint64
+	)
+	if provision {
+		if restoreImage == "" {
+			return nil, fmt.Errorf("a restore image is required to provision %s", bundleDir)
+		}
+		image, err = LoadMacOSRestoreImageFromPath(restoreImage)
+		if err != nil {
+			return nil, err
+		}
+		if image.mostFeaturefulSupportedConfigurationPtr == nil {
+			return nil, fmt.Errorf("%w: no hardware model in the restore image %s is supported by this host",
+				ErrRestoreImageIncompatible, image.BuildVersion())
+		}
+		requirements := image.MostFeaturefulSupportedConfiguration()
+		minCPUs = requirements.MinimumSupportedCPUCount()
+		minBytes = requirements.MinimumSupportedMemorySize()
+		platform, err = provisionMacOSBundle(layout, requirements.HardwareModel(), opts.DiskSize)
+		if err != nil {
+			return nil, err
+		}
+		// Without the cleanup, a retry would load the half-provisioned bundle.
+		defer func() {
+			if err != nil {
+				removeFiles(macOSBundleFiles(layout)...)
+			}
+		}()
+	} else {
+		platform, err = loadMacOSBundle(layout)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cpuCount, memorySize := macOSVMResources(&opts, RecommendedCPUCount(), RecommendedMemorySize(0.5), minCPUs, minBytes)
+	config, err := newMacOSVirtualMachineConfiguration(layout, platform, cpuCount, memorySize, &opts)
+	if err != nil {
+		return nil, err
+	}
+	if image != nil {
+		if err := image.ValidateAgainst(config); err != nil {
+			return nil, err
+		}
+	}
+	vm, err := NewVirtualMachine(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if provision && opts.Install {
+		if err := installMacOS(ctx, vm, restoreImage, opts.InstallProgress); err != nil {
+			return nil, fmt.Errorf("failed to install macOS: %w", err)
+		}
+	}
+	return vm, nil
+}
+
+// macOSBundleFiles returns the paths to the files created by provisionMacOSBundle.
+func macOSBundleFiles(layout BundleLayout) []string {
+	return []string{
+		layout.AuxiliaryStoragePath(),
+		layout.DiskImagePath(),
+		layout.MachineIdentifierPath(),
+		layout.HardwareModelPath(),
+	}
+}
+
+func removeFiles(paths ...string) {
+	for _, path := range paths {
+		os.Remove(path)
+	}
+}
+
+// provisionMacOSBundle creates the files of a new bundle for the hardware model.
+// The files it created are removed if an error is returned.
+func provisionMacOSBundle(layout BundleLayout, hardwareModel *MacHardwareModel, diskSize int64) (_ *MacPlatformConfiguration, err error) {
+	if !hardwareModel.Supported() {
+		return nil, fmt.Errorf("%w: the hardware model is not supported by this host", ErrRestoreImageIncompatible)
+	}
+	machineIdentifier, err := NewMacMachineIdentifier()
+	if err != nil {
+		return nil, err
+	}
+
+	// Each file is registered for the removal before it is created, so a file which is left
+	// partially created by a failed step is removed too. Files which existed before are kept.
+	var created []string
+	defer func() {
+		if err != nil {
+			removeFiles(created...)
+		}
+	}()
+	willCreate := func(path string) {
+		if _, err := os.Lstat(path); errors.Is(err, os.ErrNotExist) {
+			created = append(created, path)
+		}
+	}
+
+	willCreate(layout.AuxiliaryStoragePath())
+	auxiliaryStorage, err := NewMacAuxiliaryStorage(
+		layout.AuxiliaryStoragePath(),
+		WithCreatingMacAuxiliaryStorage(hardwareModel),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create auxiliary storage: %w", err)
+	}
+	if diskSize == 0 {
+		diskSize = defaultMacOSDiskSize
+	}
+	willCreate(layout.DiskImagePath())
+	if err := CreateDiskImage(layout.DiskImagePath(), diskSize); err != nil {
+		return nil, fmt.Errorf("failed to create disk image: %w", err)
+	}
+	willCreate(layout.MachineIdentifierPath())
+	if err := os.WriteFile(layout.MachineIdentifierPath(), machineIdentifier.DataRepresentation(), 0644); err != nil {
+		return nil, err
+	}
+	// The hardware model is written last, because it marks the bundle as provisioned.
+	willCreate(layout.HardwareModelPath())
+	if err := os.WriteFile(layout.HardwareModelPath(), hardwareModel.DataRepresentation(), 0644); err != nil {
+		return nil, err
+	}
+	return NewMacPlatformConfiguration(
+		WithMacAuxiliaryStorage(auxiliaryStorage),
+		WithMacHardwareModel(hardwareModel),
+		WithMacMachineIdentifier(machineIdentifier),
+	)
+}
+
+// loadMacOSBundle loads the files of a provisioned bundle.
+func loadMacOSBundle(layout BundleLayout) (*MacPlatformConfiguration, error) {
+	hardwareModel, err := NewMacHardwareModelWithDataPath(layout.HardwareModelPath())
+	if err != nil {
+		return nil, err
+	}
+	if !hardwareModel.Supported() {
+		return nil, fmt.Errorf("the hardware model of %s is not supported by this host", layout.Dir)
+	}
+	machineIdentifier, err := NewMacMachineIdentifierWithDataPath(layout.MachineIdentifierPath())
+	if err != nil {
+		return nil, err
+	}
+	auxiliaryStorage, err := NewMacAuxiliaryStorage(layout.AuxiliaryStoragePath())
+	if err != nil {
+		return nil, err
+	}
+	return NewMacPlatformConfiguration(
+		WithMacAuxiliaryStorage(auxiliaryStorage),
+		WithMacHardwareModel(hardwareModel),
+		WithMacMachineIdentifier(machineIdentifier),
+	)
+}
+
+func newMacOSVirtualMachineConfiguration(layout BundleLayout, platform *MacPlatformConfiguration, cpuCount uint, memorySize uint64, opts *MacOSVMOptions) (*VirtualMachineConfiguration, error) {
+	bootLoader, err := NewMacOSBootLoader()
+	if err != nil {
+		return nil, err
+	}
+	config, err := NewVirtualMachineConfiguration(bootLoader, cpuCount, memorySize)
+	if err != nil {
+		return nil, err
+	}
+	config.SetPlatformVirtualMachineConfiguration(platform)
+
+	width, height, ppi := opts.DisplayWidth, opts.DisplayHeight, opts.DisplayPixelsPerInch
+	if width == 0 || height == 0 || ppi == 0 {
+		width, height, ppi = 1920, 1200, 80
+	}
+	graphicsDevice, err := NewMacGraphicsDeviceConfiguration()
+	if err != nil {
+		return nil, err
+	}
+	display, err := NewMacGraphicsDisplayConfiguration(width, height, ppi)
+	if err != nil {
+		return nil, err
+	}
+	graphicsDevice.SetDisplays(display)
+	config.SetGraphicsDevicesVirtualMachineConfiguration([]GraphicsDeviceConfiguration{graphicsDevice})
+
+	attachment, err := NewDiskImageStorageDeviceAttachment(layout.DiskImagePath(), false)
+	if err != nil {
+		return nil, err
+	}
+	blockDevice, err := NewVirtioBlockDeviceConfiguration(attachment)
+	if err != nil {
+		return nil, err
+	}
+	config.SetStorageDevicesVirtualMachineConfiguration([]StorageDeviceConfiguration{blockDevice})
+
+	if opts.NAT {
+		natAttachment, err := NewNATNetworkDeviceAttachment()
+		if err != nil {
+			return nil, err
+		}
+		networkDevice, err := NewVirtioNetworkDeviceConfiguration(natAttachment)
+		if err != nil {
+			return nil, err
+		}
+		macAddress, err := NewRandomLocallyAdministeredMACAddress()
+		if err != nil {
+			return nil, err
+		}
+		networkDevice.SetMACAddress(macAddress)
+		config.SetNetworkDevicesVirtualMachineConfiguration([]*VirtioNetworkDeviceConfiguration{networkDevice})
+	}
+
+	pointingDevice, err := NewUSBScreenCoordinatePointingDeviceConfiguration()
+	if err != nil {
+		return nil, err
+	}
+	pointingDevices := []PointingDeviceConfiguration{pointingDevice}
+	// The trackpad is supported on macOS 13 and newer.
+	if trackpad, err := NewMacTrackpadConfiguration(); err == nil {
+		pointingDevices = append(pointingDevices, trackpad)
+	}
+	config.SetPointingDevicesVirtualMachineConfiguration(pointingDevices)
+
+	// The Mac keyboard is supported on macOS 14 and newer.
+	var keyboard KeyboardConfiguration
+	if macKeyboard, err := NewMacKeyboardConfiguration(); err == nil {
+		keyboard = macKeyboard
+	} else {
+		usbKeyboard, err := NewUSBKeyboardConfiguration()
+		if err != nil {
+			return nil, err
+		}
+		keyboard = usbKeyboard
+	}
+	config.SetKeyboardsVirtualMachineConfiguration([]KeyboardConfiguration{keyboard})
+
+	validated, err := config.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if !validated {
+		return nil, errors.New("invalid virtual machine configuration")
+	}
+	return config, nil
+}
+
+// installMacOS installs macOS from the restore image, reporting the progress to progress if not nil.
+func installMacOS(ctx context.Context, vm *VirtualMachine, restoreImage string, progress func(float64)) error {
+	installer, err := NewMacOSInstaller(vm, restoreImage)
+	if err != nil {
+		return err
+	}
+	if progress != nil {
+		go func() {
+			ticker := time.NewTicker(500 * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-installer.Done():
+					progress(installer.FractionCompleted())
+					return
+				case <-ticker.C:
+					progress(installer.FractionCompleted())
+				}
+			}
+		}()
+	}
+	return installer.Install(ctx)
+}
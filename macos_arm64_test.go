@@ -0,0 +1,89 @@
+//go:build darwin && arm64
+// +build darwin,arm64
+
+package vz_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/Code-Hex/vz/v3"
+)
+
+func TestMacOSVMResources(t *testing.T) {
+	const gib = 1024 * 1024 * 1024
+	cases := []struct {
+		name       string
+		opts       vz.MacOSVMOptions
+		minCPUs    uint64
+		minMemory  uint64
+		wantCPUs   uint
+		wantMemory uint64
+	}{
+		{name: "recommended", wantCPUs: 7, wantMemory: 8 * gib},
+		{name: "recommended raised to minimums", minCPUs: 8, minMemory: 16 * gib, wantCPUs: 8, wantMemory: 16 * gib},
+		{name: "recommended above minimums", minCPUs: 2, minMemory: 4 * gib, wantCPUs: 7, wantMemory: 8 * gib},
+		{
+			name: "explicit values are kept",
+			opts: vz.MacOSVMOptions{CPUCount: 2, MemorySize: 4 * gib}, minCPUs: 4, minMemory: 8 * gib,
+			// ValidateAgainst reports the explicit values which are below the minimums.
+			wantCPUs: 2, wantMemory: 4 * gib,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cpus, memory := vz.MacOSVMResources(&tc.opts, 7, 8*gib, tc.minCPUs, tc.minMemory)
+			if cpus != tc.wantCPUs || memory != tc.wantMemory {
+				t.Fatalf("want %d CPUs and %d bytes but got %d CPUs and %d bytes", tc.wantCPUs, tc.wantMemory, cpus, memory)
+			}
+		})
+	}
+}
+
+func TestNewMacOSVirtualMachineRequiresRestoreImage(t *testing.T) {
+	if vz.Available(12) {
+		t.Skip("NewMacOSVirtualMachine is supported from macOS 12")
+	}
+	if _, err := vz.NewMacOSVirtualMachine(context.Background(), t.TempDir(), "", vz.MacOSVMOptions{}); err == nil {
+		t.Fatal("want error for provisioning without a restore image")
+	}
+}
+
+// TestNewMacOSVirtualMachine provisions a bundle from the restore image in
+// VZ_TEST_MACOS_RESTORE_IMAGE without installing macOS, then loads it again.
+func TestNewMacOSVirtualMachine(t *testing.T) {
+	if vz.Available(12) {
+		t.Skip("NewMacOSVirtualMachine is supported from macOS 12")
+	}
+	restoreImage := os.Getenv("VZ_TEST_MACOS_RESTORE_IMAGE")
+	if restoreImage == "" {
+		t.Skip("set VZ_TEST_MACOS_RESTORE_IMAGE to the path of an .ipsw file to run this test")
+	}
+
+	bundleDir := t.TempDir()
+	opts := vz.MacOSVMOptions{DiskSize: 1024 * 1024 * 1024, NAT: true}
+	vm, err := vz.NewMacOSVirtualMachine(context.Background(), bundleDir, restoreImage, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !vm.CanStart() {
+		t.Fatal("want the virtual machine to be startable")
+	}
+	layout := vz.NewBundleLayout(bundleDir)
+	for _, path := range []string{
+		layout.AuxiliaryStoragePath(),
+		layout.DiskImagePath(),
+		layout.HardwareModelPath(),
+		layout.MachineIdentifierPath(),
+	} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("want %s in the bundle but got %v", path, err)
+		}
+	}
+
+	// The provisioned bundle is loaded without the restore image.
+	if _, err := vz.NewMacOSVirtualMachine(context.Background(), bundleDir, "", opts); err != nil {
+		t.Fatal(err)
+	}
+}
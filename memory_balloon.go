@@ -7,6 +7,10 @@ package vz
 */
 import "C"
 import (
+	"runtime/cgo"
+	"unsafe"
+
+	infinity "github.com/Code-Hex/go-infinity-channel"
 	"github.com/Code-Hex/vz/v3/internal/objc"
 )
 
@@ -72,23 +76,30 @@ func (*baseMemoryBalloonDevice) memoryBalloonDevice() {}
 //
 // Returns an empty array if no memory balloon device is configured.
 //
-// This is only supported on macOS 11 and newer.
+// This is only supported on macOS 11 and newer, nil will
+// be returned on older versions.
 func (v *VirtualMachine) MemoryBalloonDevices() []MemoryBalloonDevice {
-	nsArray := objc.NewNSArray(
-		C.VZVirtualMachine_memoryBalloonDevices(objc.Ptr(v)),
+	return runtimeDevices(11,
+		func() unsafe.Pointer { return C.VZVirtualMachine_memoryBalloonDevices(objc.Ptr(v)) },
+		func(ptr unsafe.Pointer) MemoryBalloonDevice {
+			// TODO: When Apple adds more memory balloon device types in future macOS versions,
+			// implement type checking here to create the appropriate device wrapper.
+			// Currently, VirtioTraditionalMemoryBalloonDevice is the only type supported.
+			return &VirtioTraditionalMemoryBalloonDevice{
+				pointer: objc.NewPointer(ptr),
+				vm:      v,
+			}
+		},
 	)
-	ptrs := nsArray.ToPointerSlice()
-	devices := make([]MemoryBalloonDevice, len(ptrs))
-	for i, ptr := range ptrs {
-		// TODO: When Apple adds more memory balloon device types in future macOS versions,
-		// implement type checking here to create the appropriate device wrapper.
-		// Currently, VirtioTraditionalMemoryBalloonDevice is the only type supported.
-		devices[i] = &VirtioTraditionalMemoryBalloonDevice{
-			pointer: objc.NewPointer(ptr),
-			vm:      v,
-		}
-	}
-	return devices
+}
+
+// SupportsMemoryBalloon reports whether a memory balloon device was configured on this
+// virtual machine, so the target memory size can be changed at runtime. Check it before
+// using MemoryBalloonDevices to adjust the memory of the guest.
+//
+// This is only supported on macOS 11 and newer.
+func (v *VirtualMachine) SupportsMemoryBalloon() bool {
+	return len(v.MemoryBalloonDevices()) > 0
 }
 
 // VirtioTraditionalMemoryBalloonDevice represents a Virtio traditional memory balloon device.
@@ -138,3 +149,84 @@ func (v *VirtioTraditionalMemoryBalloonDevice) SetTargetVirtualMachineMemorySize
 func (v *VirtioTraditionalMemoryBalloonDevice) GetTargetVirtualMachineMemorySize() uint64 {
 	return uint64(C.VZVirtioTraditionalMemoryBalloonDevice_getTargetVirtualMachineMemorySize(objc.Ptr(v), v.vm.dispatchQueue))
 }
+
+// TargetChangedNotify returns a receive channel which emits the target memory size in bytes
+// each time the target of the memory balloon is changed.
+//
+// The channel is driven by key-value observing on the targetVirtualMachineMemorySize property of
+// the device, so the event is delivered when the framework accepts a new target, not when the balloon
+// has finished inflating or deflating. The Virtualization framework reports neither the progress of
+// the balloon nor guest memory pressure.
+//
+// Every call for the same device returns the same channel, also from the wrappers returned by
+// different calls of (*VirtualMachine).MemoryBalloonDevices. The observer is registered on the first
+// call and removed when the virtual machine is garbage collected.
+//
+// This is only supported on macOS 11 and newer.
+func (v *VirtioTraditionalMemoryBalloonDevice) TargetChangedNotify() <-chan uint64 {
+	return v.vm.memoryBalloonTargetNotify(objc.Ptr(v))
+}
+
+// memoryBalloonTargetObserver observes the target memory size of a memory balloon device
+// for TargetChangedNotify.
+type memoryBalloonTargetObserver struct {
+	notify   *infinity.Channel[uint64]
+	handle   cgo.Handle
+	observer unsafe.Pointer
+}
+
+// memoryBalloonTargetNotify returns the channel of the observer of the memory balloon device,
+// and registers the observer on the first call for the device.
+func (v *VirtualMachine) memoryBalloonTargetNotify(device unsafe.Pointer) <-chan uint64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if o, ok := v.memoryBalloonTargetObservers[device]; ok {
+		return o.notify.Out()
+	}
+	notify := infinity.NewChannel[uint64]()
+	handle := cgo.NewHandle(notify)
+	observer := C.VZVirtioTraditionalMemoryBalloonDevice_addTargetVirtualMachineMemorySizeObserver(
+		device,
+		v.dispatchQueue,
+		C.uintptr_t(handle),
+	)
+	if v.memoryBalloonTargetObservers == nil {
+		v.memoryBalloonTargetObservers = make(map[unsafe.Pointer]*memoryBalloonTargetObserver)
+	}
+	v.memoryBalloonTargetObservers[device] = &memoryBalloonTargetObserver{
+		notify:   notify,
+		handle:   handle,
+		observer: observer,
+	}
+	return notify.Out()
+}
+
+// removeMemoryBalloonTargetObservers removes the observers registered by
+// memoryBalloonTargetNotify and closes their channels. It is called when the virtual
+// machine is garbage collected, before the virtual machine is released.
+func (v *VirtualMachine) removeMemoryBalloonTargetObservers() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for device, o := range v.memoryBalloonTargetObservers {
+		C.VZVirtioTraditionalMemoryBalloonDevice_removeTargetVirtualMachineMemorySizeObserver(
+			device,
+			v.dispatchQueue,
+			o.observer,
+		)
+		o.handle.Delete()
+		o.notify.Close()
+	}
+	v.memoryBalloonTargetObservers = nil
+}
+
+//export changeTargetVirtualMachineMemorySizeOnObserver
+func changeTargetVirtualMachineMemorySizeOnObserver(targetMemorySize C.ulonglong, cgoHandleUintptr C.uintptr_t) {
+	notifyTargetVirtualMachineMemorySize(cgo.Handle(cgoHandleUintptr), uint64(targetMemorySize))
+}
+
+func notifyTargetVirtualMachineMemorySize(handle cgo.Handle, targetMemorySize uint64) {
+	// I expected it will not cause panic.
+	// if caused panic, that's unexpected behavior.
+	ch, _ := handle.Value().(*infinity.Channel[uint64])
+	ch.In() <- targetMemorySize
+}
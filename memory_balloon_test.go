@@ -1,9 +1,12 @@
 package vz_test
 
 import (
+	"runtime"
+	"runtime/cgo"
 	"testing"
 	"time"
 
+	infinity "github.com/Code-Hex/go-infinity-channel"
 	"github.com/Code-Hex/vz/v3"
 )
 
@@ -18,6 +21,38 @@ func TestVirtioTraditionalMemoryBalloonDeviceConfiguration(t *testing.T) {
 	}
 }
 
+func TestRuntimeDevicesWithoutDevices(t *testing.T) {
+	bootLoader, err := vz.NewLinuxBootLoader(
+		"./testdata/Image",
+		vz.WithCommandLine("console=hvc0"),
+	)
+	if err != nil {
+		t.Fatalf("failed to create boot loader: %v", err)
+	}
+	config, err := vz.NewVirtualMachineConfiguration(bootLoader, 1, 256*1024*1024)
+	if err != nil {
+		t.Fatalf("failed to create virtual machine configuration: %v", err)
+	}
+	vm, err := vz.NewVirtualMachine(config)
+	if err != nil {
+		t.Fatalf("failed to create virtual machine: %v", err)
+	}
+
+	// An accessor returns an empty, non-nil slice when the kind of device is supported but not configured.
+	if got := vm.SocketDevices(); got == nil || len(got) != 0 {
+		t.Errorf("SocketDevices: want an empty slice but got %#v", got)
+	}
+	if got := vm.MemoryBalloonDevices(); got == nil || len(got) != 0 {
+		t.Errorf("MemoryBalloonDevices: want an empty slice but got %#v", got)
+	}
+	if got := vm.DirectorySharingDevices(); vz.Available(12) != (got == nil) || len(got) != 0 {
+		t.Errorf("DirectorySharingDevices: want nil only on unsupported versions but got %#v", got)
+	}
+	if got := vm.USBControllers(); vz.Available(15) != (got == nil) || len(got) != 0 {
+		t.Errorf("USBControllers: want nil only on unsupported versions but got %#v", got)
+	}
+}
+
 func TestMemoryBalloonDevices(t *testing.T) {
 	// Create a simple VM configuration
 	bootLoader, err := vz.NewLinuxBootLoader(
@@ -162,9 +197,31 @@ func TestMemoryBalloonTargetSizeAdjustment(t *testing.T) {
 		t.Fatalf("expected starting memory size to be %d, got %d", startingMemory, currentMemoryBefore)
 	}
 
+	targetChanged := balloonDevice.TargetChangedNotify()
+
+	// The observer is owned by the virtual machine: a garbage collection does not close
+	// the channel, and another wrapper of the device shares it.
+	runtime.GC()
+	again, ok := vm.MemoryBalloonDevices()[0].(*vz.VirtioTraditionalMemoryBalloonDevice)
+	if !ok {
+		t.Fatal("failed to cast to VirtioTraditionalMemoryBalloonDevice")
+	}
+	if again.TargetChangedNotify() != targetChanged {
+		t.Fatal("want the same channel for the same device")
+	}
+
 	// Set a new target memory size
 	balloonDevice.SetTargetVirtualMachineMemorySize(targetMemory)
 
+	select {
+	case got := <-targetChanged:
+		if got != targetMemory {
+			t.Fatalf("expected notified target memory size to be %d, got %d", targetMemory, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for target memory size notification")
+	}
+
 	// Verify the new memory size was set
 	currentMemoryAfter := balloonDevice.GetTargetVirtualMachineMemorySize()
 
@@ -172,3 +229,56 @@ func TestMemoryBalloonTargetSizeAdjustment(t *testing.T) {
 		t.Fatalf("expected memory size after adjustment to be %d, got %d", targetMemory, currentMemoryAfter)
 	}
 }
+
+func TestNotifyTargetVirtualMachineMemorySize(t *testing.T) {
+	ch := infinity.NewChannel[uint64]()
+	handle := cgo.NewHandle(ch)
+	defer handle.Delete()
+
+	want := []uint64{
+		300 * 1024 * 1024,
+		512 * 1024 * 1024,
+	}
+	for _, size := range want {
+		vz.NotifyTargetVirtualMachineMemorySize(handle, size)
+	}
+	for _, size := range want {
+		select {
+		case got := <-ch.Out():
+			if got != size {
+				t.Fatalf("want %d but got %d", size, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for target memory size notification")
+		}
+	}
+}
+
+func TestSupportsMemoryBalloon(t *testing.T) {
+	for _, withBalloon := range []bool{false, true} {
+		bootLoader, err := vz.NewLinuxBootLoader("./testdata/Image")
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, err := vz.NewVirtualMachineConfiguration(bootLoader, 1, 256*1024*1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if withBalloon {
+			balloonConfig, err := vz.NewVirtioTraditionalMemoryBalloonDeviceConfiguration()
+			if err != nil {
+				t.Fatal(err)
+			}
+			config.SetMemoryBalloonDevicesVirtualMachineConfiguration([]vz.MemoryBalloonDeviceConfiguration{
+				balloonConfig,
+			})
+		}
+		vm, err := vz.NewVirtualMachine(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := vm.SupportsMemoryBalloon(); got != withBalloon {
+			t.Errorf("balloon configured=%v: want %v but got %v", withBalloon, withBalloon, got)
+		}
+	}
+}
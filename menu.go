@@ -0,0 +1,223 @@
+package vz
+
+/*
+#cgo darwin CFLAGS: -mmacosx-version-min=11 -x objective-c -fno-objc-arc
+#cgo darwin LDFLAGS: -lobjc -framework Foundation -framework Cocoa
+# include "virtualization_default_app.h"
+*/
+import "C"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// MenuKeyModifier is a modifier key of the key equivalent of a menu item.
+type MenuKeyModifier uint
+
+const (
+	// MenuKeyModifierCommand is the Command key.
+	MenuKeyModifierCommand MenuKeyModifier = 1 << iota
+
+	// MenuKeyModifierShift is the Shift key.
+	MenuKeyModifierShift
+
+	// MenuKeyModifierOption is the Option key.
+	MenuKeyModifierOption
+
+	// MenuKeyModifierControl is the Control key.
+	MenuKeyModifierControl
+)
+
+// Menu is a menu of the menu bar of the application.
+type Menu struct {
+	// Title is shown in the menu bar.
+	Title string
+
+	// Items are the items of the menu.
+	Items []MenuItem
+}
+
+// MenuItem is an item of Menu.
+type MenuItem struct {
+	// Title of the menu item. This is ignored for separators.
+	Title string
+
+	// KeyEquivalent is the character of the keyboard shortcut (e.g. "n"). Empty means no shortcut.
+	KeyEquivalent string
+
+	// Modifiers are the modifier keys of the keyboard shortcut.
+	// MenuKeyModifierCommand is used if KeyEquivalent is set and Modifiers is zero.
+	Modifiers MenuKeyModifier
+
+	// Action is called on the main thread when the menu item is selected.
+	// It must not block because the event loop of the application waits for it.
+	Action func()
+
+	// Submenu are the items of the submenu of the menu item.
+	Submenu []MenuItem
+
+	// Separator makes this item a separator line. The other fields are ignored.
+	Separator bool
+}
+
+// MenuSeparator returns a separator menu item.
+func MenuSeparator() MenuItem {
+	return MenuItem{Separator: true}
+}
+
+// menuSpec is the JSON representation of Menu which is passed to the Objective-C side.
+type menuSpec struct {
+	Title string         `json:"title"`
+	Items []menuItemSpec `json:"items"`
+}
+
+type menuItemSpec struct {
+	Title         string          `json:"title,omitempty"`
+	KeyEquivalent string          `json:"keyEquivalent,omitempty"`
+	Modifiers     MenuKeyModifier `json:"modifiers,omitempty"`
+	// Action is the tag of the menu item to look up the Go callback. Zero means no action.
+	Action    int            `json:"action,omitempty"`
+	Submenu   []menuItemSpec `json:"submenu,omitempty"`
+	Separator bool           `json:"separator,omitempty"`
+}
+
+// marshalMenus converts menus to JSON. Actions are numbered from 1 in order.
+func marshalMenus(menus []Menu) ([]byte, []func(), error) {
+	var actions []func()
+	var convert func(path string, items []MenuItem) ([]menuItemSpec, error)
+	convert = func(path string, items []MenuItem) ([]menuItemSpec, error) {
+		specs := make([]menuItemSpec, 0, len(items))
+		for _, item := range items {
+			if item.Separator {
+				specs = append(specs, menuItemSpec{Separator: true})
+				continue
+			}
+			itemPath := path + " > " + item.Title
+			if item.Title == "" {
+				return nil, fmt.Errorf("menu item in %q: title must not be empty", path)
+			}
+			if utf8.RuneCountInString(item.KeyEquivalent) > 1 {
+				return nil, fmt.Errorf("menu item %q: key equivalent must be a single character: %q", itemPath, item.KeyEquivalent)
+			}
+			if item.Action != nil && len(item.Submenu) > 0 {
+				return nil, fmt.Errorf("menu item %q: both action and submenu are set", itemPath)
+			}
+			spec := menuItemSpec{
+				Title:         item.Title,
+				KeyEquivalent: item.KeyEquivalent,
+				Modifiers:     item.Modifiers,
+			}
+			if spec.KeyEquivalent != "" && spec.Modifiers == 0 {
+				spec.Modifiers = MenuKeyModifierCommand
+			}
+			if item.Action != nil {
+				actions = append(actions, item.Action)
+				spec.Action = len(actions)
+			}
+			if len(item.Submenu) > 0 {
+				submenu, err := convert(itemPath, item.Submenu)
+				if err != nil {
+					return nil, err
+				}
+				spec.Submenu = submenu
+			}
+			specs = append(specs, spec)
+		}
+		return specs, nil
+	}
+
+	specs := make([]menuSpec, 0, len(menus))
+	for _, menu := range menus {
+		if menu.Title == "" {
+			return nil, nil, errors.New("menu title must not be empty")
+		}
+		items, err := convert(menu.Title, menu.Items)
+		if err != nil {
+			return nil, nil, err
+		}
+		specs = append(specs, menuSpec{Title: menu.Title, Items: items})
+	}
+	b, err := json.Marshal(specs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, actions, nil
+}
+
+// menuActions holds the actions of the menus set by SetApplicationMenu.
+var menuActions struct {
+	mu      sync.RWMutex
+	actions []func()
+}
+
+//export menuItemActionHandler
+func menuItemActionHandler(action C.int) {
+	menuActions.mu.RLock()
+	var f func()
+	if i := int(action) - 1; i >= 0 && i < len(menuActions.actions) {
+		f = menuActions.actions[i]
+	}
+	menuActions.mu.RUnlock()
+	if f != nil {
+		f()
+	}
+}
+
+// SetApplicationMenu sets the menus of the application. The menus are placed in the menu bar
+// between the application menu and the "Window" menu. Calling this again replaces the menus
+// which were set before.
+//
+// The menus can be set before RunApplication is called. They are shown once the application
+// finished launching.
+//
+// This is only supported on macOS 12 and newer, error will be returned on older versions.
+func SetApplicationMenu(menus ...Menu) error {
+	if err := macOSAvailable(12); err != nil {
+		return err
+	}
+	spec, actions, err := marshalMenus(menus)
+	if err != nil {
+		return err
+	}
+	menuActions.mu.Lock()
+	menuActions.actions = actions
+	menuActions.mu.Unlock()
+
+	cs := charWithGoString(string(spec))
+	defer cs.Free()
+	C.setApplicationMenus(cs.CString())
+	return nil
+}
+
+// SetDockIcon sets the icon of the application in the Dock. image is the content of an image
+// file in a format which NSImage supports (e.g. PNG or ICNS).
+//
+// This is only supported on macOS 12 and newer, error will be returned on older versions.
+func SetDockIcon(image []byte) error {
+	if err := macOSAvailable(12); err != nil {
+		return err
+	}
+	if len(image) == 0 {
+		return errors.New("image must not be empty")
+	}
+	if !bool(C.setDockIcon(unsafe.Pointer(&image[0]), C.int(len(image)))) {
+		return errors.New("failed to load the dock icon image")
+	}
+	return nil
+}
+
+// RunApplicationWithMenu sets the menus with SetApplicationMenu and runs RunApplication.
+//
+// You must call runtime.LockOSThread before calling this method.
+//
+// This is only supported on macOS 12 and newer, error will be returned on older versions.
+func RunApplicationWithMenu(menus ...Menu) error {
+	if err := SetApplicationMenu(menus...); err != nil {
+		return err
+	}
+	return RunApplication()
+}
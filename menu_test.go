@@ -0,0 +1,93 @@
+package vz_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/Code-Hex/vz/v3"
+)
+
+func TestMarshalMenus(t *testing.T) {
+	var called []string
+	menus := []vz.Menu{
+		{
+			Title: "File",
+			Items: []vz.MenuItem{
+				{
+					Title:         "New VM…",
+					KeyEquivalent: "n",
+					Modifiers:     vz.MenuKeyModifierCommand | vz.MenuKeyModifierShift,
+					Action:        func() { called = append(called, "new") },
+				},
+				{
+					Title:         "Start VM…",
+					KeyEquivalent: "o",
+					Action:        func() { called = append(called, "start") },
+				},
+				vz.MenuSeparator(),
+				{
+					Title: "Recent",
+					Submenu: []vz.MenuItem{
+						{Title: "default", Action: func() { called = append(called, "default") }},
+					},
+				},
+			},
+		},
+	}
+	b, actions, err := vz.MarshalMenus(menus)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got any
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	var want any
+	if err := json.Unmarshal([]byte(`[{
+		"title": "File",
+		"items": [
+			{"title": "New VM…", "keyEquivalent": "n", "modifiers": 3, "action": 1},
+			{"title": "Start VM…", "keyEquivalent": "o", "modifiers": 1, "action": 2},
+			{"separator": true},
+			{"title": "Recent", "submenu": [{"title": "default", "action": 3}]}
+		]
+	}]`), &want); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("want %s but got %s", want, b)
+	}
+
+	// Action numbers are 1-origin indexes of actions.
+	for _, f := range actions {
+		f()
+	}
+	if want := []string{"new", "start", "default"}; !reflect.DeepEqual(want, called) {
+		t.Fatalf("want actions %v but got %v", want, called)
+	}
+}
+
+func TestMarshalMenusInvalid(t *testing.T) {
+	cases := map[string][]vz.Menu{
+		"empty menu title": {{Title: ""}},
+		"empty item title": {{Title: "File", Items: []vz.MenuItem{{}}}},
+		"long key equivalent": {{Title: "File", Items: []vz.MenuItem{
+			{Title: "Open", KeyEquivalent: "op"},
+		}}},
+		"action and submenu": {{Title: "File", Items: []vz.MenuItem{
+			{Title: "Open", Action: func() {}, Submenu: []vz.MenuItem{{Title: "Recent"}}},
+		}}},
+		"invalid submenu item": {{Title: "File", Items: []vz.MenuItem{
+			{Title: "Open", Submenu: []vz.MenuItem{{KeyEquivalent: "o"}}},
+		}}},
+	}
+	for name, menus := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := vz.MarshalMenus(menus); err == nil {
+				t.Fatal("want error")
+			}
+		})
+	}
+}
@@ -8,6 +8,8 @@ package vz
 */
 import "C"
 import (
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"net"
 	"os"
@@ -198,7 +200,7 @@ func NewFileHandleNetworkDeviceAttachment(file *os.File) (*FileHandleNetworkDevi
 				C.int(file.Fd()),
 			),
 		),
-		mtu: 1500, // The default MTU is 1500.
+		mtu: DefaultMaximumTransmissionUnit,
 	}
 	objc.SetFinalizer(attachment, func(self *FileHandleNetworkDeviceAttachment) {
 		objc.Release(self)
@@ -316,10 +318,77 @@ func (v *VirtioNetworkDeviceConfiguration) SetMACAddress(macAddress *MACAddress)
 	C.setNetworkDevicesVZMACAddress(objc.Ptr(v), objc.Ptr(macAddress))
 }
 
+// MACAddress returns the MAC address of the network device. It is a random, locally administered
+// address unless one was set with SetMACAddress.
+func (v *VirtioNetworkDeviceConfiguration) MACAddress() *MACAddress {
+	ma := &MACAddress{
+		pointer: objc.NewPointer(
+			C.getNetworkDevicesVZMACAddress(objc.Ptr(v)),
+		),
+	}
+	objc.SetFinalizer(ma, func(self *MACAddress) {
+		objc.Release(self)
+	})
+	return ma
+}
+
 func (v *VirtioNetworkDeviceConfiguration) Attachment() NetworkDeviceAttachment {
 	return v.attachment
 }
 
+// Limits of the maximum transmission unit (MTU) of a network device, and the MTU of a network
+// device whose attachment does not support changing it.
+const (
+	MinMaximumTransmissionUnit     = 1500
+	MaxMaximumTransmissionUnit     = 65535
+	DefaultMaximumTransmissionUnit = 1500
+)
+
+// mtuAttachment is a network device attachment whose MTU can be changed, such as
+// FileHandleNetworkDeviceAttachment and the attachments which embed it.
+type mtuAttachment interface {
+	SetMaximumTransmissionUnit(mtu int) error
+	MaximumTransmissionUnit() int
+}
+
+// ErrMaximumTransmissionUnitUnsupported is returned by (*VirtioNetworkDeviceConfiguration).SetMaximumTransmissionUnit
+// when the attachment of the network device does not support changing the MTU. It also matches errors.ErrUnsupported.
+var ErrMaximumTransmissionUnitUnsupported = fmt.Errorf("the MTU of the network device attachment is fixed: %w", errors.ErrUnsupported)
+
+// SetMaximumTransmissionUnit sets the maximum transmission unit (MTU) of the network device, e.g. to
+// use jumbo frames. mtu must be between MinMaximumTransmissionUnit and MaxMaximumTransmissionUnit.
+//
+// The Virtualization framework only supports changing the MTU of a FileHandleNetworkDeviceAttachment
+// (including a PcapRecordingNetworkDeviceAttachment, which wraps one), see
+// (*FileHandleNetworkDeviceAttachment).SetMaximumTransmissionUnit. ErrMaximumTransmissionUnitUnsupported
+// is returned for the other attachments (e.g. NATNetworkDeviceAttachment), whose MTU is always
+// DefaultMaximumTransmissionUnit.
+//
+// This is only supported on macOS 13 and newer, error will
+// be returned on older versions.
+func (v *VirtioNetworkDeviceConfiguration) SetMaximumTransmissionUnit(mtu int) error {
+	if err := macOSAvailable(13); err != nil {
+		return err
+	}
+	if mtu < MinMaximumTransmissionUnit || mtu > MaxMaximumTransmissionUnit {
+		return fmt.Errorf("invalid MTU %d: must be between %d and %d", mtu, MinMaximumTransmissionUnit, MaxMaximumTransmissionUnit)
+	}
+	attachment, ok := v.attachment.(mtuAttachment)
+	if !ok {
+		return fmt.Errorf("%w: %T", ErrMaximumTransmissionUnitUnsupported, v.attachment)
+	}
+	return attachment.SetMaximumTransmissionUnit(mtu)
+}
+
+// MaximumTransmissionUnit returns the maximum transmission unit (MTU) of the network device.
+// It is DefaultMaximumTransmissionUnit unless the MTU of the attachment was changed.
+func (v *VirtioNetworkDeviceConfiguration) MaximumTransmissionUnit() int {
+	if attachment, ok := v.attachment.(mtuAttachment); ok {
+		return attachment.MaximumTransmissionUnit()
+	}
+	return DefaultMaximumTransmissionUnit
+}
+
 // MACAddress represents a media access control address (MAC address), the 48-bit ethernet address.
 // see: https://developer.apple.com/documentation/virtualization/vzmacaddress?language=objc
 type MACAddress struct {
@@ -368,6 +437,24 @@ func NewRandomLocallyAdministeredMACAddress() (*MACAddress, error) {
 	return ma, nil
 }
 
+// DeterministicMACAddress creates a unicast, locally administered address which is derived from seed
+// (e.g. the name of the virtual machine). The same seed always yields the same address, so a virtual
+// machine which is recreated keeps its address (and the DHCP lease bound to it).
+//
+// This is only supported on macOS 11 and newer, error will
+// be returned on older versions.
+func DeterministicMACAddress(seed string) (*MACAddress, error) {
+	return NewMACAddress(deterministicHardwareAddr(seed))
+}
+
+func deterministicHardwareAddr(seed string) net.HardwareAddr {
+	sum := sha256.Sum256([]byte(seed))
+	hw := net.HardwareAddr(sum[:6])
+	// Set the locally administered bit and clear the multicast bit.
+	hw[0] = (hw[0] | 0x02) &^ 0x01
+	return hw
+}
+
 func (m *MACAddress) String() string {
 	cstring := (*char)(C.getVZMACAddressString(objc.Ptr(m)))
 	return cstring.String()
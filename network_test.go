@@ -1,7 +1,9 @@
 package vz_test
 
 import (
+	"errors"
 	"net"
+	"path/filepath"
 	"testing"
 
 	"github.com/Code-Hex/vz/v3"
@@ -45,3 +47,135 @@ func TestFileHandleNetworkDeviceAttachmentMTU(t *testing.T) {
 		t.Fatalf("want mtu %d but got %d", want, got)
 	}
 }
+
+func TestVirtioNetworkDeviceConfigurationMTU(t *testing.T) {
+	if vz.Available(13) {
+		t.Skip("VirtioNetworkDeviceConfiguration.SetMaximumTransmissionUnit is supported from macOS 13")
+	}
+
+	ln, err := net.ListenUDP("udp", &net.UDPAddr{
+		Port: 0,
+		IP:   net.ParseIP("127.0.0.1"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	f, err := ln.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileHandleAttachment, err := vz.NewFileHandleNetworkDeviceAttachment(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := vz.NewVirtioNetworkDeviceConfiguration(fileHandleAttachment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := config.MaximumTransmissionUnit(); got != vz.DefaultMaximumTransmissionUnit {
+		t.Fatalf("want default mtu %d but got %d", vz.DefaultMaximumTransmissionUnit, got)
+	}
+	for _, mtu := range []int{0, 1499, 65536} {
+		if err := config.SetMaximumTransmissionUnit(mtu); err == nil {
+			t.Errorf("want error for mtu %d", mtu)
+		}
+	}
+	if err := config.SetMaximumTransmissionUnit(9000); err != nil {
+		t.Fatal(err)
+	}
+	if got := config.MaximumTransmissionUnit(); got != 9000 {
+		t.Fatalf("want mtu 9000 but got %d", got)
+	}
+	if got := fileHandleAttachment.MaximumTransmissionUnit(); got != 9000 {
+		t.Fatalf("want attachment mtu 9000 but got %d", got)
+	}
+
+	natAttachment, err := vz.NewNATNetworkDeviceAttachment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	natConfig, err := vz.NewVirtioNetworkDeviceConfiguration(natAttachment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := natConfig.SetMaximumTransmissionUnit(9000); !errors.Is(err, vz.ErrMaximumTransmissionUnitUnsupported) {
+		t.Fatalf("want ErrMaximumTransmissionUnitUnsupported but got %v", err)
+	}
+	if !errors.Is(vz.ErrMaximumTransmissionUnitUnsupported, errors.ErrUnsupported) {
+		t.Error("want ErrMaximumTransmissionUnitUnsupported to match errors.ErrUnsupported")
+	}
+	if got := natConfig.MaximumTransmissionUnit(); got != vz.DefaultMaximumTransmissionUnit {
+		t.Fatalf("want mtu %d but got %d", vz.DefaultMaximumTransmissionUnit, got)
+	}
+}
+
+func TestVirtioNetworkDeviceConfigurationMTUWithPcap(t *testing.T) {
+	if vz.Available(13) {
+		t.Skip("VirtioNetworkDeviceConfiguration.SetMaximumTransmissionUnit is supported from macOS 13")
+	}
+
+	peer, network := datagramSocketPair(t)
+	defer network.Close()
+	attachment, err := vz.NewPcapRecordingNetworkDeviceAttachment(peer, filepath.Join(t.TempDir(), "vm.pcap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer attachment.Close()
+	config, err := vz.NewVirtioNetworkDeviceConfiguration(attachment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.SetMaximumTransmissionUnit(9000); err != nil {
+		t.Fatal(err)
+	}
+	if got := config.MaximumTransmissionUnit(); got != 9000 {
+		t.Fatalf("want mtu 9000 but got %d", got)
+	}
+	if got := attachment.MaximumTransmissionUnit(); got != 9000 {
+		t.Fatalf("want attachment mtu 9000 but got %d", got)
+	}
+}
+
+func TestDeterministicMACAddress(t *testing.T) {
+	seeds := []string{"", "ubuntu", "debian", "a very long name of the virtual machine"}
+	seen := map[string]string{}
+	for _, seed := range seeds {
+		hw := vz.DeterministicHardwareAddr(seed)
+		if len(hw) != 6 {
+			t.Fatalf("seed %q: want 6 bytes but got %d", seed, len(hw))
+		}
+		if hw[0]&0x02 == 0 {
+			t.Errorf("seed %q: %s is not locally administered", seed, hw)
+		}
+		if hw[0]&0x01 != 0 {
+			t.Errorf("seed %q: %s is not unicast", seed, hw)
+		}
+		if again := vz.DeterministicHardwareAddr(seed); again.String() != hw.String() {
+			t.Errorf("seed %q: want %s but got %s", seed, hw, again)
+		}
+		if other, ok := seen[hw.String()]; ok {
+			t.Errorf("seeds %q and %q yield the same address %s", other, seed, hw)
+		}
+		seen[hw.String()] = seed
+	}
+
+	if vz.Available(11) {
+		t.Skip("MACAddress is supported from macOS 11")
+	}
+	mac1, err := vz.DeterministicMACAddress("ubuntu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac2, err := vz.DeterministicMACAddress("ubuntu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mac1.String() != mac2.String() {
+		t.Fatalf("want the same address but got %s and %s", mac1, mac2)
+	}
+	if want := vz.DeterministicHardwareAddr("ubuntu").String(); mac1.HardwareAddr().String() != want {
+		t.Fatalf("want %s but got %s", want, mac1.HardwareAddr())
+	}
+}
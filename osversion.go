@@ -13,8 +13,6 @@ import (
 	"strings"
 	"sync"
 	"syscall"
-
-	"golang.org/x/mod/semver"
 )
 
 var (
@@ -44,13 +42,11 @@ var (
 )
 
 func fetchMajorMinorVersion() (float64, error) {
-	osver, err := sysctl("kern.osproductversion")
+	osver, err := fetchMacOSVersion()
 	if err != nil {
 		return 0, err
 	}
-	prefix := "v"
-	majorMinor := strings.TrimPrefix(semver.MajorMinor(prefix+osver), prefix)
-	version, err := strconv.ParseFloat(majorMinor, 64)
+	version, err := strconv.ParseFloat(fmt.Sprintf("%d.%d", osver[0], osver[1]), 64)
 	if err != nil {
 		return 0, err
 	}
@@ -68,6 +64,46 @@ func macOSMajorMinorVersion() float64 {
 	return majorMinorVersion
 }
 
+var (
+	macOSVersion     [3]int
+	macOSVersionOnce interface{ Do(func()) } = &sync.Once{}
+)
+
+// MacOSVersion returns the version of macOS which is running, e.g. (14, 5, 0) on macOS 14.5.
+//
+// The availability of APIs in this package is checked against the major and minor parts of
+// this version.
+func MacOSVersion() (major, minor, patch int) {
+	macOSVersionOnce.Do(func() {
+		version, err := fetchMacOSVersion()
+		if err != nil {
+			panic(err)
+		}
+		macOSVersion = version
+	})
+	return macOSVersion[0], macOSVersion[1], macOSVersion[2]
+}
+
+func fetchMacOSVersion() ([3]int, error) {
+	var version [3]int
+	osver, err := sysctl("kern.osproductversion")
+	if err != nil {
+		return version, err
+	}
+	parts := strings.Split(osver, ".")
+	if len(parts) > len(version) {
+		return version, fmt.Errorf("invalid macOS version %q", osver)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return version, fmt.Errorf("invalid macOS version %q", osver)
+		}
+		version[i] = n
+	}
+	return version, nil
+}
+
 var (
 	maxAllowedVersion     int
 	maxAllowedVersionOnce interface{ Do(func()) } = &sync.Once{}
@@ -84,6 +84,9 @@ func TestAvailableVersionArm64(t *testing.T) {
 			"WithStartUpFromMacOSRecovery": func() error {
 				return (*VirtualMachine)(nil).Start(WithStartUpFromMacOSRecovery(true))
 			},
+			"WithMacOSStartOptions": func() error {
+				return (*VirtualMachine)(nil).Start(WithMacOSStartOptions(MacOSStartOptions{}))
+			},
 			"MacOSGuestAutomountTag": func() error {
 				_, err := MacOSGuestAutomountTag()
 				return err
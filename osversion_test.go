@@ -286,6 +286,9 @@ func TestAvailableVersion(t *testing.T) {
 			"SetMaximumTransmissionUnit": func() error {
 				return (*FileHandleNetworkDeviceAttachment)(nil).SetMaximumTransmissionUnit(0)
 			},
+			"VirtioNetworkDeviceConfiguration.SetMaximumTransmissionUnit": func() error {
+				return (*VirtioNetworkDeviceConfiguration)(nil).SetMaximumTransmissionUnit(9000)
+			},
 		}
 		for name, fn := range cases {
 			t.Run(name, func(t *testing.T) {
@@ -336,6 +339,38 @@ func TestAvailableVersion(t *testing.T) {
 
 }
 
+func TestRuntimeDevicesUnsupportedVersion(t *testing.T) {
+	majorMinorVersionOnce = &nopDoer{}
+	defer func() {
+		majorMinorVersion = 0
+		majorMinorVersionOnce = &sync.Once{}
+	}()
+
+	// The version is checked before the virtual machine is used.
+	v := &VirtualMachine{}
+	t.Run("macOS 10", func(t *testing.T) {
+		majorMinorVersion = 10
+		if got := v.SocketDevices(); got != nil {
+			t.Errorf("SocketDevices: want nil but got %v", got)
+		}
+		if got := v.MemoryBalloonDevices(); got != nil {
+			t.Errorf("MemoryBalloonDevices: want nil but got %v", got)
+		}
+		if got := v.DirectorySharingDevices(); got != nil {
+			t.Errorf("DirectorySharingDevices: want nil but got %v", got)
+		}
+		if got := v.USBControllers(); got != nil {
+			t.Errorf("USBControllers: want nil but got %v", got)
+		}
+	})
+	t.Run("macOS 14", func(t *testing.T) {
+		majorMinorVersion = 14
+		if got := v.USBControllers(); got != nil {
+			t.Errorf("USBControllers: want nil but got %v", got)
+		}
+	})
+}
+
 func Test_fetchMajorMinorVersion(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -394,6 +429,46 @@ func Test_fetchMajorMinorVersion(t *testing.T) {
 	}
 }
 
+func Test_fetchMacOSVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		osver   string
+		want    [3]int
+		wantErr bool
+	}{
+		{name: "valid 14", osver: "14", want: [3]int{14, 0, 0}},
+		{name: "valid 14.5", osver: "14.5", want: [3]int{14, 5, 0}},
+		{name: "valid 12.3.1", osver: "12.3.1", want: [3]int{12, 3, 1}},
+		{name: "invalid unknown", osver: "unknown", wantErr: true},
+		{name: "invalid too many parts", osver: "12.3.1.1", wantErr: true},
+		{name: "invalid empty part", osver: "12..1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sysctl = func(string) (string, error) { return tt.osver, nil }
+			defer func() {
+				sysctl = syscall.Sysctl
+			}()
+
+			version, err := fetchMacOSVersion()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("fetchMacOSVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && version != tt.want {
+				t.Errorf("want version %v but got %v", tt.want, version)
+			}
+		})
+	}
+}
+
+func TestMacOSVersion(t *testing.T) {
+	major, minor, patch := MacOSVersion()
+	t.Logf("running on macOS %d.%d.%d", major, minor, patch)
+	if major < 11 {
+		t.Fatalf("want macOS 11 or newer but got %d.%d.%d", major, minor, patch)
+	}
+}
+
 func Test_macOSBuildTargetAvailable(t *testing.T) {
 	maxAllowedVersionOnce = &nopDoer{}
 	defer func() {
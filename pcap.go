@@ -0,0 +1,244 @@
+package vz
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// pcapSnapLen is the maximum number of bytes of a frame which are recorded.
+	// A frame of the maximum MTU (65535) with an Ethernet header fits in it.
+	pcapSnapLen = 65535 + 18
+
+	// pcapLinkTypeEthernet is LINKTYPE_ETHERNET. The file handle attachment
+	// transmits Ethernet frames.
+	pcapLinkTypeEthernet = 1
+)
+
+// pcapWriter writes frames in the classic libpcap file format with microsecond timestamps.
+type pcapWriter struct {
+	w io.Writer
+}
+
+// newPcapWriter writes the global header of the pcap file to w.
+func newPcapWriter(w io.Writer) (*pcapWriter, error) {
+	var hdr [24]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], 0xa1b2c3d4) // magic number
+	binary.LittleEndian.PutUint16(hdr[4:6], 2)          // major version
+	binary.LittleEndian.PutUint16(hdr[6:8], 4)          // minor version
+	// thiszone and sigfigs are always 0.
+	binary.LittleEndian.PutUint32(hdr[16:20], pcapSnapLen)
+	binary.LittleEndian.PutUint32(hdr[20:24], pcapLinkTypeEthernet)
+	if _, err := w.Write(hdr[:]); err != nil {
+		return nil, fmt.Errorf("failed to write pcap header: %w", err)
+	}
+	return &pcapWriter{w: w}, nil
+}
+
+// writeFrame writes a record of the frame which was captured at ts.
+func (p *pcapWriter) writeFrame(ts time.Time, frame []byte) error {
+	captured := frame
+	if len(captured) > pcapSnapLen {
+		captured = captured[:pcapSnapLen]
+	}
+	var hdr [16]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(hdr[4:8], uint32(ts.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(hdr[8:12], uint32(len(captured)))
+	binary.LittleEndian.PutUint32(hdr[12:16], uint32(len(frame)))
+
+	if _, err := p.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := p.w.Write(captured)
+	return err
+}
+
+// PcapRecordingNetworkDeviceAttachment is a FileHandleNetworkDeviceAttachment which records
+// every frame exchanged between the virtual machine and a datagram socket to a pcap file.
+//
+// The Virtualization framework does not expose the traffic of the NAT and bridged attachments,
+// so only the traffic of a user space network stack connected with a datagram socket
+// (e.g. gvisor-tap-vsock or vmnet-helper) can be recorded.
+//
+// Frames are relayed between the virtual machine and the socket by goroutines, so call Close
+// once the virtual machine has stopped.
+type PcapRecordingNetworkDeviceAttachment struct {
+	*FileHandleNetworkDeviceAttachment
+
+	recorder *pcapRecorder
+}
+
+var _ NetworkDeviceAttachment = (*PcapRecordingNetworkDeviceAttachment)(nil)
+
+func (*PcapRecordingNetworkDeviceAttachment) String() string {
+	return "PcapRecordingNetworkDeviceAttachment"
+}
+
+// NewPcapRecordingNetworkDeviceAttachment creates a file handle attachment which relays the frames
+// of the virtual machine to peer and records them to a pcap file created at pcapPath, which can be
+// opened with e.g. Wireshark or tcpdump -r.
+//
+// peer must be a connected datagram socket, like for NewFileHandleNetworkDeviceAttachment.
+// The attachment takes the ownership of peer and closes it on Close. peer is also closed
+// if an error is returned.
+//
+// This is only supported on macOS 11 and newer, error will
+// be returned on older versions.
+func NewPcapRecordingNetworkDeviceAttachment(peer *os.File, pcapPath string) (*PcapRecordingNetworkDeviceAttachment, error) {
+	if err := macOSAvailable(11); err != nil {
+		peer.Close()
+		return nil, err
+	}
+	if err := validateDatagramSocket(int(peer.Fd())); err != nil {
+		peer.Close()
+		return nil, err
+	}
+	f, err := os.Create(pcapPath)
+	if err != nil {
+		peer.Close()
+		return nil, err
+	}
+	recorder, guest, err := newPcapRecorder(peer, f)
+	if err != nil {
+		// newPcapRecorder closes peer only once it succeeded.
+		peer.Close()
+		f.Close()
+		return nil, err
+	}
+	attachment, err := NewFileHandleNetworkDeviceAttachment(guest)
+	if err != nil {
+		recorder.Close()
+		return nil, err
+	}
+	return &PcapRecordingNetworkDeviceAttachment{
+		FileHandleNetworkDeviceAttachment: attachment,
+		recorder:                          recorder,
+	}, nil
+}
+
+// Close stops relaying and recording the frames, and closes the peer socket and the pcap file.
+// It returns the first error which happened while writing the pcap file.
+func (p *PcapRecordingNetworkDeviceAttachment) Close() error {
+	return p.recorder.Close()
+}
+
+// pcapRecorder relays datagrams between the host end of a socket pair and the peer
+// socket, and writes each of them to a pcap file.
+type pcapRecorder struct {
+	host  *os.File
+	guest *os.File
+	peer  *os.File
+	out   io.WriteCloser
+	pcap  *pcapWriter
+
+	wg        sync.WaitGroup
+	mu        sync.Mutex
+	writeErr  error
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// newPcapRecorder creates a socket pair whose guest end is returned to be attached to the
+// virtual machine, and starts relaying between its host end and peer.
+func newPcapRecorder(peer *os.File, out io.WriteCloser) (*pcapRecorder, *os.File, error) {
+	pcap, err := newPcapWriter(out)
+	if err != nil {
+		return nil, nil, err
+	}
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create a socket pair: %w", err)
+	}
+	syscall.CloseOnExec(fds[0])
+	syscall.CloseOnExec(fds[1])
+	// The framework recommends SO_RCVBUF to be four times SO_SNDBUF.
+	for _, fd := range fds {
+		_ = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, 1<<20)
+		_ = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, 4<<20)
+	}
+	// The relayed sockets are non-blocking so that they use the runtime poller,
+	// which lets Close interrupt pending reads.
+	closeAll := func(fds ...int) {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+	}
+	peerFd, err := syscall.Dup(int(peer.Fd()))
+	if err != nil {
+		closeAll(fds[0], fds[1])
+		return nil, nil, fmt.Errorf("failed to duplicate the peer socket: %w", err)
+	}
+	syscall.CloseOnExec(peerFd)
+	for _, fd := range []int{fds[0], peerFd} {
+		if err := syscall.SetNonblock(fd, true); err != nil {
+			closeAll(fds[0], fds[1], peerFd)
+			return nil, nil, fmt.Errorf("failed to set the socket non-blocking: %w", err)
+		}
+	}
+	peer.Close()
+
+	r := &pcapRecorder{
+		host:  os.NewFile(uintptr(fds[0]), "pcap-recorder"),
+		guest: os.NewFile(uintptr(fds[1]), "pcap-recorder-guest"),
+		peer:  os.NewFile(uintptr(peerFd), "pcap-recorder-peer"),
+		out:   out,
+		pcap:  pcap,
+	}
+	r.wg.Add(2)
+	go r.relay(r.peer, r.host)
+	go r.relay(r.host, r.peer)
+	return r, r.guest, nil
+}
+
+// relay copies each datagram read from src to dst, and records it.
+func (r *pcapRecorder) relay(dst, src *os.File) {
+	defer r.wg.Done()
+	buf := make([]byte, pcapSnapLen)
+	for {
+		n, err := src.Read(buf)
+		if err != nil {
+			return
+		}
+		r.record(buf[:n])
+		if _, err := dst.Write(buf[:n]); errors.Is(err, os.ErrClosed) {
+			return
+		}
+		// Other write errors (e.g. ENOBUFS) drop the frame like a congested link.
+	}
+}
+
+// record writes the frame to the pcap file. Recording stops at the first write error.
+func (r *pcapRecorder) record(frame []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.writeErr != nil {
+		return
+	}
+	if err := r.pcap.writeFrame(time.Now(), frame); err != nil {
+		r.writeErr = fmt.Errorf("failed to write pcap record: %w", err)
+	}
+}
+
+// Close closes the sockets, waits for the relays to stop, and closes the pcap file.
+func (r *pcapRecorder) Close() error {
+	r.closeOnce.Do(func() {
+		r.host.Close()
+		r.peer.Close()
+		r.wg.Wait()
+		r.guest.Close()
+		r.mu.Lock()
+		r.closeErr = r.writeErr
+		r.mu.Unlock()
+		if err := r.out.Close(); err != nil && r.closeErr == nil {
+			r.closeErr = err
+		}
+	})
+	return r.closeErr
+}
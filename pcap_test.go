@@ -0,0 +1,143 @@
+package vz_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/Code-Hex/vz/v3"
+)
+
+func datagramSocketPair(t *testing.T) (*os.File, *os.File) {
+	t.Helper()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return os.NewFile(uintptr(fds[0]), "a"), os.NewFile(uintptr(fds[1]), "b")
+}
+
+func TestPcapRecorder(t *testing.T) {
+	peer, network := datagramSocketPair(t)
+	defer network.Close()
+
+	pcapPath := filepath.Join(t.TempDir(), "vm.pcap")
+	out, err := os.Create(pcapPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder, guest, err := vz.NewPcapRecorder(peer, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fromGuest := bytes.Repeat([]byte{0xaa}, 60)
+	fromNetwork := bytes.Repeat([]byte{0xbb}, 1514)
+	buf := make([]byte, 2048)
+
+	// The frames are relayed in both directions.
+	if _, err := guest.Write(fromGuest); err != nil {
+		t.Fatal(err)
+	}
+	n, err := network.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], fromGuest) {
+		t.Fatalf("want frame from the guest relayed to the network but got %d bytes", n)
+	}
+	if _, err := network.Write(fromNetwork); err != nil {
+		t.Fatal(err)
+	}
+	n, err = guest.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], fromNetwork) {
+		t.Fatalf("want frame from the network relayed to the guest but got %d bytes", n)
+	}
+
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(pcapPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) < 24 {
+		t.Fatalf("pcap file is too short: %d bytes", len(data))
+	}
+	le := binary.LittleEndian
+	if magic := le.Uint32(data[0:4]); magic != 0xa1b2c3d4 {
+		t.Errorf("want magic number 0xa1b2c3d4 but got %#x", magic)
+	}
+	if major, minor := le.Uint16(data[4:6]), le.Uint16(data[6:8]); major != 2 || minor != 4 {
+		t.Errorf("want version 2.4 but got %d.%d", major, minor)
+	}
+	if linkType := le.Uint32(data[20:24]); linkType != 1 {
+		t.Errorf("want link type Ethernet (1) but got %d", linkType)
+	}
+
+	records := data[24:]
+	for i, want := range [][]byte{fromGuest, fromNetwork} {
+		if len(records) < 16 {
+			t.Fatalf("record %d: missing", i)
+		}
+		inclLen, origLen := le.Uint32(records[8:12]), le.Uint32(records[12:16])
+		if int(inclLen) != len(want) || int(origLen) != len(want) {
+			t.Fatalf("record %d: want length %d but got %d (%d)", i, len(want), inclLen, origLen)
+		}
+		if !bytes.Equal(records[16:16+inclLen], want) {
+			t.Errorf("record %d: frame is not recorded as is", i)
+		}
+		records = records[16+inclLen:]
+	}
+	if len(records) != 0 {
+		t.Errorf("want 2 records but got %d trailing bytes", len(records))
+	}
+}
+
+func TestNewPcapRecordingNetworkDeviceAttachmentClosesPeerOnError(t *testing.T) {
+	if vz.Available(11) {
+		t.Skip("NewPcapRecordingNetworkDeviceAttachment is supported from macOS 11")
+	}
+	streamSocketPair := func(t *testing.T) (*os.File, *os.File) {
+		t.Helper()
+		fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return os.NewFile(uintptr(fds[0]), "a"), os.NewFile(uintptr(fds[1]), "b")
+	}
+	cases := map[string]struct {
+		socketPair func(t *testing.T) (*os.File, *os.File)
+		pcapPath   string
+	}{
+		"not a datagram socket": {
+			socketPair: streamSocketPair,
+			pcapPath:   filepath.Join(t.TempDir(), "vm.pcap"),
+		},
+		"pcap file can not be created": {
+			socketPair: datagramSocketPair,
+			pcapPath:   filepath.Join(t.TempDir(), "missing", "vm.pcap"),
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			peer, network := tc.socketPair(t)
+			defer network.Close()
+
+			if _, err := vz.NewPcapRecordingNetworkDeviceAttachment(peer, tc.pcapPath); err == nil {
+				t.Fatal("want an error")
+			}
+			if err := peer.Close(); !errors.Is(err, os.ErrClosed) {
+				t.Fatalf("want peer to be closed but got %v", err)
+			}
+		})
+	}
+}
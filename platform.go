@@ -10,6 +10,8 @@ package vz
 */
 import "C"
 import (
+	"errors"
+	"fmt"
 	"os"
 	"unsafe"
 
@@ -21,12 +23,26 @@ type PlatformConfiguration interface {
 	objc.NSObject
 
 	platformConfiguration()
+
+	// validatePlatform returns an error which wraps ErrInvalidPlatform if the
+	// platform is invalid or can not boot with the boot loader.
+	validatePlatform(bootLoader BootLoader) error
 }
 
 type basePlatformConfiguration struct{}
 
 func (*basePlatformConfiguration) platformConfiguration() {}
 
+// macOSBootLoader is implemented by MacOSBootLoader, which is only available on arm64.
+type macOSBootLoader interface {
+	macOSBootLoader()
+}
+
+func isMacOSBootLoader(bootLoader BootLoader) bool {
+	_, ok := bootLoader.(macOSBootLoader)
+	return ok
+}
+
 // GenericPlatformConfiguration is the platform configuration for a generic Intel or ARM virtual machine.
 type GenericPlatformConfiguration struct {
 	*pointer
@@ -34,6 +50,8 @@ type GenericPlatformConfiguration struct {
 	*basePlatformConfiguration
 
 	machineIdentifier *GenericMachineIdentifier
+
+	nestedVirtualizationEnabled bool
 }
 
 // MachineIdentifier returns the machine identifier.
@@ -41,7 +59,12 @@ func (m *GenericPlatformConfiguration) MachineIdentifier() *GenericMachineIdenti
 	return m.machineIdentifier
 }
 
+// ErrNestedVirtualizationUnsupported is returned when nested virtualization is enabled on a host
+// which does not support it. See IsNestedVirtualizationSupported.
+var ErrNestedVirtualizationUnsupported = fmt.Errorf("nested virtualization is not supported on this host: %w", errors.ErrUnsupported)
+
 // IsNestedVirtualizationSupported reports if nested virtualization is supported.
+// It requires macOS 15 and newer and a host such as Apple M3 or later, false is returned otherwise.
 func IsNestedVirtualizationSupported() bool {
 	if err := macOSAvailable(15); err != nil {
 		return false
@@ -50,21 +73,47 @@ func IsNestedVirtualizationSupported() bool {
 	return (bool)(C.isNestedVirtualizationSupported())
 }
 
-// SetNestedVirtualizationEnabled toggles nested virtualization.
+// SetNestedVirtualizationEnabled toggles nested virtualization, which lets the guest run its own
+// virtual machines (e.g. with KVM). The default is false.
+//
+// ErrNestedVirtualizationUnsupported is returned if enable is true and IsNestedVirtualizationSupported
+// is false. Disabling it does nothing on older macOS versions, where it can not be enabled.
+//
+// This is only supported on macOS 15 and newer, error will
+// be returned on older versions.
 func (m *GenericPlatformConfiguration) SetNestedVirtualizationEnabled(enable bool) error {
 	if err := macOSAvailable(15); err != nil {
+		if !enable {
+			return nil
+		}
 		return err
 	}
+	if enable && !IsNestedVirtualizationSupported() {
+		return ErrNestedVirtualizationUnsupported
+	}
 
 	C.setNestedVirtualizationEnabled(
 		objc.Ptr(m),
 		C.bool(enable),
 	)
+	m.nestedVirtualizationEnabled = enable
 	return nil
 }
 
+// NestedVirtualizationEnabled reports if nested virtualization is enabled.
+func (m *GenericPlatformConfiguration) NestedVirtualizationEnabled() bool {
+	return m.nestedVirtualizationEnabled
+}
+
 var _ PlatformConfiguration = (*GenericPlatformConfiguration)(nil)
 
+func (m *GenericPlatformConfiguration) validatePlatform(bootLoader BootLoader) error {
+	if isMacOSBootLoader(bootLoader) {
+		return fmt.Errorf("%w: MacOSBootLoader requires MacPlatformConfiguration, not GenericPlatformConfiguration", ErrInvalidPlatform)
+	}
+	return nil
+}
+
 // NewGenericPlatformConfiguration creates a new generic platform configuration.
 //
 // This is only supported on macOS 12 and newer, error will
@@ -175,3 +224,11 @@ func WithGenericMachineIdentifier(m *GenericMachineIdentifier) GenericPlatformCo
 		return nil
 	}
 }
+
+// WithNestedVirtualization is an option to create a new GenericPlatformConfiguration
+// with nested virtualization enabled or disabled. See SetNestedVirtualizationEnabled.
+func WithNestedVirtualization(enable bool) GenericPlatformConfigurationOption {
+	return func(mpc *GenericPlatformConfiguration) error {
+		return mpc.SetNestedVirtualizationEnabled(enable)
+	}
+}
@@ -10,6 +10,8 @@ package vz
 */
 import "C"
 import (
+	"fmt"
+
 	"github.com/Code-Hex/vz/v3/internal/objc"
 )
 
@@ -39,6 +41,22 @@ type MacPlatformConfiguration struct {
 
 var _ PlatformConfiguration = (*MacPlatformConfiguration)(nil)
 
+func (m *MacPlatformConfiguration) validatePlatform(bootLoader BootLoader) error {
+	switch {
+	case !isMacOSBootLoader(bootLoader):
+		return fmt.Errorf("%w: MacPlatformConfiguration requires MacOSBootLoader", ErrInvalidPlatform)
+	case m.hardwareModel == nil:
+		return fmt.Errorf("%w: MacPlatformConfiguration has no hardware model", ErrInvalidPlatform)
+	case !m.hardwareModel.Supported():
+		return fmt.Errorf("%w: the hardware model of MacPlatformConfiguration is not supported by this host", ErrInvalidPlatform)
+	case m.machineIdentifier == nil:
+		return fmt.Errorf("%w: MacPlatformConfiguration has no machine identifier", ErrInvalidPlatform)
+	case m.auxiliaryStorage == nil:
+		return fmt.Errorf("%w: MacPlatformConfiguration has no auxiliary storage", ErrInvalidPlatform)
+	}
+	return nil
+}
+
 // MacPlatformConfigurationOption is an optional function to create its configuration.
 type MacPlatformConfigurationOption func(*MacPlatformConfiguration)
 
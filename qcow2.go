@@ -0,0 +1,387 @@
+package vz
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// DiskFormat is the format of a disk image file.
+//
+//go:generate stringer -type=DiskFormat
+type DiskFormat int
+
+const (
+	// DiskFormatRaw is the raw disk image format which the Virtualization framework boots.
+	DiskFormatRaw DiskFormat = iota
+
+	// DiskFormatQCOW2 is the QEMU copy-on-write version 2 (and 3) disk image format.
+	DiskFormatQCOW2
+)
+
+// ErrUnsupportedQCOW2 is returned by ConvertDiskImage if the qcow2 image uses a feature
+// which is not supported (e.g. backing files, encryption or extended L2 entries).
+var ErrUnsupportedQCOW2 = fmt.Errorf("unsupported qcow2 image: %w", errors.ErrUnsupported)
+
+// ConvertDiskImage converts the disk image at src to format and writes the result to dst.
+// The format of src is detected from the content of the file. Conversions between DiskFormatQCOW2
+// and DiskFormatRaw are supported, e.g. to boot a cloud image which is distributed in qcow2.
+//
+// The image is converted by streaming, the whole disk is never loaded in memory. Zero clusters
+// are not written, so the raw disk image is sparse. Compressed qcow2 clusters are supported.
+//
+// Note that if you have specified a dst which already exists, this function
+// returns os.ErrExist error. So you can handle it with os.IsExist function.
+func ConvertDiskImage(src, dst string, format DiskFormat) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	srcFormat, err := detectDiskFormat(in)
+	if err != nil {
+		return err
+	}
+	if srcFormat == format {
+		return fmt.Errorf("%q is already in %s", src, format)
+	}
+	switch format {
+	case DiskFormatRaw, DiskFormatQCOW2:
+	default:
+		return fmt.Errorf("unknown disk format: %s", format)
+	}
+
+	out, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if format == DiskFormatRaw {
+		err = convertQCOW2ToRaw(out, in)
+	} else {
+		err = convertRawToQCOW2(out, in)
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("failed to convert %q to %s: %w", src, format, err)
+	}
+	return nil
+}
+
+var qcow2Magic = []byte{'Q', 'F', 'I', 0xfb}
+
+func detectDiskFormat(r io.ReaderAt) (DiskFormat, error) {
+	magic := make([]byte, len(qcow2Magic))
+	n, err := r.ReadAt(magic, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+	if n == len(magic) && bytes.Equal(magic, qcow2Magic) {
+		return DiskFormatQCOW2, nil
+	}
+	return DiskFormatRaw, nil
+}
+
+const (
+	qcow2HeaderV2Size = 72
+	qcow2HeaderV3Size = 104
+
+	// Incompatible feature bits which does not prevent reading.
+	qcow2IncompatibleDirty = 1 << 0
+
+	qcow2OffsetMask     = 0x00fffffffffffe00
+	qcow2CopiedFlag     = 1 << 63
+	qcow2CompressedFlag = 1 << 62
+	qcow2ZeroFlag       = 1 << 0
+)
+
+type qcow2Header struct {
+	Magic                 uint32
+	Version               uint32
+	BackingFileOffset     uint64
+	BackingFileSize       uint32
+	ClusterBits           uint32
+	Size                  uint64
+	CryptMethod           uint32
+	L1Size                uint32
+	L1TableOffset         uint64
+	RefcountTableOffset   uint64
+	RefcountTableClusters uint32
+	NbSnapshots           uint32
+	SnapshotsOffset       uint64
+
+	// Version 3 and newer.
+	IncompatibleFeatures uint64
+	CompatibleFeatures   uint64
+	AutoclearFeatures    uint64
+	RefcountOrder        uint32
+	HeaderLength         uint32
+}
+
+func readQCOW2Header(r io.ReaderAt) (*qcow2Header, error) {
+	buf := make([]byte, qcow2HeaderV3Size)
+	if _, err := r.ReadAt(buf, 0); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	h := new(qcow2Header)
+	if err := binary.Read(bytes.NewReader(buf), binary.BigEndian, h); err != nil {
+		return nil, err
+	}
+	switch {
+	case !bytes.Equal(buf[:4], qcow2Magic):
+		return nil, errors.New("not a qcow2 image")
+	case h.Version == 2:
+		// Fields of version 3 are not in the header.
+		h.IncompatibleFeatures, h.CompatibleFeatures, h.AutoclearFeatures = 0, 0, 0
+		h.RefcountOrder, h.HeaderLength = 4, qcow2HeaderV2Size
+	case h.Version == 3:
+	default:
+		return nil, fmt.Errorf("%w: version %d", ErrUnsupportedQCOW2, h.Version)
+	}
+	switch {
+	case h.ClusterBits < 9 || h.ClusterBits > 21:
+		return nil, fmt.Errorf("invalid cluster bits: %d", h.ClusterBits)
+	case h.BackingFileOffset != 0:
+		return nil, fmt.Errorf("%w: backing file", ErrUnsupportedQCOW2)
+	case h.CryptMethod != 0:
+		return nil, fmt.Errorf("%w: encryption", ErrUnsupportedQCOW2)
+	case h.IncompatibleFeatures&^qcow2IncompatibleDirty != 0:
+		return nil, fmt.Errorf("%w: incompatible features %#x", ErrUnsupportedQCOW2, h.IncompatibleFeatures)
+	}
+	return h, nil
+}
+
+// convertQCOW2ToRaw writes the guest visible content of the qcow2 image in r to w.
+func convertQCOW2ToRaw(w *os.File, r io.ReaderAt) error {
+	h, err := readQCOW2Header(r)
+	if err != nil {
+		return err
+	}
+	clusterSize := int64(1) << h.ClusterBits
+	l2Entries := clusterSize / 8
+	if need := (int64(h.Size) + clusterSize*l2Entries - 1) / (clusterSize * l2Entries); int64(h.L1Size) < need {
+		return fmt.Errorf("L1 table is too small: %d < %d", h.L1Size, need)
+	}
+
+	if err := w.Truncate(int64(h.Size)); err != nil {
+		return err
+	}
+
+	l1 := make([]byte, int64(h.L1Size)*8)
+	if _, err := r.ReadAt(l1, int64(h.L1TableOffset)); err != nil {
+		return fmt.Errorf("failed to read L1 table: %w", err)
+	}
+	l2 := make([]byte, clusterSize)
+	cluster := make([]byte, clusterSize)
+	zero := make([]byte, clusterSize)
+	compressed := make([]byte, 0, clusterSize*2)
+
+	for i := int64(0); i < int64(h.L1Size); i++ {
+		guestOffset := i * l2Entries * clusterSize
+		if guestOffset >= int64(h.Size) {
+			break
+		}
+		l2Offset := int64(binary.BigEndian.Uint64(l1[i*8:]) & qcow2OffsetMask)
+		if l2Offset == 0 {
+			// Not allocated, reads as zeros.
+			continue
+		}
+		if _, err := r.ReadAt(l2, l2Offset); err != nil {
+			return fmt.Errorf("failed to read L2 table at %d: %w", l2Offset, err)
+		}
+		for j := int64(0); j < l2Entries; j++ {
+			offset := guestOffset + j*clusterSize
+			if offset >= int64(h.Size) {
+				break
+			}
+			entry := binary.BigEndian.Uint64(l2[j*8:])
+			if entry&qcow2CompressedFlag != 0 {
+				compressed, err = readQCOW2CompressedCluster(r, compressed, cluster, entry, h.ClusterBits)
+				if err != nil {
+					return fmt.Errorf("failed to read compressed cluster at %d: %w", offset, err)
+				}
+			} else {
+				hostOffset := int64(entry & qcow2OffsetMask)
+				if hostOffset == 0 || entry&qcow2ZeroFlag != 0 {
+					continue
+				}
+				n, err := r.ReadAt(cluster, hostOffset)
+				if err != nil && !errors.Is(err, io.EOF) {
+					return fmt.Errorf("failed to read cluster at %d: %w", offset, err)
+				}
+				clear(cluster[n:])
+			}
+			n := min(clusterSize, int64(h.Size)-offset)
+			if bytes.Equal(cluster[:n], zero[:n]) {
+				continue
+			}
+			if _, err := w.WriteAt(cluster[:n], offset); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readQCOW2CompressedCluster decompresses the cluster described by the L2 entry into cluster.
+// buf is a reusable buffer for the compressed data, and it is returned for the next call.
+func readQCOW2CompressedCluster(r io.ReaderAt, buf, cluster []byte, entry uint64, clusterBits uint32) ([]byte, error) {
+	x := 62 - (clusterBits - 8)
+	hostOffset := int64(entry & (1<<x - 1))
+	sectors := int64((entry&(1<<62-1))>>x) + 1
+	size := sectors*512 - hostOffset&511
+
+	if int64(cap(buf)) < size {
+		buf = make([]byte, size)
+	}
+	buf = buf[:size]
+	// The compressed data may end before the last sector at the end of the file.
+	n, err := r.ReadAt(buf, hostOffset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return buf, err
+	}
+	zr := flate.NewReader(bytes.NewReader(buf[:n]))
+	defer zr.Close()
+	if _, err := io.ReadFull(zr, cluster); err != nil {
+		return buf, err
+	}
+	return buf, nil
+}
+
+// qcow2WriterClusterBits is the cluster size (64 KiB) of qcow2 images created by ConvertDiskImage,
+// which is the default of qemu-img.
+const qcow2WriterClusterBits = 16
+
+// convertRawToQCOW2 writes the raw disk image in r to w as a qcow2 version 3 image.
+//
+// The clusters are laid out as: the header, the data clusters in guest order, the L2 tables,
+// the L1 table, the refcount blocks and the refcount table. Only the L2 tables are kept in memory
+// until the data is written.
+func convertRawToQCOW2(w *os.File, r *os.File) error {
+	fi, err := r.Stat()
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+	const clusterSize = int64(1) << qcow2WriterClusterBits
+	const l2Entries = clusterSize / 8
+
+	guestClusters := (size + clusterSize - 1) / clusterSize
+	l1Size := (guestClusters + l2Entries - 1) / l2Entries
+	l2Tables := make([][]uint64, l1Size)
+
+	// The first cluster is for the header.
+	hostCluster := int64(1)
+	cluster := make([]byte, clusterSize)
+	zero := make([]byte, clusterSize)
+	for i := int64(0); i < guestClusters; i++ {
+		n, err := io.ReadFull(r, cluster)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+		clear(cluster[n:])
+		if bytes.Equal(cluster, zero) {
+			continue
+		}
+		if _, err := w.WriteAt(cluster, hostCluster*clusterSize); err != nil {
+			return err
+		}
+		l2 := l2Tables[i/l2Entries]
+		if l2 == nil {
+			l2 = make([]uint64, l2Entries)
+			l2Tables[i/l2Entries] = l2
+		}
+		l2[i%l2Entries] = uint64(hostCluster*clusterSize) | qcow2CopiedFlag
+		hostCluster++
+	}
+
+	l1 := make([]byte, l1Size*8)
+	for i, l2 := range l2Tables {
+		if l2 == nil {
+			continue
+		}
+		for j, entry := range l2 {
+			binary.BigEndian.PutUint64(cluster[j*8:], entry)
+		}
+		if _, err := w.WriteAt(cluster, hostCluster*clusterSize); err != nil {
+			return err
+		}
+		binary.BigEndian.PutUint64(l1[i*8:], uint64(hostCluster*clusterSize)|qcow2CopiedFlag)
+		hostCluster++
+	}
+
+	l1Offset := hostCluster * clusterSize
+	if len(l1) > 0 {
+		if _, err := w.WriteAt(l1, l1Offset); err != nil {
+			return err
+		}
+	}
+	hostCluster += max(1, (int64(len(l1))+clusterSize-1)/clusterSize)
+
+	// Every cluster is referenced once. The refcount blocks and the table must count themselves,
+	// so find the smallest number of clusters for them.
+	const refcountsPerBlock = clusterSize / 2 // 16 bits refcounts.
+	var refcountBlocks, refcountTableClusters int64
+	for {
+		total := hostCluster + refcountBlocks + refcountTableClusters
+		blocks := (total + refcountsPerBlock - 1) / refcountsPerBlock
+		tableClusters := (blocks*8 + clusterSize - 1) / clusterSize
+		if blocks == refcountBlocks && tableClusters == refcountTableClusters {
+			break
+		}
+		refcountBlocks, refcountTableClusters = blocks, tableClusters
+	}
+	totalClusters := hostCluster + refcountBlocks + refcountTableClusters
+
+	refcountTable := make([]byte, refcountTableClusters*clusterSize)
+	for b := int64(0); b < refcountBlocks; b++ {
+		clear(cluster)
+		for k := int64(0); k < refcountsPerBlock; k++ {
+			if b*refcountsPerBlock+k >= totalClusters {
+				break
+			}
+			binary.BigEndian.PutUint16(cluster[k*2:], 1)
+		}
+		blockOffset := (hostCluster + b) * clusterSize
+		if _, err := w.WriteAt(cluster, blockOffset); err != nil {
+			return err
+		}
+		binary.BigEndian.PutUint64(refcountTable[b*8:], uint64(blockOffset))
+	}
+	refcountTableOffset := (hostCluster + refcountBlocks) * clusterSize
+	if _, err := w.WriteAt(refcountTable, refcountTableOffset); err != nil {
+		return err
+	}
+
+	h := qcow2Header{
+		Version:               3,
+		ClusterBits:           qcow2WriterClusterBits,
+		Size:                  uint64(size),
+		L1Size:                uint32(l1Size),
+		L1TableOffset:         uint64(l1Offset),
+		RefcountTableOffset:   uint64(refcountTableOffset),
+		RefcountTableClusters: uint32(refcountTableClusters),
+		RefcountOrder:         4,
+		HeaderLength:          qcow2HeaderV3Size,
+	}
+	h.Magic = binary.BigEndian.Uint32(qcow2Magic)
+	var header bytes.Buffer
+	if err := binary.Write(&header, binary.BigEndian, &h); err != nil {
+		return err
+	}
+	// The rest of the cluster is zero, which is the end of the header extensions.
+	clear(cluster)
+	copy(cluster, header.Bytes())
+	_, err = w.WriteAt(cluster, 0)
+	return err
+}
@@ -0,0 +1,137 @@
+package vz_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Code-Hex/vz/v3"
+)
+
+// fixtureQCOW2Content returns the guest visible content of testdata/fixture.qcow2.
+//
+// The fixture is a qcow2 version 3 image with 4 KiB clusters which has a standard cluster,
+// a compressed cluster, a cluster with the zero flag, unallocated clusters and a partial
+// last cluster.
+func fixtureQCOW2Content() []byte {
+	const clusterSize = 4096
+	content := make([]byte, 8*clusterSize+100)
+	for i := 0; i < clusterSize; i++ {
+		content[i] = byte(i % 251)
+	}
+	text := bytes.Repeat([]byte("vz qcow2 fixture\n"), clusterSize/17+1)
+	copy(content[3*clusterSize:4*clusterSize], text)
+	copy(content[8*clusterSize:], bytes.Repeat([]byte{0xab}, 100))
+	return content
+}
+
+func TestConvertDiskImageQCOW2ToRaw(t *testing.T) {
+	dst := filepath.Join(t.TempDir(), "disk.img")
+	if err := vz.ConvertDiskImage("testdata/fixture.qcow2", dst, vz.DiskFormatRaw); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fixtureQCOW2Content(); !bytes.Equal(want, got) {
+		t.Fatalf("converted raw disk image differs from the fixture (want %d bytes, got %d bytes)", len(want), len(got))
+	}
+
+	if err := vz.ConvertDiskImage("testdata/fixture.qcow2", dst, vz.DiskFormatRaw); !os.IsExist(err) {
+		t.Fatalf("want os.ErrExist but got %v", err)
+	}
+}
+
+func TestConvertDiskImageRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.img")
+	want := bytes.Join([][]byte{
+		bytes.Repeat([]byte{1}, 100),
+		make([]byte, 200*1024),
+		bytes.Repeat([]byte("data"), 40000),
+		make([]byte, 1024*1024),
+		{2},
+	}, nil)
+	if err := os.WriteFile(src, want, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	qcow2 := filepath.Join(dir, "disk.qcow2")
+	if err := vz.ConvertDiskImage(src, qcow2, vz.DiskFormatQCOW2); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(qcow2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() >= int64(len(want)) {
+		t.Errorf("want qcow2 image smaller than %d bytes but got %d", len(want), fi.Size())
+	}
+
+	raw := filepath.Join(dir, "disk.img")
+	if err := vz.ConvertDiskImage(qcow2, raw, vz.DiskFormatRaw); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(want, got) {
+		t.Fatalf("round trip content differs (want %d bytes, got %d bytes)", len(want), len(got))
+	}
+}
+
+func TestConvertDiskImageErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("same format", func(t *testing.T) {
+		src := filepath.Join(dir, "same.img")
+		if err := os.WriteFile(src, []byte("raw"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		dst := filepath.Join(dir, "same-out.img")
+		if err := vz.ConvertDiskImage(src, dst, vz.DiskFormatRaw); err == nil {
+			t.Fatal("want error")
+		}
+		if _, err := os.Stat(dst); !os.IsNotExist(err) {
+			t.Fatalf("want no output file but got %v", err)
+		}
+	})
+
+	t.Run("backing file", func(t *testing.T) {
+		b, err := os.ReadFile("testdata/fixture.qcow2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Set the backing file offset in the header.
+		binary.BigEndian.PutUint64(b[8:], 4096)
+		src := filepath.Join(dir, "backing.qcow2")
+		if err := os.WriteFile(src, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+		dst := filepath.Join(dir, "backing.img")
+		if err := vz.ConvertDiskImage(src, dst, vz.DiskFormatRaw); !errors.Is(err, vz.ErrUnsupportedQCOW2) || !errors.Is(err, errors.ErrUnsupported) {
+			t.Fatalf("want ErrUnsupportedQCOW2 but got %v", err)
+		}
+		if _, err := os.Stat(dst); !os.IsNotExist(err) {
+			t.Fatalf("want output file removed but got %v", err)
+		}
+	})
+}
+
+func TestDiskFormatString(t *testing.T) {
+	cases := map[vz.DiskFormat]string{
+		vz.DiskFormatRaw:   "DiskFormatRaw",
+		vz.DiskFormatQCOW2: "DiskFormatQCOW2",
+		vz.DiskFormat(10):  "DiskFormat(10)",
+	}
+	for format, want := range cases {
+		if got := format.String(); got != want {
+			t.Errorf("want %q but got %q", want, got)
+		}
+	}
+}
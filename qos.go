@@ -0,0 +1,97 @@
+package vz
+
+/*
+#cgo darwin CFLAGS: -mmacosx-version-min=11 -x objective-c -fno-objc-arc
+#cgo darwin LDFLAGS: -lobjc -framework Foundation -framework Virtualization
+# include "virtualization_11.h"
+*/
+import "C"
+import (
+	"fmt"
+	"strconv"
+)
+
+// QoSClass is a quality-of-service class of the dispatch queue of a virtual machine.
+// A lower class lets the system prioritize the other work of the host, e.g. to make
+// background virtual machines yield to foreground ones.
+//
+// see: https://developer.apple.com/documentation/dispatch/dispatchqos/qosclass
+type QoSClass uint32
+
+const (
+	// QoSClassUnspecified is the absence of a quality-of-service class.
+	QoSClassUnspecified QoSClass = C.QOS_CLASS_UNSPECIFIED
+
+	// QoSClassUserInteractive is for work which interacts with the user.
+	QoSClassUserInteractive QoSClass = C.QOS_CLASS_USER_INTERACTIVE
+
+	// QoSClassUserInitiated is for work which the user started and is waiting for.
+	QoSClassUserInitiated QoSClass = C.QOS_CLASS_USER_INITIATED
+
+	// QoSClassDefault is the default quality-of-service class.
+	QoSClassDefault QoSClass = C.QOS_CLASS_DEFAULT
+
+	// QoSClassUtility is for long-running work which the user does not track actively.
+	QoSClassUtility QoSClass = C.QOS_CLASS_UTILITY
+
+	// QoSClassBackground is for maintenance or cleanup work which the user is not aware of.
+	QoSClassBackground QoSClass = C.QOS_CLASS_BACKGROUND
+)
+
+func (q QoSClass) String() string {
+	switch q {
+	case QoSClassUnspecified:
+		return "QoSClassUnspecified"
+	case QoSClassUserInteractive:
+		return "QoSClassUserInteractive"
+	case QoSClassUserInitiated:
+		return "QoSClassUserInitiated"
+	case QoSClassDefault:
+		return "QoSClassDefault"
+	case QoSClassUtility:
+		return "QoSClassUtility"
+	case QoSClassBackground:
+		return "QoSClassBackground"
+	}
+	return "QoSClass(" + strconv.FormatUint(uint64(q), 10) + ")"
+}
+
+func (q QoSClass) valid() bool {
+	switch q {
+	case QoSClassUserInteractive, QoSClassUserInitiated, QoSClassDefault, QoSClassUtility, QoSClassBackground:
+		return true
+	}
+	return false
+}
+
+// SetQoSClass sets the quality-of-service class of the dispatch queue of the virtual machine.
+// This can be changed while the virtual machine is running, e.g. QoSClassBackground for a
+// virtual machine which runs a build in the background.
+//
+// The queue runs the work of the Virtualization framework in this process: operations such as
+// Start and Stop, state observation and device delegates. The framework does not provide a
+// quality-of-service or priority setting for the I/O of storage device attachments.
+func (v *VirtualMachine) SetQoSClass(qos QoSClass) error {
+	if !qos.valid() {
+		return fmt.Errorf("invalid QoS class: %s", qos)
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	C.setDispatchQueueQoSClass(v.dispatchQueue, C.uint(qos))
+	v.qosClass = qos
+	return nil
+}
+
+// QoSClass returns the quality-of-service class which is set by WithQoSClass or SetQoSClass.
+// QoSClassUnspecified is returned if it is not set.
+func (v *VirtualMachine) QoSClass() QoSClass {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.qosClass
+}
+
+// dispatchQueueQoSClass returns the quality-of-service class which the tasks of the dispatch
+// queue run with.
+func (v *VirtualMachine) dispatchQueueQoSClass() QoSClass {
+	return QoSClass(C.dispatchQueueQoSClass(v.dispatchQueue))
+}
@@ -0,0 +1,90 @@
+package vz_test
+
+import (
+	"testing"
+
+	"github.com/Code-Hex/vz/v3"
+)
+
+func TestQoSClassString(t *testing.T) {
+	cases := map[vz.QoSClass]string{
+		vz.QoSClassUnspecified:     "QoSClassUnspecified",
+		vz.QoSClassUserInteractive: "QoSClassUserInteractive",
+		vz.QoSClassUserInitiated:   "QoSClassUserInitiated",
+		vz.QoSClassDefault:         "QoSClassDefault",
+		vz.QoSClassUtility:         "QoSClassUtility",
+		vz.QoSClassBackground:      "QoSClassBackground",
+		vz.QoSClass(1):             "QoSClass(1)",
+	}
+	for qos, want := range cases {
+		if got := qos.String(); got != want {
+			t.Errorf("want %q but got %q", want, got)
+		}
+	}
+}
+
+func TestSetQoSClass(t *testing.T) {
+	bootLoader, err := vz.NewLinuxBootLoader("./testdata/Image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := setupConfiguration(bootLoader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, err := vz.NewVirtualMachine(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := vm.QoSClass(); got != vz.QoSClassUnspecified {
+		t.Fatalf("want %s but got %s", vz.QoSClassUnspecified, got)
+	}
+	for _, qos := range []vz.QoSClass{vz.QoSClassBackground, vz.QoSClassUtility, vz.QoSClassUserInteractive} {
+		if err := vm.SetQoSClass(qos); err != nil {
+			t.Fatal(err)
+		}
+		if got := vm.QoSClass(); got != qos {
+			t.Fatalf("want %s but got %s", qos, got)
+		}
+		if got := vm.DispatchQueueQoSClass(); got != qos {
+			t.Fatalf("want the tasks of the queue to run with %s but got %s", qos, got)
+		}
+	}
+	for _, qos := range []vz.QoSClass{vz.QoSClassUnspecified, vz.QoSClass(1)} {
+		if err := vm.SetQoSClass(qos); err == nil {
+			t.Fatalf("want error for %s", qos)
+		}
+	}
+	if got := vm.QoSClass(); got != vz.QoSClassUserInteractive {
+		t.Fatalf("want QoS class unchanged by invalid values but got %s", got)
+	}
+}
+
+func TestWithQoSClass(t *testing.T) {
+	bootLoader, err := vz.NewLinuxBootLoader("./testdata/Image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := setupConfiguration(bootLoader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, qos := range []vz.QoSClass{vz.QoSClassUtility, vz.QoSClassBackground} {
+		vm, err := vz.NewVirtualMachine(config, vz.WithQoSClass(qos))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := vm.DispatchQueueQoSClass(); got != qos {
+			t.Fatalf("want the tasks of the queue to run with %s but got %s", qos, got)
+		}
+		if got := vm.QoSClass(); got != qos {
+			t.Fatalf("want %s but got %s", qos, got)
+		}
+	}
+
+	if _, err := vz.NewVirtualMachine(config, vz.WithQoSClass(vz.QoSClass(1))); err == nil {
+		t.Fatal("want error for an invalid QoS class")
+	}
+}
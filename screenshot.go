@@ -0,0 +1,108 @@
+package vz
+
+/*
+#cgo darwin CFLAGS: -mmacosx-version-min=11 -x objective-c -fno-objc-arc
+#cgo darwin LDFLAGS: -lobjc -framework Foundation -framework Virtualization -framework Cocoa
+# include <stdlib.h>
+# include "virtualization_default_app.h"
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"image"
+	"unsafe"
+
+	"github.com/Code-Hex/vz/v3/internal/objc"
+)
+
+// ErrScreenshotUnavailable is returned by (*VirtualMachine).Screenshot and (*VirtualMachine).Thumbnail
+// when the display of the virtual machine can not be captured.
+var ErrScreenshotUnavailable = errors.New("screenshot is unavailable")
+
+// Screenshot captures the display of the virtual machine as shown in the window created by
+// (*VirtualMachine).CreateWindow, at the resolution of the window on screen.
+//
+// The Virtualization framework does not expose the framebuffer of the guest, so the window is
+// captured instead as the window server composited it. An error which wraps ErrScreenshotUnavailable
+// is returned if the virtual machine is not running or paused, or has no visible window (e.g. a
+// headless virtual machine, or a window which is hidden or minimized). Other windows covering the
+// window are not captured.
+//
+// This is only supported on macOS 12 and newer, error will be returned on older versions.
+func (v *VirtualMachine) Screenshot() (*image.RGBA, error) {
+	if err := macOSAvailable(12); err != nil {
+		return nil, err
+	}
+	if state := v.State(); state != VirtualMachineStateRunning && state != VirtualMachineStatePaused {
+		return nil, fmt.Errorf("%w: virtual machine is %v", ErrScreenshotUnavailable, state)
+	}
+	var width, height C.int
+	pixels := C.captureVirtualMachineWindow(objc.Ptr(v), &width, &height)
+	if pixels == nil {
+		return nil, fmt.Errorf("%w: virtual machine has no visible window", ErrScreenshotUnavailable)
+	}
+	defer C.free(pixels)
+
+	img := image.NewRGBA(image.Rect(0, 0, int(width), int(height)))
+	copy(img.Pix, unsafe.Slice((*byte)(pixels), len(img.Pix)))
+	return img, nil
+}
+
+// Thumbnail captures the display of the virtual machine like Screenshot and scales it down so that
+// neither side is longer than maxDim pixels, keeping the aspect ratio. This is useful to show a small
+// preview of each running virtual machine, e.g. in a virtual machine picker.
+//
+// The screenshot is returned as is if it already fits in maxDim.
+//
+// This is only supported on macOS 12 and newer, error will be returned on older versions.
+func (v *VirtualMachine) Thumbnail(maxDim int) (image.Image, error) {
+	if maxDim < 1 {
+		return nil, fmt.Errorf("thumbnail size must be at least 1: %d", maxDim)
+	}
+	img, err := v.Screenshot()
+	if err != nil {
+		return nil, err
+	}
+	return downscale(img, maxDim), nil
+}
+
+// downscale scales src down so that neither side is longer than maxDim pixels. Each pixel of the
+// result is the average of the pixels of src which it covers, so details do not alias.
+func downscale(src *image.RGBA, maxDim int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	if sw <= maxDim && sh <= maxDim {
+		return src
+	}
+	dw, dh := maxDim, maxDim
+	if sw >= sh {
+		dh = max(1, sh*maxDim/sw)
+	} else {
+		dw = max(1, sw*maxDim/sh)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	origin := src.Bounds().Min
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, (y+1)*sh/dh
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, (x+1)*sw/dw
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.PixOffset(origin.X+x0, origin.Y+sy)
+				for sx := x0; sx < x1; sx++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += int(src.Pix[row+c])
+					}
+					row += 4
+				}
+			}
+			n := (x1 - x0) * (y1 - y0)
+			i := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[i+c] = uint8((sum[c] + n/2) / n)
+			}
+		}
+	}
+	return dst
+}
@@ -0,0 +1,97 @@
+package vz_test
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/Code-Hex/vz/v3"
+)
+
+// checkerboard returns a w x h image whose blocks of block x block pixels alternate between a and b.
+func checkerboard(w, h, block int, a, b color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if (x/block+y/block)%2 == 0 {
+				img.SetRGBA(x, y, a)
+			} else {
+				img.SetRGBA(x, y, b)
+			}
+		}
+	}
+	return img
+}
+
+func TestDownscale(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+
+	t.Run("blocks are averaged", func(t *testing.T) {
+		// Each 2x2 block of the 8x4 framebuffer becomes one pixel.
+		got := vz.Downscale(checkerboard(8, 4, 2, red, blue), 4)
+		if b := got.Bounds(); b.Dx() != 4 || b.Dy() != 2 {
+			t.Fatalf("want 4x2 but got %dx%d", b.Dx(), b.Dy())
+		}
+		for y := 0; y < 2; y++ {
+			for x := 0; x < 4; x++ {
+				want := red
+				if (x+y)%2 == 1 {
+					want = blue
+				}
+				if c := got.RGBAAt(x, y); c != want {
+					t.Errorf("pixel (%d, %d): want %v but got %v", x, y, want, c)
+				}
+			}
+		}
+	})
+
+	t.Run("details are blended", func(t *testing.T) {
+		got := vz.Downscale(checkerboard(4, 4, 1, red, blue), 2)
+		want := color.RGBA{R: 128, B: 128, A: 255}
+		if c := got.RGBAAt(0, 0); c != want {
+			t.Errorf("want %v but got %v", want, c)
+		}
+	})
+
+	cases := []struct {
+		name         string
+		w, h, maxDim int
+		wantW, wantH int
+	}{
+		{name: "landscape", w: 1920, h: 1080, maxDim: 160, wantW: 160, wantH: 90},
+		{name: "portrait", w: 1080, h: 1920, maxDim: 160, wantW: 90, wantH: 160},
+		{name: "already small", w: 100, h: 50, maxDim: 160, wantW: 100, wantH: 50},
+		{name: "very wide", w: 4000, h: 10, maxDim: 100, wantW: 100, wantH: 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := vz.Downscale(image.NewRGBA(image.Rect(0, 0, tc.w, tc.h)), tc.maxDim)
+			if b := got.Bounds(); b.Dx() != tc.wantW || b.Dy() != tc.wantH {
+				t.Fatalf("want %dx%d but got %dx%d", tc.wantW, tc.wantH, b.Dx(), b.Dy())
+			}
+		})
+	}
+}
+
+func TestThumbnailStoppedVirtualMachine(t *testing.T) {
+	if vz.Available(12) {
+		t.Skip("Thumbnail is supported from macOS 12")
+	}
+	bootLoader, err := vz.NewLinuxBootLoader("./testdata/Image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := setupConfiguration(bootLoader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, err := vz.NewVirtualMachine(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vm.Thumbnail(160); !errors.Is(err, vz.ErrScreenshotUnavailable) {
+		t.Fatalf("want ErrScreenshotUnavailable but got %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package vz
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+var _ SerialPortAttachment = (*BufferedSerialPortAttachment)(nil)
+
+// BufferedSerialPortAttachment is a serial port attachment which keeps the most recent output
+// of the guest in memory, so the console output which lead to e.g. a boot failure can be read
+// at any time, even if nothing was reading the console when it was written.
+//
+// Data written with Write is sent to the guest.
+type BufferedSerialPortAttachment struct {
+	*FileHandleSerialPortAttachment
+
+	ring *ringBuffer
+
+	mu     sync.Mutex
+	window *consoleWindow // the window which shows the output, see CreateConsoleWindow
+
+	// input is written to send data to the guest, output is read to receive the data of the guest.
+	// guestInput and guestOutput are the other ends, which are handed to the virtual machine.
+	input, guestInput   *os.File
+	output, guestOutput *os.File
+	done                chan struct{}
+	closeOnce           sync.Once
+	closeErr            error
+}
+
+// NewBufferedSerialPortAttachment creates a serial port attachment which keeps the last size bytes
+// of the output of the guest. Call Close after the virtual machine stopped to release the pipes.
+//
+// This is only supported on macOS 11 and newer, error will
+// be returned on older versions.
+func NewBufferedSerialPortAttachment(size int) (*BufferedSerialPortAttachment, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid buffer size %d", size)
+	}
+	guestInput, input, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a pipe: %w", err)
+	}
+	output, guestOutput, err := os.Pipe()
+	if err != nil {
+		guestInput.Close()
+		input.Close()
+		return nil, fmt.Errorf("failed to create a pipe: %w", err)
+	}
+	attachment, err := NewFileHandleSerialPortAttachment(guestInput, guestOutput)
+	if err != nil {
+		for _, f := range []*os.File{guestInput, input, output, guestOutput} {
+			f.Close()
+		}
+		return nil, err
+	}
+	b := &BufferedSerialPortAttachment{
+		FileHandleSerialPortAttachment: attachment,
+		ring:                           newRingBuffer(size),
+		input:                          input,
+		guestInput:                     guestInput,
+		output:                         output,
+		guestOutput:                    guestOutput,
+		done:                           make(chan struct{}),
+	}
+	go b.copyOutput()
+	return b, nil
+}
+
+func (b *BufferedSerialPortAttachment) copyOutput() {
+	defer close(b.done)
+	buf := make([]byte, 4096)
+	for {
+		n, err := b.output.Read(buf)
+		b.mu.Lock()
+		b.ring.Write(buf[:n])
+		if b.window != nil {
+			b.window.write(buf[:n])
+		}
+		b.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Tail returns a copy of the last n bytes of the output of the guest, or all of the buffered
+// output if there is less than n bytes.
+func (b *BufferedSerialPortAttachment) Tail(n int) []byte {
+	return b.ring.Tail(n)
+}
+
+// Write sends p to the guest.
+func (b *BufferedSerialPortAttachment) Write(p []byte) (int, error) {
+	return b.input.Write(p)
+}
+
+// Close closes the pipes of the attachment. The buffered output can still be read with Tail.
+// The virtual machine must not use the attachment anymore, e.g. it is stopped.
+func (b *BufferedSerialPortAttachment) Close() error {
+	b.closeOnce.Do(func() {
+		// Closing the write end of the guest output ends copyOutput after the buffered data is read.
+		b.closeErr = errors.Join(b.input.Close(), b.guestInput.Close(), b.guestOutput.Close())
+		<-b.done
+		b.closeErr = errors.Join(b.closeErr, b.output.Close())
+	})
+	return b.closeErr
+}
+
+// ringBuffer keeps the last bytes written to it.
+type ringBuffer struct {
+	mu   sync.Mutex
+	buf  []byte
+	pos  int // where the next byte is written
+	full bool
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{buf: make([]byte, size)}
+}
+
+// Write appends p, overwriting the oldest bytes when the buffer is full. It never fails.
+func (r *ringBuffer) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := len(p)
+	if len(p) >= len(r.buf) {
+		copy(r.buf, p[len(p)-len(r.buf):])
+		r.pos, r.full = 0, true
+		return n, nil
+	}
+	if c := copy(r.buf[r.pos:], p); c < len(p) {
+		copy(r.buf, p[c:])
+	}
+	if r.pos+len(p) >= len(r.buf) {
+		r.full = true
+	}
+	r.pos = (r.pos + len(p)) % len(r.buf)
+	return n, nil
+}
+
+// Tail returns a copy of the last n bytes.
+func (r *ringBuffer) Tail(n int) []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	length := r.pos
+	if r.full {
+		length = len(r.buf)
+	}
+	n = max(min(n, length), 0)
+	tail := make([]byte, n)
+	start := r.pos - n
+	if start >= 0 {
+		copy(tail, r.buf[start:r.pos])
+		return tail
+	}
+	c := copy(tail, r.buf[len(r.buf)+start:])
+	copy(tail[c:], r.buf[:r.pos])
+	return tail
+}
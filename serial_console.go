@@ -4,10 +4,63 @@ package vz
 #cgo darwin CFLAGS: -mmacosx-version-min=11 -x objective-c -fno-objc-arc
 #cgo darwin LDFLAGS: -lobjc -framework Foundation -framework Virtualization
 # include "virtualization_11.h"
+# include <fcntl.h>
+# include <stdlib.h>
+# include <string.h>
+# include <termios.h>
+# include <unistd.h>
+
+// Same settings as "Running Linux in a Virtual Machine" sample code:
+// disable local echo, input canonicalization and CR-NL mapping.
+static int makeRawTerminal(int fd, struct termios *saved)
+{
+	if (tcgetattr(fd, saved) < 0) {
+		return -1;
+	}
+	struct termios attr = *saved;
+	attr.c_iflag &= ~ICRNL;
+	attr.c_lflag &= ~(ICANON | ECHO);
+	attr.c_cc[VMIN] = 1;
+	attr.c_cc[VTIME] = 0;
+	return tcsetattr(fd, TCSANOW, &attr);
+}
+
+static int restoreTerminal(int fd, struct termios *saved)
+{
+	return tcsetattr(fd, TCSANOW, saved);
+}
+
+// openPTY opens a new pseudo terminal in raw mode. The name of the terminal device
+// must be freed by the caller.
+static int openPTY(char **name)
+{
+	int fd = posix_openpt(O_RDWR | O_NOCTTY);
+	if (fd < 0) {
+		return -1;
+	}
+	if (grantpt(fd) < 0 || unlockpt(fd) < 0) {
+		close(fd);
+		return -1;
+	}
+	struct termios attr;
+	if (tcgetattr(fd, &attr) == 0) {
+		cfmakeraw(&attr);
+		tcsetattr(fd, TCSANOW, &attr);
+	}
+	char *n = ptsname(fd);
+	if (n == NULL) {
+		close(fd);
+		return -1;
+	}
+	*name = strdup(n);
+	return fd;
+}
 */
 import "C"
 import (
+	"fmt"
 	"os"
+	"unsafe"
 
 	"github.com/Code-Hex/vz/v3/internal/objc"
 )
@@ -63,6 +116,78 @@ func NewFileHandleSerialPortAttachment(read, write *os.File) (*FileHandleSerialP
 	return attachment, nil
 }
 
+// NewStdioSerialPortAttachment initialize the FileHandleSerialPortAttachment from the standard
+// input and output of the process, which is the typical console of a headless virtual machine.
+//
+// If the standard input is a terminal, it is put into raw mode (no local echo, no line buffering)
+// so that every key is sent to the guest. Call restore to reset the terminal before the process exits.
+// restore does nothing if the standard input is not a terminal.
+//
+// This is only supported on macOS 11 and newer, error will
+// be returned on older versions.
+func NewStdioSerialPortAttachment() (attachment *FileHandleSerialPortAttachment, restore func() error, err error) {
+	attachment, err = NewFileHandleSerialPortAttachment(os.Stdin, os.Stdout)
+	if err != nil {
+		return nil, nil, err
+	}
+	restore = func() error { return nil }
+
+	fd := C.int(os.Stdin.Fd())
+	if C.isatty(fd) != 1 {
+		return attachment, restore, nil
+	}
+	saved := (*C.struct_termios)(C.malloc(C.sizeof_struct_termios))
+	if ret, err := C.makeRawTerminal(fd, saved); ret != 0 {
+		C.free(unsafe.Pointer(saved))
+		return nil, nil, fmt.Errorf("failed to put the terminal into raw mode: %w", err)
+	}
+	restored := false
+	restore = func() error {
+		if restored {
+			return nil
+		}
+		restored = true
+		defer C.free(unsafe.Pointer(saved))
+		if ret, err := C.restoreTerminal(fd, saved); ret != 0 {
+			return fmt.Errorf("failed to restore the terminal: %w", err)
+		}
+		return nil
+	}
+	return attachment, restore, nil
+}
+
+// NewPTYSerialPortAttachment initialize the FileHandleSerialPortAttachment with a new pseudo terminal.
+// path is the terminal device (e.g. "/dev/ttys004") which is connected to the serial port, so you can
+// attach to the console of a headless virtual machine with a terminal program, e.g. "screen <path>".
+//
+// The pseudo terminal stays open until the process exits, because the virtual machine may use
+// the attachment after it is garbage collected on the Go side.
+//
+// This is only supported on macOS 11 and newer, error will
+// be returned on older versions.
+func NewPTYSerialPortAttachment() (attachment *FileHandleSerialPortAttachment, path string, err error) {
+	if err := macOSAvailable(11); err != nil {
+		return nil, "", err
+	}
+	var name *C.char
+	fd, err := C.openPTY(&name)
+	if fd < 0 {
+		return nil, "", fmt.Errorf("failed to open a pseudo terminal: %w", err)
+	}
+	path = C.GoString(name)
+	C.free(unsafe.Pointer(name))
+
+	attachment = &FileHandleSerialPortAttachment{
+		pointer: objc.NewPointer(
+			C.newVZFileHandleSerialPortAttachment(fd, fd),
+		),
+	}
+	objc.SetFinalizer(attachment, func(self *FileHandleSerialPortAttachment) {
+		objc.Release(self)
+	})
+	return attachment, path, nil
+}
+
 var _ SerialPortAttachment = (*FileSerialPortAttachment)(nil)
 
 // FileSerialPortAttachment defines a serial port attachment from a file.
@@ -9,7 +9,13 @@ package vz
 */
 import "C"
 import (
+	"errors"
+	"fmt"
+	"maps"
 	"os"
+	"slices"
+	"strings"
+	"unsafe"
 
 	"github.com/Code-Hex/vz/v3/internal/objc"
 )
@@ -34,6 +40,9 @@ type VirtioFileSystemDeviceConfiguration struct {
 	*pointer
 
 	*baseDirectorySharingDeviceConfiguration
+
+	tag   string
+	share DirectoryShare
 }
 
 // NewVirtioFileSystemDeviceConfiguration create a new VirtioFileSystemDeviceConfiguration.
@@ -52,6 +61,7 @@ func NewVirtioFileSystemDeviceConfiguration(tag string) (*VirtioFileSystemDevice
 		pointer: objc.NewPointer(
 			C.newVZVirtioFileSystemDeviceConfiguration(tagChar.CString(), &nserrPtr),
 		),
+		tag: tag,
 	}
 	if err := newNSError(nserrPtr); err != nil {
 		return nil, err
@@ -65,11 +75,138 @@ func NewVirtioFileSystemDeviceConfiguration(tag string) (*VirtioFileSystemDevice
 // SetDirectoryShare sets the directory share associated with this configuration.
 func (c *VirtioFileSystemDeviceConfiguration) SetDirectoryShare(share DirectoryShare) {
 	C.setVZVirtioFileSystemDeviceConfigurationShare(objc.Ptr(c), objc.Ptr(share))
+	c.share = share
+}
+
+// Tag returns the tag which the guest uses to mount the device.
+func (c *VirtioFileSystemDeviceConfiguration) Tag() string {
+	return c.tag
+}
+
+// DirectoryShare returns the directory share which was set with SetDirectoryShare, or nil.
+func (c *VirtioFileSystemDeviceConfiguration) DirectoryShare() DirectoryShare {
+	return c.share
+}
+
+// DirectorySharingDevice is a directory sharing device of a virtual machine.
+//
+// see: https://developer.apple.com/documentation/virtualization/vzdirectorysharingdevice?language=objc
+type DirectorySharingDevice interface {
+	objc.NSObject
+
+	directorySharingDevice()
+}
+
+type baseDirectorySharingDevice struct{}
+
+func (*baseDirectorySharingDevice) directorySharingDevice() {}
+
+// DirectorySharingDevices returns the list of directory sharing devices configured on this virtual machine.
+//
+// Returns an empty array if no directory sharing device is configured.
+//
+// This is only supported on macOS 12 and newer, nil will
+// be returned on older versions.
+func (v *VirtualMachine) DirectorySharingDevices() []DirectorySharingDevice {
+	return runtimeDevices(12,
+		func() unsafe.Pointer {
+			return C.VZVirtualMachine_directorySharingDevices(objc.Ptr(v), v.dispatchQueue)
+		},
+		func(ptr unsafe.Pointer) DirectorySharingDevice {
+			// VirtioFileSystemDevice is currently the only directory sharing device.
+			return &VirtioFileSystemDevice{
+				pointer: objc.NewPointer(ptr),
+				vm:      v,
+			}
+		},
+	)
+}
+
+// DirectoryShareInfo describes a directory sharing device as it was configured.
+type DirectoryShareInfo struct {
+	// Tag is the tag which the guest uses to mount the device.
+	Tag string
+	// Directories are the host directories which are shared, sorted by Name. Name is empty for
+	// a SingleDirectoryShare. It is empty for a share without host directories, such as
+	// LinuxRosettaDirectoryShare, or a device without a share.
+	Directories []SharedDirectoryEntry
+}
+
+// DirectoryShares returns the tags and host directories of the directory sharing devices of the
+// virtual machine, as they were set in the configuration when the virtual machine was created,
+// e.g. to find out why a mount is missing in the guest. Use DirectorySharingDevices to access
+// the devices of the running virtual machine.
+func (v *VirtualMachine) DirectoryShares() []DirectoryShareInfo {
+	return directoryShareInfos(v.directorySharingDevices)
+}
+
+func directoryShareInfos(configs []DirectorySharingDeviceConfiguration) []DirectoryShareInfo {
+	infos := make([]DirectoryShareInfo, 0, len(configs))
+	for _, config := range configs {
+		fs, ok := config.(*VirtioFileSystemDeviceConfiguration)
+		if !ok {
+			continue
+		}
+		info := DirectoryShareInfo{Tag: fs.Tag()}
+		switch share := fs.DirectoryShare().(type) {
+		case *SingleDirectoryShare:
+			info.Directories = []SharedDirectoryEntry{newSharedDirectoryEntry("", share.Directory())}
+		case *MultipleDirectoryShare:
+			for _, name := range slices.Sorted(maps.Keys(share.directories)) {
+				info.Directories = append(info.Directories, newSharedDirectoryEntry(name, share.directories[name]))
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+func newSharedDirectoryEntry(name string, dir *SharedDirectory) SharedDirectoryEntry {
+	return SharedDirectoryEntry{Name: name, Path: dir.Path(), ReadOnly: dir.ReadOnly()}
+}
+
+var _ DirectorySharingDevice = (*VirtioFileSystemDevice)(nil)
+
+// VirtioFileSystemDevice is a Virtio file system device of a running virtual machine,
+// which is created from a VirtioFileSystemDeviceConfiguration.
+//
+// see: https://developer.apple.com/documentation/virtualization/vzvirtiofilesystemdevice?language=objc
+type VirtioFileSystemDevice struct {
+	*pointer
+	vm *VirtualMachine
+
+	*baseDirectorySharingDevice
+}
+
+// Tag returns the tag which the guest uses to mount the device.
+func (d *VirtioFileSystemDevice) Tag() string {
+	return C.GoString(C.VZVirtioFileSystemDevice_tag(objc.Ptr(d)))
+}
+
+// SetShare replaces the directory share of the device. This can be done while the virtual
+// machine is running: the guest keeps the tag mounted and sees the new directories.
+// A nil share removes the shared directories from the device.
+//
+// This is only supported on macOS 12 and newer, error will
+// be returned on older versions.
+func (d *VirtioFileSystemDevice) SetShare(share DirectoryShare) error {
+	if err := macOSAvailable(12); err != nil {
+		return err
+	}
+	var ptr unsafe.Pointer
+	if share != nil {
+		ptr = objc.Ptr(share)
+	}
+	C.VZVirtioFileSystemDevice_setShare(objc.Ptr(d), d.vm.dispatchQueue, ptr)
+	return nil
 }
 
 // SharedDirectory is a shared directory.
 type SharedDirectory struct {
 	*pointer
+
+	path     string
+	readOnly bool
 }
 
 // NewSharedDirectory creates a new shared directory.
@@ -90,6 +227,8 @@ func NewSharedDirectory(dirPath string, readOnly bool) (*SharedDirectory, error)
 		pointer: objc.NewPointer(
 			C.newVZSharedDirectory(dirPathChar.CString(), C.bool(readOnly)),
 		),
+		path:     dirPath,
+		readOnly: readOnly,
 	}
 	objc.SetFinalizer(sd, func(self *SharedDirectory) {
 		objc.Release(self)
@@ -97,6 +236,16 @@ func NewSharedDirectory(dirPath string, readOnly bool) (*SharedDirectory, error)
 	return sd, nil
 }
 
+// Path returns the path of the directory on the host.
+func (s *SharedDirectory) Path() string {
+	return s.path
+}
+
+// ReadOnly reports whether the guest can only read the directory.
+func (s *SharedDirectory) ReadOnly() bool {
+	return s.readOnly
+}
+
 // DirectoryShare is the base interface for a directory share.
 type DirectoryShare interface {
 	objc.NSObject
@@ -115,6 +264,8 @@ type SingleDirectoryShare struct {
 	*pointer
 
 	*baseDirectoryShare
+
+	directory *SharedDirectory
 }
 
 // NewSingleDirectoryShare creates a new single directory share.
@@ -129,6 +280,7 @@ func NewSingleDirectoryShare(share *SharedDirectory) (*SingleDirectoryShare, err
 		pointer: objc.NewPointer(
 			C.newVZSingleDirectoryShare(objc.Ptr(share)),
 		),
+		directory: share,
 	}
 	objc.SetFinalizer(config, func(self *SingleDirectoryShare) {
 		objc.Release(self)
@@ -136,11 +288,18 @@ func NewSingleDirectoryShare(share *SharedDirectory) (*SingleDirectoryShare, err
 	return config, nil
 }
 
+// Directory returns the shared directory.
+func (s *SingleDirectoryShare) Directory() *SharedDirectory {
+	return s.directory
+}
+
 // MultipleDirectoryShare defines the directory share for multiple directories.
 type MultipleDirectoryShare struct {
 	*pointer
 
 	*baseDirectoryShare
+
+	directories map[string]*SharedDirectory
 }
 
 var _ DirectoryShare = (*MultipleDirectoryShare)(nil)
@@ -155,15 +314,18 @@ func NewMultipleDirectoryShare(shares map[string]*SharedDirectory) (*MultipleDir
 	}
 	directories := make(map[string]objc.NSObject, len(shares))
 	for k, v := range shares {
+		if err := validateSharedDirectoryName(k); err != nil {
+			return nil, err
+		}
 		directories[k] = v
 	}
-
 	dict := objc.ConvertToNSMutableDictionary(directories)
 
 	config := &MultipleDirectoryShare{
 		pointer: objc.NewPointer(
 			C.newVZMultipleDirectoryShare(objc.Ptr(dict)),
 		),
+		directories: maps.Clone(shares),
 	}
 	objc.SetFinalizer(config, func(self *MultipleDirectoryShare) {
 		objc.Release(self)
@@ -171,6 +333,76 @@ func NewMultipleDirectoryShare(shares map[string]*SharedDirectory) (*MultipleDir
 	return config, nil
 }
 
+// Directories returns the shared directories by their name in the guest mount point.
+func (s *MultipleDirectoryShare) Directories() map[string]*SharedDirectory {
+	return maps.Clone(s.directories)
+}
+
+// ErrInvalidSharedDirectoryName is returned when the name of a directory in MultipleDirectoryShare
+// is not usable as a directory name in the guest.
+var ErrInvalidSharedDirectoryName = errors.New("invalid shared directory name")
+
+// ErrDuplicateSharedDirectoryName is returned by NewMultipleDirectoryShareWithEntries when
+// the same name is used more than once.
+var ErrDuplicateSharedDirectoryName = errors.New("duplicate shared directory name")
+
+// SharedDirectoryEntry describes a host directory which is shared in a MultipleDirectoryShare.
+type SharedDirectoryEntry struct {
+	// Name is the name of the directory in the guest mount point.
+	Name string
+	// Path is the path of the directory on the host.
+	Path string
+	// ReadOnly indicates whether the guest can only read the directory.
+	ReadOnly bool
+}
+
+// NewMultipleDirectoryShareWithEntries creates a new multiple directories share from entries
+// in one call, e.g. sharing "/src" as read-write and "/deps" as read-only.
+//
+// ErrInvalidSharedDirectoryName is returned if a name is empty, ".", ".." or contains "/".
+// ErrDuplicateSharedDirectoryName is returned if a name is used more than once.
+//
+// This is only supported on macOS 12 and newer, error will
+// be returned on older versions.
+func NewMultipleDirectoryShareWithEntries(entries ...SharedDirectoryEntry) (*MultipleDirectoryShare, error) {
+	if err := macOSAvailable(12); err != nil {
+		return nil, err
+	}
+	if err := validateSharedDirectoryEntries(entries); err != nil {
+		return nil, err
+	}
+	shares := make(map[string]*SharedDirectory, len(entries))
+	for _, entry := range entries {
+		sd, err := NewSharedDirectory(entry.Path, entry.ReadOnly)
+		if err != nil {
+			return nil, err
+		}
+		shares[entry.Name] = sd
+	}
+	return NewMultipleDirectoryShare(shares)
+}
+
+func validateSharedDirectoryEntries(entries []SharedDirectoryEntry) error {
+	seen := make(map[string]struct{}, len(entries))
+	for _, entry := range entries {
+		if err := validateSharedDirectoryName(entry.Name); err != nil {
+			return err
+		}
+		if _, ok := seen[entry.Name]; ok {
+			return fmt.Errorf("%w: %q", ErrDuplicateSharedDirectoryName, entry.Name)
+		}
+		seen[entry.Name] = struct{}{}
+	}
+	return nil
+}
+
+func validateSharedDirectoryName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\x00") {
+		return fmt.Errorf("%w: %q", ErrInvalidSharedDirectoryName, name)
+	}
+	return nil
+}
+
 // MacOSGuestAutomountTag returns the macOS automount tag.
 //
 // A device configured with this tag will be automatically mounted in a macOS guest.
@@ -11,6 +11,7 @@ package vz
 */
 import "C"
 import (
+	"errors"
 	"fmt"
 	"runtime/cgo"
 	"unsafe"
@@ -47,6 +48,17 @@ func linuxInstallRosettaWithCompletionHandler(cgoHandleUintptr C.uintptr_t, errP
 	}
 }
 
+var (
+	// ErrRosettaNotSupported is returned by NewLinuxRosettaDirectoryShare when Rosetta support
+	// for Linux binaries is not available on the host (e.g. the host is not Apple silicon).
+	ErrRosettaNotSupported = errors.New("rosetta support for Linux binaries is not supported on this host")
+
+	// ErrRosettaNotInstalled is returned by NewLinuxRosettaDirectoryShare when Rosetta support
+	// for Linux binaries is supported but not installed. It can be installed with
+	// LinuxRosettaDirectoryShareInstallRosetta.
+	ErrRosettaNotInstalled = errors.New("rosetta support for Linux binaries is not installed")
+)
+
 // LinuxRosettaDirectoryShare directory share to enable Rosetta support for Linux binaries.
 // see: https://developer.apple.com/documentation/virtualization/vzlinuxrosettadirectoryshare?language=objc
 type LinuxRosettaDirectoryShare struct {
@@ -60,12 +72,20 @@ var _ DirectoryShare = (*LinuxRosettaDirectoryShare)(nil)
 // NewLinuxRosettaDirectoryShare creates a new Rosetta directory share if Rosetta support
 // for Linux binaries is installed.
 //
+// ErrRosettaNotSupported is returned if the host does not support Rosetta for Linux binaries and
+// ErrRosettaNotInstalled is returned if it is not installed yet, so callers can offer to install
+// it with LinuxRosettaDirectoryShareInstallRosetta.
+//
 // This is only supported on macOS 13 and newer, error will
 // be returned on older versions.
 func NewLinuxRosettaDirectoryShare() (*LinuxRosettaDirectoryShare, error) {
 	if err := macOSAvailable(13); err != nil {
 		return nil, err
 	}
+	availability := LinuxRosettaDirectoryShareAvailability()
+	if err := linuxRosettaDirectoryShareError(availability, nil); err != nil {
+		return nil, err
+	}
 	nserrPtr := newNSErrorAsNil()
 	ds := &LinuxRosettaDirectoryShare{
 		pointer: objc.NewPointer(
@@ -73,7 +93,8 @@ func NewLinuxRosettaDirectoryShare() (*LinuxRosettaDirectoryShare, error) {
 		),
 	}
 	if err := newNSError(nserrPtr); err != nil {
-		return nil, err
+		// The availability may have changed since it was checked.
+		return nil, linuxRosettaDirectoryShareError(LinuxRosettaDirectoryShareAvailability(), err)
 	}
 	objc.SetFinalizer(ds, func(self *LinuxRosettaDirectoryShare) {
 		objc.Release(self)
@@ -120,6 +141,30 @@ func LinuxRosettaDirectoryShareAvailability() LinuxRosettaAvailability {
 	return LinuxRosettaAvailability(C.availabilityVZLinuxRosettaDirectoryShare())
 }
 
+// linuxRosettaDirectoryShareError maps the availability and the error returned by the framework
+// to ErrRosettaNotSupported or ErrRosettaNotInstalled. The framework error is kept in the chain.
+func linuxRosettaDirectoryShareError(availability LinuxRosettaAvailability, err error) error {
+	var typed error
+	switch availability {
+	case LinuxRosettaAvailabilityNotSupported:
+		typed = ErrRosettaNotSupported
+	case LinuxRosettaAvailabilityNotInstalled:
+		typed = ErrRosettaNotInstalled
+	}
+	var nserr *NSError
+	if typed == nil && errors.As(err, &nserr) && nserr.Domain == ErrorDomain && nserr.Code == int(ErrorNotSupported) {
+		typed = ErrRosettaNotSupported
+	}
+	switch {
+	case typed == nil:
+		return err
+	case err == nil:
+		return typed
+	default:
+		return fmt.Errorf("%w: %w", typed, err)
+	}
+}
+
 // LinuxRosettaCachingOptions for a directory sharing device configuration.
 type LinuxRosettaCachingOptions interface {
 	objc.NSObject
@@ -4,6 +4,7 @@
 package vz_test
 
 import (
+	"errors"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -39,6 +40,75 @@ func TestLinuxRosettaAvailabilityString(t *testing.T) {
 	}
 }
 
+func TestLinuxRosettaDirectoryShareError(t *testing.T) {
+	nserrNotSupported := &vz.NSError{
+		Domain: "VZErrorDomain",
+		Code:   int(vz.ErrorNotSupported),
+	}
+	nserrInternal := &vz.NSError{
+		Domain: "VZErrorDomain",
+		Code:   int(vz.ErrorInternal),
+	}
+	cases := []struct {
+		name         string
+		availability vz.LinuxRosettaAvailability
+		err          error
+		want         []error
+	}{
+		{
+			name:         "not supported",
+			availability: vz.LinuxRosettaAvailabilityNotSupported,
+			want:         []error{vz.ErrRosettaNotSupported},
+		},
+		{
+			name:         "not installed",
+			availability: vz.LinuxRosettaAvailabilityNotInstalled,
+			want:         []error{vz.ErrRosettaNotInstalled},
+		},
+		{
+			name:         "not installed with framework error",
+			availability: vz.LinuxRosettaAvailabilityNotInstalled,
+			err:          nserrInternal,
+			want:         []error{vz.ErrRosettaNotInstalled, nserrInternal},
+		},
+		{
+			name:         "framework reports not supported",
+			availability: vz.LinuxRosettaAvailabilityInstalled,
+			err:          nserrNotSupported,
+			want:         []error{vz.ErrRosettaNotSupported, nserrNotSupported},
+		},
+		{
+			name:         "installed with other framework error",
+			availability: vz.LinuxRosettaAvailabilityInstalled,
+			err:          nserrInternal,
+			want:         []error{nserrInternal},
+		},
+		{
+			name:         "installed",
+			availability: vz.LinuxRosettaAvailabilityInstalled,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := vz.LinuxRosettaDirectoryShareError(tc.availability, tc.err)
+			if len(tc.want) == 0 {
+				if got != nil {
+					t.Fatalf("want nil but got %v", got)
+				}
+				return
+			}
+			for _, want := range tc.want {
+				if !errors.Is(got, want) {
+					t.Errorf("want %v in the chain of %v", want, got)
+				}
+			}
+			if errors.Is(got, vz.ErrRosettaNotSupported) && errors.Is(got, vz.ErrRosettaNotInstalled) {
+				t.Errorf("want either of typed errors but got %v", got)
+			}
+		})
+	}
+}
+
 func TestNewLinuxRosettaUnixSocketCachingOptions(t *testing.T) {
 	if vz.Available(14) {
 		t.Skip("NewLinuxRosettaUnixSocketCachingOptions is supported from macOS 14")
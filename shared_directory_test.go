@@ -2,10 +2,12 @@ package vz_test
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -241,3 +243,265 @@ func TestMultipleDirectoryShare(t *testing.T) {
 		t.Fatalf("expected the file to exist in read/write directory: %v", err)
 	}
 }
+
+func TestValidateSharedDirectoryEntries(t *testing.T) {
+	cases := []struct {
+		name    string
+		entries []vz.SharedDirectoryEntry
+		wantErr error
+	}{
+		{
+			name: "valid",
+			entries: []vz.SharedDirectoryEntry{
+				{Name: "src", Path: "/src"},
+				{Name: "deps", Path: "/deps", ReadOnly: true},
+			},
+		},
+		{
+			name: "duplicate name",
+			entries: []vz.SharedDirectoryEntry{
+				{Name: "src", Path: "/src"},
+				{Name: "src", Path: "/deps", ReadOnly: true},
+			},
+			wantErr: vz.ErrDuplicateSharedDirectoryName,
+		},
+		{
+			name:    "empty name",
+			entries: []vz.SharedDirectoryEntry{{Name: "", Path: "/src"}},
+			wantErr: vz.ErrInvalidSharedDirectoryName,
+		},
+		{
+			name:    "dot dot",
+			entries: []vz.SharedDirectoryEntry{{Name: "..", Path: "/src"}},
+			wantErr: vz.ErrInvalidSharedDirectoryName,
+		},
+		{
+			name:    "contains slash",
+			entries: []vz.SharedDirectoryEntry{{Name: "a/b", Path: "/src"}},
+			wantErr: vz.ErrInvalidSharedDirectoryName,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := vz.ValidateSharedDirectoryEntries(tc.entries)
+			if tc.wantErr == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("want %v but got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestMultipleDirectoryShareWithEntries(t *testing.T) {
+	if vz.Available(12) {
+		t.Skip("MultipleDirectoryShare is supported from macOS 12")
+	}
+
+	srcDir := t.TempDir()
+	depsDir := t.TempDir()
+	multiple, err := vz.NewMultipleDirectoryShareWithEntries(
+		vz.SharedDirectoryEntry{Name: "src", Path: srcDir},
+		vz.SharedDirectoryEntry{Name: "deps", Path: depsDir, ReadOnly: true},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tag := "multiple"
+	fileSystemDeviceConfig, err := vz.NewVirtioFileSystemDeviceConfiguration(tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSystemDeviceConfig.SetDirectoryShare(multiple)
+
+	container := newVirtualizationMachine(t,
+		func(vmc *vz.VirtualMachineConfiguration) error {
+			vmc.SetDirectorySharingDevicesVirtualMachineConfiguration(
+				[]vz.DirectorySharingDeviceConfiguration{
+					fileSystemDeviceConfig,
+				},
+			)
+			return nil
+		},
+	)
+	t.Cleanup(func() {
+		if err := container.Shutdown(); err != nil {
+			log.Println(err)
+		}
+	})
+
+	for _, v := range []struct {
+		cmd     string
+		wantErr bool
+	}{
+		{
+			cmd:     fmt.Sprintf("mkdir -p /mnt/shared && mount -t virtiofs %s /mnt/shared", tag),
+			wantErr: false,
+		},
+		{
+			cmd:     "touch /mnt/shared/src/hello.txt",
+			wantErr: false,
+		},
+		{
+			cmd:     "touch /mnt/shared/deps/hello.txt",
+			wantErr: true,
+		},
+	} {
+		session := container.NewSession(t)
+		var buf bytes.Buffer
+		session.Stderr = &buf
+		err := session.Run(v.cmd)
+		session.Close()
+		if (err != nil) != v.wantErr {
+			t.Fatalf("command %q: want error %v but got %v\nstderr: %q", v.cmd, v.wantErr, err, buf.String())
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(srcDir, "hello.txt")); err != nil {
+		t.Fatalf("expected the file to exist in read/write directory: %v", err)
+	}
+
+	if _, err := vz.NewMultipleDirectoryShareWithEntries(
+		vz.SharedDirectoryEntry{Name: "src", Path: srcDir},
+		vz.SharedDirectoryEntry{Name: "src", Path: depsDir},
+	); !errors.Is(err, vz.ErrDuplicateSharedDirectoryName) {
+		t.Fatalf("want ErrDuplicateSharedDirectoryName but got %v", err)
+	}
+}
+
+func TestVirtioFileSystemDeviceSetShare(t *testing.T) {
+	if vz.Available(12) {
+		t.Skip("VirtioFileSystemDevice is supported from macOS 12")
+	}
+
+	newShare := func(t *testing.T) *vz.SingleDirectoryShare {
+		t.Helper()
+		sharedDirectory, err := vz.NewSharedDirectory(t.TempDir(), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		share, err := vz.NewSingleDirectoryShare(sharedDirectory)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return share
+	}
+
+	bootLoader, err := vz.NewLinuxBootLoader("./testdata/Image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := setupConfiguration(bootLoader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsConfig, err := vz.NewVirtioFileSystemDeviceConfiguration("share")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsConfig.SetDirectoryShare(newShare(t))
+	config.SetDirectorySharingDevicesVirtualMachineConfiguration([]vz.DirectorySharingDeviceConfiguration{
+		fsConfig,
+	})
+	vm, err := vz.NewVirtualMachine(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	devices := vm.DirectorySharingDevices()
+	if len(devices) != 1 {
+		t.Fatalf("want 1 directory sharing device but got %d", len(devices))
+	}
+	device, ok := devices[0].(*vz.VirtioFileSystemDevice)
+	if !ok {
+		t.Fatalf("want *vz.VirtioFileSystemDevice but got %T", devices[0])
+	}
+	if got := device.Tag(); got != "share" {
+		t.Fatalf("want tag %q but got %q", "share", got)
+	}
+
+	// Swap the share, then remove it.
+	if err := device.SetShare(newShare(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := device.SetShare(nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDirectoryShares(t *testing.T) {
+	if vz.Available(12) {
+		t.Skip("VirtioFileSystemDeviceConfiguration is supported from macOS 12")
+	}
+
+	srcDir, depsDir, cacheDir := t.TempDir(), t.TempDir(), t.TempDir()
+	src, err := vz.NewSharedDirectory(srcDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	single, err := vz.NewSingleDirectoryShare(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srcConfig, err := vz.NewVirtioFileSystemDeviceConfiguration("src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srcConfig.SetDirectoryShare(single)
+
+	multiple, err := vz.NewMultipleDirectoryShareWithEntries(
+		vz.SharedDirectoryEntry{Name: "deps", Path: depsDir, ReadOnly: true},
+		vz.SharedDirectoryEntry{Name: "cache", Path: cacheDir},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toolsConfig, err := vz.NewVirtioFileSystemDeviceConfiguration("tools")
+	if err != nil {
+		t.Fatal(err)
+	}
+	toolsConfig.SetDirectoryShare(multiple)
+
+	// A device without a share is listed without directories.
+	emptyConfig, err := vz.NewVirtioFileSystemDeviceConfiguration("empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bootLoader, err := vz.NewLinuxBootLoader("./testdata/Image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := setupConfiguration(bootLoader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs := []vz.DirectorySharingDeviceConfiguration{srcConfig, toolsConfig, emptyConfig}
+	config.SetDirectorySharingDevicesVirtualMachineConfiguration(configs)
+	if got := config.DirectorySharingDevices(); len(got) != len(configs) {
+		t.Fatalf("want %d directory sharing device configurations but got %d", len(configs), len(got))
+	}
+	vm, err := vz.NewVirtualMachine(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Later changes to the configuration do not apply to the virtual machine.
+	config.SetDirectorySharingDevicesVirtualMachineConfiguration(nil)
+
+	want := []vz.DirectoryShareInfo{
+		{Tag: "src", Directories: []vz.SharedDirectoryEntry{{Path: srcDir}}},
+		{Tag: "tools", Directories: []vz.SharedDirectoryEntry{
+			{Name: "cache", Path: cacheDir},
+			{Name: "deps", Path: depsDir, ReadOnly: true},
+		}},
+		{Tag: "empty"},
+	}
+	if got := vm.DirectoryShares(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %+v but got %+v", want, got)
+	}
+}
@@ -274,6 +274,16 @@ var _ net.Conn = (*VirtioSocketConnection)(nil)
 func newVirtioSocketConnection(ptr unsafe.Pointer) (*VirtioSocketConnection, error) {
 	vzVirtioSocketConnection := C.convertVZVirtioSocketConnection2Flat(ptr)
 	file := os.NewFile((uintptr)(vzVirtioSocketConnection.fileDescriptor), "")
+	return newVirtioSocketConnectionFromFile(
+		file,
+		(uint32)(vzVirtioSocketConnection.destinationPort),
+		(uint32)(vzVirtioSocketConnection.sourcePort),
+	)
+}
+
+// newVirtioSocketConnectionFromFile creates a connection from the unix socket file
+// and closes file, whose file descriptor is duplicated by the connection.
+func newVirtioSocketConnectionFromFile(file *os.File, destinationPort, sourcePort uint32) (*VirtioSocketConnection, error) {
 	defer file.Close()
 	rawConn, err := net.FileConn(file)
 	if err != nil {
@@ -281,8 +291,8 @@ func newVirtioSocketConnection(ptr unsafe.Pointer) (*VirtioSocketConnection, err
 	}
 	conn := &VirtioSocketConnection{
 		rawConn:         rawConn,
-		destinationPort: (uint32)(vzVirtioSocketConnection.destinationPort),
-		sourcePort:      (uint32)(vzVirtioSocketConnection.sourcePort),
+		destinationPort: destinationPort,
+		sourcePort:      sourcePort,
 	}
 	return conn, nil
 }
@@ -298,6 +308,32 @@ func (v *VirtioSocketConnection) Close() error {
 	return v.rawConn.Close()
 }
 
+// CloseWrite shuts down the writing side of the connection (shutdown(2) with SHUT_WR), so the
+// guest reads EOF while the host can still read the response. The file descriptor stays open
+// until Close is called.
+func (v *VirtioSocketConnection) CloseWrite() error {
+	return v.shutdown("close write", func(c halfCloser) error { return c.CloseWrite() })
+}
+
+// CloseRead shuts down the reading side of the connection (shutdown(2) with SHUT_RD).
+func (v *VirtioSocketConnection) CloseRead() error {
+	return v.shutdown("close read", func(c halfCloser) error { return c.CloseRead() })
+}
+
+// halfCloser is implemented by *net.UnixConn, which the connection is made of.
+type halfCloser interface {
+	CloseRead() error
+	CloseWrite() error
+}
+
+func (v *VirtioSocketConnection) shutdown(op string, f func(halfCloser) error) error {
+	c, ok := v.rawConn.(halfCloser)
+	if !ok {
+		return fmt.Errorf("%s of %T: %w", op, v.rawConn, errors.ErrUnsupported)
+	}
+	return f(c)
+}
+
 // LocalAddr returns the local network address.
 func (v *VirtioSocketConnection) LocalAddr() net.Addr { return v.rawConn.LocalAddr() }
 
@@ -2,9 +2,13 @@ package vz_test
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
+	"os"
+	"syscall"
 	"testing"
 	"time"
 
@@ -77,3 +81,54 @@ func TestVirtioSocketListener(t *testing.T) {
 		t.Fatalf("timeout connection handling after accepted")
 	}
 }
+
+func TestVirtioSocketConnectionCloseWrite(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := vz.NewVirtioSocketConnectionFromFile(os.NewFile(uintptr(fds[0]), "host"), 1024, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	guestFile := os.NewFile(uintptr(fds[1]), "guest")
+	guest, err := net.FileConn(guestFile)
+	guestFile.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer guest.Close()
+
+	if _, err := conn.Write([]byte("request")); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The guest reads the request up to EOF, then responds on the other direction.
+	got, err := io.ReadAll(guest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "request" {
+		t.Fatalf("want %q but got %q", "request", got)
+	}
+	if _, err := guest.Write([]byte("response")); err != nil {
+		t.Fatal(err)
+	}
+	guest.Close()
+
+	got, err = io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "response" {
+		t.Fatalf("want %q but got %q", "response", got)
+	}
+
+	if _, err := conn.Write([]byte("more")); !errors.Is(err, syscall.EPIPE) {
+		t.Errorf("want EPIPE for a write after CloseWrite but got %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package vz
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// DefaultRetryableErrorCodes are the error codes of the Virtualization framework which
+// StartWithRetry retries when RetryPolicy.RetryableCodes is nil. They report a resource which
+// may become available again, e.g. another virtual machine stopping below the limit of the host.
+var DefaultRetryableErrorCodes = []ErrorCode{
+	ErrorInternal,
+	ErrorVirtualMachineLimitExceeded,
+}
+
+// RetryPolicy controls how StartWithRetry retries a start which failed transiently.
+// The zero value retries DefaultRetryableErrorCodes up to 3 attempts, waiting 1 second
+// before the first retry and twice as long before each next one, up to 30 seconds.
+type RetryPolicy struct {
+	// MaxAttempts is the number of starts including the first one. Zero means 3.
+	MaxAttempts int
+
+	// InitialBackoff is the wait before the first retry, which doubles for each next
+	// retry. Zero means 1 second.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the wait between retries. Zero means 30 seconds.
+	MaxBackoff time.Duration
+
+	// RetryableCodes are the error codes which are retried. Any other error, such as
+	// ErrorInvalidVirtualMachineConfiguration or an error which is not an *NSError of the
+	// Virtualization framework, is permanent and returned at once.
+	// nil means DefaultRetryableErrorCodes.
+	RetryableCodes []ErrorCode
+}
+
+func (p RetryPolicy) maxAttempts() int {
+	if p.MaxAttempts <= 0 {
+		return 3
+	}
+	return p.MaxAttempts
+}
+
+// backoff returns the wait before the retry which follows the failed attempt (counted from 1).
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d, maxBackoff := p.InitialBackoff, p.MaxBackoff
+	if d <= 0 {
+		d = time.Second
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = 30 * time.Second
+	}
+	for i := 1; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxBackoff)
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	code, ok := ErrorCodeOf(err)
+	if !ok {
+		return false
+	}
+	codes := p.RetryableCodes
+	if codes == nil {
+		codes = DefaultRetryableErrorCodes
+	}
+	return slices.Contains(codes, code)
+}
+
+// StartWithRetry starts the virtual machine like Start, and retries with backoff while the start
+// fails with one of the error codes of policy. It returns the error of the last attempt if the
+// error is permanent or all attempts failed, or the error of ctx if it is done while waiting
+// to retry.
+//
+// A failed start leaves the virtual machine in Stopped or Error state, both of which can be started again.
+func (v *VirtualMachine) StartWithRetry(ctx context.Context, policy RetryPolicy, opts ...VirtualMachineStartOption) error {
+	return retryStart(ctx, policy, func() error { return v.Start(opts...) })
+}
+
+func retryStart(ctx context.Context, policy RetryPolicy, start func() error) error {
+	maxAttempts := policy.maxAttempts()
+	for attempt := 1; ; attempt++ {
+		err := start()
+		if err == nil || !policy.retryable(err) {
+			return err
+		}
+		if attempt == maxAttempts {
+			return fmt.Errorf("failed to start after %d attempts: %w", attempt, err)
+		}
+		timer := time.NewTimer(policy.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w: start is not retried after: %w", ctx.Err(), err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package vz_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Code-Hex/vz/v3"
+)
+
+func newStartError(code vz.ErrorCode) error {
+	return fmt.Errorf("start: %w", &vz.NSError{Domain: vz.ErrorDomain, Code: int(code)})
+}
+
+func TestRetryStart(t *testing.T) {
+	policy := vz.RetryPolicy{MaxAttempts: 4, InitialBackoff: time.Millisecond}
+	cases := []struct {
+		name         string
+		errs         []error
+		policy       vz.RetryPolicy
+		wantAttempts int
+		wantCode     vz.ErrorCode
+	}{
+		{
+			name:         "success",
+			errs:         []error{nil},
+			policy:       policy,
+			wantAttempts: 1,
+		},
+		{
+			name:         "transient then success",
+			errs:         []error{newStartError(vz.ErrorVirtualMachineLimitExceeded), newStartError(vz.ErrorInternal), nil},
+			policy:       policy,
+			wantAttempts: 3,
+		},
+		{
+			name:         "permanent",
+			errs:         []error{newStartError(vz.ErrorInvalidVirtualMachineConfiguration), nil},
+			policy:       policy,
+			wantAttempts: 1,
+			wantCode:     vz.ErrorInvalidVirtualMachineConfiguration,
+		},
+		{
+			name:         "permanent after transient",
+			errs:         []error{newStartError(vz.ErrorInternal), newStartError(vz.ErrorInvalidVirtualMachineConfiguration), nil},
+			policy:       policy,
+			wantAttempts: 2,
+			wantCode:     vz.ErrorInvalidVirtualMachineConfiguration,
+		},
+		{
+			name:         "attempts exhausted",
+			errs:         []error{newStartError(vz.ErrorInternal), newStartError(vz.ErrorInternal), newStartError(vz.ErrorInternal), newStartError(vz.ErrorInternal), nil},
+			policy:       policy,
+			wantAttempts: 4,
+			wantCode:     vz.ErrorInternal,
+		},
+		{
+			name:         "custom codes",
+			errs:         []error{newStartError(vz.ErrorOutOfDiskSpace), newStartError(vz.ErrorInternal), nil},
+			policy:       vz.RetryPolicy{InitialBackoff: time.Millisecond, RetryableCodes: []vz.ErrorCode{vz.ErrorOutOfDiskSpace}},
+			wantAttempts: 2,
+			wantCode:     vz.ErrorInternal,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			attempts := 0
+			err := vz.RetryStart(context.Background(), tc.policy, func() error {
+				err := tc.errs[attempts]
+				attempts++
+				return err
+			})
+			if attempts != tc.wantAttempts {
+				t.Errorf("want %d attempts but got %d", tc.wantAttempts, attempts)
+			}
+			if tc.wantCode == 0 {
+				if err != nil {
+					t.Fatalf("want no error but got %v", err)
+				}
+				return
+			}
+			if code, ok := vz.ErrorCodeOf(err); !ok || code != tc.wantCode {
+				t.Fatalf("want %v but got %v", tc.wantCode, err)
+			}
+		})
+	}
+
+	t.Run("not an NSError", func(t *testing.T) {
+		attempts := 0
+		err := vz.RetryStart(context.Background(), policy, func() error {
+			attempts++
+			return vz.ErrInvalidVirtualMachineState
+		})
+		if attempts != 1 || !errors.Is(err, vz.ErrInvalidVirtualMachineState) {
+			t.Fatalf("want a single attempt with ErrInvalidVirtualMachineState but got %d, %v", attempts, err)
+		}
+	})
+
+	t.Run("context done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		attempts := 0
+		err := vz.RetryStart(ctx, vz.RetryPolicy{InitialBackoff: time.Hour}, func() error {
+			attempts++
+			cancel()
+			return newStartError(vz.ErrorInternal)
+		})
+		if attempts != 1 || !errors.Is(err, context.Canceled) {
+			t.Fatalf("want a single attempt with context.Canceled but got %d, %v", attempts, err)
+		}
+		if code, ok := vz.ErrorCodeOf(err); !ok || code != vz.ErrorInternal {
+			t.Errorf("want the last error of start in %v", err)
+		}
+	})
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := vz.RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if got := policy.Backoff(i + 1); got != w {
+			t.Errorf("attempt %d: want %v but got %v", i+1, w, got)
+		}
+	}
+	if got := (vz.RetryPolicy{}).Backoff(1); got != time.Second {
+		t.Errorf("want default backoff of 1s but got %v", got)
+	}
+}
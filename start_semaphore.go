@@ -0,0 +1,50 @@
+package vz
+
+import (
+	"context"
+	"fmt"
+)
+
+// StartSemaphore bounds the number of virtual machines which are starting at the same time.
+//
+// Starting a virtual machine allocates its memory and boots the guest, so starting many of them
+// at once (e.g. when restoring a session) can exhaust the host and make some starts fail.
+// Share one StartSemaphore between the Start calls with WithStartSemaphore to serialize them.
+type StartSemaphore struct {
+	slots chan struct{}
+}
+
+// NewStartSemaphore creates a new StartSemaphore which allows at most n concurrent starts.
+func NewStartSemaphore(n int) (*StartSemaphore, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("maximum concurrent starts must be at least 1: %d", n)
+	}
+	return &StartSemaphore{
+		slots: make(chan struct{}, n),
+	}, nil
+}
+
+// Acquire blocks until a start slot is available or ctx is done.
+// Call Release once the start has completed.
+func (s *StartSemaphore) Acquire(ctx context.Context) error {
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release returns the slot taken by Acquire.
+func (s *StartSemaphore) Release() {
+	<-s.slots
+}
+
+// WithStartSemaphore makes Start wait for a slot of s before starting the virtual machine,
+// and hold it until the start has completed.
+func WithStartSemaphore(s *StartSemaphore) VirtualMachineStartOption {
+	return func(vmso *virtualMachineStartOptions) error {
+		vmso.startSemaphore = s
+		return nil
+	}
+}
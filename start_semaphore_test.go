@@ -0,0 +1,75 @@
+package vz_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Code-Hex/vz/v3"
+)
+
+func TestStartSemaphore(t *testing.T) {
+	const limit = 2
+	sem, err := vz.NewStartSemaphore(limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var running, maxRunning atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := sem.Acquire(context.Background()); err != nil {
+				t.Error(err)
+				return
+			}
+			defer sem.Release()
+			n := running.Add(1)
+			for {
+				m := maxRunning.Load()
+				if n <= m || maxRunning.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			running.Add(-1)
+		}()
+	}
+	wg.Wait()
+
+	if got := maxRunning.Load(); got != limit {
+		t.Fatalf("want at most %d concurrent starts but got %d", limit, got)
+	}
+}
+
+func TestStartSemaphoreAcquireCanceled(t *testing.T) {
+	sem, err := vz.NewStartSemaphore(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sem.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := sem.Acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want context.DeadlineExceeded but got %v", err)
+	}
+
+	sem.Release()
+	if err := sem.Acquire(context.Background()); err != nil {
+		t.Fatalf("want the released slot to be available but got %v", err)
+	}
+}
+
+func TestNewStartSemaphoreInvalid(t *testing.T) {
+	if _, err := vz.NewStartSemaphore(0); err == nil {
+		t.Fatal("want error for zero concurrent starts")
+	}
+}
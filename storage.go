@@ -11,6 +11,7 @@ package vz
 */
 import "C"
 import (
+	"fmt"
 	"os"
 	"runtime/cgo"
 	"time"
@@ -40,16 +41,40 @@ var _ StorageDeviceAttachment = (*DiskImageStorageDeviceAttachment)(nil)
 //
 // This storage device attachment uses a disk image on the host file system as the drive of the storage device.
 // Only raw data disk images are supported.
+//
+// The Virtualization framework does not provide I/O throttling or priority for storage device attachments.
+// Use (*VirtualMachine).SetQoSClass to lower the priority of a background virtual machine.
 // see: https://developer.apple.com/documentation/virtualization/vzdiskimagestoragedeviceattachment?language=objc
 type DiskImageStorageDeviceAttachment struct {
 	*pointer
 
 	*baseStorageDeviceAttachment
+
+	// file is retained to keep the file descriptor open while the attachment is alive.
+	file *os.File
+
+	readOnly bool
+}
+
+// readOnlyStorageDeviceAttachment is implemented by storage device attachments
+// which can be exposed to the guest as read-only.
+type readOnlyStorageDeviceAttachment interface {
+	ReadOnly() bool
 }
 
+var _ readOnlyStorageDeviceAttachment = (*DiskImageStorageDeviceAttachment)(nil)
+
+// ReadOnly returns whether the disk image is exposed to the guest as read-only.
+//
+// The guest can not write to a read-only disk image, so the same image can be safely
+// attached to multiple virtual machines at the same time.
+func (d *DiskImageStorageDeviceAttachment) ReadOnly() bool { return d.readOnly }
+
 // DiskImageCachingMode describes the disk image caching mode.
 //
 // see: https://developer.apple.com/documentation/virtualization/vzdiskimagecachingmode?language=objc
+//
+//go:generate stringer -type=DiskImageCachingMode
 type DiskImageCachingMode int
 
 const (
@@ -61,6 +86,8 @@ const (
 // DiskImageSynchronizationMode describes the disk image synchronization mode.
 //
 // see: https://developer.apple.com/documentation/virtualization/vzdiskimagesynchronizationmode?language=objc
+//
+//go:generate stringer -type=DiskImageSynchronizationMode
 type DiskImageSynchronizationMode int
 
 const (
@@ -97,6 +124,7 @@ func NewDiskImageStorageDeviceAttachment(diskPath string, readOnly bool) (*DiskI
 				&nserrPtr,
 			),
 		),
+		readOnly: readOnly,
 	}
 	if err := newNSError(nserrPtr); err != nil {
 		return nil, err
@@ -107,6 +135,34 @@ func NewDiskImageStorageDeviceAttachment(diskPath string, readOnly bool) (*DiskI
 	return attachment, nil
 }
 
+// NewDiskImageStorageDeviceAttachmentFromFile initialize the attachment from an opened file.
+// Returns error is not nil, assigned with the error if the initialization failed.
+//
+// This is useful to attach an image generated on the fly (e.g. a file created by os.CreateTemp
+// and already removed from the file system) without keeping a path around.
+//
+// - file is the *os.File of the disk image in RAW format. The attachment retains the file, and the
+// file must stay open until the virtual machine is stopped.
+// - readOnly if YES, the device attachment is read-only, otherwise the device can write data to the disk image.
+//
+// This is only supported on macOS 11 and newer, error will
+// be returned on older versions.
+func NewDiskImageStorageDeviceAttachmentFromFile(file *os.File, readOnly bool) (*DiskImageStorageDeviceAttachment, error) {
+	if err := macOSAvailable(11); err != nil {
+		return nil, err
+	}
+	// The framework only accepts a URL, so refer to the file descriptor through fdesc.
+	attachment, err := NewDiskImageStorageDeviceAttachment(
+		fmt.Sprintf("/dev/fd/%d", file.Fd()),
+		readOnly,
+	)
+	if err != nil {
+		return nil, err
+	}
+	attachment.file = file
+	return attachment, nil
+}
+
 // NewDiskImageStorageDeviceAttachmentWithCacheAndSync initialize the attachment from a local file path.
 // Returns error is not nil, assigned with the error if the initialization failed.
 //
@@ -139,6 +195,7 @@ func NewDiskImageStorageDeviceAttachmentWithCacheAndSync(diskPath string, readOn
 				&nserrPtr,
 			),
 		),
+		readOnly: readOnly,
 	}
 	if err := newNSError(nserrPtr); err != nil {
 		return nil, err
@@ -149,6 +206,54 @@ func NewDiskImageStorageDeviceAttachmentWithCacheAndSync(diskPath string, readOn
 	return attachment, nil
 }
 
+// NewDirectIODiskImageStorageDeviceAttachment initialize the attachment from an opened file, with the
+// host page cache bypassed for predictable performance (e.g. for benchmarks).
+// Returns error is not nil, assigned with the error if the initialization failed.
+//
+// macOS has no O_DIRECT, so F_NOCACHE is set on file instead, which also affects the other
+// file descriptors which share its open file description. The disk image is attached with
+// DiskImageCachingModeUncached too.
+//
+// Direct I/O requires aligned requests: the size of the disk image must be a multiple of
+// 4096 bytes, otherwise an error which wraps ErrDirectIOUnaligned is returned. Use a guest
+// file system with a block size of 4096 bytes, unaligned requests of the guest are served
+// through the page cache.
+//
+// - file is the *os.File of the disk image in RAW format. The attachment retains the file, and the
+// file must stay open until the virtual machine is stopped.
+// - readOnly if YES, the device attachment is read-only, otherwise the device can write data to the disk image.
+// - syncMode is to define how the disk image synchronizes with the underlying storage when the guest operating system flushes data.
+//
+// This is only supported on macOS 12 and newer, error will
+// be returned on older versions.
+func NewDirectIODiskImageStorageDeviceAttachment(file *os.File, readOnly bool, syncMode DiskImageSynchronizationMode) (*DiskImageStorageDeviceAttachment, error) {
+	if err := macOSAvailable(12); err != nil {
+		return nil, err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDirectIOAlignment(fi.Size()); err != nil {
+		return nil, err
+	}
+	if err := setNoCache(file); err != nil {
+		return nil, err
+	}
+	// The framework only accepts a URL, so refer to the file descriptor through fdesc.
+	attachment, err := NewDiskImageStorageDeviceAttachmentWithCacheAndSync(
+		fmt.Sprintf("/dev/fd/%d", file.Fd()),
+		readOnly,
+		DiskImageCachingModeUncached,
+		syncMode,
+	)
+	if err != nil {
+		return nil, err
+	}
+	attachment.file = file
+	return attachment, nil
+}
+
 // StorageDeviceConfiguration for a storage device configuration.
 type StorageDeviceConfiguration interface {
 	objc.NSObject
@@ -175,6 +280,12 @@ var _ StorageDeviceConfiguration = (*VirtioBlockDeviceConfiguration)(nil)
 //
 // The host implementation of the device is done through an attachment subclassing VZStorageDeviceAttachment
 // like VZDiskImageStorageDeviceAttachment.
+//
+// The guest always sees this device as a fixed disk, never as CD-ROM media.
+// Use NewISOStorageDeviceConfiguration to attach an ISO image as removable media.
+//
+// The block size cannot be configured: the guest always sees 512-byte logical blocks. Create the
+// file system in the guest with 4096-byte blocks to align I/O to the host storage.
 // see: https://developer.apple.com/documentation/virtualization/vzvirtioblockdeviceconfiguration?language=objc
 type VirtioBlockDeviceConfiguration struct {
 	*pointer
@@ -253,6 +364,19 @@ func (v *VirtioBlockDeviceConfiguration) SetBlockDeviceIdentifier(identifier str
 	return nil
 }
 
+// ReadOnly returns whether the guest sees this device as read-only.
+//
+// The read-only flag is a property of the attachment, so this reports the flag which was
+// given to the attachment (e.g. NewDiskImageStorageDeviceAttachment). A read-only device
+// rejects writes from the guest, and one read-only disk image can be shared by
+// multiple virtual machines.
+func (v *VirtioBlockDeviceConfiguration) ReadOnly() bool {
+	if a, ok := v.attachment.(readOnlyStorageDeviceAttachment); ok {
+		return a.ReadOnly()
+	}
+	return false
+}
+
 // USBMassStorageDeviceConfiguration is a configuration of a USB Mass Storage storage device.
 //
 // This device configuration creates a storage device that conforms to the USB Mass Storage specification.
@@ -287,6 +411,46 @@ func NewUSBMassStorageDeviceConfiguration(attachment StorageDeviceAttachment) (*
 	return usbMass, nil
 }
 
+// ReadOnly returns whether the guest sees this USB mass storage device as read-only.
+//
+// Like VirtioBlockDeviceConfiguration, the flag comes from the attachment. Create the attachment
+// with readOnly false (e.g. NewDiskImageStorageDeviceAttachment(path, false)) to provide
+// writable media such as scratch space for an installer.
+func (u *USBMassStorageDeviceConfiguration) ReadOnly() bool {
+	if a, ok := u.attachment.(readOnlyStorageDeviceAttachment); ok {
+		return a.ReadOnly()
+	}
+	return false
+}
+
+// NewISOStorageDeviceConfiguration creates a read-only USB mass storage device which provides the
+// ISO image at isoPath to the guest, e.g. an installer image.
+//
+// The Virtualization framework has no CD-ROM device: a Virtio block device can not be marked as removable
+// or optical media. USB mass storage is the only way to attach an ISO image as removable media, and the
+// guest sees it as a USB disk. Guests which boot from or install with ISO images (e.g. Linux distributions
+// booting with EFI) detect the ISO 9660 file system on it.
+//
+// This is only supported on macOS 13 and newer, error will
+// be returned on older versions.
+func NewISOStorageDeviceConfiguration(isoPath string) (*USBMassStorageDeviceConfiguration, error) {
+	if err := macOSAvailable(13); err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(isoPath)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("ISO image %q is not a regular file", isoPath)
+	}
+	attachment, err := NewDiskImageStorageDeviceAttachment(isoPath, true)
+	if err != nil {
+		return nil, err
+	}
+	return NewUSBMassStorageDeviceConfiguration(attachment)
+}
+
 // NVMExpressControllerDeviceConfiguration is a configuration of an NVM Express Controller storage device.
 //
 // This device configuration creates a storage device that conforms to the NVM Express specification revision 1.1b.
@@ -363,10 +527,17 @@ type DiskBlockDeviceStorageDeviceAttachment struct {
 	*pointer
 
 	*baseStorageDeviceAttachment
+
+	readOnly bool
 }
 
 var _ StorageDeviceAttachment = (*DiskBlockDeviceStorageDeviceAttachment)(nil)
 
+var _ readOnlyStorageDeviceAttachment = (*DiskBlockDeviceStorageDeviceAttachment)(nil)
+
+// ReadOnly returns whether the disk is exposed to the guest as read-only.
+func (d *DiskBlockDeviceStorageDeviceAttachment) ReadOnly() bool { return d.readOnly }
+
 // NewDiskBlockDeviceStorageDeviceAttachment creates a new block storage device attachment from a file handle and with the
 // specified access mode, synchronization mode, and error object that you provide.
 //
@@ -397,6 +568,7 @@ func NewDiskBlockDeviceStorageDeviceAttachment(file *os.File, readOnly bool, syn
 				&nserrPtr,
 			),
 		),
+		readOnly: readOnly,
 	}
 	if err := newNSError(nserrPtr); err != nil {
 		return nil, err
@@ -422,6 +594,8 @@ type NetworkBlockDeviceStorageDeviceAttachment struct {
 
 	didEncounterError *infinity.Channel[error]
 	connected         *infinity.Channel[struct{}]
+
+	readOnly bool
 }
 
 var _ StorageDeviceAttachment = (*NetworkBlockDeviceStorageDeviceAttachment)(nil)
@@ -473,6 +647,7 @@ func NewNetworkBlockDeviceStorageDeviceAttachment(url string, timeout time.Durat
 		),
 		didEncounterError: didEncounterError,
 		connected:         connected,
+		readOnly:          forcedReadOnly,
 	}
 	if err := newNSError(nserrPtr); err != nil {
 		return nil, err
@@ -483,6 +658,11 @@ func NewNetworkBlockDeviceStorageDeviceAttachment(url string, timeout time.Durat
 	return attachment, nil
 }
 
+var _ readOnlyStorageDeviceAttachment = (*NetworkBlockDeviceStorageDeviceAttachment)(nil)
+
+// ReadOnly returns whether the NBD export is forced to be read-only.
+func (n *NetworkBlockDeviceStorageDeviceAttachment) ReadOnly() bool { return n.readOnly }
+
 // Connected receive the signal via channel when the NBD client successfully connects or reconnects with the server.
 //
 // The NBD connection with the server takes place when the VM is first started, and reconnection attempts take place when the connection
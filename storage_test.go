@@ -1,8 +1,12 @@
 package vz_test
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 
@@ -64,6 +68,52 @@ func TestBlockDeviceIdentifier(t *testing.T) {
 	}
 }
 
+func TestBlockDeviceIdentifierInGuest(t *testing.T) {
+	if vz.Available(12.3) {
+		t.Skip("VirtioBlockDeviceConfiguration.SetBlockDeviceIdentifier is supported from macOS 12.3")
+	}
+
+	const want = "vz-test-disk"
+	container := newVirtualizationMachine(t,
+		func(vmc *vz.VirtualMachineConfiguration) error {
+			path := filepath.Join(t.TempDir(), "disk.img")
+			if err := vz.CreateDiskImage(path, 512*1024); err != nil {
+				t.Fatal(err)
+			}
+			attachment, err := vz.NewDiskImageStorageDeviceAttachment(path, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			config, err := vz.NewVirtioBlockDeviceConfiguration(attachment)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := config.SetBlockDeviceIdentifier(want); err != nil {
+				t.Fatal(err)
+			}
+			vmc.SetStorageDevicesVirtualMachineConfiguration([]vz.StorageDeviceConfiguration{
+				config,
+			})
+			return nil
+		},
+	)
+	t.Cleanup(func() {
+		if err := container.Shutdown(); err != nil {
+			log.Println(err)
+		}
+	})
+
+	session := container.NewSession(t)
+	defer session.Close()
+	output, err := session.CombinedOutput("cat /sys/block/vda/serial")
+	if err != nil {
+		t.Fatalf("failed to read the block device serial: %v: %s", err, output)
+	}
+	if got := strings.TrimSpace(string(output)); got != want {
+		t.Fatalf("want %q but got %q", want, got)
+	}
+}
+
 func TestBlockDeviceWithCacheAndSyncMode(t *testing.T) {
 	if vz.Available(12) {
 		t.Skip("vz.NewDiskImageStorageDeviceAttachmentWithCacheAndSync is supported from macOS 12")
@@ -103,3 +153,270 @@ func TestBlockDeviceWithCacheAndSyncMode(t *testing.T) {
 		t.Fatalf("want state %v but got %v", vz.VirtualMachineStateRunning, got)
 	}
 }
+
+func TestDiskImageStorageDeviceAttachmentFromFile(t *testing.T) {
+	container := newVirtualizationMachine(t,
+		func(vmc *vz.VirtualMachineConfiguration) error {
+			f, err := os.CreateTemp(t.TempDir(), "disk-*.img")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Truncate(512 * 1024); err != nil {
+				t.Fatal(err)
+			}
+			// The image is only reachable via the file descriptor from here on.
+			if err := os.Remove(f.Name()); err != nil {
+				t.Fatal(err)
+			}
+
+			attachment, err := vz.NewDiskImageStorageDeviceAttachmentFromFile(f, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			config, err := vz.NewVirtioBlockDeviceConfiguration(attachment)
+			if err != nil {
+				t.Fatal(err)
+			}
+			vmc.SetStorageDevicesVirtualMachineConfiguration([]vz.StorageDeviceConfiguration{
+				config,
+			})
+			return nil
+		},
+	)
+	t.Cleanup(func() {
+		if err := container.Shutdown(); err != nil {
+			log.Println(err)
+		}
+	})
+
+	// The attachment retains the file, so the descriptor must not be closed by a finalizer.
+	runtime.GC()
+
+	vm := container.VirtualMachine
+	if got := vm.State(); vz.VirtualMachineStateRunning != got {
+		t.Fatalf("want state %v but got %v", vz.VirtualMachineStateRunning, got)
+	}
+}
+
+func TestDirectIODiskImageStorageDeviceAttachment(t *testing.T) {
+	if vz.Available(12) {
+		t.Skip("NewDirectIODiskImageStorageDeviceAttachment is supported from macOS 12")
+	}
+	newImage := func(t *testing.T, size int64) *os.File {
+		t.Helper()
+		f, err := os.CreateTemp(t.TempDir(), "disk-*.img")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+		if err := f.Truncate(size); err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+
+	attachment, err := vz.NewDirectIODiskImageStorageDeviceAttachment(newImage(t, 1024*1024), true, vz.DiskImageSynchronizationModeFull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !attachment.ReadOnly() {
+		t.Fatal("want read-only attachment")
+	}
+	if _, err := vz.NewVirtioBlockDeviceConfiguration(attachment); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = vz.NewDirectIODiskImageStorageDeviceAttachment(newImage(t, 1024*1024+512), false, vz.DiskImageSynchronizationModeFull)
+	if !errors.Is(err, vz.ErrDirectIOUnaligned) {
+		t.Fatalf("want ErrDirectIOUnaligned but got %v", err)
+	}
+}
+
+func TestDiskImageCachingModeString(t *testing.T) {
+	cases := []struct {
+		mode vz.DiskImageCachingMode
+		want string
+	}{
+		{
+			mode: vz.DiskImageCachingModeAutomatic,
+			want: "DiskImageCachingModeAutomatic",
+		},
+		{
+			mode: vz.DiskImageCachingModeUncached,
+			want: "DiskImageCachingModeUncached",
+		},
+		{
+			mode: vz.DiskImageCachingModeCached,
+			want: "DiskImageCachingModeCached",
+		},
+		{
+			mode: vz.DiskImageCachingMode(3),
+			want: "DiskImageCachingMode(3)",
+		},
+	}
+	for _, tc := range cases {
+		got := fmt.Sprint(tc.mode)
+		if tc.want != got {
+			t.Fatalf("want %q but got %q", tc.want, got)
+		}
+	}
+}
+
+func TestDiskImageSynchronizationModeString(t *testing.T) {
+	cases := []struct {
+		mode vz.DiskImageSynchronizationMode
+		want string
+	}{
+		{
+			mode: vz.DiskImageSynchronizationModeFull,
+			want: "DiskImageSynchronizationModeFull",
+		},
+		{
+			mode: vz.DiskImageSynchronizationModeFsync,
+			want: "DiskImageSynchronizationModeFsync",
+		},
+		{
+			mode: vz.DiskImageSynchronizationModeNone,
+			want: "DiskImageSynchronizationModeNone",
+		},
+		{
+			mode: vz.DiskImageSynchronizationMode(0),
+			want: "DiskImageSynchronizationMode(0)",
+		},
+	}
+	for _, tc := range cases {
+		got := fmt.Sprint(tc.mode)
+		if tc.want != got {
+			t.Fatalf("want %q but got %q", tc.want, got)
+		}
+	}
+}
+
+func TestVirtioBlockDeviceConfigurationReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "disk.img")
+	if err := vz.CreateDiskImage(path, 512); err != nil {
+		t.Fatal(err)
+	}
+	for _, readOnly := range []bool{true, false} {
+		attachment, err := vz.NewDiskImageStorageDeviceAttachment(path, readOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := attachment.ReadOnly(); got != readOnly {
+			t.Fatalf("want attachment read-only %v but got %v", readOnly, got)
+		}
+		config, err := vz.NewVirtioBlockDeviceConfiguration(attachment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := config.ReadOnly(); got != readOnly {
+			t.Fatalf("want device read-only %v but got %v", readOnly, got)
+		}
+	}
+}
+
+func TestUSBMassStorageDeviceConfigurationReadOnly(t *testing.T) {
+	if vz.Available(13) {
+		t.Skip("USBMassStorageDeviceConfiguration is supported from macOS 13")
+	}
+	path := filepath.Join(t.TempDir(), "installer.img")
+	if err := vz.CreateDiskImage(path, 512); err != nil {
+		t.Fatal(err)
+	}
+	for _, readOnly := range []bool{true, false} {
+		attachment, err := vz.NewDiskImageStorageDeviceAttachment(path, readOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, err := vz.NewUSBMassStorageDeviceConfiguration(attachment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := config.ReadOnly(); got != readOnly {
+			t.Fatalf("want device read-only %v but got %v", readOnly, got)
+		}
+	}
+}
+
+func TestNewISOStorageDeviceConfiguration(t *testing.T) {
+	if vz.Available(13) {
+		t.Skip("USBMassStorageDeviceConfiguration is supported from macOS 13")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "installer.iso")
+	if err := vz.CreateDiskImage(path, 2048); err != nil {
+		t.Fatal(err)
+	}
+	config, err := vz.NewISOStorageDeviceConfiguration(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.ReadOnly() {
+		t.Fatal("want read-only device")
+	}
+	if _, ok := config.Attachment().(*vz.DiskImageStorageDeviceAttachment); !ok {
+		t.Fatalf("want *vz.DiskImageStorageDeviceAttachment but got %T", config.Attachment())
+	}
+
+	if _, err := vz.NewISOStorageDeviceConfiguration(filepath.Join(dir, "not-exist.iso")); !os.IsNotExist(err) {
+		t.Fatalf("want os.ErrNotExist but got %v", err)
+	}
+	if _, err := vz.NewISOStorageDeviceConfiguration(dir); err == nil {
+		t.Fatal("want error for a directory")
+	}
+}
+
+func TestSharedReadOnlyDiskImage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "base.img")
+	if err := vz.CreateDiskImage(path, 512*1024); err != nil {
+		t.Fatal(err)
+	}
+
+	attachReadOnlyDisk := func(vmc *vz.VirtualMachineConfiguration) error {
+		attachment, err := vz.NewDiskImageStorageDeviceAttachment(path, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, err := vz.NewVirtioBlockDeviceConfiguration(attachment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vmc.SetStorageDevicesVirtualMachineConfiguration([]vz.StorageDeviceConfiguration{
+			config,
+		})
+		return nil
+	}
+
+	// newVirtualizationMachine validates each configuration before starting.
+	containers := []*Container{
+		newVirtualizationMachine(t, attachReadOnlyDisk),
+		newVirtualizationMachine(t, attachReadOnlyDisk),
+	}
+	for _, container := range containers {
+		container := container
+		t.Cleanup(func() {
+			if err := container.Shutdown(); err != nil {
+				log.Println(err)
+			}
+		})
+	}
+
+	for i, container := range containers {
+		session := container.NewSession(t)
+		output, err := session.CombinedOutput("cat /sys/block/vda/ro")
+		session.Close()
+		if err != nil {
+			t.Fatalf("vm%d: failed to read the block device flag: %v: %s", i, err, output)
+		}
+		if got := strings.TrimSpace(string(output)); got != "1" {
+			t.Fatalf("vm%d: want read-only block device but got %q", i, got)
+		}
+
+		session = container.NewSession(t)
+		output, err = session.CombinedOutput("dd if=/dev/zero of=/dev/vda bs=512 count=1 conv=fsync")
+		session.Close()
+		if err == nil {
+			t.Fatalf("vm%d: want write error on the read-only block device: %s", i, output)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package vz
+
+import "time"
+
+// stateTimes records when a virtual machine changed its state, for Uptime and
+// LastStateChange.
+type stateTimes struct {
+	// lastChange is when the state last changed.
+	lastChange time.Time
+
+	// runningSince is when the virtual machine last entered VirtualMachineStateRunning.
+	// It is zero while the virtual machine is in another state.
+	runningSince time.Time
+
+	// ranFor is the time spent running since the virtual machine was last started,
+	// up to runningSince.
+	ranFor time.Duration
+}
+
+// record records the change to state at now.
+func (t *stateTimes) record(state VirtualMachineState, now time.Time) {
+	t.lastChange = now
+	if !t.runningSince.IsZero() {
+		t.ranFor += now.Sub(t.runningSince)
+		t.runningSince = time.Time{}
+	}
+	if !holdsMemory(state) {
+		// Stopped; the next start begins a new run.
+		t.ranFor = 0
+	}
+	if state == VirtualMachineStateRunning {
+		t.runningSince = now
+	}
+}
+
+// uptime returns the time spent running at now since the virtual machine was last started.
+func (t *stateTimes) uptime(now time.Time) time.Duration {
+	if t.runningSince.IsZero() {
+		return t.ranFor
+	}
+	return t.ranFor + now.Sub(t.runningSince)
+}
+
+// recordStateChange records the change to state in the state times.
+// The caller must hold m.mu.
+func (m *machineState) recordStateChange(state VirtualMachineState, now time.Time) {
+	m.times.record(state, now)
+}
+
+// Uptime returns how long the virtual machine has been running since it was last started.
+// The time spent paused is not counted, and 0 is returned while the virtual machine is
+// stopped.
+func (v *VirtualMachine) Uptime() time.Duration {
+	v.machineState.mu.RLock()
+	defer v.machineState.mu.RUnlock()
+	return v.machineState.times.uptime(time.Now())
+}
+
+// LastStateChange returns when the state of the virtual machine last changed, e.g. when it
+// was paused if it is VirtualMachineStatePaused. The zero time is returned if the state has
+// not changed since the virtual machine was created.
+func (v *VirtualMachine) LastStateChange() time.Time {
+	v.machineState.mu.RLock()
+	defer v.machineState.mu.RUnlock()
+	return v.machineState.times.lastChange
+}
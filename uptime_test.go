@@ -0,0 +1,75 @@
+package vz_test
+
+import (
+	"log"
+	"testing"
+	"time"
+
+	"github.com/Code-Hex/vz/v3"
+)
+
+func TestStateTimes(t *testing.T) {
+	c := vz.NewStateClock()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if got := c.Uptime(start); got != 0 {
+		t.Fatalf("want no uptime before the first start but got %v", got)
+	}
+	if got := c.LastStateChange(); !got.IsZero() {
+		t.Fatalf("want no state change yet but got %v", got)
+	}
+
+	steps := []struct {
+		after time.Duration
+		state vz.VirtualMachineState
+		want  time.Duration // uptime at the change
+		later time.Duration // uptime 10 seconds after the change
+	}{
+		{0, vz.VirtualMachineStateStarting, 0, 0},
+		{time.Second, vz.VirtualMachineStateRunning, 0, 10 * time.Second},
+		{time.Minute, vz.VirtualMachineStatePausing, time.Minute, time.Minute},
+		{time.Second, vz.VirtualMachineStatePaused, time.Minute, time.Minute},
+		{time.Hour, vz.VirtualMachineStateResuming, time.Minute, time.Minute},
+		{time.Second, vz.VirtualMachineStateRunning, time.Minute, time.Minute + 10*time.Second},
+		{time.Minute, vz.VirtualMachineStateStopping, 2 * time.Minute, 2 * time.Minute},
+		{time.Second, vz.VirtualMachineStateStopped, 0, 0},
+		{time.Hour, vz.VirtualMachineStateStarting, 0, 0},
+		{time.Second, vz.VirtualMachineStateRunning, 0, 10 * time.Second},
+		{time.Minute, vz.VirtualMachineStateError, 0, 0},
+	}
+	now := start
+	for _, step := range steps {
+		now = now.Add(step.after)
+		c.SetState(step.state, now)
+		if got := c.LastStateChange(); !got.Equal(now) {
+			t.Fatalf("after %v: want last state change at %v but got %v", step.state, now, got)
+		}
+		if got := c.Uptime(now); got != step.want {
+			t.Fatalf("after %v: want uptime %v but got %v", step.state, step.want, got)
+		}
+		if got := c.Uptime(now.Add(10 * time.Second)); got != step.later {
+			t.Fatalf("10s after %v: want uptime %v but got %v", step.state, step.later, got)
+		}
+	}
+}
+
+func TestUptime(t *testing.T) {
+	container := newVirtualizationMachine(t)
+	t.Cleanup(func() {
+		if err := container.Shutdown(); err != nil {
+			log.Println(err)
+		}
+	})
+
+	vm := container.VirtualMachine
+	if vm.LastStateChange().IsZero() {
+		t.Fatal("want the time the virtual machine started")
+	}
+	if vm.State() != vz.VirtualMachineStateRunning {
+		t.Fatalf("want running but got %v", vm.State())
+	}
+	before := vm.Uptime()
+	time.Sleep(100 * time.Millisecond)
+	if got := vm.Uptime(); got <= before {
+		t.Fatalf("want the uptime to grow while running: %v then %v", before, got)
+	}
+}
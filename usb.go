@@ -108,16 +108,15 @@ func (u *USBController) Attach(device USBDevice) error {
 	if err := macOSAvailable(15); err != nil {
 		return err
 	}
-	h, errCh := makeHandler()
-	handle := cgo.NewHandle(h)
-	defer handle.Delete()
+	handle, errCh, release := makeHandler()
+	defer release()
 	C.attachDeviceVZUSBController(
 		objc.Ptr(u),
 		objc.Ptr(device),
 		u.dispatchQueue,
 		C.uintptr_t(handle),
 	)
-	return <-errCh
+	return waitCompletion("attach USB device", errCh)
 }
 
 // Detach detaches a USB device.
@@ -133,16 +132,15 @@ func (u *USBController) Detach(device USBDevice) error {
 	if err := macOSAvailable(15); err != nil {
 		return err
 	}
-	h, errCh := makeHandler()
-	handle := cgo.NewHandle(h)
-	defer handle.Delete()
+	handle, errCh, release := makeHandler()
+	defer release()
 	C.detachDeviceVZUSBController(
 		objc.Ptr(u),
 		objc.Ptr(device),
 		u.dispatchQueue,
 		C.uintptr_t(handle),
 	)
-	return <-errCh
+	return waitCompletion("detach USB device", errCh)
 }
 
 // USBDevices return a list of USB devices attached to controller.
@@ -2,7 +2,7 @@ package vz
 
 /*
 #cgo darwin CFLAGS: -mmacosx-version-min=11 -x objective-c -fno-objc-arc
-#cgo darwin LDFLAGS: -lobjc -framework Foundation -framework Virtualization -framework Cocoa
+#cgo darwin LDFLAGS: -lobjc -framework Foundation -framework Virtualization -framework Cocoa -framework Security
 # include "virtualization_11.h"
 # include "virtualization_12.h"
 # include "virtualization_13.h"
@@ -11,9 +11,14 @@ package vz
 */
 import "C"
 import (
+	"context"
+	"errors"
 	"fmt"
 	"runtime/cgo"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 	"unsafe"
 
 	infinity "github.com/Code-Hex/go-infinity-channel"
@@ -70,6 +75,20 @@ const (
 	VirtualMachineStateRestoring
 )
 
+// ErrInvalidVirtualMachineState is returned when an operation is requested on a virtual machine
+// that is not in a state which allows it (e.g. starting a virtual machine which is already running).
+var ErrInvalidVirtualMachineState = errors.New("invalid virtual machine state")
+
+// ErrVirtualizationEntitlementMissing is returned by NewVirtualMachine when the running binary is not
+// signed with the "com.apple.security.virtualization" entitlement.
+var ErrVirtualizationEntitlementMissing = errors.New(`missing "com.apple.security.virtualization" entitlement: ` +
+	"sign the binary with an entitlements file which enables it (e.g. codesign --entitlements vz.entitlements -s - <binary>)")
+
+// hasVirtualizationEntitlement reports whether the running process has the virtualization entitlement.
+var hasVirtualizationEntitlement = func() bool {
+	return bool(C.hasVirtualizationEntitlement())
+}
+
 // VirtualMachine represents the entire state of a single virtual machine.
 //
 // A Virtual Machine is the emulation of a complete hardware machine of the same architecture as the real hardware machine.
@@ -79,6 +98,16 @@ const (
 // The definition of a virtual machine starts with its configuration. This is done by setting up a VirtualMachineConfiguration struct.
 // Once configured, the virtual machine can be started with (*VirtualMachine).Start() method.
 //
+// The lifecycle operations (Start, Pause, Resume, Stop, RequestStop, SaveMachineStateToPath and
+// RestoreMachineStateFromURL) are safe to call from multiple goroutines. They are serialized per
+// virtual machine: an operation waits until the previous one has completed, and then checks the
+// state again, so an operation which no longer applies (e.g. Pause after a concurrent Stop)
+// returns ErrInvalidVirtualMachineState instead of racing on the dispatch queue.
+//
+// The Virtualization framework exposes neither the RTC of the virtual machine nor a time synchronization
+// device, so the guest clock cannot be adjusted from the host, e.g. after the host wakes from sleep. Run a
+// time synchronization daemon (e.g. chrony or systemd-timesyncd) in the guest instead.
+//
 // Creating a virtual machine using the Virtualization framework requires the app to have the "com.apple.security.virtualization" entitlement.
 // see: https://developer.apple.com/documentation/virtualization/vzvirtualmachine?language=objc
 type VirtualMachine struct {
@@ -101,37 +130,109 @@ type VirtualMachine struct {
 
 	finalizeOnce sync.Once
 
+	// opMu serializes the lifecycle operations, which are started on the dispatch queue
+	// and completed by a completion handler.
+	opMu sync.Mutex
+
 	config *VirtualMachineConfiguration
 
+	// The devices set in config when the virtual machine was created. The framework
+	// copies the configuration, so later changes to config do not apply.
+	networkDevices  []*VirtioNetworkDeviceConfiguration
+	graphicsDevices []GraphicsDeviceConfiguration
+	storageDevices  []StorageDeviceConfiguration
+	// directorySharingDevices is empty on macOS 11, which has no directory sharing devices.
+	directorySharingDevices []DirectorySharingDeviceConfiguration
+
+	// consolePorts is nil on macOS 12 and older.
+	consolePorts *consolePortState
+
+	events *eventEmitter
+
+	// qosClass is the quality-of-service class of dispatchQueue.
+	qosClass QoSClass
+
+	// memoryBalloonTargetObservers are the observers of TargetChangedNotify by the memory
+	// balloon device, which live as long as the virtual machine.
+	memoryBalloonTargetObservers map[unsafe.Pointer]*memoryBalloonTargetObserver
+
 	mu sync.RWMutex
 }
 
 type machineState struct {
 	state       VirtualMachineState
 	stateNotify *infinity.Channel[VirtualMachineState]
+	events      *eventEmitter
+
+	// memorySize is the memory size of the configuration, which is counted
+	// in committedMemory while memoryCommitted is true.
+	memorySize      uint64
+	memoryCommitted bool
+
+	times stateTimes
 
 	mu sync.RWMutex
 }
 
+type newVirtualMachineOptions struct {
+	qosClass QoSClass
+}
+
+// NewVirtualMachineOption is an option for NewVirtualMachine.
+type NewVirtualMachineOption func(*newVirtualMachineOptions) error
+
+// WithQoSClass is an option to create the dispatch queue of the virtual machine with
+// the quality-of-service class, e.g. QoSClassUtility or QoSClassBackground for a virtual
+// machine which should not compete with interactive work. See (*VirtualMachine).SetQoSClass.
+func WithQoSClass(qos QoSClass) NewVirtualMachineOption {
+	return func(o *newVirtualMachineOptions) error {
+		if !qos.valid() {
+			return fmt.Errorf("invalid QoS class: %s", qos)
+		}
+		o.qosClass = qos
+		return nil
+	}
+}
+
 // NewVirtualMachine creates a new VirtualMachine with VirtualMachineConfiguration.
 //
 // The configuration must be valid. Validation can be performed at runtime with (*VirtualMachineConfiguration).Validate() method.
 // The configuration is copied by the initializer.
 //
+// ErrVirtualizationEntitlementMissing is returned if the running binary lacks the
+// "com.apple.security.virtualization" entitlement.
+//
 // This is only supported on macOS 11 and newer, error will
 // be returned on older versions.
-func NewVirtualMachine(config *VirtualMachineConfiguration) (*VirtualMachine, error) {
+func NewVirtualMachine(config *VirtualMachineConfiguration, opts ...NewVirtualMachineOption) (*VirtualMachine, error) {
 	if err := macOSAvailable(11); err != nil {
 		return nil, err
 	}
+	if !hasVirtualizationEntitlement() {
+		return nil, ErrVirtualizationEntitlementMissing
+	}
+	o := &newVirtualMachineOptions{}
+	for _, optFunc := range opts {
+		if err := optFunc(o); err != nil {
+			return nil, err
+		}
+	}
 
 	// should not call Free function for this string.
 	cs := (*char)(objc.GetUUID())
-	dispatchQueue := C.makeDispatchQueue(cs.CString())
+	dispatchQueue := C.makeDispatchQueue(cs.CString(), C.uint(o.qosClass))
+
+	// The network devices are copied, so a disconnection is reported with the device the virtual
+	// machine was created with even if the configuration is changed afterwards.
+	networkDevices := slices.Clone(config.networkDeviceConfiguration)
+	events := newEventEmitter(cs.String(), networkDevices)
+	eventsHandle := cgo.NewHandle(events)
 
 	machineState := &machineState{
 		state:       VirtualMachineState(0),
 		stateNotify: infinity.NewChannel[VirtualMachineState](),
+		events:      events,
+		memorySize:  config.memorySize,
 	}
 	stateHandle := cgo.NewHandle(machineState)
 
@@ -154,11 +255,32 @@ func NewVirtualMachine(config *VirtualMachineConfiguration) (*VirtualMachine, er
 		disconnectedIn:  disconnectedIn,
 		disconnectedOut: disconnectedOut,
 		config:          config,
+		networkDevices:  networkDevices,
+		graphicsDevices: slices.Clone(config.graphicsDeviceConfiguration),
+		storageDevices:  slices.Clone(config.storageDeviceConfiguration),
+		events:          events,
+		qosClass:        o.qosClass,
+
+		directorySharingDevices: slices.Clone(config.directorySharingConfiguration),
+	}
+	C.VZVirtualMachine_setEventHandler(objc.Ptr(v), C.uintptr_t(eventsHandle))
+
+	var consolePortsHandle cgo.Handle
+	if macOSAvailable(13) == nil {
+		v.consolePorts = newConsolePortState()
+		consolePortsHandle = cgo.NewHandle(v.consolePorts)
+		C.VZVirtualMachine_setConsoleDevicesDelegate(objc.Ptr(v), dispatchQueue, C.uintptr_t(consolePortsHandle))
 	}
 
 	objc.SetFinalizer(v, func(self *VirtualMachine) {
+		self.removeMemoryBalloonTargetObservers()
 		self.finalize()
 		stateHandle.Delete()
+		events.close()
+		eventsHandle.Delete()
+		if consolePortsHandle != 0 {
+			consolePortsHandle.Delete()
+		}
 	})
 	return v, nil
 }
@@ -170,22 +292,40 @@ func (v *VirtualMachine) finalize() {
 	})
 }
 
+// The accessors of the devices of a virtual machine (SocketDevices, MemoryBalloonDevices,
+// DirectorySharingDevices and USBControllers) share the same contract:
+//
+//   - nil is returned if the running macOS does not support the kind of device.
+//   - An empty, non-nil slice is returned if no device of the kind is configured.
+//
+// runtimeDevices implements this contract. devices returns the NSArray of the devices
+// and wrap creates the Go value of each of them.
+func runtimeDevices[T any](version float64, devices func() unsafe.Pointer, wrap func(ptr unsafe.Pointer) T) []T {
+	if err := macOSAvailable(version); err != nil {
+		return nil
+	}
+	ptrs := objc.NewNSArray(devices()).ToPointerSlice()
+	ret := make([]T, len(ptrs))
+	for i, ptr := range ptrs {
+		ret[i] = wrap(ptr)
+	}
+	return ret
+}
+
 // SocketDevices return the list of socket devices configured on this virtual machine.
 // Return an empty array if no socket device is configured.
 //
 // Since only NewVirtioSocketDeviceConfiguration is available in vz package,
 // it will always return VirtioSocketDevice.
 // see: https://developer.apple.com/documentation/virtualization/vzvirtualmachine/3656702-socketdevices?language=objc
+//
+// This is only supported on macOS 11 and newer, nil will
+// be returned on older versions.
 func (v *VirtualMachine) SocketDevices() []*VirtioSocketDevice {
-	nsArray := objc.NewNSArray(
-		C.VZVirtualMachine_socketDevices(objc.Ptr(v)),
+	return runtimeDevices(11,
+		func() unsafe.Pointer { return C.VZVirtualMachine_socketDevices(objc.Ptr(v)) },
+		func(ptr unsafe.Pointer) *VirtioSocketDevice { return newVirtioSocketDevice(ptr, v.dispatchQueue) },
 	)
-	ptrs := nsArray.ToPointerSlice()
-	socketDevices := make([]*VirtioSocketDevice, len(ptrs))
-	for i, ptr := range ptrs {
-		socketDevices[i] = newVirtioSocketDevice(ptr, v.dispatchQueue)
-	}
-	return socketDevices
 }
 
 // USBControllers return the list of USB controllers configured on this virtual machine. Return an empty array if no USB controller is configured.
@@ -193,18 +333,38 @@ func (v *VirtualMachine) SocketDevices() []*VirtioSocketDevice {
 // This is only supported on macOS 15 and newer, nil will
 // be returned on older versions.
 func (v *VirtualMachine) USBControllers() []*USBController {
-	if err := macOSAvailable(15); err != nil {
-		return nil
-	}
-	nsArray := objc.NewNSArray(
-		C.VZVirtualMachine_usbControllers(objc.Ptr(v)),
+	return runtimeDevices(15,
+		func() unsafe.Pointer { return C.VZVirtualMachine_usbControllers(objc.Ptr(v)) },
+		func(ptr unsafe.Pointer) *USBController { return newUSBController(ptr, v.dispatchQueue) },
 	)
-	ptrs := nsArray.ToPointerSlice()
-	usbControllers := make([]*USBController, len(ptrs))
-	for i, ptr := range ptrs {
-		usbControllers[i] = newUSBController(ptr, v.dispatchQueue)
-	}
-	return usbControllers
+}
+
+// NetworkDevices returns the network device configurations of the virtual machine, as they were
+// set in the configuration when the virtual machine was created.
+func (v *VirtualMachine) NetworkDevices() []*VirtioNetworkDeviceConfiguration {
+	return slices.Clone(v.networkDevices)
+}
+
+// GraphicsDevices returns the graphics device configurations of the virtual machine, as they were
+// set in the configuration when the virtual machine was created. It is empty for a headless virtual machine.
+func (v *VirtualMachine) GraphicsDevices() []GraphicsDeviceConfiguration {
+	return slices.Clone(v.graphicsDevices)
+}
+
+// CPUCount returns the number of CPUs of the virtual machine, as set in the configuration when the
+// virtual machine was created.
+//
+// The Virtualization framework does not report the number of CPUs of a running virtual machine, and it
+// does not adjust the configured number (see (*VirtualMachineConfiguration).CPUCount), so this is also
+// the number of CPUs the guest sees.
+func (v *VirtualMachine) CPUCount() uint {
+	return v.config.cpuCount
+}
+
+// StorageDeviceCount returns the number of storage devices of the virtual machine, as they were
+// set in the configuration when the virtual machine was created.
+func (v *VirtualMachine) StorageDeviceCount() int {
+	return len(v.storageDevices)
 }
 
 //export changeStateOnObserver
@@ -213,11 +373,22 @@ func changeStateOnObserver(newStateRaw C.int, cgoHandleUintptr C.uintptr_t) {
 	// I expected it will not cause panic.
 	// if caused panic, that's unexpected behavior.
 	v, _ := stateHandle.Value().(*machineState)
-	v.mu.Lock()
-	newState := VirtualMachineState(newStateRaw)
-	v.state = newState
-	v.stateNotify.In() <- newState
-	v.mu.Unlock()
+	v.changeState(VirtualMachineState(newStateRaw), time.Now())
+}
+
+// changeState records the new state and notifies it.
+//
+// The event is emitted after the lock is released, so a logger which calls back into the
+// virtual machine (e.g. State) does not deadlock. The state observer is called on the serial
+// dispatch queue of the virtual machine, so the events stay in order.
+func (m *machineState) changeState(newState VirtualMachineState, now time.Time) {
+	m.mu.Lock()
+	m.recordStateChange(newState, now)
+	m.state = newState
+	m.commitMemory(newState)
+	m.stateNotify.In() <- newState
+	m.mu.Unlock()
+	m.events.emit(Event{Kind: EventStateChanged, State: newState})
 }
 
 // State represents execution state of the virtual machine.
@@ -265,29 +436,166 @@ func (v *VirtualMachine) CanStop() bool {
 	return (bool)(C.vmCanStop(objc.Ptr(v), v.dispatchQueue))
 }
 
+// VMCapabilities is a snapshot of the operations which the virtual machine
+// can perform in its current state.
+type VMCapabilities struct {
+	// CanStart is true if the machine is in a state that can be started.
+	CanStart bool
+	// CanPause is true if the machine is in a state that can be paused.
+	CanPause bool
+	// CanResume is true if the machine is in a state that can be resumed.
+	CanResume bool
+	// CanRequestStop is true if the machine is in a state where the guest can be asked to stop.
+	CanRequestStop bool
+	// CanStop is true if the machine is in a state that can be stopped.
+	// This is always false on macOS 11.
+	CanStop bool
+}
+
+// Capabilities returns CanStart, CanPause, CanResume, CanRequestStop and CanStop
+// at once. Unlike calling each method individually, the values are read in a
+// single round-trip to the dispatch queue of the virtual machine, so they are
+// consistent with each other.
+func (v *VirtualMachine) Capabilities() VMCapabilities {
+	c := C.vmCapabilities(objc.Ptr(v), v.dispatchQueue)
+	return VMCapabilities{
+		CanStart:       bool(c.canStart),
+		CanPause:       bool(c.canPause),
+		CanResume:      bool(c.canResume),
+		CanRequestStop: bool(c.canRequestStop),
+		CanStop:        bool(c.canStop),
+	}
+}
+
+// ErrUnresponsive is returned by (*VirtualMachine).Healthcheck when the dispatch queue of
+// the virtual machine does not run a task before the context is done.
+var ErrUnresponsive = errors.New("virtual machine is unresponsive")
+
+// Healthcheck verifies that the virtual machine is running and that its dispatch queue,
+// on which the Virtualization framework runs every operation of the virtual machine,
+// still runs tasks. It is a cheap liveness check for orchestration beyond State.
+//
+// ErrInvalidVirtualMachineState is returned if the virtual machine is not running, and
+// ErrUnresponsive (which also wraps the error of ctx) if the dispatch queue is hung.
+//
+// The Virtualization framework knows nothing about the guest, so this does not detect a hung
+// guest OS. Check a guest agent, e.g. over a virtio socket, for that.
+func (v *VirtualMachine) Healthcheck(ctx context.Context) error {
+	state := v.State()
+	if err := checkVirtualMachineState("healthcheck", state == VirtualMachineStateRunning, state); err != nil {
+		return err
+	}
+	return pingDispatchQueue(ctx, func(handle cgo.Handle) {
+		C.pingDispatchQueue(v.dispatchQueue, C.uintptr_t(handle))
+	})
+}
+
+// pingDispatchQueue calls ping with the handle of a completion handler, which ping must call
+// from the dispatch queue, and waits for it until ctx is done.
+func pingDispatchQueue(ctx context.Context, ping func(cgo.Handle)) error {
+	handle, errCh, release := makeHandler()
+	defer release()
+	ping(handle)
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("%w: dispatch queue did not respond: %w", ErrUnresponsive, ctx.Err())
+	}
+}
+
 //export virtualMachineCompletionHandler
 func virtualMachineCompletionHandler(cgoHandleUintptr C.uintptr_t, errPtr unsafe.Pointer) {
 	cgoHandle := cgo.Handle(cgoHandleUintptr)
 
-	handler := cgoHandle.Value().(func(error))
-
 	if err := newNSError(errPtr); err != nil {
-		handler(err)
+		callCompletionHandler(cgoHandle, err)
 	} else {
-		handler(nil)
+		callCompletionHandler(cgoHandle, nil)
+	}
+}
+
+// callCompletionHandler calls the handler made by makeHandler.
+func callCompletionHandler(cgoHandle cgo.Handle, err error) {
+	handler := cgoHandle.Value().(func(error))
+	handler(err)
+}
+
+// ErrCompletionHandlerTimeout is returned when the Virtualization framework does not call
+// the completion handler of an operation within the timeout set by SetCompletionHandlerTimeout.
+var ErrCompletionHandlerTimeout = errors.New("completion handler timed out")
+
+var completionHandlerTimeout atomic.Int64
+
+// SetCompletionHandlerTimeout sets the maximum duration to wait for the completion handler of
+// operations of the Virtualization framework, such as (*VirtualMachine).Start, Pause, Resume and Stop.
+// If the completion handler is not called in time, the operation returns ErrCompletionHandlerTimeout
+// and releases the resources for the handler, so a framework bug can not block the caller forever.
+//
+// Zero (the default) waits without timeout. Note that the operation may still complete in the
+// framework after the timeout, so check the state of the virtual machine before retrying.
+func SetCompletionHandlerTimeout(d time.Duration) {
+	completionHandlerTimeout.Store(int64(d))
+}
+
+// waitCompletion waits for the handler made by makeHandler with the timeout set by
+// SetCompletionHandlerTimeout. op describes the operation for the error message.
+func waitCompletion(op string, errCh <-chan error) error {
+	timeout := time.Duration(completionHandlerTimeout.Load())
+	if timeout <= 0 {
+		return <-errCh
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-errCh:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("%w: %s did not complete in %s", ErrCompletionHandlerTimeout, op, timeout)
 	}
 }
 
-func makeHandler() (func(error), chan error) {
+// checkVirtualMachineState returns ErrInvalidVirtualMachineState which describes
+// the current state if the operation is not allowed.
+func checkVirtualMachineState(op string, allowed bool, state VirtualMachineState) error {
+	if allowed {
+		return nil
+	}
+	return fmt.Errorf("%w: cannot %s in %s", ErrInvalidVirtualMachineState, op, state)
+}
+
+// makeHandler makes a completion handler and returns its handle, which is passed to the
+// Virtualization framework, and the channel which receives the result of the operation.
+//
+// release must be called when the caller stops waiting for the result. The handle is deleted
+// by whichever of the completion and release happens last, so a completion which arrives after
+// the caller gave up (see SetCompletionHandlerTimeout) still finds a valid handle and is ignored.
+func makeHandler() (handle cgo.Handle, errCh <-chan error, release func()) {
 	ch := make(chan error, 1)
-	return func(err error) {
+	var finished atomic.Bool
+	finish := func() {
+		if !finished.CompareAndSwap(false, true) {
+			handle.Delete()
+		}
+	}
+	handle = cgo.NewHandle(func(err error) {
 		ch <- err
 		close(ch)
-	}, ch
+		finish()
+	})
+	return handle, ch, finish
 }
 
 type virtualMachineStartOptions struct {
-	macOSVirtualMachineStartOptionsPtr unsafe.Pointer
+	// macOSStartOptions is assembled by the options for the macOS boot loader.
+	macOSStartOptions startOptions
+	startSemaphore    *StartSemaphore
+}
+
+// startOptions builds the VZVirtualMachineStartOptions which the virtual machine is started with.
+type startOptions interface {
+	// newStartOptions returns a new retained VZVirtualMachineStartOptions.
+	newStartOptions() unsafe.Pointer
 }
 
 // VirtualMachineStartOption is an option for virtual machine start.
@@ -298,7 +606,12 @@ type VirtualMachineStartOption func(*virtualMachineStartOptions) error
 // If you want to listen status change events, use the "StateChangedNotify" method.
 //
 // If options are specified, also checks whether these options are
-// available in use your macOS version available.
+// available in use your macOS version available. Use WithStartSemaphore
+// to bound the number of virtual machines starting at the same time.
+//
+// ErrInvalidVirtualMachineState is returned if the virtual machine cannot be started
+// in the current state. If the framework fails to start the virtual machine, *StartError
+// is returned, which wraps the *NSError of the framework.
 func (v *VirtualMachine) Start(opts ...VirtualMachineStartOption) error {
 	o := &virtualMachineStartOptions{}
 	for _, optFunc := range opts {
@@ -306,44 +619,72 @@ func (v *VirtualMachine) Start(opts ...VirtualMachineStartOption) error {
 			return err
 		}
 	}
+	if o.startSemaphore != nil {
+		if err := o.startSemaphore.Acquire(context.Background()); err != nil {
+			return err
+		}
+		defer o.startSemaphore.Release()
+	}
+	v.opMu.Lock()
+	defer v.opMu.Unlock()
+	// The state is checked after waiting for the semaphore and the previous operation,
+	// because it may change meanwhile.
+	if err := checkVirtualMachineState("start", v.CanStart(), v.State()); err != nil {
+		return err
+	}
 
-	h, errCh := makeHandler()
-	handle := cgo.NewHandle(h)
-	defer handle.Delete()
+	handle, errCh, release := makeHandler()
+	defer release()
 
-	if o.macOSVirtualMachineStartOptionsPtr != nil {
+	if o.macOSStartOptions != nil {
+		startOpts := objc.NewPointer(o.macOSStartOptions.newStartOptions())
+		defer objc.Release(startOpts)
 		C.startWithOptionsCompletionHandler(
 			objc.Ptr(v),
 			v.dispatchQueue,
-			o.macOSVirtualMachineStartOptionsPtr,
+			objc.Ptr(startOpts),
 			C.uintptr_t(handle),
 		)
 	} else {
 		C.startWithCompletionHandler(objc.Ptr(v), v.dispatchQueue, C.uintptr_t(handle))
 	}
-	return <-errCh
+	return newStartError(waitCompletion("start", errCh), v.State(), v.config.Validate)
 }
 
 // Pause a virtual machine that is in Running state.
 //
 // If you want to listen status change events, use the "StateChangedNotify" method.
+//
+// ErrInvalidVirtualMachineState is returned if the virtual machine cannot be paused
+// in the current state.
 func (v *VirtualMachine) Pause() error {
-	h, errCh := makeHandler()
-	handle := cgo.NewHandle(h)
-	defer handle.Delete()
+	v.opMu.Lock()
+	defer v.opMu.Unlock()
+	if err := checkVirtualMachineState("pause", v.CanPause(), v.State()); err != nil {
+		return err
+	}
+	handle, errCh, release := makeHandler()
+	defer release()
 	C.pauseWithCompletionHandler(objc.Ptr(v), v.dispatchQueue, C.uintptr_t(handle))
-	return <-errCh
+	return waitCompletion("pause", errCh)
 }
 
 // Resume a virtual machine that is in the Paused state.
 //
 // If you want to listen status change events, use the "StateChangedNotify" method.
+//
+// ErrInvalidVirtualMachineState is returned if the virtual machine cannot be resumed
+// in the current state.
 func (v *VirtualMachine) Resume() error {
-	h, errCh := makeHandler()
-	handle := cgo.NewHandle(h)
-	defer handle.Delete()
+	v.opMu.Lock()
+	defer v.opMu.Unlock()
+	if err := checkVirtualMachineState("resume", v.CanResume(), v.State()); err != nil {
+		return err
+	}
+	handle, errCh, release := makeHandler()
+	defer release()
 	C.resumeWithCompletionHandler(objc.Ptr(v), v.dispatchQueue, C.uintptr_t(handle))
-	return <-errCh
+	return waitCompletion("resume", errCh)
 }
 
 // RequestStop requests that the guest turns itself off.
@@ -351,6 +692,8 @@ func (v *VirtualMachine) Resume() error {
 // If returned error is not nil, assigned with the error if the request failed.
 // Returns true if the request was made successfully.
 func (v *VirtualMachine) RequestStop() (bool, error) {
+	v.opMu.Lock()
+	defer v.opMu.Unlock()
 	nserrPtr := newNSErrorAsNil()
 	ret := (bool)(C.requestStopVirtualMachine(objc.Ptr(v), v.dispatchQueue, &nserrPtr))
 	if err := newNSError(nserrPtr); err != nil {
@@ -369,22 +712,141 @@ func (v *VirtualMachine) RequestStop() (bool, error) {
 // Warning: This is a destructive operation. It stops the VM without
 // giving the guest a chance to stop cleanly.
 //
+// ErrInvalidVirtualMachineState is returned if the virtual machine cannot be stopped
+// in the current state.
+//
 // This is only supported on macOS 12 and newer, error will be returned on older versions.
 func (v *VirtualMachine) Stop() error {
 	if err := macOSAvailable(12); err != nil {
 		return err
 	}
-	h, errCh := makeHandler()
-	handle := cgo.NewHandle(h)
-	defer handle.Delete()
+	v.opMu.Lock()
+	defer v.opMu.Unlock()
+	if err := checkVirtualMachineState("stop", v.CanStop(), v.State()); err != nil {
+		return err
+	}
+	handle, errCh, release := makeHandler()
+	defer release()
 	C.stopWithCompletionHandler(objc.Ptr(v), v.dispatchQueue, C.uintptr_t(handle))
-	return <-errCh
+	return waitCompletion("stop", errCh)
+}
+
+// gracefulStopPollInterval is the interval at which RequestStopAndWait checks whether the guest has stopped.
+var gracefulStopPollInterval = 100 * time.Millisecond
+
+// GracefulStop asks the guest to turn itself off with RequestStopAndWait and waits up to timeout
+// for the virtual machine to stop. If the guest does not stop in time, or the request cannot be
+// made (e.g. the virtual machine is paused), the virtual machine is stopped with Stop.
+//
+// It returns nil if the virtual machine is already stopped. If the virtual machine stops in the
+// error state instead, the error of RequestStopAndWait is returned.
+//
+// This is only supported on macOS 12 and newer, error will be returned on older versions.
+func (v *VirtualMachine) GracefulStop(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := v.RequestStopAndWait(ctx)
+	if err == nil || !v.CanStop() {
+		return err
+	}
+	return v.Stop()
+}
+
+// ErrStopRequestIgnored is returned by RequestStopAndWait when the guest does not stop
+// before the context ends, e.g. because it does not handle the power button.
+var ErrStopRequestIgnored = errors.New("guest did not stop on request")
+
+// RequestStopAndWait asks the guest to turn itself off with RequestStop and waits until the
+// virtual machine is stopped. Unlike GracefulStop, the virtual machine is not stopped forcibly.
+//
+// If ctx ends before the guest stops, an error which matches both ErrStopRequestIgnored and
+// the error of ctx is returned, and the virtual machine keeps running; call Stop to stop it
+// anyway. If the virtual machine stops with an error instead, an error which matches
+// ErrInvalidVirtualMachineState is returned.
+//
+// It returns nil if the virtual machine is already stopped.
+//
+// This is only supported on macOS 12 and newer, error will be returned on older versions.
+func (v *VirtualMachine) RequestStopAndWait(ctx context.Context) error {
+	if err := macOSAvailable(12); err != nil {
+		return err
+	}
+	if v.State() == VirtualMachineStateStopped {
+		return nil
+	}
+	if err := checkVirtualMachineState("request stop", v.CanRequestStop(), v.State()); err != nil {
+		return err
+	}
+	ok, err := v.RequestStop()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("failed to request the guest to stop")
+	}
+	return waitStopped(ctx, v.State, gracefulStopPollInterval)
+}
+
+// waitStopped polls state every interval until it is VirtualMachineStateStopped or ctx ends.
+func waitStopped(ctx context.Context, state func() VirtualMachineState, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		switch s := state(); s {
+		case VirtualMachineStateStopped:
+			return nil
+		case VirtualMachineStateError:
+			return fmt.Errorf("%w: stopped in %s while waiting for the guest to stop", ErrInvalidVirtualMachineState, s)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %w", ErrStopRequestIgnored, ctx.Err())
+		}
+	}
+}
+
+// Reboot reboots the guest by stopping and starting the virtual machine with the same configuration.
+// The Virtualization framework has no request which makes the guest reboot itself, so the reboot is
+// driven by the host: the guest is asked to turn itself off with RequestStopAndWait, then the
+// virtual machine is started again with opts like Start.
+//
+// If the guest does not stop before ctx ends, the error of RequestStopAndWait is returned and the
+// virtual machine keeps running. A stopped virtual machine is only started. To reset a guest which
+// is hung, call Stop and then Start instead.
+//
+// This is only supported on macOS 12 and newer, error will be returned on older versions.
+func (v *VirtualMachine) Reboot(ctx context.Context, opts ...VirtualMachineStartOption) error {
+	return reboot(ctx, v.RequestStopAndWait, func() error { return v.Start(opts...) })
+}
+
+func reboot(ctx context.Context, stop func(ctx context.Context) error, start func() error) error {
+	if err := stop(ctx); err != nil {
+		return fmt.Errorf("failed to reboot: %w", err)
+	}
+	if err := start(); err != nil {
+		return fmt.Errorf("failed to start again to reboot: %w", err)
+	}
+	return nil
 }
 
 type startGraphicApplicationOptions struct {
 	title              string
 	enableController   bool
 	confirmStopOnClose bool
+	startHidden        bool
+}
+
+func newStartGraphicApplicationOptions(opts ...StartGraphicApplicationOption) (*startGraphicApplicationOptions, error) {
+	o := &startGraphicApplicationOptions{
+		confirmStopOnClose: true,
+	}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
 }
 
 // StartGraphicApplicationOption is an option for display graphics start.
@@ -416,6 +878,16 @@ func WithConfirmStopOnClose(enable bool) StartGraphicApplicationOption {
 	}
 }
 
+// WithStartHidden is an option to create the window without showing it, e.g. when many virtual machines
+// are started at login. Show it later with (*VirtualMachine).ShowWindow. The window is listed by
+// ListVirtualMachineWindows even while it is hidden.
+func WithStartHidden(hidden bool) StartGraphicApplicationOption {
+	return func(sgao *startGraphicApplicationOptions) error {
+		sgao.startHidden = hidden
+		return nil
+	}
+}
+
 // CreateWindow creates and displays a graphics window for the VM without blocking.
 // Call RunApplication() to start the event loop, or use this in an app that
 // already has an event loop running.
@@ -427,13 +899,9 @@ func (v *VirtualMachine) CreateWindow(width, height float64, opts ...StartGraphi
 	if err := macOSAvailable(12); err != nil {
 		return err
 	}
-	defaultOpts := &startGraphicApplicationOptions{
-		confirmStopOnClose: true,
-	}
-	for _, opt := range opts {
-		if err := opt(defaultOpts); err != nil {
-			return err
-		}
+	defaultOpts, err := newStartGraphicApplicationOptions(opts...)
+	if err != nil {
+		return err
 	}
 
 	windowTitle := charWithGoString(defaultOpts.title)
@@ -447,8 +915,167 @@ func (v *VirtualMachine) CreateWindow(width, height float64, opts ...StartGraphi
 		windowTitle.CString(),
 		C.bool(defaultOpts.enableController),
 		C.bool(defaultOpts.confirmStopOnClose),
+		C.bool(defaultOpts.startHidden),
 	)
-	_ = windowController // window is shown during creation
+	// The window is shown during creation unless it starts hidden.
+	windows.add(uintptr(windowController), v, WindowInfo{
+		Title:            defaultOpts.title,
+		VirtualMachineID: v.id,
+	})
+	return nil
+}
+
+// liveWindowControllers returns the number of window controllers created by CreateWindow which
+// are not deallocated yet. The controller of a window is deallocated after the window is closed.
+func liveWindowControllers() int {
+	return int(C.liveVirtualMachineWindowControllers())
+}
+
+// ErrWindowNotFound is returned by FocusWindowByTitle when no window has the title, and by
+// (*VirtualMachine).ShowWindow when the virtual machine has no window.
+var ErrWindowNotFound = errors.New("window not found")
+
+// WindowInfo describes a window created by (*VirtualMachine).CreateWindow.
+type WindowInfo struct {
+	// Title is the title of the window.
+	Title string
+
+	// VirtualMachineID identifies the virtual machine which is displayed in the window.
+	VirtualMachineID string
+}
+
+// windowRegistry tracks the windows which are open.
+type windowRegistry struct {
+	mu      sync.Mutex
+	windows []windowEntry
+}
+
+// windowEntry is an open window. A virtual machine may have several windows, so the
+// window is identified by its window controller.
+type windowEntry struct {
+	info       WindowInfo
+	controller uintptr
+	vm         *VirtualMachine
+}
+
+var windows = &windowRegistry{}
+
+func (r *windowRegistry) add(controller uintptr, vm *VirtualMachine, info WindowInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.windows = append(r.windows, windowEntry{info: info, controller: controller, vm: vm})
+}
+
+// remove removes the window of the window controller.
+func (r *windowRegistry) remove(controller uintptr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.windows = slices.DeleteFunc(r.windows, func(w windowEntry) bool {
+		return w.controller == controller
+	})
+}
+
+func (r *windowRegistry) list() []WindowInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ret := make([]WindowInfo, len(r.windows))
+	for i, w := range r.windows {
+		ret[i] = w.info
+	}
+	return ret
+}
+
+// virtualMachines returns the virtual machines which have a window, each of them once.
+func (r *windowRegistry) virtualMachines() []*VirtualMachine {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var vms []*VirtualMachine
+	for _, w := range r.windows {
+		if w.vm != nil && !slices.Contains(vms, w.vm) {
+			vms = append(vms, w.vm)
+		}
+	}
+	return vms
+}
+
+// has reports whether the virtual machine has a window.
+func (r *windowRegistry) has(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.ContainsFunc(r.windows, func(w windowEntry) bool {
+		return w.info.VirtualMachineID == id
+	})
+}
+
+func (r *windowRegistry) lookup(title string) (WindowInfo, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, w := range r.windows {
+		if w.info.Title == title {
+			return w.info, true
+		}
+	}
+	return WindowInfo{}, false
+}
+
+// ListVirtualMachineWindows returns the windows created by (*VirtualMachine).CreateWindow
+// which are still open, in the order they were created. This can be used to build a
+// "Window" menu for applications which display multiple virtual machines.
+func ListVirtualMachineWindows() []WindowInfo {
+	return windows.list()
+}
+
+// FocusWindowByTitle brings the window which has the title to the front and makes it the key window.
+// If multiple windows have the same title, the one which was created first is focused.
+//
+// ErrWindowNotFound is returned if no window created by (*VirtualMachine).CreateWindow has the title.
+//
+// This is only supported on macOS 12 and newer, error will be returned on older versions.
+func FocusWindowByTitle(title string) error {
+	if err := macOSAvailable(12); err != nil {
+		return err
+	}
+	if _, ok := windows.lookup(title); !ok {
+		return fmt.Errorf("%w: %q", ErrWindowNotFound, title)
+	}
+	cs := charWithGoString(title)
+	defer cs.Free()
+	if !bool(C.focusVirtualMachineWindow(cs.CString())) {
+		return fmt.Errorf("%w: %q", ErrWindowNotFound, title)
+	}
+	return nil
+}
+
+// ShowWindow shows the window created by (*VirtualMachine).CreateWindow, brings it to the front and
+// makes it the key window. This reveals a window created with WithStartHidden, or a minimized one.
+//
+// ErrWindowNotFound is returned if the virtual machine has no window.
+//
+// This is only supported on macOS 12 and newer, error will be returned on older versions.
+func (v *VirtualMachine) ShowWindow() error {
+	if err := macOSAvailable(12); err != nil {
+		return err
+	}
+	if !bool(C.showVirtualMachineWindow(objc.Ptr(v))) {
+		return fmt.Errorf("%w: virtual machine %s has no window", ErrWindowNotFound, v.id)
+	}
+	return nil
+}
+
+// SetWindowFullscreen makes the window created by (*VirtualMachine).CreateWindow enter or leave
+// the native fullscreen mode of macOS. It does nothing if the window is already in the mode.
+// The transition is animated, and this returns without waiting for it.
+//
+// ErrWindowNotFound is returned if the virtual machine has no window.
+//
+// This is only supported on macOS 12 and newer, error will be returned on older versions.
+func (v *VirtualMachine) SetWindowFullscreen(fullscreen bool) error {
+	if err := macOSAvailable(12); err != nil {
+		return err
+	}
+	if !windows.has(v.id) || !bool(C.setVirtualMachineWindowFullscreen(objc.Ptr(v), C.bool(fullscreen))) {
+		return fmt.Errorf("%w: virtual machine %s has no window", ErrWindowNotFound, v.id)
+	}
 	return nil
 }
 
@@ -477,21 +1104,93 @@ func (v *VirtualMachine) CreateVMView() (unsafe.Pointer, error) {
 	return unsafe.Pointer(view), nil
 }
 
+type runApplicationOptions struct {
+	shutdownHooks []func()
+}
+
+// RunApplicationOption is an option for RunApplication.
+type RunApplicationOption func(*runApplicationOptions) error
+
+// WithShutdownHook registers hook which is called when the application quits, after the event loop
+// has stopped and before RunApplication returns. The application quits when the Quit menu item is
+// chosen, the last window is closed or StopApplication is called. Hooks are called in the order they
+// were registered.
+//
+// Quitting from the menu does not stop any virtual machine, and closing the last window only stops
+// the virtual machine displayed in it, so use a hook to stop the others cleanly (e.g. with
+// (*VirtualMachine).GracefulStop) before the process exits.
+func WithShutdownHook(hook func()) RunApplicationOption {
+	return func(o *runApplicationOptions) error {
+		if hook == nil {
+			return errors.New("shutdown hook must not be nil")
+		}
+		o.shutdownHooks = append(o.shutdownHooks, hook)
+		return nil
+	}
+}
+
+func (o *runApplicationOptions) runShutdownHooks() {
+	for _, hook := range o.shutdownHooks {
+		hook()
+	}
+}
+
 // RunApplication starts the AppKit event loop.
-// This function blocks until the application terminates.
+// This function blocks until the event loop stops, which happens when StopApplication is called
+// or the last window created by CreateWindow() is closed.
 // Call this after CreateWindow() to process window events.
 //
 // You must call runtime.LockOSThread before calling this method.
 //
 // This is only supported on macOS 12 and newer, error will be returned on older versions.
-func RunApplication() error {
+func RunApplication(opts ...RunApplicationOption) error {
 	if err := macOSAvailable(12); err != nil {
 		return err
 	}
+	o := &runApplicationOptions{}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return err
+		}
+	}
 	C.runApplication()
+	o.runShutdownHooks()
 	return nil
 }
 
+// StopApplication stops the virtual machines displayed in the windows created by
+// (*VirtualMachine).CreateWindow and makes RunApplication return.
+//
+// Each virtual machine is stopped with GracefulStop, so its guest is asked to turn itself off and
+// is stopped forcibly if it does not stop within timeout. Once all of them are stopped, the windows
+// are closed without confirmation and the event loop is stopped. The errors of stopping the virtual
+// machines are returned after that.
+//
+// This waits for the virtual machines to stop, so call it from a goroutine other than the one which
+// runs RunApplication. It returns without waiting for the event loop to stop.
+//
+// This is only supported on macOS 12 and newer, error will be returned on older versions.
+func StopApplication(timeout time.Duration) error {
+	if err := macOSAvailable(12); err != nil {
+		return err
+	}
+	vms := windows.virtualMachines()
+	errs := make([]error, len(vms))
+	var wg sync.WaitGroup
+	for i, vm := range vms {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := vm.GracefulStop(timeout); err != nil {
+				errs[i] = fmt.Errorf("failed to stop virtual machine %s: %w", vm.id, err)
+			}
+		}()
+	}
+	wg.Wait()
+	C.stopApplication()
+	return errors.Join(errs...)
+}
+
 // StartGraphicApplication starts an application to display graphics of the VM.
 // This is equivalent to calling CreateWindow() followed by RunApplication().
 //
@@ -515,6 +1214,20 @@ type DisconnectedError struct {
 	// This configuration helps identify which network device experienced the disconnection.
 	// If Config is nil, the specific configuration details are unavailable.
 	Config *VirtioNetworkDeviceConfiguration
+	// Index is the index of the network device in (*VirtualMachine).NetworkDevices, which is the
+	// order of the slice passed to SetNetworkDevicesVirtualMachineConfiguration. -1 if unknown.
+	Index int
+}
+
+func newDisconnectedError(networkDevices []*VirtioNetworkDeviceConfiguration, index int, err error) *DisconnectedError {
+	if index < 0 || index >= len(networkDevices) {
+		index = -1
+	}
+	return &DisconnectedError{
+		Err:    err,
+		Config: sliceutil.FindValueByIndex(networkDevices, index),
+		Index:  index,
+	}
 }
 
 var _ error = (*DisconnectedError)(nil)
@@ -548,19 +1261,9 @@ func (v *VirtualMachine) NetworkDeviceAttachmentWasDisconnected() (<-chan *Disco
 	return v.disconnectedOut.Out(), nil
 }
 
-// TODO(codehex): refactoring to leave using machineState's mutex lock.
 func (v *VirtualMachine) watchDisconnected() {
 	for disconnected := range v.disconnectedIn.Out() {
-		v.mu.RLock()
-		config := sliceutil.FindValueByIndex(
-			v.config.networkDeviceConfiguration,
-			disconnected.index,
-		)
-		v.mu.RUnlock()
-		v.disconnectedOut.In() <- &DisconnectedError{
-			Err:    disconnected.err,
-			Config: config,
-		}
+		v.disconnectedOut.In() <- newDisconnectedError(v.networkDevices, disconnected.index, disconnected.err)
 	}
 	v.disconnectedOut.Close()
 }
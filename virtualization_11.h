@@ -7,14 +7,20 @@
 #pragma once
 
 #import "virtualization_helper.h"
+#import <Security/Security.h>
 #import <Virtualization/Virtualization.h>
 
 /* exported from cgo */
 void connectionHandler(void *connection, void *err, uintptr_t cgoHandle);
 void changeStateOnObserver(int state, uintptr_t cgoHandle);
+void changeTargetVirtualMachineMemorySizeOnObserver(unsigned long long targetMemorySize, uintptr_t cgoHandle);
 bool shouldAcceptNewConnectionHandler(uintptr_t cgoHandle, void *connection, void *socketDevice);
 void emitAttachmentWasDisconnected(int index, void *err, uintptr_t cgoHandle);
 void closeAttachmentWasDisconnectedChannel(uintptr_t cgoHandle);
+void emitGuestStoppedEvent(uintptr_t cgoHandle);
+void emitStoppedWithErrorEvent(void *err, uintptr_t cgoHandle);
+void emitNetworkDisconnectedEvent(int index, void *err, uintptr_t cgoHandle);
+void emitWindowClosedEvent(uintptr_t cgoHandle);
 
 @interface Observer : NSObject
 - (void)observeValueForKeyPath:(NSString *)keyPath ofObject:(id)object change:(NSDictionary *)change context:(void *)context;
@@ -49,6 +55,16 @@ void closeAttachmentWasDisconnectedChannel(uintptr_t cgoHandle);
 - (void)dealloc;
 @end
 
+@interface VirtualMachineEventHandler : NSObject <VZVirtualMachineDelegate>
+- (instancetype)initWithHandle:(uintptr_t)cgoHandle;
+- (void)guestDidStopVirtualMachine:(VZVirtualMachine *)virtualMachine;
+- (void)virtualMachine:(VZVirtualMachine *)virtualMachine didStopWithError:(NSError *)error;
+- (void)virtualMachine:(VZVirtualMachine *)virtualMachine
+                         networkDevice:(VZNetworkDevice *)networkDevice
+    attachmentWasDisconnectedWithError:(NSError *)error API_AVAILABLE(macos(12.0));
+- (void)windowDidClose:(void *)windowController;
+@end
+
 /* VZVirtioSocketListener */
 @interface VZVirtioSocketListenerDelegateImpl : NSObject <VZVirtioSocketListenerDelegate>
 - (instancetype)initWithHandle:(uintptr_t)cgoHandle;
@@ -66,9 +82,12 @@ unsigned long long minimumAllowedMemorySizeVZVirtualMachineConfiguration();
 unsigned long long maximumAllowedMemorySizeVZVirtualMachineConfiguration();
 unsigned int minimumAllowedCPUCountVZVirtualMachineConfiguration();
 unsigned int maximumAllowedCPUCountVZVirtualMachineConfiguration();
+unsigned long long hostPhysicalMemorySize();
+bool hasVirtualizationEntitlement();
 void *newVZVirtualMachineConfiguration(void *bootLoader,
     unsigned int CPUCount,
     unsigned long long memorySize);
+unsigned int CPUCountVZVirtualMachineConfiguration(void *config);
 void setEntropyDevicesVZVirtualMachineConfiguration(void *config,
     void *entropyDevices);
 void setMemoryBalloonDevicesVZVirtualMachineConfiguration(void *config,
@@ -97,6 +116,7 @@ void *newVZNATNetworkDeviceAttachment(void);
 void *newVZFileHandleNetworkDeviceAttachment(int fileDescriptor);
 void *newVZVirtioNetworkDeviceConfiguration(void *attachment);
 void setNetworkDevicesVZMACAddress(void *config, void *macAddress);
+void *getNetworkDevicesVZMACAddress(void *config);
 void *newVZVirtioEntropyDeviceConfiguration(void);
 void *newVZVirtioBlockDeviceConfiguration(void *attachment);
 void *newVZDiskImageStorageDeviceAttachment(const char *diskPath, bool readOnly, void **error);
@@ -114,6 +134,8 @@ void *VZVirtualMachine_memoryBalloonDevices(void *machine);
 
 /* VirtualMachine */
 void *newVZVirtualMachineWithDispatchQueue(void *config, void *queue, uintptr_t statusUpdateCgoHandle, uintptr_t disconnectedCgoHandle);
+void VZVirtualMachine_setEventHandler(void *machine, uintptr_t cgoHandle);
+void VZVirtualMachine_windowDidClose(void *machine, void *windowController);
 bool requestStopVirtualMachine(void *machine, void *queue, void **error);
 void startWithCompletionHandler(void *machine, void *queue, uintptr_t cgoHandle);
 void pauseWithCompletionHandler(void *machine, void *queue, uintptr_t cgoHandle);
@@ -123,7 +145,20 @@ bool vmCanPause(void *machine, void *queue);
 bool vmCanResume(void *machine, void *queue);
 bool vmCanRequestStop(void *machine, void *queue);
 
-void *makeDispatchQueue(const char *label);
+typedef struct VZVirtualMachineCapabilitiesFlat {
+    bool canStart;
+    bool canPause;
+    bool canResume;
+    bool canRequestStop;
+    bool canStop;
+} VZVirtualMachineCapabilitiesFlat;
+
+VZVirtualMachineCapabilitiesFlat vmCapabilities(void *machine, void *queue);
+
+void *makeDispatchQueue(const char *label, unsigned int qos);
+void setDispatchQueueQoSClass(void *queue, unsigned int qos);
+unsigned int dispatchQueueQoSClass(void *queue);
+void pingDispatchQueue(void *queue, uintptr_t cgoHandle);
 
 /* VZVirtioSocketConnection */
 typedef struct VZVirtioSocketConnectionFlat {
@@ -137,3 +172,5 @@ VZVirtioSocketConnectionFlat convertVZVirtioSocketConnection2Flat(void *connecti
 /* VZVirtioTraditionalMemoryBalloonDevice */
 void VZVirtioTraditionalMemoryBalloonDevice_setTargetVirtualMachineMemorySize(void *balloonDevice, void *queue, unsigned long long targetMemorySize);
 unsigned long long VZVirtioTraditionalMemoryBalloonDevice_getTargetVirtualMachineMemorySize(void *balloonDevice, void *queue);
+void *VZVirtioTraditionalMemoryBalloonDevice_addTargetVirtualMachineMemorySizeObserver(void *balloonDevice, void *queue, uintptr_t cgoHandle);
+void VZVirtioTraditionalMemoryBalloonDevice_removeTargetVirtualMachineMemorySizeObserver(void *balloonDevice, void *queue, void *observer);
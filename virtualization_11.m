@@ -5,6 +5,7 @@
 //
 
 #import "virtualization_11.h"
+#import <objc/runtime.h>
 
 @implementation Observer
 - (void)observeValueForKeyPath:(NSString *)keyPath ofObject:(id)object change:(NSDictionary *)change context:(void *)context;
@@ -13,6 +14,9 @@
     if ([keyPath isEqualToString:@"state"]) {
         int newState = (int)[change[NSKeyValueChangeNewKey] integerValue];
         changeStateOnObserver(newState, (uintptr_t)context);
+    } else if ([keyPath isEqualToString:@"targetVirtualMachineMemorySize"]) {
+        unsigned long long targetMemorySize = [change[NSKeyValueChangeNewKey] unsignedLongLongValue];
+        changeTargetVirtualMachineMemorySizeOnObserver(targetMemorySize, (uintptr_t)context);
     }
 }
 @end
@@ -99,6 +103,43 @@
 }
 @end
 
+@implementation VirtualMachineEventHandler {
+    uintptr_t _cgoHandle;
+}
+
+- (instancetype)initWithHandle:(uintptr_t)cgoHandle
+{
+    self = [super init];
+    if (self) {
+        _cgoHandle = cgoHandle;
+    }
+    return self;
+}
+
+- (void)guestDidStopVirtualMachine:(VZVirtualMachine *)virtualMachine
+{
+    emitGuestStoppedEvent(_cgoHandle);
+}
+
+- (void)virtualMachine:(VZVirtualMachine *)virtualMachine didStopWithError:(NSError *)error
+{
+    emitStoppedWithErrorEvent(error, _cgoHandle);
+}
+
+- (void)virtualMachine:(VZVirtualMachine *)virtualMachine
+                         networkDevice:(VZNetworkDevice *)networkDevice
+    attachmentWasDisconnectedWithError:(NSError *)error
+{
+    NSInteger index = [virtualMachine.networkDevices indexOfObject:networkDevice];
+    emitNetworkDisconnectedEvent(index != NSNotFound ? (int)index : -1, error, _cgoHandle);
+}
+
+- (void)windowDidClose:(void *)windowController
+{
+    emitWindowClosedEvent(_cgoHandle, (uintptr_t)windowController);
+}
+@end
+
 @implementation ObservableVZVirtualMachine {
     Observer *_observer;
     VZVirtualMachineDelegateWrapper *_delegateWrapper;
@@ -278,6 +319,34 @@ unsigned int maximumAllowedCPUCountVZVirtualMachineConfiguration()
     RAISE_UNSUPPORTED_MACOS_EXCEPTION();
 }
 
+/*!
+ @abstract: Amount of physical memory on the host in bytes.
+ @see NSProcessInfo.physicalMemory
+ */
+unsigned long long hostPhysicalMemorySize()
+{
+    return (unsigned long long)[[NSProcessInfo processInfo] physicalMemory];
+}
+
+/*!
+ @abstract: Whether the current process is signed with the "com.apple.security.virtualization" entitlement.
+ */
+bool hasVirtualizationEntitlement()
+{
+    SecTaskRef task = SecTaskCreateFromSelf(kCFAllocatorDefault);
+    if (task == NULL) {
+        return false;
+    }
+    CFTypeRef value = SecTaskCopyValueForEntitlement(task, CFSTR("com.apple.security.virtualization"), NULL);
+    CFRelease(task);
+    if (value == NULL) {
+        return false;
+    }
+    bool entitled = CFGetTypeID(value) == CFBooleanGetTypeID() && CFBooleanGetValue((CFBooleanRef)value);
+    CFRelease(value);
+    return entitled;
+}
+
 /*!
  @abstract Create a new Virtual machine configuration.
  @param bootLoader Boot loader used when the virtual machine starts.
@@ -315,6 +384,19 @@ void *newVZVirtualMachineConfiguration(void *bootLoaderPtr,
     RAISE_UNSUPPORTED_MACOS_EXCEPTION();
 }
 
+/*!
+ @abstract Number of CPUs of the configuration, as held by the framework.
+ @see VZVirtualMachineConfiguration.CPUCount
+*/
+unsigned int CPUCountVZVirtualMachineConfiguration(void *config)
+{
+    if (@available(macOS 11, *)) {
+        return (unsigned int)[(VZVirtualMachineConfiguration *)config CPUCount];
+    }
+
+    RAISE_UNSUPPORTED_MACOS_EXCEPTION();
+}
+
 /*!
  @abstract List of entropy devices. Empty by default.
  @see VZVirtioEntropyDeviceConfiguration
@@ -848,6 +930,34 @@ void *newVZVirtualMachineWithDispatchQueue(void *config, void *queue, uintptr_t
     RAISE_UNSUPPORTED_MACOS_EXCEPTION();
 }
 
+static char eventHandlerKey;
+
+/*!
+ @abstract Add the delegate which emits the lifecycle events of the virtual machine.
+ @discussion The delegates of the virtual machine are held weakly, so the virtual machine keeps the handler alive.
+ */
+void VZVirtualMachine_setEventHandler(void *machine, uintptr_t cgoHandle)
+{
+    if (@available(macOS 11, *)) {
+        VirtualMachineEventHandler *handler = [[VirtualMachineEventHandler alloc] initWithHandle:cgoHandle];
+        [(VZVirtualMachine *)machine setDelegate:handler];
+        objc_setAssociatedObject((VZVirtualMachine *)machine, &eventHandlerKey, handler, OBJC_ASSOCIATION_RETAIN);
+        [handler release];
+        return;
+    }
+
+    RAISE_UNSUPPORTED_MACOS_EXCEPTION();
+}
+
+/*!
+ @abstract Emit the window closed event of the virtual machine if the event handler is set.
+ */
+void VZVirtualMachine_windowDidClose(void *machine, void *windowController)
+{
+    VirtualMachineEventHandler *handler = objc_getAssociatedObject((VZVirtualMachine *)machine, &eventHandlerKey);
+    [handler windowDidClose:windowController];
+}
+
 /*!
  @abstract Return the list of socket devices configured on this virtual machine. Return an empty array if no socket device is configured.
  @see VZVirtioSocketDeviceConfiguration
@@ -907,6 +1017,20 @@ void setNetworkDevicesVZMACAddress(void *config, void *macAddress)
     RAISE_UNSUPPORTED_MACOS_EXCEPTION();
 }
 
+/*!
+ @abstract Returns the media access control address of the device. The caller must release it.
+ @discussion
+    A random, locally administered address is assigned if none was set.
+ */
+void *getNetworkDevicesVZMACAddress(void *config)
+{
+    if (@available(macOS 11, *)) {
+        return [[(VZNetworkDeviceConfiguration *)config MACAddress] copy];
+    }
+
+    RAISE_UNSUPPORTED_MACOS_EXCEPTION();
+}
+
 /*!
  @abstract The address represented as a string.
  @discussion
@@ -942,14 +1066,47 @@ bool requestStopVirtualMachine(void *machine, void *queue, void **error)
     RAISE_UNSUPPORTED_MACOS_EXCEPTION();
 }
 
-void *makeDispatchQueue(const char *label)
+void *makeDispatchQueue(const char *label, unsigned int qos)
 {
-    // dispatch_queue_attr_t attr = dispatch_queue_attr_make_with_qos_class(DISPATCH_QUEUE_SERIAL, QOS_CLASS_DEFAULT, 0);
+    // The queue has no QoS class attribute, which would take precedence over the one of its
+    // target queue. The QoS class is set by the target queue only, so it can be changed later.
     dispatch_queue_t queue = dispatch_queue_create(label, DISPATCH_QUEUE_SERIAL);
+    if (qos != QOS_CLASS_UNSPECIFIED) {
+        setDispatchQueueQoSClass(queue, qos);
+    }
     // dispatch_retain(queue);
     return queue;
 }
 
+void setDispatchQueueQoSClass(void *queue, unsigned int qos)
+{
+    dispatch_set_target_queue((dispatch_queue_t)queue, dispatch_get_global_queue((dispatch_qos_class_t)qos, 0));
+}
+
+unsigned int dispatchQueueQoSClass(void *queue)
+{
+    // Read the QoS class which a task of the queue runs with. The task is not assigned the QoS
+    // class of the calling thread, so it runs with the one of the queue and its target queue.
+    __block qos_class_t qos = QOS_CLASS_UNSPECIFIED;
+    dispatch_semaphore_t done = dispatch_semaphore_create(0);
+    dispatch_block_t block = dispatch_block_create(DISPATCH_BLOCK_NO_QOS_CLASS, ^{
+        qos = qos_class_self();
+        dispatch_semaphore_signal(done);
+    });
+    dispatch_async((dispatch_queue_t)queue, block);
+    dispatch_semaphore_wait(done, DISPATCH_TIME_FOREVER);
+    Block_release(block);
+    dispatch_release(done);
+    return (unsigned int)qos;
+}
+
+void pingDispatchQueue(void *queue, uintptr_t cgoHandle)
+{
+    dispatch_async((dispatch_queue_t)queue, ^{
+        virtualMachineCompletionHandler(cgoHandle, nil);
+    });
+}
+
 void startWithCompletionHandler(void *machine, void *queue, uintptr_t cgoHandle)
 {
     if (@available(macOS 11, *)) {
@@ -1045,6 +1202,31 @@ bool vmCanRequestStop(void *machine, void *queue)
     RAISE_UNSUPPORTED_MACOS_EXCEPTION();
 }
 
+/*!
+ @abstract Return all of the "can" properties of the virtual machine in a single dispatch to the queue.
+ @discussion canStop is always false on macOS 11.
+ */
+VZVirtualMachineCapabilitiesFlat vmCapabilities(void *machine, void *queue)
+{
+    if (@available(macOS 11, *)) {
+        __block VZVirtualMachineCapabilitiesFlat ret;
+        dispatch_sync((dispatch_queue_t)queue, ^{
+            VZVirtualMachine *vm = (VZVirtualMachine *)machine;
+            ret.canStart = (bool)vm.canStart;
+            ret.canPause = (bool)vm.canPause;
+            ret.canResume = (bool)vm.canResume;
+            ret.canRequestStop = (bool)vm.canRequestStop;
+            ret.canStop = false;
+            if (@available(macOS 12, *)) {
+                ret.canStop = (bool)vm.canStop;
+            }
+        });
+        return ret;
+    }
+
+    RAISE_UNSUPPORTED_MACOS_EXCEPTION();
+}
+
 // --- TODO end
 
 /*!
@@ -1099,3 +1281,47 @@ unsigned long long VZVirtioTraditionalMemoryBalloonDevice_getTargetVirtualMachin
 
     RAISE_UNSUPPORTED_MACOS_EXCEPTION();
 }
+
+/*!
+ @abstract Observe changes of the target memory size for the virtual machine.
+ @discussion The observer must be removed with VZVirtioTraditionalMemoryBalloonDevice_removeTargetVirtualMachineMemorySizeObserver.
+ @param memoryBalloonDevice The memory balloon device to observe.
+ @param vmQueue The dispatch queue on which the virtual machine operates.
+ @param cgoHandle The cgo handle which receives the new target memory size.
+ @return The observer registered to the memory balloon device.
+ */
+void *VZVirtioTraditionalMemoryBalloonDevice_addTargetVirtualMachineMemorySizeObserver(void *memoryBalloonDevice, void *vmQueue, uintptr_t cgoHandle)
+{
+    if (@available(macOS 11, *)) {
+        Observer *observer = [[Observer alloc] init];
+        dispatch_sync((dispatch_queue_t)vmQueue, ^{
+            [(VZVirtioTraditionalMemoryBalloonDevice *)memoryBalloonDevice addObserver:observer
+                                                                            forKeyPath:@"targetVirtualMachineMemorySize"
+                                                                               options:NSKeyValueObservingOptionNew
+                                                                               context:(void *)cgoHandle];
+        });
+        return observer;
+    }
+
+    RAISE_UNSUPPORTED_MACOS_EXCEPTION();
+}
+
+/*!
+ @abstract Remove the observer which is added by VZVirtioTraditionalMemoryBalloonDevice_addTargetVirtualMachineMemorySizeObserver.
+ @param memoryBalloonDevice The memory balloon device which is observed.
+ @param vmQueue The dispatch queue on which the virtual machine operates.
+ @param observer The observer to remove.
+ */
+void VZVirtioTraditionalMemoryBalloonDevice_removeTargetVirtualMachineMemorySizeObserver(void *memoryBalloonDevice, void *vmQueue, void *observer)
+{
+    if (@available(macOS 11, *)) {
+        dispatch_sync((dispatch_queue_t)vmQueue, ^{
+            [(VZVirtioTraditionalMemoryBalloonDevice *)memoryBalloonDevice removeObserver:(Observer *)observer
+                                                                               forKeyPath:@"targetVirtualMachineMemorySize"];
+        });
+        [(Observer *)observer release];
+        return;
+    }
+
+    RAISE_UNSUPPORTED_MACOS_EXCEPTION();
+}
@@ -34,6 +34,9 @@ void *newVZVirtioFileSystemDeviceConfiguration(const char *tag, void **error);
 void setVZVirtioFileSystemDeviceConfigurationShare(void *config, void *share);
 
 void setDirectorySharingDevicesVZVirtualMachineConfiguration(void *config, void *directorySharingDevices);
+void *VZVirtualMachine_directorySharingDevices(void *machine, void *queue);
+const char *VZVirtioFileSystemDevice_tag(void *device);
+void VZVirtioFileSystemDevice_setShare(void *device, void *queue, void *share);
 void setPlatformVZVirtualMachineConfiguration(void *config,
     void *platform);
 void setGraphicsDevicesVZVirtualMachineConfiguration(void *config,
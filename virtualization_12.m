@@ -342,3 +342,48 @@ void *newVZUSBKeyboardConfiguration()
 
     RAISE_UNSUPPORTED_MACOS_EXCEPTION();
 }
+
+/*!
+ @abstract Return the list of directory sharing devices configured on this virtual machine.
+ @discussion Returns an empty array if no directory sharing device is configured.
+ */
+void *VZVirtualMachine_directorySharingDevices(void *machine, void *queue)
+{
+    if (@available(macOS 12, *)) {
+        __block NSArray<VZDirectorySharingDevice *> *devices;
+        dispatch_sync((dispatch_queue_t)queue, ^{
+            devices = [(VZVirtualMachine *)machine directorySharingDevices];
+        });
+        return devices;
+    }
+
+    RAISE_UNSUPPORTED_MACOS_EXCEPTION();
+}
+
+/*!
+ @abstract The tag which identifies the Virtio file system device in the guest.
+ */
+const char *VZVirtioFileSystemDevice_tag(void *device)
+{
+    if (@available(macOS 12, *)) {
+        return [[(VZVirtioFileSystemDevice *)device tag] UTF8String];
+    }
+
+    RAISE_UNSUPPORTED_MACOS_EXCEPTION();
+}
+
+/*!
+ @abstract Replace the directory share of the Virtio file system device while the virtual machine is running.
+ @param share The new directory share, or NULL to share nothing with the guest.
+ */
+void VZVirtioFileSystemDevice_setShare(void *device, void *queue, void *share)
+{
+    if (@available(macOS 12, *)) {
+        dispatch_sync((dispatch_queue_t)queue, ^{
+            [(VZVirtioFileSystemDevice *)device setShare:(VZDirectoryShare *)share];
+        });
+        return;
+    }
+
+    RAISE_UNSUPPORTED_MACOS_EXCEPTION();
+}
@@ -9,6 +9,9 @@
 #import "virtualization_helper.h"
 #import <Virtualization/Virtualization.h>
 
+/* exported from cgo */
+void consolePortDidChangeHandler(uintptr_t cgoHandle, const char *portName, bool open);
+
 /* macOS 13 API */
 void setConsoleDevicesVZVirtualMachineConfiguration(void *config, void *consoleDevices);
 
@@ -45,4 +48,15 @@ void startWithOptionsCompletionHandler(void *machine, void *queue, void *options
 
 const char *getMacOSGuestAutomountTag();
 
-void setMaximumTransmissionUnitVZFileHandleNetworkDeviceAttachment(void *attachment, NSInteger mtu);
\ No newline at end of file
+void setMaximumTransmissionUnitVZFileHandleNetworkDeviceAttachment(void *attachment, NSInteger mtu);
+void VZVirtualMachine_setConsoleDevicesDelegate(void *machine, void *queue, uintptr_t cgoHandle);
+void *VZVirtualMachine_virtioConsolePortNamed(void *machine, void *queue, const char *name);
+void VZVirtioConsolePort_setAttachment(void *port, void *queue, void *serialPortAttachment);
+
+#ifdef INCLUDE_TARGET_OSX_13
+@interface VZVirtioConsoleDeviceDelegateImpl : NSObject <VZVirtioConsoleDeviceDelegate>
+- (instancetype)initWithHandle:(uintptr_t)cgoHandle;
+- (void)consoleDevice:(VZVirtioConsoleDevice *)consoleDevice didOpenPort:(VZVirtioConsolePort *)consolePort API_AVAILABLE(macos(13.0));
+- (void)consoleDevice:(VZVirtioConsoleDevice *)consoleDevice didClosePort:(VZVirtioConsolePort *)consolePort API_AVAILABLE(macos(13.0));
+@end
+#endif
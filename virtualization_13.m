@@ -6,6 +6,7 @@
 
 #import "virtualization_13.h"
 #import "virtualization_view.h"
+#import <objc/runtime.h>
 
 /*!
  @abstract List of console devices. Empty by default.
@@ -475,4 +476,102 @@ void setMaximumTransmissionUnitVZFileHandleNetworkDeviceAttachment(void *attachm
     }
 #endif
     RAISE_UNSUPPORTED_MACOS_EXCEPTION();
-}
\ No newline at end of file
+}
+
+/*!
+ @abstract Set the delegate to the Virtio console devices of the virtual machine to be notified when
+    the guest opens or closes console ports.
+ */
+void VZVirtualMachine_setConsoleDevicesDelegate(void *machine, void *queue, uintptr_t cgoHandle)
+{
+#ifdef INCLUDE_TARGET_OSX_13
+    if (@available(macOS 13, *)) {
+        dispatch_sync((dispatch_queue_t)queue, ^{
+            for (VZConsoleDevice *device in [(VZVirtualMachine *)machine consoleDevices]) {
+                if (![device isKindOfClass:[VZVirtioConsoleDevice class]]) {
+                    continue;
+                }
+                VZVirtioConsoleDeviceDelegateImpl *delegate = [[VZVirtioConsoleDeviceDelegateImpl alloc] initWithHandle:cgoHandle];
+                [(VZVirtioConsoleDevice *)device setDelegate:delegate];
+                // The delegate property is weak, so the device keeps the delegate alive.
+                objc_setAssociatedObject(device, @selector(delegate), delegate, OBJC_ASSOCIATION_RETAIN);
+                [delegate release];
+            }
+        });
+        return;
+    }
+#endif
+    RAISE_UNSUPPORTED_MACOS_EXCEPTION();
+}
+
+/*!
+ @abstract Find the port of the Virtio console devices of the virtual machine by the name.
+ @return A retained VZVirtioConsolePort, or nil if no port has the name.
+ */
+void *VZVirtualMachine_virtioConsolePortNamed(void *machine, void *queue, const char *name)
+{
+#ifdef INCLUDE_TARGET_OSX_13
+    if (@available(macOS 13, *)) {
+        NSString *portName = [NSString stringWithUTF8String:name];
+        __block VZVirtioConsolePort *found = nil;
+        dispatch_sync((dispatch_queue_t)queue, ^{
+            for (VZConsoleDevice *device in [(VZVirtualMachine *)machine consoleDevices]) {
+                if (![device isKindOfClass:[VZVirtioConsoleDevice class]]) {
+                    continue;
+                }
+                VZVirtioConsolePortArray *ports = [(VZVirtioConsoleDevice *)device ports];
+                for (uint32_t i = 0; i < [ports maximumPortCount]; i++) {
+                    VZVirtioConsolePort *port = ports[i];
+                    if (port != nil && [[port name] isEqualToString:portName]) {
+                        found = [port retain];
+                        return;
+                    }
+                }
+            }
+        });
+        return found;
+    }
+#endif
+    RAISE_UNSUPPORTED_MACOS_EXCEPTION();
+}
+
+/*!
+ @abstract Replace the attachment of the console port while the virtual machine is running.
+ @param serialPortAttachment The new attachment, or NULL to detach the port.
+ */
+void VZVirtioConsolePort_setAttachment(void *port, void *queue, void *serialPortAttachment)
+{
+#ifdef INCLUDE_TARGET_OSX_13
+    if (@available(macOS 13, *)) {
+        dispatch_sync((dispatch_queue_t)queue, ^{
+            [(VZVirtioConsolePort *)port setAttachment:(VZSerialPortAttachment *)serialPortAttachment];
+        });
+        return;
+    }
+#endif
+    RAISE_UNSUPPORTED_MACOS_EXCEPTION();
+}
+
+#ifdef INCLUDE_TARGET_OSX_13
+@implementation VZVirtioConsoleDeviceDelegateImpl {
+    uintptr_t _cgoHandle;
+}
+
+- (instancetype)initWithHandle:(uintptr_t)cgoHandle
+{
+    self = [super init];
+    _cgoHandle = cgoHandle;
+    return self;
+}
+
+- (void)consoleDevice:(VZVirtioConsoleDevice *)consoleDevice didOpenPort:(VZVirtioConsolePort *)consolePort
+{
+    consolePortDidChangeHandler(_cgoHandle, [[consolePort name] UTF8String], true);
+}
+
+- (void)consoleDevice:(VZVirtioConsoleDevice *)consoleDevice didClosePort:(VZVirtioConsolePort *)consolePort
+{
+    consolePortDidChangeHandler(_cgoHandle, [[consolePort name] UTF8String], false);
+}
+@end
+#endif
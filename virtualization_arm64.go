@@ -13,7 +13,9 @@ package vz
 */
 import "C"
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -27,9 +29,55 @@ import (
 	"github.com/Code-Hex/vz/v3/internal/progress"
 )
 
+// MacOSStartOptions are the options to start a virtual machine which uses the macOS boot loader.
+// It covers every option of VZMacOSVirtualMachineStartOptions. Pass it to Start with
+// WithMacOSStartOptions.
+//
+// see: https://developer.apple.com/documentation/virtualization/vzmacosvirtualmachinestartoptions?language=objc
+type MacOSStartOptions struct {
+	// StartUpFromMacOSRecovery starts up the guest from macOS Recovery.
+	// See WithStartUpFromMacOSRecovery.
+	StartUpFromMacOSRecovery bool
+}
+
+func (o *MacOSStartOptions) newStartOptions() unsafe.Pointer {
+	return C.newVZMacOSVirtualMachineStartOptions(
+		C.bool(o.StartUpFromMacOSRecovery),
+	)
+}
+
+// macOSStartOptionsOf returns the macOS start options assembled in vmso, creating them if needed.
+func macOSStartOptionsOf(vmso *virtualMachineStartOptions) *MacOSStartOptions {
+	opts, ok := vmso.macOSStartOptions.(*MacOSStartOptions)
+	if !ok {
+		opts = &MacOSStartOptions{}
+		vmso.macOSStartOptions = opts
+	}
+	return opts
+}
+
+// WithMacOSStartOptions is an option to start up a macOS VM with opts. It replaces
+// the options set by previous options such as WithStartUpFromMacOSRecovery.
+//
+// This is only supported on macOS 13 and newer, error will
+// be returned on older versions.
+func WithMacOSStartOptions(opts MacOSStartOptions) VirtualMachineStartOption {
+	return func(vmso *virtualMachineStartOptions) error {
+		if err := macOSAvailable(13); err != nil {
+			return err
+		}
+		*macOSStartOptionsOf(vmso) = opts
+		return nil
+	}
+}
+
 // WithStartUpFromMacOSRecovery is an option to specifiy whether to start up
 // from macOS Recovery for macOS VM.
 //
+// The Virtualization framework does not report when macOS Recovery is ready. macOS Recovery
+// does not write to a serial console by itself, so for automation attach a console and wait
+// with a ConsoleMatcher for output which your tooling makes the guest write once it is ready.
+//
 // This is only supported on macOS 13 and newer, error will
 // be returned on older versions.
 func WithStartUpFromMacOSRecovery(startInRecovery bool) VirtualMachineStartOption {
@@ -37,9 +85,7 @@ func WithStartUpFromMacOSRecovery(startInRecovery bool) VirtualMachineStartOptio
 		if err := macOSAvailable(13); err != nil {
 			return err
 		}
-		vmso.macOSVirtualMachineStartOptionsPtr = C.newVZMacOSVirtualMachineStartOptions(
-			C.bool(startInRecovery),
-		)
+		macOSStartOptionsOf(vmso).StartUpFromMacOSRecovery = startInRecovery
 		return nil
 	}
 }
@@ -278,6 +324,65 @@ func (m *MacOSRestoreImage) MostFeaturefulSupportedConfiguration() *MacOSConfigu
 	return newMacOSConfigurationRequirements(m.mostFeaturefulSupportedConfigurationPtr)
 }
 
+// ErrRestoreImageIncompatible is returned by (*MacOSRestoreImage).ValidateAgainst when the virtual machine
+// configuration does not meet the requirements of the restore image.
+var ErrRestoreImageIncompatible = errors.New("restore image is incompatible with the virtual machine configuration")
+
+// ValidateAgainst checks that the configuration meets MostFeaturefulSupportedConfiguration of the restore image:
+// the number of CPUs and the memory size are not less than the minimums, and the hardware model of the
+// MacPlatformConfiguration is the one of the requirements.
+//
+// Call this before (*MacOSInstaller).Install to fail early, instead of failing at the end of the installation.
+// The returned error wraps ErrRestoreImageIncompatible and describes the first requirement which is not met.
+func (m *MacOSRestoreImage) ValidateAgainst(cfg *VirtualMachineConfiguration) error {
+	if m.mostFeaturefulSupportedConfigurationPtr == nil {
+		return fmt.Errorf("%w: no hardware model in the restore image %s is supported by this host", ErrRestoreImageIncompatible, m.buildVersion)
+	}
+	requirements := m.MostFeaturefulSupportedConfiguration()
+	if err := validateMacOSConfigurationRequirements(
+		cfg.cpuCount,
+		cfg.memorySize,
+		requirements.MinimumSupportedCPUCount(),
+		requirements.MinimumSupportedMemorySize(),
+	); err != nil {
+		return err
+	}
+	platform, ok := cfg.platformConfiguration.(*MacPlatformConfiguration)
+	if !ok {
+		return fmt.Errorf("%w: the platform must be MacPlatformConfiguration", ErrRestoreImageIncompatible)
+	}
+	if platform.HardwareModel() == nil {
+		return fmt.Errorf("%w: the hardware model of the platform is not set", ErrRestoreImageIncompatible)
+	}
+	if !platform.HardwareModel().Supported() {
+		return fmt.Errorf("%w: the hardware model of the platform is not supported by this host", ErrRestoreImageIncompatible)
+	}
+	return validateMacHardwareModel(
+		platform.HardwareModel().DataRepresentation(),
+		requirements.HardwareModel().DataRepresentation(),
+	)
+}
+
+func validateMacOSConfigurationRequirements(cpuCount uint, memorySize, minCPUCount, minMemorySize uint64) error {
+	if uint64(cpuCount) < minCPUCount {
+		return fmt.Errorf("%w: %d CPUs are configured but the restore image requires at least %d",
+			ErrRestoreImageIncompatible, cpuCount, minCPUCount)
+	}
+	if memorySize < minMemorySize {
+		const mib = 1024 * 1024
+		return fmt.Errorf("%w: %d MiB of memory is configured but the restore image requires at least %d MiB",
+			ErrRestoreImageIncompatible, memorySize/mib, (minMemorySize+mib-1)/mib)
+	}
+	return nil
+}
+
+func validateMacHardwareModel(configured, required []byte) error {
+	if !bytes.Equal(configured, required) {
+		return fmt.Errorf("%w: the hardware model of the platform is different from the one supported by the restore image", ErrRestoreImageIncompatible)
+	}
+	return nil
+}
+
 // MacOSConfigurationRequirements describes the parameter constraints required by a specific configuration of macOS.
 //
 // When a VZMacOSRestoreImage is loaded, it can be inspected to determine the configurations supported by that restore image.
@@ -379,7 +484,12 @@ func downloadRestoreImage(ctx context.Context, url string, destPath string) (*pr
 		return nil, fmt.Errorf("unexpected http status code: %d", resp.StatusCode)
 	}
 
-	reader := progress.NewReader(resp.Body, resp.ContentLength, fileInfo.Size())
+	// The total includes the part which was downloaded before when the download is resumed.
+	total := resp.ContentLength
+	if resp.StatusCode == http.StatusPartialContent {
+		total += fileInfo.Size()
+	}
+	reader := progress.NewReader(resp.Body, total, fileInfo.Size())
 
 	go func() {
 		defer f.Close()
@@ -396,39 +506,65 @@ func downloadRestoreImage(ctx context.Context, url string, destPath string) (*pr
 // This is only supported on macOS 12 and newer, error will
 // be returned on older versions.
 func GetLatestSupportedMacOSRestoreImageURL() (string, error) {
+	return GetLatestSupportedMacOSRestoreImageURLWithContext(context.Background())
+}
+
+// GetLatestSupportedMacOSRestoreImageURLWithContext is like GetLatestSupportedMacOSRestoreImageURL,
+// but returns ctx.Err() as soon as ctx is done. The request to the network which is made by the
+// Virtualization framework cannot be cancelled, so its result is discarded in that case.
+//
+// This is only supported on macOS 12 and newer, error will
+// be returned on older versions.
+func GetLatestSupportedMacOSRestoreImageURLWithContext(ctx context.Context) (string, error) {
 	if err := macOSAvailable(12); err != nil {
 		return "", err
 	}
-	waitCh := make(chan struct{})
-	var (
-		url      string
-		fetchErr error
-	)
+	return fetchLatestSupportedMacOSRestoreImageURL(ctx)
+}
+
+// fetchLatestSupportedMacOSRestoreImageURL fetches the URL of the latest restore image.
+// This is a variable to replace it in tests.
+var fetchLatestSupportedMacOSRestoreImageURL = func(ctx context.Context) (string, error) {
+	type result struct {
+		url string
+		err error
+	}
+	// Buffered so that the handler does not block if ctx is done before the fetch completes.
+	resultCh := make(chan result, 1)
 	handler := macOSRestoreImageHandler(func(restoreImage *MacOSRestoreImage, err error) {
-		url = restoreImage.URL()
-		fetchErr = err
-		defer close(waitCh)
+		resultCh <- result{url: restoreImage.URL(), err: err}
 	})
 	cgoHandle := cgo.NewHandle(handler)
 	C.fetchLatestSupportedMacOSRestoreImageWithCompletionHandler(
 		C.uintptr_t(cgoHandle),
 	)
-	<-waitCh
-	if fetchErr != nil {
-		return "", fetchErr
+	select {
+	case r := <-resultCh:
+		if r.err != nil {
+			return "", r.err
+		}
+		return r.url, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
 	}
-	return url, nil
 }
 
 // FetchLatestSupportedMacOSRestoreImage fetches the latest macOS restore image supported by this host from the network.
 //
+// ctx is used both for looking up the latest restore image and for downloading it. If ctx is done while
+// looking up, ctx.Err() is returned. If ctx is done while downloading, the download is aborted and
+// the returned progress.Reader finishes with the error.
+//
+// The returned progress.Reader reports the progress of the download with FractionCompleted.
+// Wait for Finished and check Err to know the download completed.
+//
 // After downloading the restore image, you can initialize a MacOSInstaller using LoadMacOSRestoreImageFromPath function
 // with the local restore image file.
 //
 // This is only supported on macOS 12 and newer, error will
 // be returned on older versions.
 func FetchLatestSupportedMacOSRestoreImage(ctx context.Context, destPath string) (*progress.Reader, error) {
-	url, err := GetLatestSupportedMacOSRestoreImageURL()
+	url, err := GetLatestSupportedMacOSRestoreImageURLWithContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -612,13 +748,14 @@ func (v *VirtualMachine) SaveMachineStateToPath(saveFilePath string) error {
 	if _, err := v.config.ValidateSaveRestoreSupport(); err != nil {
 		return err
 	}
+	v.opMu.Lock()
+	defer v.opMu.Unlock()
 	cs := charWithGoString(saveFilePath)
 	defer cs.Free()
-	h, errCh := makeHandler()
-	handle := cgo.NewHandle(h)
-	defer handle.Delete()
+	handle, errCh, release := makeHandler()
+	defer release()
 	C.saveMachineStateToURLWithCompletionHandler(objc.Ptr(v), v.dispatchQueue, C.uintptr_t(handle), cs.CString())
-	return <-errCh
+	return waitCompletion("save machine state", errCh)
 }
 
 // RestoreMachineStateFromURL restores a VM from a previously saved state.
@@ -646,11 +783,49 @@ func (v *VirtualMachine) RestoreMachineStateFromURL(saveFilePath string) error {
 	if _, err := v.config.ValidateSaveRestoreSupport(); err != nil {
 		return err
 	}
+	v.opMu.Lock()
+	defer v.opMu.Unlock()
 	cs := charWithGoString(saveFilePath)
 	defer cs.Free()
-	h, errCh := makeHandler()
-	handle := cgo.NewHandle(h)
-	defer handle.Delete()
+	handle, errCh, release := makeHandler()
+	defer release()
 	C.restoreMachineStateFromURLWithCompletionHandler(objc.Ptr(v), v.dispatchQueue, C.uintptr_t(handle), cs.CString())
-	return <-errCh
+	return waitCompletion("restore machine state", errCh)
+}
+
+// RestoreVirtualMachine creates a virtual machine with config and restores it from the state
+// saved to saveFilePath by SaveMachineStateToPath, for workflows which never boot the virtual
+// machine from scratch. The returned virtual machine is in the paused state, call Resume to run it.
+//
+// config must be equivalent to the configuration the state was saved with: the same boot loader,
+// platform (including the machine identifier of a macOS guest) and devices. The boot loader is not
+// used to boot, but it is still required, see NewVirtualMachineConfiguration.
+//
+// Before the virtual machine is created, saveFilePath must exist, config must be valid and must
+// support save and restore. If the framework rejects the saved state, the error of
+// RestoreMachineStateFromURL is returned and the virtual machine is discarded.
+//
+// This is only supported on macOS 14 and newer, error will
+// be returned on older versions.
+func RestoreVirtualMachine(config *VirtualMachineConfiguration, saveFilePath string, opts ...NewVirtualMachineOption) (*VirtualMachine, error) {
+	if err := macOSAvailable(14); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(saveFilePath); err != nil {
+		return nil, fmt.Errorf("failed to open saved state: %w", err)
+	}
+	if _, err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration to restore: %w", err)
+	}
+	if _, err := config.ValidateSaveRestoreSupport(); err != nil {
+		return nil, fmt.Errorf("configuration does not support restore: %w", err)
+	}
+	vm, err := NewVirtualMachine(config, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := vm.RestoreMachineStateFromURL(saveFilePath); err != nil {
+		return nil, err
+	}
+	return vm, nil
 }
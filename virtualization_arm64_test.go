@@ -0,0 +1,327 @@
+//go:build darwin && arm64
+// +build darwin,arm64
+
+package vz_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Code-Hex/vz/v3"
+)
+
+func TestFetchLatestSupportedMacOSRestoreImageCancel(t *testing.T) {
+	if vz.Available(12) {
+		t.Skip("FetchLatestSupportedMacOSRestoreImage is supported from macOS 12")
+	}
+	// The fetch by the framework hangs until ctx is done.
+	defer vz.SwapFetchLatestSupportedMacOSRestoreImageURL(func(ctx context.Context) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	})()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := vz.FetchLatestSupportedMacOSRestoreImage(ctx, filepath.Join(t.TempDir(), "RestoreImage.ipsw"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want context.DeadlineExceeded but got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("want to return promptly but took %v", elapsed)
+	}
+}
+
+func TestFetchLatestSupportedMacOSRestoreImageProgress(t *testing.T) {
+	if vz.Available(12) {
+		t.Skip("FetchLatestSupportedMacOSRestoreImage is supported from macOS 12")
+	}
+	content := bytes.Repeat([]byte("ipsw"), 256*1024)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "RestoreImage.ipsw", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+	defer vz.SwapFetchLatestSupportedMacOSRestoreImageURL(func(ctx context.Context) (string, error) {
+		return srv.URL, nil
+	})()
+
+	// Resume from the middle of the file.
+	destPath := filepath.Join(t.TempDir(), "RestoreImage.ipsw")
+	if err := os.WriteFile(destPath, content[:len(content)/2], 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := vz.FetchLatestSupportedMacOSRestoreImage(context.Background(), destPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reader.FractionCompleted(); got != 0.5 {
+		t.Fatalf("want fraction 0.5 at the start of resumed download but got %v", got)
+	}
+	select {
+	case <-reader.Finished():
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the download")
+	}
+	if err := reader.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got := reader.FractionCompleted(); got != 1 {
+		t.Fatalf("want fraction 1 but got %v", got)
+	}
+	got, err := os.ReadFile(destPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, got) {
+		t.Fatal("downloaded content is different")
+	}
+}
+
+func TestFetchLatestSupportedMacOSRestoreImageCancelDownload(t *testing.T) {
+	if vz.Available(12) {
+		t.Skip("FetchLatestSupportedMacOSRestoreImage is supported from macOS 12")
+	}
+	// The server sends a part of the body and stalls.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1048576")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(strings.Repeat("x", 1024)))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	defer vz.SwapFetchLatestSupportedMacOSRestoreImageURL(func(ctx context.Context) (string, error) {
+		return srv.URL, nil
+	})()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reader, err := vz.FetchLatestSupportedMacOSRestoreImage(ctx, filepath.Join(t.TempDir(), "RestoreImage.ipsw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	select {
+	case <-reader.Finished():
+	case <-time.After(time.Second):
+		t.Fatal("want the download to be aborted promptly")
+	}
+	if err := reader.Err(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("want context.Canceled but got %v", err)
+	}
+}
+
+func TestValidateMacOSConfigurationRequirements(t *testing.T) {
+	const (
+		gib           = 1024 * 1024 * 1024
+		minCPUCount   = 2
+		minMemorySize = 4 * gib
+	)
+	cases := []struct {
+		name       string
+		cpuCount   uint
+		memorySize uint64
+		wantErr    string
+	}{
+		{name: "exactly minimum", cpuCount: 2, memorySize: 4 * gib},
+		{name: "more than minimum", cpuCount: 8, memorySize: 16 * gib},
+		{name: "too few CPUs", cpuCount: 1, memorySize: 8 * gib, wantErr: "1 CPUs are configured but the restore image requires at least 2"},
+		{name: "too little memory", cpuCount: 4, memorySize: 4*gib - 1024*1024, wantErr: "4095 MiB of memory is configured but the restore image requires at least 4096 MiB"},
+		{name: "CPU is checked first", cpuCount: 1, memorySize: 1 * gib, wantErr: "CPUs are configured"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := vz.ValidateMacOSConfigurationRequirements(tc.cpuCount, tc.memorySize, minCPUCount, minMemorySize)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("want no error but got %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, vz.ErrRestoreImageIncompatible) {
+				t.Fatalf("want ErrRestoreImageIncompatible but got %v", err)
+			}
+			if !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("want error containing %q but got %q", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateMacHardwareModel(t *testing.T) {
+	if err := vz.ValidateMacHardwareModel([]byte("model"), []byte("model")); err != nil {
+		t.Fatalf("want no error but got %v", err)
+	}
+	if err := vz.ValidateMacHardwareModel([]byte("model"), []byte("other")); !errors.Is(err, vz.ErrRestoreImageIncompatible) {
+		t.Fatalf("want ErrRestoreImageIncompatible but got %v", err)
+	}
+}
+
+func TestValidatePlatformMac(t *testing.T) {
+	if vz.Available(12) {
+		t.Skip("MacPlatformConfiguration is supported from macOS 12")
+	}
+	macOSBootLoader, err := vz.NewMacOSBootLoader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	linuxBootLoader, err := vz.NewLinuxBootLoader("./testdata/Image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	genericPlatform, err := vz.NewGenericPlatformConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	macPlatform, err := vz.NewMacPlatformConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name       string
+		bootLoader vz.BootLoader
+		platform   vz.PlatformConfiguration
+		wantErr    string
+	}{
+		{name: "macOS boot loader without platform", bootLoader: macOSBootLoader, wantErr: "MacOSBootLoader requires MacPlatformConfiguration"},
+		{name: "macOS boot loader with generic platform", bootLoader: macOSBootLoader, platform: genericPlatform, wantErr: "not GenericPlatformConfiguration"},
+		{name: "Linux boot loader with Mac platform", bootLoader: linuxBootLoader, platform: macPlatform, wantErr: "MacPlatformConfiguration requires MacOSBootLoader"},
+		{name: "Mac platform without hardware model", bootLoader: macOSBootLoader, platform: macPlatform, wantErr: "no hardware model"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			config, err := vz.NewVirtualMachineConfiguration(tc.bootLoader, 2, 4*1024*1024*1024)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.platform != nil {
+				config.SetPlatformVirtualMachineConfiguration(tc.platform)
+			}
+			err = config.ValidatePlatform()
+			if !errors.Is(err, vz.ErrInvalidPlatform) {
+				t.Fatalf("want ErrInvalidPlatform but got %v", err)
+			}
+			if !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("want error containing %q but got %q", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestMacOSStartOptions(t *testing.T) {
+	if vz.Available(13) {
+		t.Skip("VZMacOSVirtualMachineStartOptions is supported from macOS 13")
+	}
+
+	got, err := vz.MacOSStartOptionsOf()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != nil {
+		t.Errorf("want no macOS start options but got %+v", got)
+	}
+
+	cases := []struct {
+		name string
+		opts []vz.VirtualMachineStartOption
+		want vz.MacOSStartOptions
+	}{
+		{
+			name: "recovery",
+			opts: []vz.VirtualMachineStartOption{vz.WithStartUpFromMacOSRecovery(true)},
+			want: vz.MacOSStartOptions{StartUpFromMacOSRecovery: true},
+		},
+		{
+			name: "options",
+			opts: []vz.VirtualMachineStartOption{vz.WithMacOSStartOptions(vz.MacOSStartOptions{StartUpFromMacOSRecovery: true})},
+			want: vz.MacOSStartOptions{StartUpFromMacOSRecovery: true},
+		},
+		{
+			name: "later option wins",
+			opts: []vz.VirtualMachineStartOption{
+				vz.WithMacOSStartOptions(vz.MacOSStartOptions{StartUpFromMacOSRecovery: true}),
+				vz.WithStartUpFromMacOSRecovery(false),
+			},
+			want: vz.MacOSStartOptions{},
+		},
+		{
+			name: "options replace",
+			opts: []vz.VirtualMachineStartOption{
+				vz.WithStartUpFromMacOSRecovery(true),
+				vz.WithMacOSStartOptions(vz.MacOSStartOptions{}),
+			},
+			want: vz.MacOSStartOptions{},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := vz.MacOSStartOptionsOf(tc.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got == nil || *got != tc.want {
+				t.Errorf("want %+v but got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRestoreVirtualMachine(t *testing.T) {
+	if vz.Available(14) {
+		t.Skip("RestoreVirtualMachine is supported from macOS 14")
+	}
+
+	var config *vz.VirtualMachineConfiguration
+	container := newVirtualizationMachine(t,
+		func(vmc *vz.VirtualMachineConfiguration) error {
+			config = vmc
+			return nil
+		},
+	)
+	vm := container.VirtualMachine
+	if err := vm.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	waitState(t, 5*time.Second, vm, vz.VirtualMachineStatePaused)
+
+	saveFile := filepath.Join(t.TempDir(), "state.vzvmsave")
+	if err := vm.SaveMachineStateToPath(saveFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := container.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("missing saved state", func(t *testing.T) {
+		_, err := vz.RestoreVirtualMachine(config, filepath.Join(t.TempDir(), "missing.vzvmsave"))
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("want os.ErrNotExist but got %v", err)
+		}
+	})
+
+	restored, err := vz.RestoreVirtualMachine(config, saveFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := restored.State(); got != vz.VirtualMachineStatePaused {
+		t.Fatalf("want state %v but got %v", vz.VirtualMachineStatePaused, got)
+	}
+	if err := restored.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	waitState(t, 5*time.Second, restored, vz.VirtualMachineStateRunning)
+	if err := restored.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	waitState(t, 5*time.Second, restored, vz.VirtualMachineStateStopped)
+}
@@ -18,6 +18,14 @@
 // Application lifecycle - call once per process
 void initializeApplication(void);
 void runApplication(void);
+void stopApplication(void);
+
+// Application menus and Dock icon
+void setApplicationMenus(const char *spec);
+bool setDockIcon(void *data, int length);
+
+/* exported from cgo */
+void menuItemActionHandler(int action);
 
 // Low-level: create raw VZVirtualMachineView for custom handlers
 // Consumer is responsible for window management, embedding, etc.
@@ -25,7 +33,39 @@ void *createVirtualMachineView(void *machine);
 
 // High-level: create window with full VMWindowController (default GUI)
 // Non-blocking, shows window immediately
-void *createVirtualMachineWindow(void *machine, void *queue, double width, double height, const char *title, bool enableController, bool confirmStopOnClose);
+void *createVirtualMachineWindow(void *machine, void *queue, double width, double height, const char *title, bool enableController, bool confirmStopOnClose, bool startHidden);
+
+// The number of window controllers created by createVirtualMachineWindow which are not deallocated yet.
+int liveVirtualMachineWindowControllers(void);
+
+// Bring the window which has the title to the front. Returns false if there is no such window.
+bool focusVirtualMachineWindow(const char *title);
+
+// Show the window of the virtual machine, e.g. one created hidden. Returns false if there is no such window.
+bool showVirtualMachineWindow(void *machine);
+
+// Make the window of the virtual machine enter or exit fullscreen. Returns false if there is no such window.
+bool setVirtualMachineWindowFullscreen(void *machine, bool fullscreen);
+
+// Capture the view in the window of the virtual machine as RGBA pixels which the caller must free.
+// Returns NULL if there is no such window or it is not visible.
+void *captureVirtualMachineWindow(void *machine, int *width, int *height);
+
+// Send a keyboard event (0: key down, 1: key up, 2: flags changed) with the macOS virtual key code
+// to the view in the window of the virtual machine. Returns false if there is no such window.
+bool sendVirtualMachineKeyEvent(void *machine, int type, unsigned short keyCode, unsigned long long modifierFlags);
+
+// Console window: shows the output of a serial console in a scrollable text view.
+// Non-blocking, shows window immediately unless it starts hidden.
+void *createConsoleWindow(double width, double height, const char *title, uintptr_t cgoHandle, bool startHidden);
+
+// Append the console output to the text view of the console window. Must not be called
+// after consoleWindowClosedHandler was called for the window.
+void appendConsoleWindowText(void *controller, const char *data, int length);
+
+/* exported from cgo */
+void consoleWindowInputHandler(uintptr_t cgoHandle, char *data, int length);
+void consoleWindowClosedHandler(uintptr_t cgoHandle);
 
 // Legacy combined API (calls create + run internally)
 void startVirtualMachineWindow(void *machine, void *queue, double width, double height, const char *title, bool enableController, bool confirmStopOnClose);
@@ -58,7 +98,27 @@ API_AVAILABLE(macos(12.0))
                       enableController:(BOOL)enableController
                     confirmStopOnClose:(BOOL)confirmStopOnClose;
 - (void)setupAndShowWindow;
+- (void)setupWindowAndShow:(BOOL)show;
 - (NSWindow *)window;
+- (VZVirtualMachine *)virtualMachine;
+- (VZVirtualMachineView *)virtualMachineView;
+@end
+
+// ConsoleTextView sends the typed keys to the console instead of inserting them,
+// the guest echoes them back.
+@interface ConsoleTextView : NSTextView
+- (instancetype)initWithFrame:(NSRect)frame cgoHandle:(uintptr_t)cgoHandle;
+@end
+
+// ConsoleWindowController manages a console window. Closing the window does not stop the VM.
+API_AVAILABLE(macos(12.0))
+@interface ConsoleWindowController : NSObject <NSWindowDelegate>
+- (instancetype)initWithTitle:(NSString *)title
+                  windowWidth:(CGFloat)windowWidth
+                 windowHeight:(CGFloat)windowHeight
+                    cgoHandle:(uintptr_t)cgoHandle;
+- (NSWindow *)window;
+- (void)appendText:(NSString *)text;
 @end
 
 // AppDelegate manages application lifecycle and menus.
@@ -68,4 +128,16 @@ API_AVAILABLE(macos(12.0))
 + (instancetype)sharedDelegate;
 - (void)addWindowController:(VMWindowController *)controller;
 - (void)removeWindowController:(VMWindowController *)controller;
+- (BOOL)focusWindowWithTitle:(NSString *)title;
+- (VZVirtualMachineView *)virtualMachineViewForVirtualMachine:(VZVirtualMachine *)virtualMachine;
+- (BOOL)showWindowForVirtualMachine:(VZVirtualMachine *)virtualMachine;
+- (BOOL)setFullscreen:(BOOL)fullscreen forVirtualMachine:(VZVirtualMachine *)virtualMachine;
+- (void)applyApplicationMenus;
+- (void)closeAllWindows;
+@end
+
+// MenuActionHandler is the target of the menu items set by setApplicationMenus.
+@interface MenuActionHandler : NSObject
++ (instancetype)sharedHandler;
+- (void)performAction:(NSMenuItem *)sender;
 @end
@@ -41,6 +41,154 @@ void runApplication()
     }
 }
 
+#pragma mark - Application Menus
+
+// The JSON spec of the menus which is set by setApplicationMenus.
+static NSString *_applicationMenuSpec = nil;
+
+static void runOnMainThread(void (^block)(void))
+{
+    if ([NSThread isMainThread]) {
+        block();
+    } else {
+        dispatch_sync(dispatch_get_main_queue(), block);
+    }
+}
+
+void setApplicationMenus(const char *spec)
+{
+    initializeApplication();
+
+    if (@available(macOS 12, *)) {
+        NSString *menuSpec = [NSString stringWithUTF8String:spec];
+        runOnMainThread(^{
+            [_applicationMenuSpec release];
+            _applicationMenuSpec = [menuSpec copy];
+            // Applied by applicationDidFinishLaunching: if the app is not launched yet.
+            [[AppDelegate sharedDelegate] applyApplicationMenus];
+        });
+    }
+}
+
+bool setDockIcon(void *data, int length)
+{
+    initializeApplication();
+
+    if (@available(macOS 12, *)) {
+        NSData *imageData = [NSData dataWithBytes:data length:(NSUInteger)length];
+        __block BOOL ok = NO;
+        runOnMainThread(^{
+            NSImage *image = [[[NSImage alloc] initWithData:imageData] autorelease];
+            if (image) {
+                [NSApp setApplicationIconImage:image];
+                ok = YES;
+            }
+        });
+        return ok;
+    }
+    return false;
+}
+
+static NSEventModifierFlags menuKeyModifierFlags(NSUInteger modifiers)
+{
+    // Keep in sync with MenuKeyModifier in menu.go.
+    NSEventModifierFlags flags = 0;
+    if (modifiers & (1 << 0))
+        flags |= NSEventModifierFlagCommand;
+    if (modifiers & (1 << 1))
+        flags |= NSEventModifierFlagShift;
+    if (modifiers & (1 << 2))
+        flags |= NSEventModifierFlagOption;
+    if (modifiers & (1 << 3))
+        flags |= NSEventModifierFlagControl;
+    return flags;
+}
+
+static NSMenu *newMenuFromSpec(NSString *title, NSArray *items)
+{
+    NSMenu *menu = [[NSMenu alloc] initWithTitle:title];
+    for (NSDictionary *spec in items) {
+        if ([spec[@"separator"] boolValue]) {
+            [menu addItem:[NSMenuItem separatorItem]];
+            continue;
+        }
+        NSString *keyEquivalent = spec[@"keyEquivalent"] ?: @"";
+        NSMenuItem *item = [[[NSMenuItem alloc] initWithTitle:spec[@"title"] ?: @""
+                                                       action:nil
+                                                keyEquivalent:keyEquivalent] autorelease];
+        if ([keyEquivalent length] > 0) {
+            [item setKeyEquivalentModifierMask:menuKeyModifierFlags([spec[@"modifiers"] unsignedIntegerValue])];
+        }
+        NSInteger action = [spec[@"action"] integerValue];
+        if (action > 0) {
+            [item setTag:action];
+            [item setTarget:[MenuActionHandler sharedHandler]];
+            [item setAction:@selector(performAction:)];
+        }
+        NSArray *submenuItems = spec[@"submenu"];
+        if ([submenuItems count] > 0) {
+            NSMenu *submenu = newMenuFromSpec([item title], submenuItems);
+            [item setSubmenu:submenu];
+            [submenu release];
+        }
+        [menu addItem:item];
+    }
+    return menu;
+}
+
+@implementation MenuActionHandler
+
++ (instancetype)sharedHandler
+{
+    static MenuActionHandler *sharedHandler = nil;
+    static dispatch_once_t onceToken;
+    dispatch_once(&onceToken, ^{
+        sharedHandler = [[MenuActionHandler alloc] init];
+    });
+    return sharedHandler;
+}
+
+- (void)performAction:(NSMenuItem *)sender
+{
+    menuItemActionHandler((int)[sender tag]);
+}
+
+@end
+
+// stopEventLoop makes [NSApp run] return instead of exiting the process like -terminate: does.
+// VZApplication wakes its event loop up in -stop:, but an NSApplication created by the host app
+// only stops once it has processed an event, so an application-defined event is posted to wake
+// it up. Must be called on the main thread.
+static void stopEventLoop(void)
+{
+    [NSApp stop:nil];
+    if ([NSApp isKindOfClass:[VZApplication class]]) {
+        return;
+    }
+    NSEvent *event = [NSEvent otherEventWithType:NSEventTypeApplicationDefined
+                                        location:NSZeroPoint
+                                   modifierFlags:0
+                                       timestamp:0
+                                    windowNumber:0
+                                         context:nil
+                                         subtype:0
+                                           data1:0
+                                           data2:0];
+    [NSApp postEvent:event atStart:NO];
+}
+
+void stopApplication()
+{
+    if (@available(macOS 12, *)) {
+        // Asynchronous so that this does not wait forever if the event loop is not running.
+        dispatch_async(dispatch_get_main_queue(), ^{
+            // The virtual machines are stopped by StopApplication already.
+            [[AppDelegate sharedDelegate] closeAllWindows];
+            stopEventLoop();
+        });
+    }
+}
+
 #pragma mark - Low-level View Creation (for custom handlers)
 
 void *createVirtualMachineView(void *machine)
@@ -63,7 +211,7 @@ void *createVirtualMachineView(void *machine)
 
 #pragma mark - Per-VM Window Management (default GUI)
 
-void *createVirtualMachineWindow(void *machine, void *queue, double width, double height, const char *title, bool enableController, bool confirmStopOnClose)
+void *createVirtualMachineWindow(void *machine, void *queue, double width, double height, const char *title, bool enableController, bool confirmStopOnClose, bool startHidden)
 {
     initializeApplication();
 
@@ -82,12 +230,15 @@ void *createVirtualMachineWindow(void *machine, void *queue, double width, doubl
                           enableController:enableController
                         confirmStopOnClose:confirmStopOnClose];
 
-                // Register with app delegate and show window
+                // Register with app delegate and show window unless it starts hidden.
+                // The app delegate owns the controller from here on so that
+                // repeated window creation does not leak the previous ones.
                 AppDelegate *appDelegate = (AppDelegate *)NSApp.delegate;
                 if (appDelegate) {
                     [appDelegate addWindowController:controller];
+                    [controller release];
                 }
-                [controller setupAndShowWindow];
+                [controller setupWindowAndShow:!startHidden];
             }
         };
 
@@ -103,6 +254,197 @@ void *createVirtualMachineWindow(void *machine, void *queue, double width, doubl
     return NULL;
 }
 
+bool focusVirtualMachineWindow(const char *title)
+{
+    if (@available(macOS 12, *)) {
+        __block BOOL focused = NO;
+        NSString *windowTitle = [NSString stringWithUTF8String:title];
+
+        void (^focusWindow)(void) = ^{
+            AppDelegate *appDelegate = (AppDelegate *)NSApp.delegate;
+            if (appDelegate) {
+                focused = [appDelegate focusWindowWithTitle:windowTitle];
+            }
+        };
+
+        // UI operations must happen on main thread
+        if ([NSThread isMainThread]) {
+            focusWindow();
+        } else {
+            dispatch_sync(dispatch_get_main_queue(), focusWindow);
+        }
+        return focused;
+    }
+    return false;
+}
+
+bool showVirtualMachineWindow(void *machine)
+{
+    if (@available(macOS 12, *)) {
+        __block BOOL shown = NO;
+
+        void (^showWindow)(void) = ^{
+            AppDelegate *appDelegate = (AppDelegate *)NSApp.delegate;
+            if (appDelegate) {
+                shown = [appDelegate showWindowForVirtualMachine:(VZVirtualMachine *)machine];
+            }
+        };
+
+        // UI operations must happen on main thread
+        if ([NSThread isMainThread]) {
+            showWindow();
+        } else {
+            dispatch_sync(dispatch_get_main_queue(), showWindow);
+        }
+        return shown;
+    }
+    return false;
+}
+
+bool setVirtualMachineWindowFullscreen(void *machine, bool fullscreen)
+{
+    if (@available(macOS 12, *)) {
+        __block BOOL found = NO;
+
+        void (^setFullscreen)(void) = ^{
+            AppDelegate *appDelegate = (AppDelegate *)NSApp.delegate;
+            if (appDelegate) {
+                found = [appDelegate setFullscreen:fullscreen forVirtualMachine:(VZVirtualMachine *)machine];
+            }
+        };
+
+        // UI operations must happen on main thread
+        if ([NSThread isMainThread]) {
+            setFullscreen();
+        } else {
+            dispatch_sync(dispatch_get_main_queue(), setFullscreen);
+        }
+        return found;
+    }
+    return false;
+}
+
+void *captureVirtualMachineWindow(void *machine, int *width, int *height)
+{
+    if (@available(macOS 12, *)) {
+        __block void *pixels = NULL;
+
+        void (^capture)(void) = ^{
+            @autoreleasepool {
+                AppDelegate *appDelegate = (AppDelegate *)NSApp.delegate;
+                if (appDelegate == nil) {
+                    return;
+                }
+                NSView *view = [appDelegate virtualMachineViewForVirtualMachine:(VZVirtualMachine *)machine];
+                NSWindow *window = [view window];
+                if (view == nil || window == nil || ![window isVisible]) {
+                    return;
+                }
+                // The guest display is drawn by Metal, which cacheDisplayInRect: does not capture,
+                // so capture the composited window instead. The rectangle of the view is converted
+                // to the global display coordinates, whose origin is the top left of the main display.
+                NSRect rect = [window convertRectToScreen:[view convertRect:[view bounds] toView:nil]];
+                CGFloat screenHeight = NSMaxY([[[NSScreen screens] firstObject] frame]);
+                CGRect captureRect = CGRectMake(NSMinX(rect), screenHeight - NSMaxY(rect), NSWidth(rect), NSHeight(rect));
+                CGImageRef image = CGWindowListCreateImage(captureRect, kCGWindowListOptionIncludingWindow,
+                    (CGWindowID)[window windowNumber], kCGWindowImageBoundsIgnoreFraming | kCGWindowImageBestResolution);
+                if (image == NULL) {
+                    return;
+                }
+                size_t w = CGImageGetWidth(image);
+                size_t h = CGImageGetHeight(image);
+                if (w == 0 || h == 0) {
+                    CGImageRelease(image);
+                    return;
+                }
+                // Redraw into RGBA so that Go does not have to handle every pixel format.
+                void *data = calloc(w * h, 4);
+                CGColorSpaceRef colorSpace = CGColorSpaceCreateWithName(kCGColorSpaceSRGB);
+                CGContextRef context = CGBitmapContextCreate(data, w, h, 8, w * 4, colorSpace,
+                    kCGImageAlphaPremultipliedLast | kCGBitmapByteOrder32Big);
+                CGColorSpaceRelease(colorSpace);
+                if (context == NULL) {
+                    CGImageRelease(image);
+                    free(data);
+                    return;
+                }
+                CGContextDrawImage(context, CGRectMake(0, 0, w, h), image);
+                CGContextRelease(context);
+                CGImageRelease(image);
+                *width = (int)w;
+                *height = (int)h;
+                pixels = data;
+            }
+        };
+
+        // UI operations must happen on main thread
+        if ([NSThread isMainThread]) {
+            capture();
+        } else {
+            dispatch_sync(dispatch_get_main_queue(), capture);
+        }
+        return pixels;
+    }
+    return NULL;
+}
+
+bool sendVirtualMachineKeyEvent(void *machine, int type, unsigned short keyCode, unsigned long long modifierFlags)
+{
+    if (@available(macOS 12, *)) {
+        __block bool sent = false;
+
+        void (^send)(void) = ^{
+            @autoreleasepool {
+                AppDelegate *appDelegate = (AppDelegate *)NSApp.delegate;
+                if (appDelegate == nil) {
+                    return;
+                }
+                NSView *view = [appDelegate virtualMachineViewForVirtualMachine:(VZVirtualMachine *)machine];
+                if (view == nil) {
+                    return;
+                }
+                NSEventType eventType = NSEventTypeFlagsChanged;
+                if (type == 0) {
+                    eventType = NSEventTypeKeyDown;
+                } else if (type == 1) {
+                    eventType = NSEventTypeKeyUp;
+                }
+                // The view translates the key code to the HID usage, so the characters are not used.
+                NSEvent *event = [NSEvent keyEventWithType:eventType
+                                                  location:NSZeroPoint
+                                             modifierFlags:(NSEventModifierFlags)modifierFlags
+                                                 timestamp:[[NSProcessInfo processInfo] systemUptime]
+                                              windowNumber:[[view window] windowNumber]
+                                                   context:nil
+                                                characters:@""
+                               charactersIgnoringModifiers:@""
+                                                 isARepeat:NO
+                                                   keyCode:keyCode];
+                if (event == nil) {
+                    return;
+                }
+                if (eventType == NSEventTypeKeyDown) {
+                    [view keyDown:event];
+                } else if (eventType == NSEventTypeKeyUp) {
+                    [view keyUp:event];
+                } else {
+                    [view flagsChanged:event];
+                }
+                sent = true;
+            }
+        };
+
+        // UI operations must happen on main thread
+        if ([NSThread isMainThread]) {
+            send();
+        } else {
+            dispatch_sync(dispatch_get_main_queue(), send);
+        }
+        return sent;
+    }
+    return false;
+}
+
 #pragma mark - Legacy API (backward compatibility)
 
 // Legacy: global window controller for single-VM case
@@ -111,7 +453,7 @@ static VMWindowController *_legacyWindowController API_AVAILABLE(macos(12.0)) =
 void startVirtualMachineWindow(void *machine, void *queue, double width, double height, const char *title, bool enableController, bool confirmStopOnClose)
 {
     if (@available(macOS 12, *)) {
-        void *controller = createVirtualMachineWindow(machine, queue, width, height, title, enableController, confirmStopOnClose);
+        void *controller = createVirtualMachineWindow(machine, queue, width, height, title, enableController, confirmStopOnClose, false);
         if (controller) {
             _legacyWindowController = (VMWindowController *)controller;
             // Window already shown by createVirtualMachineWindow
@@ -120,6 +462,57 @@ void startVirtualMachineWindow(void *machine, void *queue, double width, double
     }
 }
 
+#pragma mark - Console Window
+
+// The console windows which are open. Each controller is owned by this array until its window is closed.
+static NSMutableArray *_consoleWindowControllers = nil;
+
+void *createConsoleWindow(double width, double height, const char *title, uintptr_t cgoHandle, bool startHidden)
+{
+    initializeApplication();
+
+    if (@available(macOS 12, *)) {
+        __block ConsoleWindowController *controller = nil;
+        NSString *windowTitle = [NSString stringWithUTF8String:title];
+
+        runOnMainThread(^{
+            @autoreleasepool {
+                if (_consoleWindowControllers == nil) {
+                    _consoleWindowControllers = [[NSMutableArray alloc] init];
+                }
+                controller = [[ConsoleWindowController alloc] initWithTitle:windowTitle
+                                                                windowWidth:(CGFloat)width
+                                                               windowHeight:(CGFloat)height
+                                                                  cgoHandle:cgoHandle];
+                [_consoleWindowControllers addObject:controller];
+                [controller release];
+                if (!startHidden) {
+                    [[controller window] makeKeyAndOrderFront:nil];
+                }
+            }
+        });
+        return controller;
+    }
+    return NULL;
+}
+
+void appendConsoleWindowText(void *controller, const char *data, int length)
+{
+    if (@available(macOS 12, *)) {
+        // Decode now, data is freed by the caller. Output which is not UTF-8 is shown as Latin-1.
+        NSString *text = [[NSString alloc] initWithBytes:data length:(NSUInteger)length encoding:NSUTF8StringEncoding];
+        if (text == nil) {
+            text = [[NSString alloc] initWithBytes:data length:(NSUInteger)length encoding:NSISOLatin1StringEncoding];
+        }
+        // The block retains the controller, so it stays alive if the window is closed in the meantime.
+        ConsoleWindowController *consoleWindowController = (ConsoleWindowController *)controller;
+        dispatch_async(dispatch_get_main_queue(), ^{
+            [consoleWindowController appendText:text];
+            [text release];
+        });
+    }
+}
+
 #pragma mark - About Panel
 
 @implementation AboutViewController
@@ -244,6 +637,14 @@ void startVirtualMachineWindow(void *machine, void *queue, double width, double
 
 #pragma mark - VMWindowController
 
+// The number of VMWindowController instances which are not deallocated yet.
+static int _liveWindowControllers = 0;
+
+int liveVirtualMachineWindowControllers(void)
+{
+    return __atomic_load_n(&_liveWindowControllers, __ATOMIC_SEQ_CST);
+}
+
 @implementation VMWindowController {
     VZVirtualMachine *_virtualMachine;
     dispatch_queue_t _queue;
@@ -270,6 +671,7 @@ void startVirtualMachineWindow(void *machine, void *queue, double width, double
                     confirmStopOnClose:(BOOL)confirmStopOnClose
 {
     self = [super init];
+    __atomic_add_fetch(&_liveWindowControllers, 1, __ATOMIC_SEQ_CST);
     _virtualMachine = virtualMachine;
     [_virtualMachine setDelegate:self];
     _confirmStopOnClose = confirmStopOnClose;
@@ -300,7 +702,9 @@ void startVirtualMachineWindow(void *machine, void *queue, double width, double
     return self;
 }
 
-- (void)dealloc
+// The event monitors and the scroll timer retain the controller, so they must be removed
+// before the controller can be deallocated.
+- (void)removeEventMonitors
 {
     if (_mouseMovedMonitor) {
         [NSEvent removeMonitor:_mouseMovedMonitor];
@@ -311,6 +715,11 @@ void startVirtualMachineWindow(void *machine, void *queue, double width, double
         _scrollWheelMonitor = nil;
     }
     [self stopScrollTimer];
+}
+
+- (void)dealloc
+{
+    [self removeEventMonitors];
     if (_virtualMachine) {
         [_virtualMachine removeObserver:self forKeyPath:@"state"];
     }
@@ -320,6 +729,7 @@ void startVirtualMachineWindow(void *machine, void *queue, double width, double
     _toolbar = nil;
     _window = nil;
     _pauseOverlayView = nil;
+    __atomic_sub_fetch(&_liveWindowControllers, 1, __ATOMIC_SEQ_CST);
     [super dealloc];
 }
 
@@ -614,8 +1024,16 @@ static NSString *const PowerToolbarIdentifier = @"Power";
 #pragma mark - Window Setup
 
 - (void)setupAndShowWindow
+{
+    [self setupWindowAndShow:YES];
+}
+
+- (void)setupWindowAndShow:(BOOL)show
 {
     [self setupGraphicWindow];
+    if (show) {
+        [_window makeKeyAndOrderFront:nil];
+    }
 }
 
 - (NSWindow *)window
@@ -623,6 +1041,16 @@ static NSString *const PowerToolbarIdentifier = @"Power";
     return _window;
 }
 
+- (VZVirtualMachine *)virtualMachine
+{
+    return _virtualMachine;
+}
+
+- (VZVirtualMachineView *)virtualMachineView
+{
+    return _virtualMachineView;
+}
+
 - (NSWindow *)createMainWindowWithTitle:(NSString *)title width:(CGFloat)width height:(CGFloat)height
 {
     NSRect rect = NSMakeRect(0, 0, width, height);
@@ -662,7 +1090,13 @@ static NSString *const PowerToolbarIdentifier = @"Power";
         }
     });
 
-    // Remove from app delegate - this may trigger app termination
+    VZVirtualMachine_windowDidClose(_virtualMachine, self);
+    [self removeEventMonitors];
+
+    // Remove from app delegate - this may trigger app termination.
+    // The app delegate owns this controller, so detach it from the window
+    // first; the controller may be deallocated by the removal.
+    [_window setDelegate:nil];
     AppDelegate *appDelegate = (AppDelegate *)NSApp.delegate;
     if (appDelegate) {
         [appDelegate removeWindowController:self];
@@ -708,7 +1142,6 @@ static NSString *const PowerToolbarIdentifier = @"Power";
     }
 
     [_window setDelegate:self];
-    [_window makeKeyAndOrderFront:nil];
     [_window setReleasedWhenClosed:NO];
 }
 
@@ -891,10 +1324,163 @@ static NSString *const PowerToolbarIdentifier = @"Power";
 
 @end
 
+#pragma mark - ConsoleWindowController
+
+// The text view keeps at most this many characters, the oldest output is dropped.
+static const NSUInteger maxConsoleWindowTextLength = 1 << 20;
+
+@implementation ConsoleTextView {
+    uintptr_t _cgoHandle;
+}
+
+- (instancetype)initWithFrame:(NSRect)frame cgoHandle:(uintptr_t)cgoHandle
+{
+    self = [super initWithFrame:frame];
+    if (self) {
+        _cgoHandle = cgoHandle;
+    }
+    return self;
+}
+
+- (void)keyDown:(NSEvent *)event
+{
+    // Keep the keyboard shortcuts (e.g. Command-C) working.
+    if ([event modifierFlags] & NSEventModifierFlagCommand) {
+        [super keyDown:event];
+        return;
+    }
+    NSString *characters = [event characters];
+    if ([characters length] == 1) {
+        // Send the arrow keys as the escape sequences of a VT100 terminal.
+        switch ([characters characterAtIndex:0]) {
+        case NSUpArrowFunctionKey:
+            characters = @"\033[A";
+            break;
+        case NSDownArrowFunctionKey:
+            characters = @"\033[B";
+            break;
+        case NSRightArrowFunctionKey:
+            characters = @"\033[C";
+            break;
+        case NSLeftArrowFunctionKey:
+            characters = @"\033[D";
+            break;
+        }
+    }
+    NSData *data = [characters dataUsingEncoding:NSUTF8StringEncoding];
+    if ([data length] == 0) {
+        return;
+    }
+    consoleWindowInputHandler(_cgoHandle, (char *)[data bytes], (int)[data length]);
+}
+
+@end
+
+@implementation ConsoleWindowController {
+    NSWindow *_window;
+    ConsoleTextView *_textView;
+    uintptr_t _cgoHandle;
+}
+
+- (instancetype)initWithTitle:(NSString *)title
+                  windowWidth:(CGFloat)windowWidth
+                 windowHeight:(CGFloat)windowHeight
+                    cgoHandle:(uintptr_t)cgoHandle
+{
+    self = [super init];
+    if (self == nil) {
+        return nil;
+    }
+    _cgoHandle = cgoHandle;
+
+    NSRect rect = NSMakeRect(0, 0, windowWidth, windowHeight);
+    _window = [[NSWindow alloc] initWithContentRect:rect
+                                          styleMask:NSWindowStyleMaskTitled | NSWindowStyleMaskClosable | NSWindowStyleMaskMiniaturizable | NSWindowStyleMaskResizable
+                                            backing:NSBackingStoreBuffered
+                                              defer:NO];
+    // The controller releases the window.
+    [_window setReleasedWhenClosed:NO];
+    [_window setTitle:title];
+    [_window setDelegate:self];
+
+    NSScrollView *scrollView = [[[NSScrollView alloc] initWithFrame:rect] autorelease];
+    [scrollView setHasVerticalScroller:YES];
+    [scrollView setAutoresizingMask:NSViewWidthSizable | NSViewHeightSizable];
+
+    NSSize contentSize = [scrollView contentSize];
+    _textView = [[ConsoleTextView alloc] initWithFrame:NSMakeRect(0, 0, contentSize.width, contentSize.height)
+                                             cgoHandle:cgoHandle];
+    [_textView setEditable:NO];
+    [_textView setSelectable:YES];
+    [_textView setRichText:NO];
+    [_textView setFont:[NSFont monospacedSystemFontOfSize:12 weight:NSFontWeightRegular]];
+    [_textView setMinSize:NSMakeSize(0, contentSize.height)];
+    [_textView setMaxSize:NSMakeSize(FLT_MAX, FLT_MAX)];
+    [_textView setVerticallyResizable:YES];
+    [_textView setHorizontallyResizable:NO];
+    [_textView setAutoresizingMask:NSViewWidthSizable];
+    [[_textView textContainer] setWidthTracksTextView:YES];
+
+    [scrollView setDocumentView:_textView];
+    [_window setContentView:scrollView];
+    [_window setInitialFirstResponder:_textView];
+    [_window center];
+    return self;
+}
+
+- (void)dealloc
+{
+    [_window setDelegate:nil];
+    [_window release];
+    [_textView release];
+    [super dealloc];
+}
+
+- (NSWindow *)window
+{
+    return _window;
+}
+
+- (void)appendText:(NSString *)text
+{
+    // Follow the output only while the view is scrolled to the end, so reading back is not interrupted.
+    BOOL atEnd = NSMaxY([_textView visibleRect]) >= NSMaxY([_textView bounds]) - 1;
+
+    NSTextStorage *storage = [_textView textStorage];
+    NSDictionary *attributes = @{
+        NSFontAttributeName : [_textView font],
+        NSForegroundColorAttributeName : [NSColor textColor],
+    };
+    NSAttributedString *attributed = [[[NSAttributedString alloc] initWithString:text attributes:attributes] autorelease];
+    [storage beginEditing];
+    [storage appendAttributedString:attributed];
+    if ([storage length] > maxConsoleWindowTextLength) {
+        [storage deleteCharactersInRange:NSMakeRange(0, [storage length] - maxConsoleWindowTextLength)];
+    }
+    [storage endEditing];
+
+    if (atEnd) {
+        [_textView scrollToEndOfDocument:nil];
+    }
+}
+
+- (void)windowWillClose:(NSNotification *)notification
+{
+    consoleWindowClosedHandler(_cgoHandle);
+
+    // The array owns this controller, so detach it from the window first;
+    // the controller may be deallocated by the removal.
+    [_window setDelegate:nil];
+    [_consoleWindowControllers removeObject:self];
+}
+
+@end
+
 #pragma mark - AppDelegate
 
 @implementation AppDelegate {
     NSMutableArray<VMWindowController *> *_windowControllers;
+    NSMutableArray<NSMenuItem *> *_applicationMenuItems;
 }
 
 static AppDelegate *_sharedDelegate API_AVAILABLE(macos(12.0)) = nil;
@@ -908,12 +1494,14 @@ static AppDelegate *_sharedDelegate API_AVAILABLE(macos(12.0)) = nil;
 {
     self = [super init];
     _windowControllers = [[NSMutableArray alloc] init];
+    _applicationMenuItems = [[NSMutableArray alloc] init];
     return self;
 }
 
 - (void)dealloc
 {
     [_windowControllers release];
+    [_applicationMenuItems release];
     [super dealloc];
 }
 
@@ -926,15 +1514,110 @@ static AppDelegate *_sharedDelegate API_AVAILABLE(macos(12.0)) = nil;
 
 - (void)removeWindowController:(VMWindowController *)controller
 {
-    BOOL shouldTerminate = NO;
+    BOOL shouldStop = NO;
     @synchronized(_windowControllers) {
         [_windowControllers removeObject:controller];
-        shouldTerminate = (_windowControllers.count == 0);
+        shouldStop = (_windowControllers.count == 0);
     }
-    if (shouldTerminate) {
-        dispatch_async(dispatch_get_main_queue(), ^{
-            [NSApp terminate:nil];
-        });
+    if (shouldStop) {
+        // Called from windowWillClose: on the main thread.
+        stopEventLoop();
+    }
+}
+
+- (BOOL)focusWindowWithTitle:(NSString *)title
+{
+    NSWindow *window = nil;
+    @synchronized(_windowControllers) {
+        for (VMWindowController *controller in _windowControllers) {
+            if ([[[controller window] title] isEqualToString:title]) {
+                window = [controller window];
+                break;
+            }
+        }
+    }
+    if (window == nil) {
+        return NO;
+    }
+    if ([window isMiniaturized]) {
+        [window deminiaturize:nil];
+    }
+    [window makeKeyAndOrderFront:nil];
+    [NSApp activateIgnoringOtherApps:YES];
+    return YES;
+}
+
+- (VZVirtualMachineView *)virtualMachineViewForVirtualMachine:(VZVirtualMachine *)virtualMachine
+{
+    @synchronized(_windowControllers) {
+        for (VMWindowController *controller in _windowControllers) {
+            if ([controller virtualMachine] == virtualMachine) {
+                return [[[controller virtualMachineView] retain] autorelease];
+            }
+        }
+    }
+    return nil;
+}
+
+- (BOOL)showWindowForVirtualMachine:(VZVirtualMachine *)virtualMachine
+{
+    NSWindow *window = nil;
+    @synchronized(_windowControllers) {
+        for (VMWindowController *controller in _windowControllers) {
+            if ([controller virtualMachine] == virtualMachine) {
+                window = [controller window];
+                break;
+            }
+        }
+    }
+    if (window == nil) {
+        return NO;
+    }
+    if ([window isMiniaturized]) {
+        [window deminiaturize:nil];
+    }
+    [window makeKeyAndOrderFront:nil];
+    [NSApp activateIgnoringOtherApps:YES];
+    return YES;
+}
+
+- (BOOL)setFullscreen:(BOOL)fullscreen forVirtualMachine:(VZVirtualMachine *)virtualMachine
+{
+    NSWindow *window = nil;
+    @synchronized(_windowControllers) {
+        for (VMWindowController *controller in _windowControllers) {
+            if ([controller virtualMachine] == virtualMachine) {
+                window = [controller window];
+                break;
+            }
+        }
+    }
+    if (window == nil) {
+        return NO;
+    }
+    // toggleFullScreen: switches the mode, so only call it when the window is not in the wanted mode yet.
+    BOOL isFullscreen = ([window styleMask] & NSWindowStyleMaskFullScreen) != 0;
+    if (isFullscreen != fullscreen) {
+        if (fullscreen) {
+            if ([window isMiniaturized]) {
+                [window deminiaturize:nil];
+            }
+            [window setCollectionBehavior:[window collectionBehavior] | NSWindowCollectionBehaviorFullScreenPrimary];
+        }
+        [window toggleFullScreen:nil];
+    }
+    return YES;
+}
+
+- (void)closeAllWindows
+{
+    NSArray<VMWindowController *> *controllers = nil;
+    @synchronized(_windowControllers) {
+        controllers = [[_windowControllers copy] autorelease];
+    }
+    for (VMWindowController *controller in controllers) {
+        // -close does not ask windowShouldClose:, so no confirmation dialog is shown.
+        [[controller window] close];
     }
 }
 
@@ -942,19 +1625,55 @@ static AppDelegate *_sharedDelegate API_AVAILABLE(macos(12.0)) = nil;
 {
     _sharedDelegate = self;
     [self setupMenuBar];
+    [self applyApplicationMenus];
     [NSApp setActivationPolicy:NSApplicationActivationPolicyRegular];
     [NSApp activateIgnoringOtherApps:YES];
 }
 
+- (NSApplicationTerminateReply)applicationShouldTerminate:(NSApplication *)sender
+{
+    // VZApplication ends its event loop in -terminate:, so this is only asked when the host app
+    // created NSApp. Stop the event loop instead of exiting the process, so that RunApplication
+    // returns and runs its shutdown hooks.
+    stopEventLoop();
+    return NSTerminateCancel;
+}
+
 - (BOOL)applicationShouldTerminateAfterLastWindowClosed:(NSApplication *)sender
 {
-    // We handle termination manually in removeWindowController
+    // We stop the event loop manually in removeWindowController
     // to properly track VM windows vs other windows
     return NO;
 }
 
 #pragma mark - Menu Bar
 
+// applyApplicationMenus replaces the menus set by setApplicationMenus.
+// They are inserted after the application menu. Must be called on the main thread.
+- (void)applyApplicationMenus
+{
+    NSMenu *mainMenu = [NSApp mainMenu];
+    if (mainMenu == nil || _applicationMenuSpec == nil) {
+        return;
+    }
+    for (NSMenuItem *item in _applicationMenuItems) {
+        [mainMenu removeItem:item];
+    }
+    [_applicationMenuItems removeAllObjects];
+
+    NSData *data = [_applicationMenuSpec dataUsingEncoding:NSUTF8StringEncoding];
+    NSArray *menus = [NSJSONSerialization JSONObjectWithData:data options:0 error:nil];
+    NSInteger index = 1;
+    for (NSDictionary *spec in menus) {
+        NSMenu *menu = newMenuFromSpec(spec[@"title"], spec[@"items"]);
+        NSMenuItem *menuItem = [[[NSMenuItem alloc] initWithTitle:spec[@"title"] action:nil keyEquivalent:@""] autorelease];
+        [menuItem setSubmenu:menu];
+        [menu release];
+        [mainMenu insertItem:menuItem atIndex:index++];
+        [_applicationMenuItems addObject:menuItem];
+    }
+}
+
 - (void)setupMenuBar
 {
     NSMenu *menuBar = [[[NSMenu alloc] init] autorelease];
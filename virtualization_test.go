@@ -1,13 +1,19 @@
 package vz_test
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"math"
 	"net"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
 	"runtime"
+	"strings"
+	"sync"
 	"syscall"
 	"testing"
 	"time"
@@ -348,6 +354,15 @@ func TestRun(t *testing.T) {
 	if got := vm.CanPause(); !got {
 		t.Fatal("want CanPause is true")
 	}
+	if got, want := vm.Capabilities(), (vz.VMCapabilities{
+		CanStart:       vm.CanStart(),
+		CanPause:       vm.CanPause(),
+		CanResume:      vm.CanResume(),
+		CanRequestStop: vm.CanRequestStop(),
+		CanStop:        vm.CanStop(),
+	}); got != want {
+		t.Fatalf("want capabilities %+v but got %+v", want, got)
+	}
 	if err := vm.Pause(); err != nil {
 		t.Fatal(err)
 	}
@@ -530,3 +545,866 @@ func TestRunIssue124(t *testing.T) {
 		t.Fatalf("want state %v but got %v", vz.VirtualMachineStateStopped, got)
 	}
 }
+
+func TestCheckVirtualMachineState(t *testing.T) {
+	cases := []struct {
+		op      string
+		allowed bool
+		state   vz.VirtualMachineState
+		want    string
+	}{
+		{
+			op:      "start",
+			allowed: false,
+			state:   vz.VirtualMachineStateRunning,
+			want:    "invalid virtual machine state: cannot start in VirtualMachineStateRunning",
+		},
+		{
+			op:      "pause",
+			allowed: false,
+			state:   vz.VirtualMachineStateStopped,
+			want:    "invalid virtual machine state: cannot pause in VirtualMachineStateStopped",
+		},
+		{
+			op:      "resume",
+			allowed: false,
+			state:   vz.VirtualMachineStateRunning,
+			want:    "invalid virtual machine state: cannot resume in VirtualMachineStateRunning",
+		},
+		{
+			op:      "stop",
+			allowed: false,
+			state:   vz.VirtualMachineStateStopped,
+			want:    "invalid virtual machine state: cannot stop in VirtualMachineStateStopped",
+		},
+	}
+	for _, tc := range cases {
+		err := vz.CheckVirtualMachineState(tc.op, tc.allowed, tc.state)
+		if !errors.Is(err, vz.ErrInvalidVirtualMachineState) {
+			t.Fatalf("want ErrInvalidVirtualMachineState but got %v", err)
+		}
+		if got := err.Error(); tc.want != got {
+			t.Fatalf("want %q but got %q", tc.want, got)
+		}
+	}
+
+	if err := vz.CheckVirtualMachineState("start", true, vz.VirtualMachineStateStopped); err != nil {
+		t.Fatalf("want nil but got %v", err)
+	}
+}
+
+func TestStartRunningVirtualMachine(t *testing.T) {
+	container := newVirtualizationMachine(t)
+	t.Cleanup(func() {
+		if err := container.Shutdown(); err != nil {
+			log.Println(err)
+		}
+	})
+
+	err := container.VirtualMachine.Start()
+	if !errors.Is(err, vz.ErrInvalidVirtualMachineState) {
+		t.Fatalf("want ErrInvalidVirtualMachineState but got %v", err)
+	}
+}
+
+func newEntitlementTestConfiguration(t *testing.T) *vz.VirtualMachineConfiguration {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "vmlinuz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	bootLoader, err := vz.NewLinuxBootLoader(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := setupConfiguration(bootLoader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestNewVirtualMachineWithoutEntitlement(t *testing.T) {
+	restore := vz.SwapHasVirtualizationEntitlement(func() bool { return false })
+	defer restore()
+
+	_, err := vz.NewVirtualMachine(newEntitlementTestConfiguration(t))
+	if !errors.Is(err, vz.ErrVirtualizationEntitlementMissing) {
+		t.Fatalf("want ErrVirtualizationEntitlementMissing but got %v", err)
+	}
+}
+
+func TestNewVirtualMachineUnentitledBinary(t *testing.T) {
+	if vz.HasVirtualizationEntitlement() {
+		t.Skip("this test binary is signed with the virtualization entitlement")
+	}
+	_, err := vz.NewVirtualMachine(newEntitlementTestConfiguration(t))
+	if !errors.Is(err, vz.ErrVirtualizationEntitlementMissing) {
+		t.Fatalf("want ErrVirtualizationEntitlementMissing but got %v", err)
+	}
+}
+
+func TestWindowRegistry(t *testing.T) {
+	r := vz.NewWindowRegistry()
+	r.Add(1, vz.WindowInfo{Title: "vm1", VirtualMachineID: "id1"})
+	r.Add(2, vz.WindowInfo{Title: "vm2", VirtualMachineID: "id2"})
+	r.Add(3, vz.WindowInfo{Title: "vm1 (2)", VirtualMachineID: "id1"})
+
+	got := r.List()
+	want := []vz.WindowInfo{
+		{Title: "vm1", VirtualMachineID: "id1"},
+		{Title: "vm2", VirtualMachineID: "id2"},
+		{Title: "vm1 (2)", VirtualMachineID: "id1"},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("want %v but got %v", want, got)
+	}
+
+	// The returned slice must not alias the registry.
+	got[0].Title = "modified"
+	if info, ok := r.Lookup("vm1"); !ok || info.VirtualMachineID != "id1" {
+		t.Fatalf("want vm1 window of id1 but got %v (found %v)", info, ok)
+	}
+
+	// Closing the second window of id1 keeps the first one.
+	r.Remove(3)
+	want = []vz.WindowInfo{
+		{Title: "vm1", VirtualMachineID: "id1"},
+		{Title: "vm2", VirtualMachineID: "id2"},
+	}
+	if got := r.List(); !reflect.DeepEqual(want, got) {
+		t.Fatalf("want %v but got %v", want, got)
+	}
+	if _, ok := r.Lookup("vm1 (2)"); ok {
+		t.Fatal("want vm1 (2) window to be removed")
+	}
+
+	r.Remove(42)
+	if got := r.List(); len(got) != 2 {
+		t.Fatalf("want 2 windows but got %v", got)
+	}
+
+	if !r.Has("id1") || !r.Has("id2") || r.Has("unknown") {
+		t.Fatal("want windows of id1 and id2 only")
+	}
+	r.Remove(1)
+	if r.Has("id1") {
+		t.Fatal("want no window of id1")
+	}
+}
+
+func TestFocusWindowByTitleNotFound(t *testing.T) {
+	if vz.Available(12) {
+		t.Skip("FocusWindowByTitle is supported from macOS 12")
+	}
+	if err := vz.FocusWindowByTitle("no such window"); !errors.Is(err, vz.ErrWindowNotFound) {
+		t.Fatalf("want ErrWindowNotFound but got %v", err)
+	}
+}
+
+func TestSetWindowFullscreenWithoutWindow(t *testing.T) {
+	if vz.Available(12) {
+		t.Skip("SetWindowFullscreen is supported from macOS 12")
+	}
+
+	container := newVirtualizationMachine(t)
+	t.Cleanup(func() {
+		if err := container.Shutdown(); err != nil {
+			log.Println(err)
+		}
+	})
+
+	vm := container.VirtualMachine
+	for _, fullscreen := range []bool{true, false} {
+		if err := vm.SetWindowFullscreen(fullscreen); !errors.Is(err, vz.ErrWindowNotFound) {
+			t.Fatalf("SetWindowFullscreen(%v): want ErrWindowNotFound but got %v", fullscreen, err)
+		}
+	}
+}
+
+// runApplicationHelperEnv is set when the test binary is executed to run the application
+// event loop on the main thread. See TestStopApplication.
+const runApplicationHelperEnv = "VZ_TEST_RUN_APPLICATION"
+
+// runApplicationReturned is printed by the helper once RunApplication has returned,
+// which tells it apart from a process which exited in the event loop.
+const runApplicationReturned = "RunApplication returned"
+
+func init() {
+	if os.Getenv(runApplicationHelperEnv) == "" {
+		return
+	}
+	fail := func(format string, args ...any) {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+		os.Exit(2)
+	}
+	// init runs on the main thread, which AppKit requires.
+	runtime.LockOSThread()
+	bootLoader, err := vz.NewLinuxBootLoader(
+		"./testdata/Image",
+		vz.WithCommandLine("console=hvc0"),
+		vz.WithInitrd("./testdata/initramfs.cpio.gz"),
+	)
+	if err != nil {
+		fail("%v", err)
+	}
+	config, err := setupConfiguration(bootLoader)
+	if err != nil {
+		fail("%v", err)
+	}
+	vm, err := vz.NewVirtualMachine(config)
+	if err != nil {
+		fail("%v", err)
+	}
+	go func() {
+		time.Sleep(time.Second)
+		if err := vm.Start(); err != nil {
+			fail("%v", err)
+		}
+		if err := vm.CreateWindow(640, 480, vz.WithConfirmStopOnClose(false)); err != nil {
+			fail("%v", err)
+		}
+		if err := vz.StopApplication(3 * time.Second); err != nil {
+			fail("%v", err)
+		}
+	}()
+	if err := vz.RunApplication(); err != nil {
+		fail("%v", err)
+	}
+	if got := vm.State(); got != vz.VirtualMachineStateStopped {
+		fail("want the virtual machine stopped before RunApplication returned but got %s", got)
+	}
+	fmt.Println(runApplicationReturned)
+	os.Exit(0)
+}
+
+func TestStopApplication(t *testing.T) {
+	if vz.Available(12) {
+		t.Skip("StopApplication is supported from macOS 12")
+	}
+	if os.Getenv("CI") != "" {
+		t.Skip("the application event loop requires a GUI session")
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(os.Environ(), runApplicationHelperEnv+"=1")
+	out := new(strings.Builder)
+	cmd.Stdout, cmd.Stderr = out, out
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("application did not exit cleanly: %v\n%s", err, out)
+		}
+		// The process also exits with 0 if the event loop terminates it.
+		if !strings.Contains(out.String(), runApplicationReturned) {
+			t.Fatalf("RunApplication did not return after StopApplication:\n%s", out)
+		}
+	case <-time.After(20 * time.Second):
+		_ = cmd.Process.Kill()
+		t.Fatal("timed out waiting for RunApplication to return after StopApplication")
+	}
+}
+
+// windowControllersHelperEnv is set when the test binary is executed to create windows on
+// the application event loop. See TestWindowControllersAreReleased.
+const windowControllersHelperEnv = "VZ_TEST_WINDOW_CONTROLLERS"
+
+func init() {
+	if os.Getenv(windowControllersHelperEnv) == "" {
+		return
+	}
+	fail := func(format string, args ...any) {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+		os.Exit(2)
+	}
+	// init runs on the main thread, which AppKit requires.
+	runtime.LockOSThread()
+	bootLoader, err := vz.NewLinuxBootLoader("./testdata/Image")
+	if err != nil {
+		fail("%v", err)
+	}
+	config, err := setupConfiguration(bootLoader)
+	if err != nil {
+		fail("%v", err)
+	}
+	vm, err := vz.NewVirtualMachine(config)
+	if err != nil {
+		fail("%v", err)
+	}
+
+	const windows = 3
+	go func() {
+		time.Sleep(time.Second)
+		for i := range windows {
+			if err := vm.CreateWindow(640, 480, vz.WithWindowTitle(fmt.Sprintf("window %d", i))); err != nil {
+				fail("%v", err)
+			}
+		}
+		if n := vz.LiveWindowControllers(); n != windows {
+			fail("want %d window controllers but got %d", windows, n)
+		}
+		// Closes the windows.
+		if err := vz.StopApplication(time.Second); err != nil {
+			fail("%v", err)
+		}
+	}()
+	if err := vz.RunApplication(); err != nil {
+		fail("%v", err)
+	}
+	if n := vz.LiveWindowControllers(); n != 0 {
+		fail("want the window controllers to be released after their windows closed, but %d are alive", n)
+	}
+	os.Exit(0)
+}
+
+func TestWindowControllersAreReleased(t *testing.T) {
+	if vz.Available(12) {
+		t.Skip("CreateWindow is supported from macOS 12")
+	}
+	if os.Getenv("CI") != "" {
+		t.Skip("the application event loop requires a GUI session")
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(os.Environ(), windowControllersHelperEnv+"=1")
+	out := new(strings.Builder)
+	cmd.Stdout, cmd.Stderr = out, out
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("window controllers were not released: %v\n%s", err, out)
+		}
+	case <-time.After(20 * time.Second):
+		_ = cmd.Process.Kill()
+		t.Fatal("timed out waiting for the windows to close")
+	}
+}
+
+func TestCompletionHandlerTimeout(t *testing.T) {
+	vz.SetCompletionHandlerTimeout(50 * time.Millisecond)
+	defer vz.SetCompletionHandlerTimeout(0)
+
+	start := time.Now()
+	complete, err := vz.NeverCompletingOperation()
+	if !errors.Is(err, vz.ErrCompletionHandlerTimeout) {
+		t.Fatalf("want ErrCompletionHandlerTimeout but got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("operation returned after %s", elapsed)
+	}
+
+	// The framework may call the handler after the handle is released.
+	complete(errors.New("late completion"))
+}
+
+// shutdownHookHelperEnv is set when the test binary is executed to quit the application
+// by closing its last window. See TestWithShutdownHook.
+const shutdownHookHelperEnv = "VZ_TEST_SHUTDOWN_HOOK"
+
+func init() {
+	if os.Getenv(shutdownHookHelperEnv) == "" {
+		return
+	}
+	fail := func(format string, args ...any) {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+		os.Exit(2)
+	}
+	// init runs on the main thread, which AppKit requires.
+	runtime.LockOSThread()
+	bootLoader, err := vz.NewLinuxBootLoader(
+		"./testdata/Image",
+		vz.WithCommandLine("console=hvc0"),
+		vz.WithInitrd("./testdata/initramfs.cpio.gz"),
+	)
+	if err != nil {
+		fail("%v", err)
+	}
+	config, err := setupConfiguration(bootLoader)
+	if err != nil {
+		fail("%v", err)
+	}
+	vm, err := vz.NewVirtualMachine(config)
+	if err != nil {
+		fail("%v", err)
+	}
+	go func() {
+		time.Sleep(time.Second)
+		if err := vm.Start(); err != nil {
+			fail("%v", err)
+		}
+		if err := vm.CreateWindow(640, 480, vz.WithConfirmStopOnClose(false)); err != nil {
+			fail("%v", err)
+		}
+		// The window closes once its virtual machine is stopped, which quits the application.
+		if err := vm.Stop(); err != nil {
+			fail("%v", err)
+		}
+	}()
+	err = vz.RunApplication(
+		vz.WithShutdownHook(func() { fmt.Println("hook 1") }),
+		vz.WithShutdownHook(func() { fmt.Println("hook 2") }),
+	)
+	if err != nil {
+		fail("%v", err)
+	}
+	fmt.Println(runApplicationReturned)
+	os.Exit(0)
+}
+
+func TestWithShutdownHook(t *testing.T) {
+	if vz.Available(12) {
+		t.Skip("RunApplication is supported from macOS 12")
+	}
+	if err := vz.RunApplication(vz.WithShutdownHook(nil)); err == nil {
+		t.Fatal("want error for nil shutdown hook")
+	}
+	if os.Getenv("CI") != "" {
+		t.Skip("the application event loop requires a GUI session")
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(os.Environ(), shutdownHookHelperEnv+"=1")
+	out := new(strings.Builder)
+	cmd.Stdout, cmd.Stderr = out, out
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("application did not exit cleanly: %v\n%s", err, out)
+		}
+		want := "hook 1\nhook 2\n" + runApplicationReturned + "\n"
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("want the hooks called in order before RunApplication returned, but got:\n%s", out)
+		}
+	case <-time.After(20 * time.Second):
+		_ = cmd.Process.Kill()
+		t.Fatal("timed out waiting for the application to quit after its last window closed")
+	}
+}
+
+func TestGracefulStop(t *testing.T) {
+	if vz.Available(12) {
+		t.Skip("GracefulStop is supported from macOS 12")
+	}
+
+	container := newVirtualizationMachine(t)
+	t.Cleanup(func() {
+		if err := container.Shutdown(); err != nil {
+			log.Println(err)
+		}
+	})
+
+	vm := container.VirtualMachine
+	if err := vm.GracefulStop(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitUntilState(5*time.Second, vm, vz.VirtualMachineStateStopped); err != nil {
+		t.Fatal(err)
+	}
+	// Stopping a stopped virtual machine is a no-op.
+	if err := vm.GracefulStop(time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRequestStopAndWait(t *testing.T) {
+	if vz.Available(12) {
+		t.Skip("RequestStopAndWait is supported from macOS 12")
+	}
+
+	container := newVirtualizationMachine(t)
+	t.Cleanup(func() {
+		if err := container.Shutdown(); err != nil {
+			log.Println(err)
+		}
+	})
+
+	vm := container.VirtualMachine
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := vm.RequestStopAndWait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// It only returns once the virtual machine is stopped.
+	if got := vm.State(); got != vz.VirtualMachineStateStopped {
+		t.Fatalf("want %s but got %s", vz.VirtualMachineStateStopped, got)
+	}
+	if err := vm.RequestStopAndWait(ctx); err != nil {
+		t.Fatalf("want nil for a stopped virtual machine but got %v", err)
+	}
+}
+
+func TestWaitStopped(t *testing.T) {
+	t.Run("stopped", func(t *testing.T) {
+		polls := 0
+		state := func() vz.VirtualMachineState {
+			polls++
+			if polls < 3 {
+				return vz.VirtualMachineStateStopping
+			}
+			return vz.VirtualMachineStateStopped
+		}
+		if err := vz.WaitStopped(context.Background(), state, time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		if polls != 3 {
+			t.Fatalf("want 3 polls but got %d", polls)
+		}
+	})
+
+	t.Run("ignored", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		state := func() vz.VirtualMachineState { return vz.VirtualMachineStateRunning }
+		start := time.Now()
+		err := vz.WaitStopped(ctx, state, time.Millisecond)
+		if !errors.Is(err, vz.ErrStopRequestIgnored) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("want ErrStopRequestIgnored and context.DeadlineExceeded but got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+			t.Fatalf("want to wait until the deadline but returned after %v", elapsed)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		state := func() vz.VirtualMachineState { return vz.VirtualMachineStateError }
+		if err := vz.WaitStopped(context.Background(), state, time.Millisecond); !errors.Is(err, vz.ErrInvalidVirtualMachineState) {
+			t.Fatalf("want ErrInvalidVirtualMachineState but got %v", err)
+		}
+	})
+}
+
+func TestReboot(t *testing.T) {
+	if vz.Available(12) {
+		t.Skip("Reboot is supported from macOS 12")
+	}
+
+	container := newVirtualizationMachine(t)
+	t.Cleanup(func() {
+		if err := container.Shutdown(); err != nil {
+			log.Println(err)
+		}
+	})
+
+	vm := container.VirtualMachine
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := vm.Reboot(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitUntilState(10*time.Second, vm, vz.VirtualMachineStateRunning); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRebootOrchestration(t *testing.T) {
+	errStop := errors.New("stop")
+	errStart := errors.New("start")
+	cases := []struct {
+		name     string
+		stopErr  error
+		startErr error
+		wantErr  error
+		want     []string
+	}{
+		{name: "stop then start", want: []string{"stop", "start"}},
+		{name: "stop failed", stopErr: errStop, wantErr: errStop, want: []string{"stop"}},
+		{name: "start failed", startErr: errStart, wantErr: errStart, want: []string{"stop", "start"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls []string
+			stop := func(context.Context) error {
+				calls = append(calls, "stop")
+				return tc.stopErr
+			}
+			start := func() error {
+				calls = append(calls, "start")
+				return tc.startErr
+			}
+			err := vz.RebootWith(context.Background(), stop, start)
+			if !errors.Is(err, tc.wantErr) || (tc.wantErr == nil) != (err == nil) {
+				t.Fatalf("want %v but got %v", tc.wantErr, err)
+			}
+			if !reflect.DeepEqual(calls, tc.want) {
+				t.Fatalf("want calls %v but got %v", tc.want, calls)
+			}
+		})
+	}
+
+	t.Run("guest ignores the stop request", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		started := false
+		stop := func(ctx context.Context) error {
+			state := func() vz.VirtualMachineState { return vz.VirtualMachineStateRunning }
+			return vz.WaitStopped(ctx, state, time.Millisecond)
+		}
+		err := vz.RebootWith(ctx, stop, func() error { started = true; return nil })
+		if !errors.Is(err, vz.ErrStopRequestIgnored) {
+			t.Fatalf("want ErrStopRequestIgnored but got %v", err)
+		}
+		if started {
+			t.Fatal("the virtual machine must not be started while the guest is running")
+		}
+	})
+}
+
+func TestStartGraphicApplicationOptions(t *testing.T) {
+	o, err := vz.NewStartGraphicApplicationOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.StartHidden() || !o.ConfirmStopOnClose() {
+		t.Fatalf("want a visible window with stop confirmation by default but got hidden=%v confirm=%v", o.StartHidden(), o.ConfirmStopOnClose())
+	}
+
+	o, err = vz.NewStartGraphicApplicationOptions(
+		vz.WithWindowTitle("ubuntu"),
+		vz.WithStartHidden(true),
+		vz.WithConfirmStopOnClose(false),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !o.StartHidden() || o.ConfirmStopOnClose() || o.Title() != "ubuntu" {
+		t.Fatalf("options are not applied: hidden=%v confirm=%v title=%q", o.StartHidden(), o.ConfirmStopOnClose(), o.Title())
+	}
+}
+
+func TestPingDispatchQueue(t *testing.T) {
+	t.Run("responsive", func(t *testing.T) {
+		err := vz.PingDispatchQueue(context.Background(), func(complete func()) {
+			go complete()
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("hung", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		var late func()
+		err := vz.PingDispatchQueue(ctx, func(complete func()) {
+			late = complete // the queue never runs the task
+		})
+		if !errors.Is(err, vz.ErrUnresponsive) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("want ErrUnresponsive and context.DeadlineExceeded but got %v", err)
+		}
+		// The queue may run the task after the healthcheck returned.
+		late()
+	})
+}
+
+func TestHealthcheckNotRunning(t *testing.T) {
+	bootLoader, err := vz.NewLinuxBootLoader("./testdata/Image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := setupConfiguration(bootLoader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, err := vz.NewVirtualMachine(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.Healthcheck(context.Background()); !errors.Is(err, vz.ErrInvalidVirtualMachineState) {
+		t.Fatalf("want ErrInvalidVirtualMachineState but got %v", err)
+	}
+}
+
+func TestVirtualMachineDevices(t *testing.T) {
+	bootLoader, err := vz.NewLinuxBootLoader("./testdata/Image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := setupConfiguration(bootLoader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var storageDevices []vz.StorageDeviceConfiguration
+	for i := range 2 {
+		path := filepath.Join(t.TempDir(), fmt.Sprintf("disk%d.img", i))
+		if err := vz.CreateDiskImage(path, 512); err != nil {
+			t.Fatal(err)
+		}
+		attachment, err := vz.NewDiskImageStorageDeviceAttachment(path, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		storage, err := vz.NewVirtioBlockDeviceConfiguration(attachment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		storageDevices = append(storageDevices, storage)
+	}
+	config.SetStorageDevicesVirtualMachineConfiguration(storageDevices)
+	var graphicsDevices []vz.GraphicsDeviceConfiguration
+	if !vz.Available(12) {
+		graphics, err := vz.NewVirtioGraphicsDeviceConfiguration()
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanout, err := vz.NewVirtioGraphicsScanoutConfiguration(640, 480)
+		if err != nil {
+			t.Fatal(err)
+		}
+		graphics.SetScanouts(scanout)
+		graphicsDevices = append(graphicsDevices, graphics)
+		config.SetGraphicsDevicesVirtualMachineConfiguration(graphicsDevices)
+	}
+
+	vm, err := vz.NewVirtualMachine(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The configuration of the virtual machine is not changed by later changes to config.
+	config.SetStorageDevicesVirtualMachineConfiguration(nil)
+
+	if got := len(vm.NetworkDevices()); got != 1 {
+		t.Errorf("want 1 network device but got %d", got)
+	}
+	if got := vm.StorageDeviceCount(); got != 2 {
+		t.Errorf("want 2 storage devices but got %d", got)
+	}
+	if got := len(vm.GraphicsDevices()); got != len(graphicsDevices) {
+		t.Errorf("want %d graphics devices but got %d", len(graphicsDevices), got)
+	}
+	for _, device := range vm.GraphicsDevices() {
+		if _, ok := device.(*vz.VirtioGraphicsDeviceConfiguration); !ok {
+			t.Errorf("want *vz.VirtioGraphicsDeviceConfiguration but got %T", device)
+		}
+	}
+}
+
+func TestLifecycleOperationsAreSerialized(t *testing.T) {
+	container := newVirtualizationMachine(t)
+	t.Cleanup(func() {
+		if err := container.Shutdown(); err != nil {
+			log.Println(err)
+		}
+	})
+
+	vm := container.VirtualMachine
+	unlock := vm.LockOperations()
+	done := make(chan error, 1)
+	go func() { done <- vm.Pause() }()
+
+	select {
+	case err := <-done:
+		unlock()
+		t.Fatalf("want Pause to wait for the previous operation, but it returned %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	unlock()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := waitUntilState(10*time.Second, vm, vz.VirtualMachineStatePaused); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.Resume(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConcurrentStartStop(t *testing.T) {
+	if vz.Available(12) {
+		t.Skip("Stop is supported from macOS 12")
+	}
+
+	container := newVirtualizationMachine(t)
+	t.Cleanup(func() {
+		if err := container.Shutdown(); err != nil {
+			log.Println(err)
+		}
+	})
+
+	vm := container.VirtualMachine
+
+	// concurrently calls op from several goroutines and returns how many calls succeeded.
+	// The others must see the state the first call left behind.
+	concurrently := func(t *testing.T, op func() error) int {
+		t.Helper()
+		const callers = 4
+		var (
+			wg        sync.WaitGroup
+			mu        sync.Mutex
+			succeeded int
+		)
+		for range callers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := op()
+				mu.Lock()
+				defer mu.Unlock()
+				switch {
+				case err == nil:
+					succeeded++
+				case !errors.Is(err, vz.ErrInvalidVirtualMachineState):
+					t.Errorf("want nil or ErrInvalidVirtualMachineState, got %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+		return succeeded
+	}
+
+	for i := range 2 {
+		if got := concurrently(t, vm.Stop); got != 1 {
+			t.Fatalf("round %d: want exactly one Stop to succeed, got %d", i, got)
+		}
+		if err := waitUntilState(10*time.Second, vm, vz.VirtualMachineStateStopped); err != nil {
+			t.Fatal(err)
+		}
+		if got := concurrently(t, func() error { return vm.Start() }); got != 1 {
+			t.Fatalf("round %d: want exactly one Start to succeed, got %d", i, got)
+		}
+		if err := waitUntilState(10*time.Second, vm, vz.VirtualMachineStateRunning); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestVirtualMachineCPUCount(t *testing.T) {
+	container := newVirtualizationMachine(t)
+	t.Cleanup(func() {
+		if err := container.Shutdown(); err != nil {
+			log.Println(err)
+		}
+	})
+
+	vm := container.VirtualMachine
+	if got := vm.CPUCount(); got != 1 {
+		t.Fatalf("want 1 CPU as configured but got %d", got)
+	}
+
+	session := container.NewSession(t)
+	defer session.Close()
+	const cmd = "grep -c ^processor /proc/cpuinfo"
+	output, err := session.Output(cmd)
+	if err != nil {
+		t.Fatalf("failed to run command %q: %v", cmd, err)
+	}
+	if got, want := strings.TrimSpace(string(output)), fmt.Sprint(vm.CPUCount()); got != want {
+		t.Fatalf("want the guest to see %s CPUs but got %s", want, got)
+	}
+}
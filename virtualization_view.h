@@ -15,7 +15,7 @@
 #import <Virtualization/Virtualization.h>
 
 // VZApplication provides a custom event loop for VM graphics applications.
-// It allows programmatic termination via the shouldKeepRunning flag.
+// It allows programmatic termination via the shouldKeepRunning flag, which -stop: clears.
 @interface VZApplication : NSApplication {
     bool shouldKeepRunning;
 }
@@ -30,7 +30,7 @@
     }
 }
 
-- (void)terminate:(id)sender
+- (void)stop:(id)sender
 {
     shouldKeepRunning = NO;
 
@@ -42,7 +42,23 @@
 
     // This method is used to end up the event loop.
     // If no events are coming, the event loop will always be in a waiting state.
-    [self postEvent:self.currentEvent atStart:NO];
+    // currentEvent is nil when this is not called from an event handler, so post a dummy event.
+    NSEvent *event = [NSEvent otherEventWithType:NSEventTypeApplicationDefined
+                                        location:NSZeroPoint
+                                   modifierFlags:0
+                                       timestamp:0
+                                    windowNumber:0
+                                         context:nil
+                                         subtype:0
+                                           data1:0
+                                           data2:0];
+    [self postEvent:event atStart:NO];
+}
+
+- (void)terminate:(id)sender
+{
+    // Quitting ends the event loop rather than exiting the process, so that [NSApp run] returns.
+    [self stop:sender];
 }
 
 @end
\ No newline at end of file
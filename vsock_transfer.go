@@ -0,0 +1,172 @@
+package vz
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// ErrFileTransferFailed is returned by VsockFileTransfer when the agent in the guest reports
+// that it failed the transfer, e.g. because the remote path can not be written.
+var ErrFileTransferFailed = errors.New("guest agent failed the file transfer")
+
+// Operations of the file transfer protocol.
+const (
+	fileTransferPush byte = 1
+	fileTransferPull byte = 2
+)
+
+// Status of the reply of the agent.
+const (
+	fileTransferOK    byte = 0
+	fileTransferError byte = 1
+)
+
+// maxFileTransferMessage is the maximum length of a path or an error message.
+const maxFileTransferMessage = 1<<16 - 1
+
+// VsockFileTransfer pushes files to and pulls files from a guest over a connection to a small agent
+// in the guest, e.g. to push a provisioning script. The connection is usually made with
+// (*VirtioSocketDevice).Connect to the port which the agent listens on. The zero value is ready to use.
+//
+// Each connection carries one transfer. All integers are big-endian, and a string is a uint16 length
+// followed by its UTF-8 bytes. The host sends a request, which the agent answers with a reply:
+//
+//	request: op (uint8, 1 = push, 2 = pull), remote path (string)
+//	         push only: mode (uint32, permission bits), size (uint64), size bytes of content
+//	reply:   status (uint8, 0 = ok, 1 = error)
+//	         error: message (string)
+//	         pull and ok: mode (uint32), size (uint64), size bytes of content
+//
+// The agent replies to a push once the content is written to the remote path, and may close the
+// connection after the reply.
+type VsockFileTransfer struct {
+	// MaxFileSize limits the size of a pulled file. Zero means no limit.
+	MaxFileSize int64
+}
+
+// Push sends the file at localPath to the agent, which writes it to remotePath in the guest
+// with the permission bits of the local file.
+func (t *VsockFileTransfer) Push(conn io.ReadWriter, localPath, remotePath string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%q is not a regular file", localPath)
+	}
+
+	w := bufio.NewWriter(conn)
+	if err := writeFileTransferRequest(w, fileTransferPush, remotePath); err != nil {
+		return err
+	}
+	var header [12]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(fi.Mode().Perm()))
+	binary.BigEndian.PutUint64(header[4:], uint64(fi.Size()))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(w, f, fi.Size()); err != nil {
+		return fmt.Errorf("failed to send %q: %w", localPath, err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to send %q: %w", localPath, err)
+	}
+	return readFileTransferStatus(conn, remotePath)
+}
+
+// Pull receives the file at remotePath in the guest from the agent and writes it to localPath
+// with the permission bits of the remote file. localPath is replaced only once the whole file
+// is received.
+func (t *VsockFileTransfer) Pull(conn io.ReadWriter, remotePath, localPath string) error {
+	w := bufio.NewWriter(conn)
+	if err := writeFileTransferRequest(w, fileTransferPull, remotePath); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	if err := readFileTransferStatus(r, remotePath); err != nil {
+		return err
+	}
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return fmt.Errorf("failed to receive %q: %w", remotePath, err)
+	}
+	mode := fs.FileMode(binary.BigEndian.Uint32(header[:4])).Perm()
+	size := binary.BigEndian.Uint64(header[4:])
+	if size > math.MaxInt64 {
+		return fmt.Errorf("%q has an invalid size of %d bytes", remotePath, size)
+	}
+	if t.MaxFileSize > 0 && size > uint64(t.MaxFileSize) {
+		return fmt.Errorf("%q has %d bytes which exceeds the limit of %d bytes", remotePath, size, t.MaxFileSize)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(localPath), "."+filepath.Base(localPath)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails once it is renamed
+	_, err = io.CopyN(tmp, r, int64(size))
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to receive %q: %w", remotePath, err)
+	}
+	return os.Rename(tmp.Name(), localPath)
+}
+
+func writeFileTransferRequest(w io.Writer, op byte, remotePath string) error {
+	if remotePath == "" || len(remotePath) > maxFileTransferMessage {
+		return fmt.Errorf("invalid remote path %q", remotePath)
+	}
+	buf := make([]byte, 0, 3+len(remotePath))
+	buf = append(buf, op)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(remotePath)))
+	buf = append(buf, remotePath...)
+	_, err := w.Write(buf)
+	return err
+}
+
+// readFileTransferStatus reads the status of the reply, and the message if it is an error.
+func readFileTransferStatus(r io.Reader, remotePath string) error {
+	var status [1]byte
+	if _, err := io.ReadFull(r, status[:]); err != nil {
+		return fmt.Errorf("failed to read the reply for %q: %w", remotePath, err)
+	}
+	switch status[0] {
+	case fileTransferOK:
+		return nil
+	case fileTransferError:
+		var length [2]byte
+		if _, err := io.ReadFull(r, length[:]); err != nil {
+			return fmt.Errorf("failed to read the reply for %q: %w", remotePath, err)
+		}
+		msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(r, msg); err != nil {
+			return fmt.Errorf("failed to read the reply for %q: %w", remotePath, err)
+		}
+		return fmt.Errorf("%w: %q: %s", ErrFileTransferFailed, remotePath, msg)
+	default:
+		return fmt.Errorf("unknown status %d in the reply for %q", status[0], remotePath)
+	}
+}
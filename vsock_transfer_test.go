@@ -0,0 +1,247 @@
+package vz_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Code-Hex/vz/v3"
+)
+
+// fileTransferAgent is the agent in the guest: it serves one transfer per connection
+// with the files in root.
+type fileTransferAgent struct {
+	root string
+	// truncate makes a pull send only the first half of the content.
+	truncate bool
+	// size replaces the size in the reply to a pull if it is not 0.
+	size uint64
+}
+
+func (a *fileTransferAgent) serve(t *testing.T, conn net.Conn) {
+	t.Helper()
+	defer conn.Close()
+	var op [1]byte
+	if _, err := io.ReadFull(conn, op[:]); err != nil {
+		t.Errorf("failed to read op: %v", err)
+		return
+	}
+	path := readString(t, conn)
+	fail := func(msg string) {
+		reply := []byte{1}
+		reply = binary.BigEndian.AppendUint16(reply, uint16(len(msg)))
+		conn.Write(append(reply, msg...))
+	}
+	switch op[0] {
+	case 1: // push
+		var header [12]byte
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			t.Errorf("failed to read header: %v", err)
+			return
+		}
+		content := make([]byte, binary.BigEndian.Uint64(header[4:]))
+		if _, err := io.ReadFull(conn, content); err != nil {
+			t.Errorf("failed to read content: %v", err)
+			return
+		}
+		mode := os.FileMode(binary.BigEndian.Uint32(header[:4]))
+		if err := os.WriteFile(filepath.Join(a.root, path), content, mode); err != nil {
+			fail(err.Error())
+			return
+		}
+		conn.Write([]byte{0})
+	case 2: // pull
+		path := filepath.Join(a.root, path)
+		content, err := os.ReadFile(path)
+		if err != nil {
+			fail("no such file")
+			return
+		}
+		fi, _ := os.Stat(path)
+		reply := []byte{0}
+		reply = binary.BigEndian.AppendUint32(reply, uint32(fi.Mode().Perm()))
+		size := uint64(len(content))
+		if a.size != 0 {
+			size = a.size
+		}
+		reply = binary.BigEndian.AppendUint64(reply, size)
+		if a.truncate {
+			content = content[:len(content)/2]
+		}
+		conn.Write(append(reply, content...))
+	default:
+		t.Errorf("unknown op %d", op[0])
+	}
+}
+
+func readString(t *testing.T, r io.Reader) string {
+	t.Helper()
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		t.Errorf("failed to read length: %v", err)
+		return ""
+	}
+	s := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, s); err != nil {
+		t.Errorf("failed to read string: %v", err)
+	}
+	return string(s)
+}
+
+// transfer runs fn with a connection to agent.
+func transfer(t *testing.T, agent *fileTransferAgent, fn func(conn net.Conn) error) error {
+	t.Helper()
+	host, guest := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		agent.serve(t, guest)
+	}()
+	err := fn(host)
+	host.Close()
+	<-done
+	return err
+}
+
+func TestVsockFileTransfer(t *testing.T) {
+	guestRoot, hostDir := t.TempDir(), t.TempDir()
+	agent := &fileTransferAgent{root: guestRoot}
+	var ft vz.VsockFileTransfer
+
+	script := []byte("#!/bin/sh\necho provisioned\n")
+	local := filepath.Join(hostDir, "provision.sh")
+	if err := os.WriteFile(local, script, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("push", func(t *testing.T) {
+		err := transfer(t, agent, func(conn net.Conn) error {
+			return ft.Push(conn, local, "provision.sh")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		remote := filepath.Join(guestRoot, "provision.sh")
+		got, err := os.ReadFile(remote)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, script) {
+			t.Fatalf("want %q but got %q", script, got)
+		}
+		if fi, err := os.Stat(remote); err != nil || fi.Mode().Perm() != 0755 {
+			t.Fatalf("want mode 0755 but got %v (%v)", fi.Mode(), err)
+		}
+	})
+
+	t.Run("pull", func(t *testing.T) {
+		if err := os.WriteFile(filepath.Join(guestRoot, "result.log"), []byte("ok\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		pulled := filepath.Join(hostDir, "result.log")
+		err := transfer(t, agent, func(conn net.Conn) error {
+			return ft.Pull(conn, "result.log", pulled)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := os.ReadFile(pulled); err != nil || string(got) != "ok\n" {
+			t.Fatalf("want %q but got %q (%v)", "ok\n", got, err)
+		}
+		if fi, err := os.Stat(pulled); err != nil || fi.Mode().Perm() != 0600 {
+			t.Fatalf("want mode 0600 but got %v (%v)", fi.Mode(), err)
+		}
+	})
+
+	t.Run("agent error", func(t *testing.T) {
+		err := transfer(t, agent, func(conn net.Conn) error {
+			return ft.Pull(conn, "missing", filepath.Join(hostDir, "missing"))
+		})
+		if !errors.Is(err, vz.ErrFileTransferFailed) {
+			t.Fatalf("want ErrFileTransferFailed but got %v", err)
+		}
+	})
+
+	t.Run("size limit", func(t *testing.T) {
+		limited := vz.VsockFileTransfer{MaxFileSize: 2}
+		pulled := filepath.Join(hostDir, "limited.log")
+		err := transfer(t, agent, func(conn net.Conn) error {
+			return limited.Pull(conn, "result.log", pulled)
+		})
+		if err == nil {
+			t.Fatal("want an error for a file over the limit")
+		}
+		if _, err := os.Stat(pulled); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("want no file but got %v", err)
+		}
+	})
+
+	t.Run("oversized header", func(t *testing.T) {
+		oversized := &fileTransferAgent{root: guestRoot, size: math.MaxInt64 + 1}
+		pulled := filepath.Join(hostDir, "oversized.log")
+		err := transfer(t, oversized, func(conn net.Conn) error {
+			return ft.Pull(conn, "result.log", pulled)
+		})
+		if err == nil {
+			t.Fatal("want an error for a size over math.MaxInt64")
+		}
+		if _, err := os.Stat(pulled); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("want no file but got %v", err)
+		}
+	})
+
+	t.Run("truncated pull", func(t *testing.T) {
+		truncating := &fileTransferAgent{root: guestRoot, truncate: true}
+		pulled := filepath.Join(hostDir, "truncated.sh")
+		err := transfer(t, truncating, func(conn net.Conn) error {
+			return ft.Pull(conn, "provision.sh", pulled)
+		})
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("want io.ErrUnexpectedEOF but got %v", err)
+		}
+		if _, err := os.Stat(pulled); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("want no file but got %v", err)
+		}
+	})
+}
+
+func TestVsockFileTransferFraming(t *testing.T) {
+	local := filepath.Join(t.TempDir(), "a")
+	if err := os.WriteFile(local, []byte("xyz"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	host, guest := net.Pipe()
+	defer host.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, 1+2+len("/tmp/a")+4+8+3)
+		_, err := io.ReadFull(guest, buf)
+		if err != nil {
+			t.Errorf("failed to read the request: %v", err)
+		}
+		received <- buf
+		guest.Write([]byte{0})
+		guest.Close()
+	}()
+	var ft vz.VsockFileTransfer
+	if err := ft.Push(host, local, "/tmp/a"); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		1,    // push
+		0, 6, // length of the path
+		'/', 't', 'm', 'p', '/', 'a',
+		0, 0, 0x01, 0xa4, // mode 0644
+		0, 0, 0, 0, 0, 0, 0, 3, // size
+		'x', 'y', 'z',
+	}
+	if got := <-received; !bytes.Equal(got, want) {
+		t.Fatalf("want %v but got %v", want, got)
+	}
+}
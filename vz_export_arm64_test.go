@@ -0,0 +1,34 @@
+//go:build darwin && arm64
+// +build darwin,arm64
+
+package vz
+
+import "context"
+
+var LinuxRosettaDirectoryShareError = linuxRosettaDirectoryShareError
+
+func SwapFetchLatestSupportedMacOSRestoreImageURL(f func(context.Context) (string, error)) (restore func()) {
+	orig := fetchLatestSupportedMacOSRestoreImageURL
+	fetchLatestSupportedMacOSRestoreImageURL = f
+	return func() { fetchLatestSupportedMacOSRestoreImageURL = orig }
+}
+
+var ValidateMacOSConfigurationRequirements = validateMacOSConfigurationRequirements
+
+var ValidateMacHardwareModel = validateMacHardwareModel
+
+var MacOSVMResources = macOSVMResources
+
+// MacOSStartOptionsOf returns the macOS start options which Start would use with opts.
+func MacOSStartOptionsOf(opts ...VirtualMachineStartOption) (*MacOSStartOptions, error) {
+	o := &virtualMachineStartOptions{}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	if o.macOSStartOptions == nil {
+		return nil, nil
+	}
+	return o.macOSStartOptions.(*MacOSStartOptions), nil
+}
@@ -1,7 +1,16 @@
 package vz
 
 import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
 	"runtime"
+	"runtime/cgo"
+	"strings"
+	"time"
+
+	infinity "github.com/Code-Hex/go-infinity-channel"
 )
 
 func (v *VirtualMachine) SetMachineStateFinalizer(f func()) {
@@ -9,3 +18,310 @@ func (v *VirtualMachine) SetMachineStateFinalizer(f func()) {
 		f()
 	})
 }
+
+var NotifyTargetVirtualMachineMemorySize = notifyTargetVirtualMachineMemorySize
+
+var CheckVirtualMachineState = checkVirtualMachineState
+
+var NewStartError = newStartError
+
+var WaitStopped = waitStopped
+
+var RebootWith = reboot
+
+var LiveWindowControllers = liveWindowControllers
+
+// LockOperations holds the lock of the lifecycle operations of v until the returned
+// function is called, as a long operation in another goroutine would.
+func (v *VirtualMachine) LockOperations() (unlock func()) {
+	v.opMu.Lock()
+	return v.opMu.Unlock
+}
+
+var EFIBootEntries = efiBootEntries
+
+var EFISetBootNext = efiSetBootNext
+
+var RecommendedCPUCountFor = recommendedCPUCount
+
+var RecommendedMemorySizeFor = recommendedMemorySize
+
+func HasVirtualizationEntitlement() bool { return hasVirtualizationEntitlement() }
+
+func SwapHasVirtualizationEntitlement(f func() bool) (restore func()) {
+	orig := hasVirtualizationEntitlement
+	hasVirtualizationEntitlement = f
+	return func() { hasVirtualizationEntitlement = orig }
+}
+
+var ValidateSharedDirectoryEntries = validateSharedDirectoryEntries
+
+type ConsolePortState = consolePortState
+
+var NewConsolePortState = newConsolePortState
+
+func (s *consolePortState) Set(name string, open bool) { s.set(name, open) }
+
+func (s *consolePortState) Wait(ctx context.Context, name string) error { return s.wait(ctx, name) }
+
+// NewConsolePortPipe returns the host end of a console port pipe and the end which would be
+// handed to the virtual machine.
+func NewConsolePortPipe(detach func()) (io.ReadWriteCloser, io.ReadWriteCloser, error) {
+	return newConsolePortPipe(detach)
+}
+
+type RingBuffer = ringBuffer
+
+var NewRingBuffer = newRingBuffer
+
+// GuestIO returns the ends of the pipes of the attachment which are handed to the virtual machine.
+func (b *BufferedSerialPortAttachment) GuestIO() (input io.Reader, output io.Writer) {
+	return b.guestInput, b.guestOutput
+}
+
+// AttachConsoleWindow attaches a window which shows the console output with appendText
+// like CreateConsoleWindow, without creating the window.
+func (b *BufferedSerialPortAttachment) AttachConsoleWindow(appendText func(text []byte)) (detach func(), err error) {
+	w := &consoleWindow{}
+	if err := b.attachWindow(w); err != nil {
+		return nil, err
+	}
+	b.showWindow(w, appendText)
+	return func() { b.detachWindow(w) }, nil
+}
+
+// FilterConsoleText passes each chunk through one consoleTextFilter and returns the results.
+func FilterConsoleText(chunks ...string) []string {
+	var f consoleTextFilter
+	texts := make([]string, 0, len(chunks))
+	for _, c := range chunks {
+		texts = append(texts, string(f.filter([]byte(c))))
+	}
+	return texts
+}
+
+type EventEmitter = eventEmitter
+
+func NewEventEmitter() *EventEmitter { return newEventEmitter("", nil) }
+
+func NewEventEmitterWithID(id string) *EventEmitter { return newEventEmitter(id, nil) }
+
+func NewEventEmitterWithNetworkDevices(networkDevices []*VirtioNetworkDeviceConfiguration) *EventEmitter {
+	return newEventEmitter("", networkDevices)
+}
+
+func (e *eventEmitter) EmitStateChanged(state VirtualMachineState) {
+	e.emit(Event{Kind: EventStateChanged, State: state})
+}
+
+func (e *eventEmitter) EmitNetworkDisconnected(index int, err error) {
+	e.networkDisconnected(index, err)
+}
+
+func (e *eventEmitter) EmitWindowClosed() { e.emit(Event{Kind: EventWindowClosed}) }
+
+func (e *eventEmitter) EmitGuestStopped() { e.guestStopped() }
+
+func (e *eventEmitter) EmitStoppedWithError(err error) { e.stoppedWithError(err) }
+
+var (
+	EmitGuestStoppedEvent     = emitGuestStopped
+	EmitStoppedWithErrorEvent = emitStoppedWithError
+)
+
+func (e *eventEmitter) Events() <-chan Event { return e.events.Out() }
+
+func (e *eventEmitter) StoppedWithErrorNotify() <-chan error { return e.stoppedErrors.Out() }
+
+func (e *eventEmitter) SetLogger(logger *slog.Logger) { e.setLogger(logger) }
+
+func (e *eventEmitter) Close() { e.close() }
+
+var NewVirtioSocketConnectionFromFile = newVirtioSocketConnectionFromFile
+
+var ParseNSErrorUserInfo = parseNSErrorUserInfo
+
+type WindowRegistry = windowRegistry
+
+func NewWindowRegistry() *WindowRegistry { return &windowRegistry{} }
+
+func (r *windowRegistry) Add(controller uintptr, info WindowInfo) { r.add(controller, nil, info) }
+
+func (r *windowRegistry) Remove(controller uintptr) { r.remove(controller) }
+
+func (r *windowRegistry) List() []WindowInfo { return r.list() }
+
+func (r *windowRegistry) Lookup(title string) (WindowInfo, bool) { return r.lookup(title) }
+
+func (r *windowRegistry) Has(id string) bool { return r.has(id) }
+
+var MarshalMenus = marshalMenus
+
+type StartGraphicApplicationOptions = startGraphicApplicationOptions
+
+var NewStartGraphicApplicationOptions = newStartGraphicApplicationOptions
+
+func (o *startGraphicApplicationOptions) Title() string { return o.title }
+
+func (o *startGraphicApplicationOptions) ConfirmStopOnClose() bool { return o.confirmStopOnClose }
+
+func (o *startGraphicApplicationOptions) StartHidden() bool { return o.startHidden }
+
+type HoleRange struct{ Offset, Length int64 }
+
+func ZeroBlockRuns(r io.ReaderAt, start, end, blockSize int64) ([]HoleRange, error) {
+	holes, err := zeroBlockRuns(r, start, end, blockSize)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]HoleRange, len(holes))
+	for i, h := range holes {
+		ret[i] = HoleRange{Offset: h.offset, Length: h.length}
+	}
+	return ret, nil
+}
+
+var DeterministicHardwareAddr = deterministicHardwareAddr
+
+// NeverCompletingOperation waits for a completion handler which the framework never calls.
+// complete calls the handler late, after the operation returned.
+func NeverCompletingOperation() (complete func(error), err error) {
+	handle, errCh, release := makeHandler()
+	defer release()
+	complete = func(err error) { callCompletionHandler(handle, err) }
+	return complete, waitCompletion("test", errCh)
+}
+
+// PingDispatchQueue waits for complete, which stands in for the dispatch queue, to be called
+// with the handle of the completion handler.
+func PingDispatchQueue(ctx context.Context, ping func(complete func())) error {
+	return pingDispatchQueue(ctx, func(handle cgo.Handle) {
+		ping(func() { callCompletionHandler(handle, nil) })
+	})
+}
+
+var RetryStart = retryStart
+
+func (p RetryPolicy) Backoff(attempt int) time.Duration { return p.backoff(attempt) }
+
+func (v *VirtualMachine) DispatchQueueQoSClass() QoSClass { return v.dispatchQueueQoSClass() }
+
+func HostMemoryAvailable(pageSize, free, inactive, speculative uint64) uint64 {
+	return hostMemoryStatistics{
+		pageSize:         pageSize,
+		freePages:        free,
+		inactivePages:    inactive,
+		speculativePages: speculative,
+	}.available()
+}
+
+var CheckHostMemory = checkHostMemory
+
+// MemoryCommitter changes the state of a machineState which is not backed by a virtual machine.
+type MemoryCommitter struct{ m *machineState }
+
+func NewMemoryCommitter(memorySize uint64) *MemoryCommitter {
+	return &MemoryCommitter{m: &machineState{memorySize: memorySize}}
+}
+
+func (c *MemoryCommitter) SetState(state VirtualMachineState) {
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	c.m.commitMemory(state)
+}
+
+// StateClock changes the state of a machineState which is not backed by a virtual machine
+// at the given times.
+type StateClock struct{ m *machineState }
+
+func NewStateClock() *StateClock {
+	return &StateClock{m: &machineState{}}
+}
+
+func (c *StateClock) SetState(state VirtualMachineState, now time.Time) {
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	c.m.recordStateChange(state, now)
+	c.m.state = state
+}
+
+func (c *StateClock) Uptime(now time.Time) time.Duration {
+	c.m.mu.RLock()
+	defer c.m.mu.RUnlock()
+	return c.m.times.uptime(now)
+}
+
+func (c *StateClock) LastStateChange() time.Time {
+	c.m.mu.RLock()
+	defer c.m.mu.RUnlock()
+	return c.m.times.lastChange
+}
+
+// StateObserver changes the state of a machineState which is not backed by a virtual machine
+// like the state observer of the Virtualization framework does.
+type StateObserver struct{ m *machineState }
+
+func NewStateObserver(events *EventEmitter) *StateObserver {
+	return &StateObserver{m: &machineState{
+		stateNotify: infinity.NewChannel[VirtualMachineState](),
+		events:      events,
+	}}
+}
+
+func (o *StateObserver) ChangeState(state VirtualMachineState) { o.m.changeState(state, time.Now()) }
+
+func (o *StateObserver) State() VirtualMachineState {
+	o.m.mu.RLock()
+	defer o.m.mu.RUnlock()
+	return o.m.state
+}
+
+func CommittedMemory() uint64 {
+	committedMemory.mu.Lock()
+	defer committedMemory.mu.Unlock()
+	return committedMemory.bytes
+}
+
+func (o *LinuxVMOptions) Validate() error { return o.validate() }
+
+type PcapRecorder = pcapRecorder
+
+var NewPcapRecorder = newPcapRecorder
+
+var Downscale = downscale
+
+type KeyEvent struct {
+	Type    string
+	KeyCode uint16
+	Flags   uint64
+}
+
+func KeyEvents(stroke KeyStroke) ([]KeyEvent, error) {
+	events, err := keyEvents(stroke)
+	if err != nil {
+		return nil, err
+	}
+	types := map[keyEventType]string{keyEventDown: "down", keyEventUp: "up", keyEventFlagsChanged: "flags"}
+	ret := make([]KeyEvent, len(events))
+	for i, e := range events {
+		ret[i] = KeyEvent{Type: types[e.typ], KeyCode: e.keyCode, Flags: e.flags}
+	}
+	return ret, nil
+}
+
+// LookupDHCPLease looks mac up in the lease database.
+func LookupDHCPLease(database string, mac net.HardwareAddr) (net.IP, error) {
+	leases, err := parseDHCPLeases(strings.NewReader(database))
+	if err != nil {
+		return nil, err
+	}
+	return lookupDHCPLease(leases, mac)
+}
+
+func SwapDHCPLeasesPath(path string) (restore func()) {
+	orig := dhcpLeasesPath
+	dhcpLeasesPath = path
+	return func() { dhcpLeasesPath = orig }
+}
+
+var WaitForGuestReachableWith = waitForGuestReachable
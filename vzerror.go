@@ -1,5 +1,13 @@
 package vz
 
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorDomain is the NSError domain of the errors returned by the Virtualization framework.
+const ErrorDomain = "VZErrorDomain"
+
 // Error type returned by the Virtualization framework.
 // The NSError domain is VZErrorDomain, the code is one of the ErrorCode constants.
 //
@@ -113,3 +121,72 @@ const (
 	// Available from macOS 15.0 and above.
 	ErrorDeviceNotFound
 )
+
+// ErrorCode returns the code of n as ErrorCode. ok is false if n is not in ErrorDomain.
+func (n *NSError) ErrorCode() (code ErrorCode, ok bool) {
+	if n == nil || n.Domain != ErrorDomain {
+		return 0, false
+	}
+	return ErrorCode(n.Code), true
+}
+
+// ErrorCodeOf returns the ErrorCode of the *NSError of the Virtualization framework in err's tree,
+// so the errors can be handled with a switch:
+//
+//	code, ok := vz.ErrorCodeOf(err)
+//	switch {
+//	case !ok:
+//		// not an error of the Virtualization framework
+//	case code == vz.ErrorNetworkError:
+//		...
+//	}
+func ErrorCodeOf(err error) (code ErrorCode, ok bool) {
+	var nserr *NSError
+	if !errors.As(err, &nserr) {
+		return 0, false
+	}
+	return nserr.ErrorCode()
+}
+
+// StartError is returned by (*VirtualMachine).Start when the Virtualization framework fails to
+// start the virtual machine. The message of the framework is often terse, so StartError adds the
+// state of the virtual machine and the result of validating its configuration at the time of the
+// failure. It wraps the error of the framework, so ErrorCodeOf and errors.As still find the *NSError.
+type StartError struct {
+	// State is the state of the virtual machine after the start failed.
+	State VirtualMachineState
+
+	// ConfigValid reports whether the configuration of the virtual machine validated.
+	ConfigValid bool
+
+	// ValidationErr is the error of validating the configuration. It is nil if ConfigValid is true.
+	ValidationErr error
+
+	// Err is the error of the framework.
+	Err error
+}
+
+func (e *StartError) Error() string {
+	msg := fmt.Sprintf("failed to start in state %s; config valid=%t", e.State, e.ConfigValid)
+	if e.ValidationErr != nil {
+		msg += fmt.Sprintf(" (%v)", e.ValidationErr)
+	}
+	return msg + ": " + e.Err.Error()
+}
+
+func (e *StartError) Unwrap() error { return e.Err }
+
+// newStartError returns err of a failed start as *StartError with the current state and the result
+// of validate, or nil if err is nil.
+func newStartError(err error, state VirtualMachineState, validate func() (bool, error)) error {
+	if err == nil {
+		return nil
+	}
+	valid, validationErr := validate()
+	return &StartError{
+		State:         state,
+		ConfigValid:   valid && validationErr == nil,
+		ValidationErr: validationErr,
+		Err:           err,
+	}
+}
@@ -1,6 +1,8 @@
 package vz_test
 
 import (
+	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/Code-Hex/vz/v3"
@@ -104,3 +106,132 @@ func TestErrorCodeString(t *testing.T) {
 		}
 	})
 }
+
+func TestNSErrorAs(t *testing.T) {
+	nserr := &vz.NSError{
+		Domain:               vz.ErrorDomain,
+		Code:                 int(vz.ErrorInvalidVirtualMachineConfiguration),
+		LocalizedDescription: "Invalid virtual machine configuration.",
+		UserInfo:             "{}",
+	}
+	err := fmt.Errorf("failed to start: %w", nserr)
+
+	var got *vz.NSError
+	if !errors.As(err, &got) {
+		t.Fatalf("want *vz.NSError in %v", err)
+	}
+	if got.Domain != vz.ErrorDomain {
+		t.Errorf("want domain %q but got %q", vz.ErrorDomain, got.Domain)
+	}
+	if code := vz.ErrorCode(got.Code); code != vz.ErrorInvalidVirtualMachineConfiguration {
+		t.Errorf("want code %v but got %v", vz.ErrorInvalidVirtualMachineConfiguration, code)
+	}
+	want := `Error Domain=VZErrorDomain Code=2 Description="Invalid virtual machine configuration." UserInfo={}`
+	if got := nserr.Error(); got != want {
+		t.Errorf("want %q but got %q", want, got)
+	}
+}
+
+func TestParseNSErrorUserInfo(t *testing.T) {
+	got := vz.ParseNSErrorUserInfo(`{"NSLocalizedFailure":"Failed to start.","NSUnderlyingError":{"Domain":"NSPOSIXErrorDomain","Code":2,"LocalizedDescription":"No such file or directory","UserInfo":{}}}`)
+	if got["NSLocalizedFailure"] != "Failed to start." {
+		t.Errorf("unexpected NSLocalizedFailure: %v", got["NSLocalizedFailure"])
+	}
+	underlying, ok := got["NSUnderlyingError"].(map[string]any)
+	if !ok {
+		t.Fatalf("want NSUnderlyingError as map but got %T", got["NSUnderlyingError"])
+	}
+	if underlying["Domain"] != "NSPOSIXErrorDomain" || underlying["Code"] != float64(2) {
+		t.Errorf("unexpected NSUnderlyingError: %v", underlying)
+	}
+
+	for _, s := range []string{"{}", "", "null", "invalid"} {
+		if got := vz.ParseNSErrorUserInfo(s); got != nil {
+			t.Errorf("want nil for %q but got %v", s, got)
+		}
+	}
+}
+
+func TestErrorCodeOf(t *testing.T) {
+	cases := []struct {
+		domain string
+		code   int
+		want   vz.ErrorCode
+		wantOK bool
+	}{
+		{domain: vz.ErrorDomain, code: 7, want: vz.ErrorNetworkError, wantOK: true},
+		{domain: vz.ErrorDomain, code: 10007, want: vz.ErrorInstallationFailed, wantOK: true},
+		{domain: vz.ErrorDomain, code: 20002, want: vz.ErrorNetworkBlockDeviceDisconnected, wantOK: true},
+		{domain: vz.ErrorDomain, code: 30004, want: vz.ErrorDeviceNotFound, wantOK: true},
+		{domain: "NSPOSIXErrorDomain", code: 7},
+	}
+	for _, tc := range cases {
+		nserr := &vz.NSError{Domain: tc.domain, Code: tc.code}
+		err := fmt.Errorf("failed to start: %w", nserr)
+
+		code, ok := vz.ErrorCodeOf(err)
+		if code != tc.want || ok != tc.wantOK {
+			t.Errorf("%s %d: want %v, %v but got %v, %v", tc.domain, tc.code, tc.want, tc.wantOK, code, ok)
+		}
+	}
+
+	if _, ok := vz.ErrorCodeOf(errors.New("not an NSError")); ok {
+		t.Error("want no code for an error which is not an NSError")
+	}
+	if _, ok := (*vz.NSError)(nil).ErrorCode(); ok {
+		t.Error("want no code for a nil NSError")
+	}
+}
+
+func TestStartError(t *testing.T) {
+	nserr := &vz.NSError{
+		Domain:               vz.ErrorDomain,
+		Code:                 int(vz.ErrorInternal),
+		LocalizedDescription: "Internal Virtualization error.",
+	}
+
+	if err := vz.NewStartError(nil, vz.VirtualMachineStateRunning, func() (bool, error) {
+		t.Fatal("want no validation for a successful start")
+		return false, nil
+	}); err != nil {
+		t.Fatalf("want nil but got %v", err)
+	}
+
+	t.Run("valid configuration", func(t *testing.T) {
+		err := vz.NewStartError(nserr, vz.VirtualMachineStateError, func() (bool, error) { return true, nil })
+		var startErr *vz.StartError
+		if !errors.As(err, &startErr) {
+			t.Fatalf("want *StartError but got %T", err)
+		}
+		if startErr.State != vz.VirtualMachineStateError || !startErr.ConfigValid || startErr.ValidationErr != nil {
+			t.Errorf("unexpected start error %+v", startErr)
+		}
+		want := "failed to start in state VirtualMachineStateError; config valid=true: " + nserr.Error()
+		if got := err.Error(); got != want {
+			t.Errorf("want %q but got %q", want, got)
+		}
+		// The error of the framework is still found.
+		if code, ok := vz.ErrorCodeOf(err); !ok || code != vz.ErrorInternal {
+			t.Errorf("want ErrorInternal but got %v (%v)", code, ok)
+		}
+		if !errors.Is(err, nserr) {
+			t.Error("want the error to wrap the NSError")
+		}
+	})
+
+	t.Run("invalid configuration", func(t *testing.T) {
+		validationErr := errors.New("storage device attachment is invalid")
+		err := vz.NewStartError(nserr, vz.VirtualMachineStateStopped, func() (bool, error) { return false, validationErr })
+		var startErr *vz.StartError
+		if !errors.As(err, &startErr) {
+			t.Fatalf("want *StartError but got %T", err)
+		}
+		if startErr.ConfigValid || startErr.ValidationErr != validationErr {
+			t.Errorf("unexpected start error %+v", startErr)
+		}
+		want := fmt.Sprintf("failed to start in state VirtualMachineStateStopped; config valid=false (%v): %v", validationErr, nserr)
+		if got := err.Error(); got != want {
+			t.Errorf("want %q but got %q", want, got)
+		}
+	})
+}
@@ -0,0 +1,258 @@
+// Package vztest provides helpers for writing integration tests against the vz package.
+//
+// The test binary must be signed with the "com.apple.security.virtualization" entitlement
+// to create virtual machines, e.g.:
+//
+//	go test -exec "go run github.com/Code-Hex/vz/v3/cmd/codesign" ./...
+package vztest
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Code-Hex/vz/v3"
+)
+
+// LinuxVM is a disposable Linux virtual machine which boots with the EFI boot loader.
+//
+// All files of the virtual machine are placed in a temporary bundle directory
+// which is removed when the test finishes.
+type LinuxVM struct {
+	*vz.VirtualMachine
+
+	// Config is the validated configuration of the virtual machine.
+	Config *vz.VirtualMachineConfiguration
+
+	// BundlePath is the temporary directory which contains the files of the virtual machine.
+	BundlePath string
+
+	// DiskImagePath is the path of the raw disk image attached as a Virtio block device.
+	DiskImagePath string
+
+	// EFIVariableStore is the EFI variable store used by the boot loader.
+	EFIVariableStore *vz.EFIVariableStore
+}
+
+type options struct {
+	cpuCount      uint
+	memorySize    uint64
+	diskImageSize int64
+	configs       []func(*vz.VirtualMachineConfiguration) error
+}
+
+// Option is an option for NewLinuxVM and NewLinuxVMConfiguration.
+type Option func(*options)
+
+// WithCPUCount is an option to set the number of CPUs. Default is 1.
+func WithCPUCount(count uint) Option {
+	return func(o *options) { o.cpuCount = count }
+}
+
+// WithMemorySize is an option to set the memory size in bytes. Default is 512 MiB.
+func WithMemorySize(size uint64) Option {
+	return func(o *options) { o.memorySize = size }
+}
+
+// WithDiskImageSize is an option to set the size in bytes of the disk image. Default is 64 MiB.
+func WithDiskImageSize(size int64) Option {
+	return func(o *options) { o.diskImageSize = size }
+}
+
+// WithConfiguration is an option to customize the configuration before it is validated.
+func WithConfiguration(f func(*vz.VirtualMachineConfiguration) error) Option {
+	return func(o *options) { o.configs = append(o.configs, f) }
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		cpuCount:      1,
+		memorySize:    512 * 1024 * 1024,
+		diskImageSize: 64 * 1024 * 1024,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// NewLinuxVMConfiguration builds a minimal valid configuration for a Linux virtual machine
+// in a temporary bundle directory.
+//
+// The configuration contains a generic platform, an EFI boot loader with a new EFI variable store,
+// a blank disk image, an entropy device, a memory balloon device and a Virtio socket device.
+//
+// The VirtualMachine field of the returned LinuxVM is nil. Use NewLinuxVM to create the virtual machine too.
+//
+// The test is skipped if the host does not support EFI boot loader (macOS 13 and newer).
+func NewLinuxVMConfiguration(t testing.TB, opts ...Option) *LinuxVM {
+	t.Helper()
+	o := newOptions(opts)
+	bundlePath := t.TempDir()
+
+	efiVariableStore, err := vz.NewEFIVariableStore(
+		filepath.Join(bundlePath, "efi-variable-store"),
+		vz.WithCreatingEFIVariableStore(),
+	)
+	if err != nil {
+		skipIfUnsupported(t, err)
+		t.Fatalf("failed to create EFI variable store: %v", err)
+	}
+	bootLoader, err := vz.NewEFIBootLoader(vz.WithEFIVariableStore(efiVariableStore))
+	if err != nil {
+		t.Fatalf("failed to create EFI boot loader: %v", err)
+	}
+
+	config, err := vz.NewVirtualMachineConfiguration(bootLoader, o.cpuCount, o.memorySize)
+	if err != nil {
+		t.Fatalf("failed to create a new virtual machine config: %v", err)
+	}
+
+	machineIdentifier, err := vz.NewGenericMachineIdentifier()
+	if err != nil {
+		t.Fatalf("failed to create machine identifier: %v", err)
+	}
+	platformConfig, err := vz.NewGenericPlatformConfiguration(
+		vz.WithGenericMachineIdentifier(machineIdentifier),
+	)
+	if err != nil {
+		t.Fatalf("failed to create platform config: %v", err)
+	}
+	config.SetPlatformVirtualMachineConfiguration(platformConfig)
+
+	diskImagePath := filepath.Join(bundlePath, "disk.img")
+	if err := vz.CreateDiskImage(diskImagePath, o.diskImageSize); err != nil {
+		t.Fatalf("failed to create disk image: %v", err)
+	}
+	attachment, err := vz.NewDiskImageStorageDeviceAttachment(diskImagePath, false)
+	if err != nil {
+		t.Fatalf("failed to create disk image attachment: %v", err)
+	}
+	blockDevice, err := vz.NewVirtioBlockDeviceConfiguration(attachment)
+	if err != nil {
+		t.Fatalf("failed to create block device config: %v", err)
+	}
+	config.SetStorageDevicesVirtualMachineConfiguration([]vz.StorageDeviceConfiguration{
+		blockDevice,
+	})
+
+	entropyConfig, err := vz.NewVirtioEntropyDeviceConfiguration()
+	if err != nil {
+		t.Fatalf("failed to create entropy device config: %v", err)
+	}
+	config.SetEntropyDevicesVirtualMachineConfiguration([]*vz.VirtioEntropyDeviceConfiguration{
+		entropyConfig,
+	})
+
+	memoryBalloonDevice, err := vz.NewVirtioTraditionalMemoryBalloonDeviceConfiguration()
+	if err != nil {
+		t.Fatalf("failed to create memory balloon device config: %v", err)
+	}
+	config.SetMemoryBalloonDevicesVirtualMachineConfiguration([]vz.MemoryBalloonDeviceConfiguration{
+		memoryBalloonDevice,
+	})
+
+	vsockDevice, err := vz.NewVirtioSocketDeviceConfiguration()
+	if err != nil {
+		t.Fatalf("failed to create virtio socket device config: %v", err)
+	}
+	config.SetSocketDevicesVirtualMachineConfiguration([]vz.SocketDeviceConfiguration{
+		vsockDevice,
+	})
+
+	for _, setConfig := range o.configs {
+		if err := setConfig(config); err != nil {
+			t.Fatalf("failed to customize config: %v", err)
+		}
+	}
+
+	validated, err := config.Validate()
+	if !validated || err != nil {
+		t.Fatalf("invalid config: %v", err)
+	}
+
+	return &LinuxVM{
+		Config:           config,
+		BundlePath:       bundlePath,
+		DiskImagePath:    diskImagePath,
+		EFIVariableStore: efiVariableStore,
+	}
+}
+
+// NewLinuxVM creates a disposable Linux virtual machine from NewLinuxVMConfiguration.
+//
+// The virtual machine is not started. It is stopped when the test finishes if it is still running.
+func NewLinuxVM(t testing.TB, opts ...Option) *LinuxVM {
+	t.Helper()
+	vm := NewLinuxVMConfiguration(t, opts...)
+
+	virtualMachine, err := vz.NewVirtualMachine(vm.Config)
+	if err != nil {
+		skipIfUnsupported(t, err)
+		t.Fatalf("failed to create virtual machine: %v", err)
+	}
+	vm.VirtualMachine = virtualMachine
+
+	t.Cleanup(func() {
+		if err := vm.shutdown(); err != nil {
+			t.Logf("failed to stop virtual machine: %v", err)
+		}
+	})
+	return vm
+}
+
+// StartAndWait starts the virtual machine and waits until it is running.
+func (vm *LinuxVM) StartAndWait(t testing.TB) {
+	t.Helper()
+	if err := vm.Start(); err != nil {
+		t.Fatalf("failed to start virtual machine: %v", err)
+	}
+	if err := WaitUntilState(vm.VirtualMachine, vz.VirtualMachineStateRunning, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (vm *LinuxVM) shutdown() error {
+	if !vm.CanStop() {
+		return nil
+	}
+	if err := vm.Stop(); err != nil {
+		return err
+	}
+	return WaitUntilState(vm.VirtualMachine, vz.VirtualMachineStateStopped, 5*time.Second)
+}
+
+// ErrErrorState is returned by WaitUntilState when the virtual machine moved to VirtualMachineStateError.
+var ErrErrorState = errors.New("virtual machine is in error state")
+
+// WaitUntilState waits until the virtual machine moves to the state.
+func WaitUntilState(vm *vz.VirtualMachine, want vz.VirtualMachineState, timeout time.Duration) error {
+	if vm.State() == want {
+		return nil
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case got := <-vm.StateChangedNotify():
+			if want == got {
+				return nil
+			}
+			if got == vz.VirtualMachineStateError {
+				return ErrErrorState
+			}
+		case <-timer.C:
+			return fmt.Errorf("timed out waiting for state %s: current state is %s", want, vm.State())
+		}
+	}
+}
+
+func skipIfUnsupported(t testing.TB, err error) {
+	t.Helper()
+	switch {
+	case errors.Is(err, vz.ErrUnsupportedOSVersion), errors.Is(err, vz.ErrBuildTargetOSVersion):
+		t.Skipf("unsupported on this host: %v", err)
+	}
+}
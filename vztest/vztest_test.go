@@ -0,0 +1,52 @@
+package vztest_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Code-Hex/vz/v3"
+	"github.com/Code-Hex/vz/v3/vztest"
+)
+
+func TestNewLinuxVMConfiguration(t *testing.T) {
+	const diskImageSize = 16 * 1024 * 1024
+	customized := false
+	vm := vztest.NewLinuxVMConfiguration(t,
+		vztest.WithDiskImageSize(diskImageSize),
+		vztest.WithConfiguration(func(*vz.VirtualMachineConfiguration) error {
+			customized = true
+			return nil
+		}),
+	)
+	if !customized {
+		t.Fatal("want the configuration is customized")
+	}
+	if vm.VirtualMachine != nil {
+		t.Fatal("want no virtual machine")
+	}
+
+	fi, err := os.Stat(vm.DiskImagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != diskImageSize {
+		t.Fatalf("want disk image size %d but got %d", diskImageSize, fi.Size())
+	}
+	if _, err := os.Stat(vm.EFIVariableStore.Path()); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(vm.Config.StorageDevices()); got != 1 {
+		t.Fatalf("want 1 storage device but got %d", got)
+	}
+}
+
+func TestNewLinuxVM(t *testing.T) {
+	vm := vztest.NewLinuxVM(t)
+	if !vm.CanStart() {
+		t.Fatal("want CanStart is true")
+	}
+	vm.StartAndWait(t)
+	if got := vm.State(); got != vz.VirtualMachineStateRunning {
+		t.Fatalf("want state %v but got %v", vz.VirtualMachineStateRunning, got)
+	}
+}